@@ -0,0 +1,75 @@
+package main
+
+// This file implements the clean subcommand:
+//
+//	agent-sandbox clean [--dry-run] [--older-than DURATION] [--all]
+//
+// It removes project state directories of deleted projects (or unused ones,
+// or all of them) and temp directories left behind by killed processes.
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
+)
+
+const cleanSubcommandName = "clean"
+
+func runCleanCommand(stdout, stderr io.Writer, args []string, env map[string]string) int {
+	flags := flag.NewFlagSet(cleanSubcommandName, flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.SetOutput(&strings.Builder{})
+	dryRun := flags.Bool("dry-run", false, "Print what would be removed without removing it")
+	olderThan := flags.Duration("older-than", 0, "Also remove project state unused for this long (e.g. 720h)")
+	all := flags.Bool("all", false, "Remove the state of all projects")
+
+	err := flags.Parse(args)
+	if err == nil && flags.NArg() != 0 {
+		err = errors.New("usage: agent-sandbox clean [--dry-run] [--older-than DURATION] [--all]")
+	}
+
+	if err != nil {
+		fprintError(stderr, err)
+
+		return 1
+	}
+
+	homeDir, err := getHomeDir(env)
+	if err != nil {
+		fprintError(stderr, err)
+
+		return 1
+	}
+
+	items, err := sandbox.Clean(sandbox.Environment{HomeDir: homeDir, HostEnv: env}, sandbox.CleanOptions{
+		OlderThan:   *olderThan,
+		AllProjects: *all,
+		DryRun:      *dryRun,
+	})
+
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+
+	for _, item := range items {
+		subject := item.Path
+		if item.Project != "" {
+			subject += " (" + item.Project + ")"
+		}
+
+		fprintf(stdout, "%s %s %s: %s\n", verb, item.Kind, subject, item.Reason)
+	}
+
+	if err != nil {
+		fprintError(stderr, err)
+
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Clean_Removes_State_Of_Deleted_Projects(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	stateDir := t.TempDir()
+	env := map[string]string{"HOME": t.TempDir(), "XDG_STATE_HOME": stateDir}
+
+	gone := filepath.Join(stateDir, "agent-sandbox", "0123456789abcdef")
+	kept := filepath.Join(stateDir, "agent-sandbox", "fedcba9876543210")
+
+	for dir, project := range map[string]string{gone: filepath.Join(t.TempDir(), "deleted"), kept: t.TempDir()} {
+		err := os.MkdirAll(dir, 0o700)
+		if err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+
+		err = os.WriteFile(filepath.Join(dir, "project"), []byte(project+"\n"), 0o600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	code := runCleanCommand(&stdout, &stderr, []string{"--dry-run"}, env)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr.String())
+	}
+
+	AssertContains(t, stdout.String(), "would remove project-state "+gone)
+
+	if _, err := os.Stat(gone); err != nil {
+		t.Fatalf("expected --dry-run to keep %s: %v", gone, err)
+	}
+
+	stdout.Reset()
+
+	code = runCleanCommand(&stdout, &stderr, nil, env)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr.String())
+	}
+
+	AssertContains(t, stdout.String(), "removed project-state "+gone)
+
+	if strings.Contains(stdout.String(), kept) {
+		t.Errorf("expected the existing project to be kept, got:\n%s", stdout.String())
+	}
+
+	if _, err := os.Stat(gone); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed (err=%v)", gone, err)
+	}
+}
@@ -2,16 +2,24 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"maps"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/pflag"
 	"github.com/tailscale/hujson"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
 )
 
 // LoadConfigInput holds the inputs for LoadConfig.
@@ -20,26 +28,101 @@ type LoadConfigInput struct {
 	ConfigPath      string
 	EnvVars         map[string]string
 	CLIFlags        *pflag.FlagSet
+
+	// StrictConfig rejects unknown config fields instead of warning about
+	// them (--strict-config).
+	StrictConfig bool
+
+	// Profile selects a config profile (--profile), overriding the
+	// "profile" field of the config files.
+	Profile string
 }
 
 // Config holds the application configuration.
 type Config struct {
-	Network    *bool                  `json:"network,omitempty"`
+	// Schema is the optional "$schema" reference used by editors. It is
+	// accepted so config files can point at "agent-sandbox config schema"
+	// output, and otherwise ignored.
+	Schema string `json:"$schema,omitempty"`
+
+	// Version is the config format version (see configVersion). Files
+	// without it are treated as version 0 and migrated on load.
+	Version int `json:"version,omitempty"`
+
+	// Extends names a base config that this file is merged on top of: a path
+	// relative to this file (a directory means its .agent-sandbox.json[c]) or
+	// the name of a config in the global config directory. Resolved by
+	// loadConfigFile; always empty after loading.
+	Extends string `json:"extends,omitempty"`
+
+	Network    *NetworkConfig         `json:"network,omitempty"`
 	Docker     *bool                  `json:"docker,omitempty"`
 	Filesystem FilesystemConfig       `json:"filesystem"`
 	Commands   map[string]CommandRule `json:"commands,omitempty"`
+	Zones      map[string]NetworkZone `json:"zones,omitempty"`
+	Artifacts  *ArtifactsConfig       `json:"artifacts,omitempty"`
+
+	// Umask is an octal umask (e.g. "027") applied before the command runs.
+	Umask string `json:"umask,omitempty"`
+
+	// DefaultACL is "group-read" or "private"; see [sandbox.Config.DefaultACL].
+	DefaultACL string `json:"defaultAcl,omitempty"`
+
+	// MapSubIDs maps the user's /etc/subuid and /etc/subgid ranges into the
+	// sandbox; see [sandbox.Config.MapSubIDs].
+	MapSubIDs *bool `json:"mapSubIds,omitempty"`
+
+	// History records every sandboxed command in the history file read by
+	// "agent-sandbox history".
+	History *bool `json:"history,omitempty"`
+
+	// Labels are key/value metadata identifying the run to an orchestrator;
+	// see [sandbox.Config.Labels]. Later layers and --label override keys.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Profile names the entry of Profiles applied on top of the merged
+	// config files. --profile overrides it.
+	Profile string `json:"profile,omitempty"`
+
+	// Profiles are named partial configs, for example "ci" and "dev",
+	// merged on top of the top-level settings when selected. Definitions
+	// with the same name in several files are merged like the files.
+	Profiles map[string]Config `json:"profiles,omitempty"`
 
 	// Resolved (not serialized)
 	EffectiveCwd string `json:"-"`
 
+	// Warnings are non-fatal config problems, such as unknown fields, found
+	// while loading. They are printed before the command runs.
+	Warnings []string `json:"-"`
+
+	// NetworkAllow is the host allowlist of the zone selected by Network.Zone.
+	// It is nil when no zone is selected.
+	NetworkAllow []string `json:"-"`
+
+	// ArtifactLauncher is the verified host path of the launcher selected by
+	// Artifacts.Launcher, set by resolveArtifacts. Empty means the running
+	// agent-sandbox binary is used.
+	ArtifactLauncher string `json:"-"`
+
 	// LoadedConfigFiles tracks which config files were loaded (for debug output).
 	// Key is the config type (global, project, explicit), value is the path.
 	LoadedConfigFiles map[string]string `json:"-"`
 
+	// ExtendedConfigFiles lists, per config type, the files pulled in via
+	// "extends", nearest first.
+	ExtendedConfigFiles map[string][]string `json:"-"`
+
+	// CommandSources records, per command name, the layer that set its
+	// effective rule (e.g. "project config /repo/.agent-sandbox.jsonc" or
+	// "--block flag"), for denial messages.
+	CommandSources map[string]string `json:"-"`
+
 	// Source-tracked filesystem paths for correct debug output labeling.
 	// These are populated during config loading to preserve path sources.
 	GlobalFilesystem  FilesystemConfig `json:"-"`
 	ProjectFilesystem FilesystemConfig `json:"-"`
+	ProfileFilesystem FilesystemConfig `json:"-"`
 	CLIFilesystem     FilesystemConfig `json:"-"`
 }
 
@@ -49,6 +132,114 @@ type FilesystemConfig struct {
 	Ro      []string `json:"ro,omitempty"`
 	Rw      []string `json:"rw,omitempty"`
 	Exclude []string `json:"exclude,omitempty"`
+
+	// ExcludeNotice places a README-agent-sandbox.txt in excluded directories
+	// explaining that they were masked by policy. Later layers win.
+	ExcludeNotice *bool `json:"excludeNotice,omitempty"`
+
+	// Masks replace files with content embedded in the config, so a policy
+	// can describe synthesized files without side-car files.
+	Masks []MaskConfig `json:"masks,omitempty"`
+
+	// AllowDangerousMounts permits rw paths such as "/", "~", /usr and /etc,
+	// which are rejected by default. Later layers win.
+	AllowDangerousMounts *bool `json:"allowDangerousMounts,omitempty"`
+}
+
+// MaskConfig replaces Path (absolute, "~"-prefixed, or relative to the
+// working directory) with read-only content.
+type MaskConfig struct {
+	Path          string `json:"path"`
+	ContentBase64 string `json:"contentBase64"`
+}
+
+// NetworkConfig controls network access.
+// In config files it is either a boolean or an object selecting a named
+// zone: {"zone": "github-only"}. Selecting a zone implies network access.
+type NetworkConfig struct {
+	Enabled bool
+	Zone    string
+}
+
+// NetworkZone is a named network policy, defined under "zones" and referenced
+// via network.zone.
+type NetworkZone struct {
+	// Allow lists the destinations reachable from the zone. Entries are host
+	// names, "*." wildcards matching any subdomain, IPv4 or IPv6 addresses,
+	// or CIDR prefixes of either family ("10.0.0.0/8", "fd00::/8").
+	Allow []string `json:"allow"`
+}
+
+// ArtifactsConfig selects a cache directory, and optionally an HTTP base URL,
+// from which policy artifacts are fetched and verified. Command rules refer to
+// artifacts as "artifact:NAME"; Launcher replaces the agent-sandbox binary
+// mounted into the sandbox.
+type ArtifactsConfig struct {
+	// Dir is the cache directory (absolute or "~"-prefixed). Defaults to
+	// $XDG_CACHE_HOME/agent-sandbox/artifacts.
+	Dir string `json:"dir,omitempty"`
+
+	// URL is the base URL artifacts are downloaded from. Every artifact
+	// downloaded from it must be pinned in SHA256.
+	URL string `json:"url,omitempty"`
+
+	// SHA256 pins artifact names to lowercase hex SHA-256 digests.
+	SHA256 map[string]string `json:"sha256,omitempty"`
+
+	// Launcher is the artifact name of the launcher binary.
+	Launcher string `json:"launcher,omitempty"`
+}
+
+// artifactRulePrefix marks command rule values that name an artifact rather
+// than a host path.
+const artifactRulePrefix = "artifact:"
+
+// UnmarshalJSON implements custom JSON unmarshaling for NetworkConfig.
+// Accepts a boolean or an object with a "zone" key.
+func (n *NetworkConfig) UnmarshalJSON(data []byte) error {
+	var boolVal bool
+
+	err := json.Unmarshal(data, &boolVal)
+	if err == nil && string(data) != "null" {
+		*n = NetworkConfig{Enabled: boolVal}
+
+		return nil
+	}
+
+	var obj struct {
+		Zone string `json:"zone"`
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(&obj)
+	if err != nil || string(data) == "null" {
+		return fmt.Errorf("network must be boolean or {\"zone\": NAME}: got %s", string(data))
+	}
+
+	if strings.TrimSpace(obj.Zone) == "" {
+		return errors.New("network.zone must not be empty")
+	}
+
+	*n = NetworkConfig{Enabled: true, Zone: obj.Zone}
+
+	return nil
+}
+
+// MarshalJSON implements custom JSON marshaling for NetworkConfig.
+func (n NetworkConfig) MarshalJSON() ([]byte, error) {
+	var val any = n.Enabled
+	if n.Zone != "" {
+		val = map[string]string{"zone": n.Zone}
+	}
+
+	data, err := json.Marshal(val)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling network config: %w", err)
+	}
+
+	return data, nil
 }
 
 // CommandRuleKind represents the type of command wrapper rule.
@@ -65,11 +256,15 @@ const (
 	CommandRulePreset
 	// CommandRuleScript uses a custom wrapper script ("/path/to/script" in config).
 	CommandRuleScript
+	// CommandRuleInline uses a wrapper script given inline
+	// ({"contentBase64": "..."} in config). Value holds the decoded script.
+	CommandRuleInline
 )
 
 // CommandRule represents a command wrapper configuration.
-// It can be a boolean (true = raw, false = block) or a string
-// (starting with @ = preset, otherwise = script path).
+// It can be a boolean (true = raw, false = block), a string
+// (starting with @ = preset, otherwise = script path) or an object with the
+// base64-encoded script content.
 type CommandRule struct {
 	Kind  CommandRuleKind
 	Value string // used for Preset (e.g., "@git") and Script (e.g., "/path/to/wrapper")
@@ -77,11 +272,55 @@ type CommandRule struct {
 
 const errInvalidCommandPresetMessage = "command preset can only be used for its matching command"
 
+const errCommandRuleTypeMessage = `command rule must be boolean or string (or {"contentBase64": ...})`
+
+// inlineContent is the config form of content embedded in a config file.
+type inlineContent struct {
+	ContentBase64 string `json:"contentBase64"`
+}
+
+// decode returns the decoded content.
+func (c inlineContent) decode() ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(c.ContentBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid contentBase64: %w", err)
+	}
+
+	return data, nil
+}
+
 // UnmarshalJSON implements custom JSON unmarshaling for commandRule.
-// Accepts boolean or string values as per spec.
+// Accepts boolean, string or {"contentBase64": ...} values as per spec.
 func (r *CommandRule) UnmarshalJSON(data []byte) error {
 	if string(data) == "null" {
-		return fmt.Errorf("command rule must be boolean or string: got %s", string(data))
+		return fmt.Errorf("%s: got %s", errCommandRuleTypeMessage, string(data))
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		var inline inlineContent
+
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&inline)
+		if err != nil || inline.ContentBase64 == "" {
+			return fmt.Errorf("%s: got %s", errCommandRuleTypeMessage, string(data))
+		}
+
+		script, err := inline.decode()
+		if err != nil {
+			return fmt.Errorf("command rule: %w", err)
+		}
+
+		// The launcher treats this prefix as a built-in wrapper.
+		if strings.HasPrefix(string(script), "preset:") {
+			return errors.New("command rule: inline script must not start with \"preset:\"")
+		}
+
+		r.Kind = CommandRuleInline
+		r.Value = string(script)
+
+		return nil
 	}
 
 	var strVal string
@@ -114,7 +353,7 @@ func (r *CommandRule) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 
-	return fmt.Errorf("command rule must be boolean or string: got %s", string(data))
+	return fmt.Errorf("%s: got %s", errCommandRuleTypeMessage, string(data))
 }
 
 // MarshalJSON implements custom JSON marshaling for commandRule.
@@ -128,6 +367,8 @@ func (r CommandRule) MarshalJSON() ([]byte, error) {
 		val = false
 	case CommandRulePreset, CommandRuleScript:
 		val = r.Value
+	case CommandRuleInline:
+		val = inlineContent{ContentBase64: base64.StdEncoding.EncodeToString([]byte(r.Value))}
 	default:
 		val = nil
 	}
@@ -147,27 +388,14 @@ func (r CommandRule) MarshalJSON() ([]byte, error) {
 //  3. Project config OR --config path (not both):
 //     - Without --config: .agent-sandbox.json or .agent-sandbox.jsonc in workDir
 //     - With --config: uses that path instead of project config
+//  4. The selected profile (--profile, or "profile" in the config files)
 //
 // Both .json and .jsonc files support comments via tailscale/hujson.
 // If both .json and .jsonc exist at the same location, it's an error.
 func LoadConfig(input LoadConfigInput) (Config, error) {
-	workDir := input.WorkDirOverride
-	if workDir == "" {
-		var err error
-
-		workDir, err = os.Getwd()
-		if err != nil {
-			return Config{}, fmt.Errorf("cannot get working directory: %w", err)
-		}
-	}
-
-	if !filepath.IsAbs(workDir) {
-		cwd, err := os.Getwd()
-		if err != nil {
-			return Config{}, fmt.Errorf("cannot get working directory: %w", err)
-		}
-
-		workDir = filepath.Join(cwd, workDir)
+	workDir, err := resolveWorkDir(input.WorkDirOverride)
+	if err != nil {
+		return Config{}, err
 	}
 
 	cfg := DefaultConfig()
@@ -178,10 +406,15 @@ func LoadConfig(input LoadConfigInput) (Config, error) {
 		return Config{}, err
 	}
 
+	namedConfigDir := ""
+	if globalConfigBasePath != "" {
+		namedConfigDir = filepath.Dir(globalConfigBasePath)
+	}
+
 	if globalConfigBasePath != "" {
 		globalConfigPath, findErr := findConfigFile(globalConfigBasePath, false)
 		if findErr == nil {
-			globalCfg, loadErr := parseConfigFile(globalConfigPath)
+			globalCfg, extended, loadErr := loadConfigFile(globalConfigPath, namedConfigDir, input.StrictConfig)
 			if loadErr != nil {
 				return Config{}, loadErr
 			}
@@ -189,6 +422,8 @@ func LoadConfig(input LoadConfigInput) (Config, error) {
 			cfg.GlobalFilesystem = globalCfg.Filesystem
 			cfg = mergeConfigs(&cfg, &globalCfg)
 			cfg.LoadedConfigFiles["global"] = globalConfigPath
+			cfg.recordExtended("global", extended)
+			cfg.recordCommandSources(globalCfg.Commands, "global config "+globalConfigPath)
 		} else if !errors.Is(findErr, os.ErrNotExist) {
 			return Config{}, findErr
 		}
@@ -201,7 +436,7 @@ func LoadConfig(input LoadConfigInput) (Config, error) {
 			configPath = filepath.Join(workDir, configPath)
 		}
 
-		explicitCfg, parseErr := parseConfigFile(configPath)
+		explicitCfg, extended, parseErr := loadConfigFile(configPath, namedConfigDir, input.StrictConfig)
 		if parseErr != nil {
 			return Config{}, parseErr
 		}
@@ -210,12 +445,14 @@ func LoadConfig(input LoadConfigInput) (Config, error) {
 		cfg.ProjectFilesystem = explicitCfg.Filesystem
 		cfg = mergeConfigs(&cfg, &explicitCfg)
 		cfg.LoadedConfigFiles["explicit"] = configPath
+		cfg.recordExtended("explicit", extended)
+		cfg.recordCommandSources(explicitCfg.Commands, "config "+configPath)
 	} else {
 		projectConfigBasePath := filepath.Join(workDir, ".agent-sandbox")
 
 		projectConfigPath, findErr := findConfigFile(projectConfigBasePath, false)
 		if findErr == nil {
-			projectCfg, loadErr := parseConfigFile(projectConfigPath)
+			projectCfg, extended, loadErr := loadConfigFile(projectConfigPath, namedConfigDir, input.StrictConfig)
 			if loadErr != nil {
 				return Config{}, loadErr
 			}
@@ -223,12 +460,19 @@ func LoadConfig(input LoadConfigInput) (Config, error) {
 			cfg.ProjectFilesystem = projectCfg.Filesystem
 			cfg = mergeConfigs(&cfg, &projectCfg)
 			cfg.LoadedConfigFiles["project"] = projectConfigPath
+			cfg.recordExtended("project", extended)
+			cfg.recordCommandSources(projectCfg.Commands, "project config "+projectConfigPath)
 		} else if !errors.Is(findErr, os.ErrNotExist) {
 			return Config{}, findErr
 		}
 		// If os.ErrNotExist, silently skip (per spec: project config is optional)
 	}
 
+	err = applyProfile(&cfg, input.Profile)
+	if err != nil {
+		return Config{}, err
+	}
+
 	cfg.EffectiveCwd = workDir
 
 	if input.CLIFlags != nil {
@@ -243,9 +487,44 @@ func LoadConfig(input LoadConfigInput) (Config, error) {
 		return Config{}, err
 	}
 
+	err = resolveNetworkZone(&cfg)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = validateArtifacts(&cfg)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = validateFileModes(&cfg)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = validateMasks(cfg.Filesystem.Masks)
+	if err != nil {
+		return Config{}, err
+	}
+
 	return cfg, nil
 }
 
+// resolveWorkDir returns the absolute working directory: override (-C,
+// relative to the current directory) or the current directory.
+func resolveWorkDir(override string) (string, error) {
+	if override != "" && filepath.IsAbs(override) {
+		return override, nil
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("cannot get working directory: %w", err)
+	}
+
+	return filepath.Join(cwd, override), nil
+}
+
 // --- internal helpers ---
 
 // DefaultConfig returns the default configuration.
@@ -253,7 +532,7 @@ func DefaultConfig() Config {
 	t, f := true, false
 
 	return Config{
-		Network: &t,
+		Network: &NetworkConfig{Enabled: t},
 		Docker:  &f,
 		Commands: map[string]CommandRule{
 			"git": {Kind: CommandRulePreset, Value: "@git"},
@@ -266,7 +545,7 @@ func DefaultConfig() Config {
 func applyCLIFlags(cfg *Config, flags *pflag.FlagSet) error {
 	if flags.Changed("network") {
 		val, _ := flags.GetBool("network")
-		cfg.Network = &val
+		cfg.Network = &NetworkConfig{Enabled: val}
 	}
 
 	if flags.Changed("docker") {
@@ -288,7 +567,15 @@ func applyCLIFlags(cfg *Config, flags *pflag.FlagSet) error {
 		exclude, _ = flags.GetStringArray("exclude")
 	}
 
-	cfg.CLIFilesystem = FilesystemConfig{Ro: ro, Rw: rw, Exclude: exclude}
+	// --preset is only defined for the "run" subcommand; Changed reports false
+	// for undefined flags.
+	var presets []string
+	if flags.Changed("preset") {
+		presets, _ = flags.GetStringArray("preset")
+	}
+
+	cfg.CLIFilesystem = FilesystemConfig{Presets: presets, Ro: ro, Rw: rw, Exclude: exclude}
+	cfg.Filesystem.Presets = append(cfg.Filesystem.Presets, presets...)
 	cfg.Filesystem.Ro = append(cfg.Filesystem.Ro, ro...)
 	cfg.Filesystem.Rw = append(cfg.Filesystem.Rw, rw...)
 	cfg.Filesystem.Exclude = append(cfg.Filesystem.Exclude, exclude...)
@@ -300,12 +587,53 @@ func applyCLIFlags(cfg *Config, flags *pflag.FlagSet) error {
 			cfg.Commands = make(map[string]CommandRule)
 		}
 
+		flagCommands := make(map[string]CommandRule)
+
 		for _, v := range cmdVals {
-			err := parseCmdFlag(cfg.Commands, v)
+			err := parseCmdFlag(flagCommands, v)
 			if err != nil {
 				return err
 			}
 		}
+
+		maps.Copy(cfg.Commands, flagCommands)
+		cfg.recordCommandSources(flagCommands, "--cmd flag")
+	}
+
+	if flags.Changed("label") {
+		labels, _ := flags.GetStringArray("label")
+
+		cfg.Labels = maps.Clone(cfg.Labels)
+		if cfg.Labels == nil {
+			cfg.Labels = make(map[string]string)
+		}
+
+		for _, label := range labels {
+			key, value, ok := strings.Cut(label, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				return fmt.Errorf("invalid --label value %q: expected KEY=VALUE", label)
+			}
+
+			cfg.Labels[strings.TrimSpace(key)] = value
+		}
+	}
+
+	if flags.Changed("block") {
+		blocked, _ := flags.GetStringArray("block")
+
+		if cfg.Commands == nil {
+			cfg.Commands = make(map[string]CommandRule)
+		}
+
+		for _, name := range blocked {
+			name = strings.TrimSpace(name)
+			if name == "" || strings.Contains(name, "/") {
+				return fmt.Errorf("invalid --block value %q: expected a command name", name)
+			}
+
+			cfg.Commands[name] = CommandRule{Kind: CommandRuleBlock}
+			cfg.recordCommandSources(map[string]CommandRule{name: {}}, "--block flag")
+		}
 	}
 
 	return nil
@@ -355,6 +683,46 @@ func parseCmdFlag(commands map[string]CommandRule, value string) error {
 	return nil
 }
 
+// applyProfile validates the defined profiles and merges the selected one,
+// name or else cfg.Profile, on top of cfg. Profiles cannot select or define
+// profiles, extend other configs or carry a version.
+func applyProfile(cfg *Config, name string) error {
+	for _, profileName := range slices.Sorted(maps.Keys(cfg.Profiles)) {
+		profile := cfg.Profiles[profileName]
+
+		switch {
+		case strings.TrimSpace(profileName) == "":
+			return errors.New("profiles: profile name must not be empty")
+		case profile.Profile != "" || len(profile.Profiles) > 0:
+			return fmt.Errorf("profiles: profile %q must not select or define profiles", profileName)
+		case profile.Extends != "" || profile.Version != 0 || profile.Schema != "":
+			return fmt.Errorf("profiles: profile %q must not set extends, version or $schema", profileName)
+		}
+	}
+
+	if name == "" {
+		name = cfg.Profile
+	}
+
+	if name == "" {
+		return nil
+	}
+
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(cfg.Profiles))
+
+		return fmt.Errorf("profile %q is not defined (defined profiles: %s)", name, strings.Join(names, ", "))
+	}
+
+	cfg.ProfileFilesystem = profile.Filesystem
+	*cfg = mergeConfigs(cfg, &profile)
+	cfg.Profile = name
+	cfg.recordCommandSources(profile.Commands, "profile "+name)
+
+	return nil
+}
+
 // validateCommandRules checks that command presets are used correctly.
 // For example, @git can only be used with the "git" command.
 func validateCommandRules(commands map[string]CommandRule) error {
@@ -373,6 +741,206 @@ func validateCommandRules(commands map[string]CommandRule) error {
 	return nil
 }
 
+// resolveNetworkZone validates all defined zones and resolves the zone
+// selected by cfg.Network into cfg.NetworkAllow.
+func resolveNetworkZone(cfg *Config) error {
+	for name, zone := range cfg.Zones {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("zones: zone name must not be empty")
+		}
+
+		if len(zone.Allow) == 0 {
+			return fmt.Errorf("zones: zone %q has an empty allow list", name)
+		}
+
+		for _, host := range zone.Allow {
+			err := validateZoneEntry(host)
+			if err != nil {
+				return fmt.Errorf("zones: zone %q: %w", name, err)
+			}
+		}
+	}
+
+	if cfg.Network == nil || cfg.Network.Zone == "" {
+		cfg.NetworkAllow = nil
+
+		return nil
+	}
+
+	zone, ok := cfg.Zones[cfg.Network.Zone]
+	if !ok {
+		names := slices.Sorted(maps.Keys(cfg.Zones))
+
+		return fmt.Errorf("network zone %q is not defined (defined zones: %s)", cfg.Network.Zone, strings.Join(names, ", "))
+	}
+
+	cfg.NetworkAllow = slices.Clone(zone.Allow)
+
+	return nil
+}
+
+// validateFileModes checks the umask and defaultAcl settings.
+func validateFileModes(cfg *Config) error {
+	if cfg.Umask != "" {
+		_, err := parseUmask(cfg.Umask)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch cfg.DefaultACL {
+	case "", string(sandbox.DefaultACLGroupRead), string(sandbox.DefaultACLPrivate):
+		return nil
+	default:
+		return fmt.Errorf("invalid defaultAcl %q: expected %q or %q", cfg.DefaultACL, sandbox.DefaultACLGroupRead, sandbox.DefaultACLPrivate)
+	}
+}
+
+// validateMasks checks that every mask names a path and decodes.
+func validateMasks(masks []MaskConfig) error {
+	for i, mask := range masks {
+		if strings.TrimSpace(mask.Path) == "" {
+			return fmt.Errorf("filesystem.masks[%d]: path is empty", i)
+		}
+
+		_, err := inlineContent{ContentBase64: mask.ContentBase64}.decode()
+		if err != nil {
+			return fmt.Errorf("filesystem.masks[%d] (%s): %w", i, mask.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// parseUmask parses an octal umask such as "027" or "0077".
+func parseUmask(s string) (int, error) {
+	umask, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || umask > 0o777 {
+		return 0, fmt.Errorf("invalid umask %q: expected an octal value between 000 and 777", s)
+	}
+
+	return int(umask), nil
+}
+
+// validateArtifacts checks the artifacts config and that every "artifact:"
+// command rule can be resolved from it.
+func validateArtifacts(cfg *Config) error {
+	for _, name := range slices.Sorted(maps.Keys(cfg.Commands)) {
+		rule := cfg.Commands[name]
+		if rule.Kind != CommandRuleScript || !strings.HasPrefix(rule.Value, artifactRulePrefix) {
+			continue
+		}
+
+		if cfg.Artifacts == nil {
+			return fmt.Errorf("commands: %q uses %s but no artifacts are configured", name, rule.Value)
+		}
+
+		if strings.TrimPrefix(rule.Value, artifactRulePrefix) == "" {
+			return fmt.Errorf("commands: %q: empty artifact name", name)
+		}
+	}
+
+	art := cfg.Artifacts
+	if art == nil {
+		return nil
+	}
+
+	if art.Dir != "" && !filepath.IsAbs(art.Dir) && art.Dir != "~" && !strings.HasPrefix(art.Dir, "~/") {
+		return fmt.Errorf("artifacts: dir %q must be absolute or start with ~/", art.Dir)
+	}
+
+	if art.URL != "" && !strings.HasPrefix(art.URL, "https://") && !strings.HasPrefix(art.URL, "http://") {
+		return fmt.Errorf("artifacts: url %q must use http or https", art.URL)
+	}
+
+	for name, sum := range art.SHA256 {
+		_, err := hex.DecodeString(sum)
+		if err != nil || len(sum) != 64 || strings.ToLower(sum) != sum {
+			return fmt.Errorf("artifacts: sha256 for %q must be 64 lowercase hex characters", name)
+		}
+	}
+
+	return nil
+}
+
+// validateZoneEntry checks one entry of a zone's allow list: a host name, a
+// "*." wildcard, an IPv4 or IPv6 address, or a CIDR prefix of either family.
+// Addresses must have one canonical form so matching a destination is
+// unambiguous: bracketed IPv6, zone IDs, IPv4-mapped IPv6 and prefixes with
+// host bits set are rejected instead of silently never matching.
+func validateZoneEntry(entry string) error {
+	addrPart, _, isPrefix := strings.Cut(entry, "/")
+
+	_, addrErr := netip.ParseAddr(addrPart)
+	if isPrefix && addrErr == nil {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", entry)
+		}
+
+		if prefix.Addr().Is4In6() {
+			return fmt.Errorf("invalid CIDR %q: write IPv4-mapped prefixes as IPv4", entry)
+		}
+
+		if masked := prefix.Masked(); masked != prefix {
+			return fmt.Errorf("invalid CIDR %q: host bits set (use %s)", entry, masked)
+		}
+
+		return nil
+	}
+
+	if strings.HasPrefix(entry, "[") {
+		return fmt.Errorf("invalid host %q: write IPv6 addresses without brackets or port", entry)
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err == nil {
+		switch {
+		case addr.Zone() != "":
+			return fmt.Errorf("invalid host %q: IPv6 zone IDs are not supported", entry)
+		case addr.Is4In6():
+			return fmt.Errorf("invalid host %q: write IPv4-mapped addresses as %s", entry, addr.Unmap())
+		}
+
+		return nil
+	}
+
+	if !isValidZoneHost(entry) {
+		return fmt.Errorf("invalid host %q (expected a host name, \"*.\" wildcard, IP address or CIDR)", entry)
+	}
+
+	return nil
+}
+
+// isValidZoneHost reports whether host is a host name, optionally prefixed
+// with a "*." subdomain wildcard. Wildcards of IP addresses are not host
+// names.
+func isValidZoneHost(host string) bool {
+	host = strings.TrimPrefix(host, "*.")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+
+	_, err := netip.ParseAddr(host)
+	if err == nil {
+		return false
+	}
+
+	for label := range strings.SplitSeq(host, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 // findConfigFile finds a config file at the given base path.
 // It checks for both .json and .jsonc extensions and returns an error if both exist.
 // basePath should be either the full path (for --config) or the directory + base name without extension.
@@ -400,6 +968,17 @@ func findConfigFile(basePath string, isExplicitPath bool) (string, error) {
 		return "", fmt.Errorf("checking %s: %w", jsoncPath, jsoncErr)
 	}
 
+	// An empty file next to a real config is a mount point left behind by
+	// a killed run (see configProtectionMounts), not a second config.
+	if jsonExists && jsoncExists {
+		switch {
+		case isEmptyFile(jsoncPath):
+			jsoncExists = false
+		case isEmptyFile(jsonPath):
+			jsonExists = false
+		}
+	}
+
 	if jsonExists && jsoncExists {
 		return "", fmt.Errorf("duplicate config files found: both %s and %s exist; remove one", jsonPath, jsoncPath)
 	}
@@ -415,6 +994,13 @@ func findConfigFile(basePath string, isExplicitPath bool) (string, error) {
 	return "", os.ErrNotExist
 }
 
+// isEmptyFile reports whether path is a regular file of size 0.
+func isEmptyFile(path string) bool {
+	info, err := os.Stat(path)
+
+	return err == nil && info.Mode().IsRegular() && info.Size() == 0
+}
+
 // fileExists checks if a file exists and is not a directory.
 // Returns (true, nil) if file exists, (false, nil) if not found,
 // or (false, error) for other errors (e.g., permission denied).
@@ -435,34 +1021,187 @@ func fileExists(path string) (bool, error) {
 	return true, nil
 }
 
-// parseConfigFile loads and parses a JSON/JSONC config file.
+// parseConfigFile loads and parses a JSON/JSONC config file, migrating it to
+// the current format (see configVersion).
 // Both .json and .jsonc files support comments via hujson.
-// Returns an error if the config contains unknown fields.
-func parseConfigFile(path string) (Config, error) {
+// Unknown fields are recorded in Config.Warnings, or rejected if strict.
+func parseConfigFile(path string, strict bool) (Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return Config{}, fmt.Errorf("reading config %s: %w", path, err)
 	}
 
+	// An empty file is an empty config. Missing project configs are masked
+	// with empty files while a command runs (see configProtectionMounts),
+	// and a run that is killed leaves them behind.
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte("{}")
+	}
+
 	// Standardize JSONC to JSON (handles comments in both .json and .jsonc)
 	standardized, err := hujson.Standardize(data)
 	if err != nil {
 		return Config{}, fmt.Errorf("parsing config %s: %w", path, err)
 	}
 
-	var cfg Config
+	var raw map[string]any
 
 	decoder := json.NewDecoder(bytes.NewReader(standardized))
-	decoder.DisallowUnknownFields()
+	decoder.UseNumber()
+
+	err = decoder.Decode(&raw)
+	if err != nil {
+		return Config{}, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+
+	err = migrateConfig(raw)
+	if err != nil {
+		return Config{}, fmt.Errorf("config %s: %w", path, err)
+	}
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return Config{}, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+
+	var cfg Config
+
+	decoder = json.NewDecoder(bytes.NewReader(migrated))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
 
 	err = decoder.Decode(&cfg)
 	if err != nil {
 		return Config{}, fmt.Errorf("parsing config %s: %w", path, err)
 	}
 
+	if !strict {
+		for _, field := range unknownConfigFields(raw, reflect.TypeFor[Config](), "") {
+			cfg.Warnings = append(cfg.Warnings, fmt.Sprintf("config %s: ignoring unknown field %q (use --strict-config to reject)", path, field))
+		}
+	}
+
 	return cfg, nil
 }
 
+// loadConfigFile parses the config file at path and, recursively, the files
+// it extends. It returns the merged config (extended files first, so the file
+// at path wins) and the extended files, nearest first. namedConfigDir is
+// where named configs ("extends": "team-base") are looked up. strict is
+// passed to parseConfigFile.
+func loadConfigFile(path, namedConfigDir string, strict bool) (Config, []string, error) {
+	return loadExtendedConfig(path, namedConfigDir, strict, nil)
+}
+
+func loadExtendedConfig(path, namedConfigDir string, strict bool, stack []string) (Config, []string, error) {
+	if slices.Contains(stack, path) {
+		return Config{}, nil, fmt.Errorf("config extends cycle: %s", strings.Join(append(stack, path), " -> "))
+	}
+
+	cfg, err := parseConfigFile(path, strict)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	if cfg.Extends == "" {
+		return cfg, nil, nil
+	}
+
+	basePath, err := resolveExtends(path, cfg.Extends, namedConfigDir)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	baseCfg, extended, err := loadExtendedConfig(basePath, namedConfigDir, strict, append(slices.Clone(stack), path))
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	merged := mergeConfigs(&baseCfg, &cfg)
+	merged.Extends = ""
+
+	return merged, append([]string{basePath}, extended...), nil
+}
+
+// resolveExtends returns the config file that the "extends" value of the
+// config at fromPath refers to.
+func resolveExtends(fromPath, extends, namedConfigDir string) (string, error) {
+	ext := filepath.Ext(extends)
+	isName := !strings.Contains(extends, "/") && extends != "." && extends != ".." && ext != ".json" && ext != ".jsonc"
+
+	if isName {
+		if namedConfigDir == "" {
+			return "", fmt.Errorf("config %s: extends %q: no global config directory for named configs", fromPath, extends)
+		}
+
+		path, err := findConfigFile(filepath.Join(namedConfigDir, extends), false)
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("config %s: extends %q: no %s.json or %s.jsonc in %s", fromPath, extends, extends, extends, namedConfigDir)
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("config %s: extends %q: %w", fromPath, extends, err)
+		}
+
+		return path, nil
+	}
+
+	path := extends
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(fromPath), path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("config %s: extends %q: %w", fromPath, extends, err)
+	}
+
+	if !info.IsDir() {
+		return path, nil
+	}
+
+	dirConfig, err := findConfigFile(filepath.Join(path, ".agent-sandbox"), false)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("config %s: extends %q: no .agent-sandbox.json or .agent-sandbox.jsonc in %s", fromPath, extends, path)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("config %s: extends %q: %w", fromPath, extends, err)
+	}
+
+	return dirConfig, nil
+}
+
+// recordExtended records the files a config of the given type extends.
+func (c *Config) recordExtended(configType string, extended []string) {
+	if len(extended) == 0 {
+		return
+	}
+
+	if c.ExtendedConfigFiles == nil {
+		c.ExtendedConfigFiles = make(map[string][]string)
+	}
+
+	c.ExtendedConfigFiles[configType] = extended
+}
+
+// recordCommandSources records source as the origin of the rules of the
+// commands set by a config layer.
+func (c *Config) recordCommandSources(commands map[string]CommandRule, source string) {
+	if len(commands) == 0 {
+		return
+	}
+
+	if c.CommandSources == nil {
+		c.CommandSources = make(map[string]string)
+	}
+
+	for name := range commands {
+		c.CommandSources[name] = source
+	}
+}
+
 // mergeConfigs merges override into base, with override taking precedence.
 // Empty/zero values in override do not override base values.
 // Note: LoadedConfigFiles from base is preserved (caller updates it after merge).
@@ -474,6 +1213,8 @@ func mergeConfigs(base, override *Config) Config {
 		result.LoadedConfigFiles = base.LoadedConfigFiles
 	}
 
+	result.Warnings = append(result.Warnings, override.Warnings...)
+
 	if override.Network != nil {
 		result.Network = override.Network
 	}
@@ -482,12 +1223,104 @@ func mergeConfigs(base, override *Config) Config {
 		result.Docker = override.Docker
 	}
 
+	if override.Umask != "" {
+		result.Umask = override.Umask
+	}
+
+	if override.DefaultACL != "" {
+		result.DefaultACL = override.DefaultACL
+	}
+
+	if override.MapSubIDs != nil {
+		result.MapSubIDs = override.MapSubIDs
+	}
+
+	if override.History != nil {
+		result.History = override.History
+	}
+
+	if override.Profile != "" {
+		result.Profile = override.Profile
+	}
+
+	// Merge profiles by name, each like a config layer
+	if len(override.Profiles) > 0 {
+		profiles := make(map[string]Config, len(result.Profiles)+len(override.Profiles))
+		maps.Copy(profiles, result.Profiles)
+
+		for name, profile := range override.Profiles {
+			base := profiles[name]
+			profiles[name] = mergeConfigs(&base, &profile)
+		}
+
+		result.Profiles = profiles
+	}
+
 	// Merge filesystem config: arrays are concatenated per spec
 	// Order matters: base paths first, then override paths (for specificity tie-breaking)
 	result.Filesystem.Presets = append(result.Filesystem.Presets, override.Filesystem.Presets...)
 	result.Filesystem.Ro = append(result.Filesystem.Ro, override.Filesystem.Ro...)
 	result.Filesystem.Rw = append(result.Filesystem.Rw, override.Filesystem.Rw...)
 	result.Filesystem.Exclude = append(result.Filesystem.Exclude, override.Filesystem.Exclude...)
+	result.Filesystem.Masks = append(result.Filesystem.Masks, override.Filesystem.Masks...)
+
+	if override.Filesystem.ExcludeNotice != nil {
+		result.Filesystem.ExcludeNotice = override.Filesystem.ExcludeNotice
+	}
+
+	if override.Filesystem.AllowDangerousMounts != nil {
+		result.Filesystem.AllowDangerousMounts = override.Filesystem.AllowDangerousMounts
+	}
+
+	// Merge labels (later values replace earlier ones with the same key)
+	if len(override.Labels) > 0 {
+		result.Labels = maps.Clone(base.Labels)
+		if result.Labels == nil {
+			result.Labels = make(map[string]string)
+		}
+
+		maps.Copy(result.Labels, override.Labels)
+	}
+
+	// Merge zones map (later definitions replace earlier ones with the same name)
+	if len(override.Zones) > 0 {
+		if result.Zones == nil {
+			result.Zones = make(map[string]NetworkZone)
+		}
+
+		maps.Copy(result.Zones, override.Zones)
+	}
+
+	// Merge artifacts: set fields replace earlier ones, checksums are merged
+	if override.Artifacts != nil {
+		merged := ArtifactsConfig{}
+		if result.Artifacts != nil {
+			merged = *result.Artifacts
+			merged.SHA256 = maps.Clone(merged.SHA256)
+		}
+
+		if override.Artifacts.Dir != "" {
+			merged.Dir = override.Artifacts.Dir
+		}
+
+		if override.Artifacts.URL != "" {
+			merged.URL = override.Artifacts.URL
+		}
+
+		if override.Artifacts.Launcher != "" {
+			merged.Launcher = override.Artifacts.Launcher
+		}
+
+		if len(override.Artifacts.SHA256) > 0 {
+			if merged.SHA256 == nil {
+				merged.SHA256 = make(map[string]string)
+			}
+
+			maps.Copy(merged.SHA256, override.Artifacts.SHA256)
+		}
+
+		result.Artifacts = &merged
+	}
 
 	// Merge commands map (later values override earlier for same key)
 	if len(override.Commands) > 0 {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -18,7 +19,7 @@ func Test_LoadConfig_Returns_Defaults_When_No_Config_Files_Exist(t *testing.T) {
 
 	(&configTestCase{
 		want: Config{
-			Network:  boolPtr(true),
+			Network:  networkPtr(true),
 			Docker:   boolPtr(false),
 			Commands: defaultCommands(),
 		},
@@ -37,13 +38,46 @@ func Test_LoadConfig_Loads_Project_Json_File(t *testing.T) {
 			".agent-sandbox.json": `{"network": false, "docker": true}`,
 		},
 		want: Config{
-			Network:  boolPtr(false),
+			Network:  networkPtr(false),
 			Docker:   boolPtr(true),
 			Commands: defaultCommands(),
 		},
 	}).run(t)
 }
 
+func Test_LoadConfig_Ignores_Schema_Key(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"$schema": "./agent-sandbox.schema.json", "network": false}`,
+		},
+		want: Config{
+			Network:  networkPtr(false),
+			Docker:   boolPtr(false),
+			Commands: defaultCommands(),
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Treats_Empty_Project_File_As_Empty_Config(t *testing.T) {
+	t.Parallel()
+
+	// Missing project configs are masked with empty files while a command
+	// runs; one left behind by a killed run must not break loading.
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json":  `{"network": false}`,
+			".agent-sandbox.jsonc": "",
+		},
+		want: Config{
+			Network:  networkPtr(false),
+			Docker:   boolPtr(false),
+			Commands: defaultCommands(),
+		},
+	}).run(t)
+}
+
 func Test_LoadConfig_Loads_Project_Jsonc_File(t *testing.T) {
 	t.Parallel()
 
@@ -55,7 +89,7 @@ func Test_LoadConfig_Loads_Project_Jsonc_File(t *testing.T) {
 			}`,
 		},
 		want: Config{
-			Network:  boolPtr(false),
+			Network:  networkPtr(false),
 			Docker:   boolPtr(false),
 			Commands: defaultCommands(),
 		},
@@ -74,7 +108,7 @@ func Test_LoadConfig_Allows_Comments_In_Json_Files(t *testing.T) {
 			}`,
 		},
 		want: Config{
-			Network:  boolPtr(true),
+			Network:  networkPtr(true),
 			Docker:   boolPtr(true),
 			Commands: defaultCommands(),
 		},
@@ -93,7 +127,7 @@ func Test_LoadConfig_Loads_Global_Json_File(t *testing.T) {
 			"agent-sandbox/config.json": `{"network": false}`,
 		},
 		want: Config{
-			Network:  boolPtr(false),
+			Network:  networkPtr(false),
 			Docker:   boolPtr(false),
 			Commands: defaultCommands(),
 		},
@@ -111,7 +145,7 @@ func Test_LoadConfig_Loads_Global_Jsonc_File(t *testing.T) {
 			}`,
 		},
 		want: Config{
-			Network:  boolPtr(true),
+			Network:  networkPtr(true),
 			Docker:   boolPtr(true),
 			Commands: defaultCommands(),
 		},
@@ -133,8 +167,8 @@ func Test_LoadConfig_Project_Overrides_Global(t *testing.T) {
 			".agent-sandbox.json": `{"network": true}`,
 		},
 		want: Config{
-			Network:  boolPtr(true), // project overrides
-			Docker:   boolPtr(true), // kept from global
+			Network:  networkPtr(true), // project overrides
+			Docker:   boolPtr(true),    // kept from global
 			Commands: defaultCommands(),
 		},
 	}).run(t)
@@ -153,8 +187,8 @@ func Test_LoadConfig_Explicit_Config_Replaces_Project_But_Not_Global(t *testing.
 		},
 		configPath: "custom.json",
 		want: Config{
-			Network:  boolPtr(false), // from custom.json
-			Docker:   boolPtr(true),  // from global (project skipped)
+			Network:  networkPtr(false), // from custom.json
+			Docker:   boolPtr(true),     // from global (project skipped)
 			Commands: defaultCommands(),
 		},
 	}).run(t)
@@ -179,7 +213,7 @@ func Test_LoadConfig_Sets_All_Filesystem_Fields_From_Project(t *testing.T) {
 			}`,
 		},
 		want: Config{
-			Network: boolPtr(true),
+			Network: networkPtr(true),
 			Docker:  boolPtr(false),
 			Filesystem: FilesystemConfig{
 				Presets: []string{"!@lint/python"},
@@ -217,7 +251,7 @@ func Test_LoadConfig_Concatenates_Global_And_Project_Filesystem_Arrays(t *testin
 			}`,
 		},
 		want: Config{
-			Network: boolPtr(true),
+			Network: networkPtr(true),
 			Docker:  boolPtr(false),
 			Filesystem: FilesystemConfig{
 				Presets: []string{"!@lint/go", "!@lint/python"},
@@ -246,7 +280,7 @@ func Test_LoadConfig_Preserves_Global_Filesystem_When_Project_Is_Empty(t *testin
 			".agent-sandbox.json": `{"network": false}`,
 		},
 		want: Config{
-			Network: boolPtr(false),
+			Network: networkPtr(false),
 			Docker:  boolPtr(false),
 			Filesystem: FilesystemConfig{
 				Ro: []string{"/global/ro"},
@@ -276,7 +310,7 @@ func Test_LoadConfig_Parses_All_Command_Rule_Types(t *testing.T) {
 			}`,
 		},
 		want: Config{
-			Network: boolPtr(true),
+			Network: networkPtr(true),
 			Docker:  boolPtr(false),
 			Commands: map[string]CommandRule{
 				"git":  {Kind: CommandRulePreset, Value: "@git"},
@@ -308,7 +342,7 @@ func Test_LoadConfig_Project_Commands_Override_Global_Commands(t *testing.T) {
 			}`,
 		},
 		want: Config{
-			Network: boolPtr(true),
+			Network: networkPtr(true),
 			Docker:  boolPtr(false),
 			Commands: map[string]CommandRule{
 				"git": {Kind: CommandRuleExplicitAllow}, // project overrides
@@ -330,7 +364,7 @@ func Test_LoadConfig_Project_Commands_Add_To_Defaults(t *testing.T) {
 			}`,
 		},
 		want: Config{
-			Network: boolPtr(true),
+			Network: networkPtr(true),
 			Docker:  boolPtr(false),
 			Commands: map[string]CommandRule{
 				"git": {Kind: CommandRulePreset, Value: "@git"}, // default preserved
@@ -360,7 +394,7 @@ func Test_LoadConfig_Commands_Three_Layer_Override(t *testing.T) {
 			}`,
 		},
 		want: Config{
-			Network: boolPtr(true),
+			Network: networkPtr(true),
 			Docker:  boolPtr(false),
 			Commands: map[string]CommandRule{
 				"git": {Kind: CommandRuleBlock},         // global overrode default
@@ -381,13 +415,407 @@ func Test_LoadConfig_Preserves_Default_Commands_When_Commands_Section_Empty(t *t
 			}`,
 		},
 		want: Config{
-			Network:  boolPtr(false),
+			Network:  networkPtr(false),
+			Docker:   boolPtr(false),
+			Commands: defaultCommands(),
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Project_ExcludeNotice_Overrides_Global(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"filesystem": {"excludeNotice": true}}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.json": `{"filesystem": {"excludeNotice": false}}`,
+		},
+		want: Config{
+			Network:    networkPtr(true),
+			Docker:     boolPtr(false),
+			Commands:   defaultCommands(),
+			Filesystem: FilesystemConfig{ExcludeNotice: boolPtr(false)},
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Project_AllowDangerousMounts_Overrides_Global(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"filesystem": {"allowDangerousMounts": false}}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.json": `{"filesystem": {"allowDangerousMounts": true}}`,
+		},
+		want: Config{
+			Network:    networkPtr(true),
+			Docker:     boolPtr(false),
+			Commands:   defaultCommands(),
+			Filesystem: FilesystemConfig{AllowDangerousMounts: boolPtr(true)},
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Project_Umask_And_DefaultACL_Override_Global(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"umask": "077", "defaultAcl": "private"}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.json": `{"umask": "027", "defaultAcl": "group-read"}`,
+		},
+		want: Config{
+			Network:    networkPtr(true),
+			Docker:     boolPtr(false),
+			Commands:   defaultCommands(),
+			Umask:      "027",
+			DefaultACL: "group-read",
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Project_History_Overrides_Global(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"history": true}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.json": `{"history": false}`,
+		},
+		want: Config{
+			Network:  networkPtr(true),
 			Docker:   boolPtr(false),
 			Commands: defaultCommands(),
+			History:  boolPtr(false),
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Project_MapSubIDs_Overrides_Global(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"mapSubIds": false}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.json": `{"mapSubIds": true}`,
+		},
+		want: Config{
+			Network:   networkPtr(true),
+			Docker:    boolPtr(false),
+			Commands:  defaultCommands(),
+			MapSubIDs: boolPtr(true),
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Rejects_Invalid_Umask(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"umask": "0800"}`,
+		},
+		wantErr: `invalid umask "0800"`,
+	}).run(t)
+}
+
+func Test_LoadConfig_Inline_Masks_And_Command_Scripts(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{
+				"filesystem": {"masks": [{"path": "~/.npmrc", "contentBase64": "cmVnaXN0cnk9eAo="}]},
+				"commands": {"npm": {"contentBase64": "IyEvYmluL3NoCmV4aXQgMQo="}}
+			}`,
+		},
+		want: Config{
+			Network: networkPtr(true),
+			Docker:  boolPtr(false),
+			Commands: map[string]CommandRule{
+				"git": {Kind: CommandRulePreset, Value: "@git"},
+				"npm": {Kind: CommandRuleInline, Value: "#!/bin/sh\nexit 1\n"},
+			},
+			Filesystem: FilesystemConfig{
+				Masks: []MaskConfig{{Path: "~/.npmrc", ContentBase64: "cmVnaXN0cnk9eAo="}},
+			},
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Rejects_Invalid_Inline_Content(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"filesystem": {"masks": [{"path": "~/.npmrc", "contentBase64": "not base64!"}]}}`,
+		},
+		wantErr: `filesystem.masks[0] (~/.npmrc): invalid contentBase64`,
+	}).run(t)
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"commands": {"npm": {"contentBase64": "cHJlc2V0OmdpdAo="}}}`,
+		},
+		wantErr: `inline script must not start with "preset:"`,
+	}).run(t)
+}
+
+func Test_LoadConfig_Rejects_Unknown_DefaultACL(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"defaultAcl": "public"}`,
+		},
+		wantErr: `invalid defaultAcl "public"`,
+	}).run(t)
+}
+
+// =============================================================================
+// Network Zones
+// =============================================================================
+
+func Test_LoadConfig_Resolves_Network_Zone_From_Global_Config(t *testing.T) {
+	t.Parallel()
+
+	zones := map[string]NetworkZone{
+		"github-only": {Allow: []string{"github.com", "*.githubusercontent.com"}},
+	}
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"zones": {"github-only": {"allow": ["github.com", "*.githubusercontent.com"]}}}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.json": `{"network": {"zone": "github-only"}}`,
+		},
+		want: Config{
+			Network:      &NetworkConfig{Enabled: true, Zone: "github-only"},
+			Docker:       boolPtr(false),
+			Commands:     defaultCommands(),
+			Zones:        zones,
+			NetworkAllow: []string{"github.com", "*.githubusercontent.com"},
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Project_Network_Bool_Overrides_Global_Zone(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"network": {"zone": "docs"}, "zones": {"docs": {"allow": ["go.dev"]}}}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.json": `{"network": false}`,
+		},
+		want: Config{
+			Network:  networkPtr(false),
+			Docker:   boolPtr(false),
+			Commands: defaultCommands(),
+			Zones:    map[string]NetworkZone{"docs": {Allow: []string{"go.dev"}}},
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Returns_Error_When_Network_Zone_Undefined(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"zones": {"docs": {"allow": ["go.dev"]}}}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.json": `{"network": {"zone": "github-only"}}`,
+		},
+		wantErr: `network zone "github-only" is not defined (defined zones: docs)`,
+	}).run(t)
+}
+
+func Test_LoadConfig_Returns_Error_When_Zone_Host_Invalid(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"zones": {"bad": {"allow": ["https://github.com"]}}}`,
+		},
+		wantErr: `zone "bad": invalid host "https://github.com"`,
+	}).run(t)
+}
+
+func Test_LoadConfig_Zone_Allow_Accepts_IP_Addresses_And_CIDRs_Of_Both_Families(t *testing.T) {
+	t.Parallel()
+
+	allow := []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "::1"}
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"zones": {"lan": {"allow": ["10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "::1"]}}}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.json": `{"network": {"zone": "lan"}}`,
+		},
+		want: Config{
+			Network:      &NetworkConfig{Enabled: true, Zone: "lan"},
+			Docker:       boolPtr(false),
+			Commands:     defaultCommands(),
+			Zones:        map[string]NetworkZone{"lan": {Allow: allow}},
+			NetworkAllow: allow,
+		},
+	}).run(t)
+
+	for entry, wantErr := range map[string]string{
+		"[::1]":            `write IPv6 addresses without brackets`,
+		"10.1.2.3/8":       `host bits set (use 10.0.0.0/8)`,
+		"fe80::1%eth0":     `zone IDs are not supported`,
+		"::ffff:192.0.2.1": `write IPv4-mapped addresses as 192.0.2.1`,
+		"*.192.0.2.1":      `invalid host "*.192.0.2.1"`,
+	} {
+		(&configTestCase{
+			globalFiles: map[string]string{
+				"agent-sandbox/config.json": `{"zones": {"bad": {"allow": ["` + entry + `"]}}}`,
+			},
+			wantErr: wantErr,
+		}).run(t)
+	}
+}
+
+func Test_LoadConfig_Returns_Error_When_Zone_Allow_Empty(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"zones": {"none": {"allow": []}}}`,
+		},
+		wantErr: `zone "none" has an empty allow list`,
+	}).run(t)
+}
+
+func Test_LoadConfig_Returns_Error_When_Network_Object_Invalid(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"network": {"zones": "github-only"}}`,
+		},
+		wantErr: "network must be boolean or",
+	}).run(t)
+}
+
+// =============================================================================
+// Artifacts
+// =============================================================================
+
+func Test_LoadConfig_Merges_Artifacts_Across_Layers(t *testing.T) {
+	t.Parallel()
+
+	commands := defaultCommands()
+	commands["npm"] = CommandRule{Kind: CommandRuleScript, Value: "artifact:wrappers/npm.sh"}
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"artifacts": {"dir": "~/.cache/policy", "url": "https://policy.example.com/v1", "sha256": {"launcher": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}}}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.json": `{"artifacts": {"launcher": "launcher", "sha256": {"wrappers/npm.sh": "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}}, "commands": {"npm": "artifact:wrappers/npm.sh"}}`,
+		},
+		want: Config{
+			Network:  networkPtr(true),
+			Docker:   boolPtr(false),
+			Commands: commands,
+			Artifacts: &ArtifactsConfig{
+				Dir:      "~/.cache/policy",
+				URL:      "https://policy.example.com/v1",
+				Launcher: "launcher",
+				SHA256: map[string]string{
+					"launcher":        "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+					"wrappers/npm.sh": "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+				},
+			},
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Returns_Error_When_Artifact_Rule_Without_Artifacts(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"commands": {"npm": "artifact:wrappers/npm.sh"}}`,
+		},
+		wantErr: `"npm" uses artifact:wrappers/npm.sh but no artifacts are configured`,
+	}).run(t)
+}
+
+func Test_LoadConfig_Returns_Error_When_Artifact_Checksum_Invalid(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"artifacts": {"sha256": {"launcher": "ABC"}}}`,
+		},
+		wantErr: `sha256 for "launcher" must be 64 lowercase hex characters`,
+	}).run(t)
+}
+
+func Test_LoadConfig_Returns_Error_When_Artifact_URL_Not_HTTP(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"artifacts": {"url": "file:///srv/policy"}}`,
 		},
+		wantErr: `url "file:///srv/policy" must use http or https`,
 	}).run(t)
 }
 
+func Test_ResolveArtifacts_Replaces_Artifact_Rules_With_Cached_Paths(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	dir := filepath.Join(home, "policy")
+	mustMkdir(t, filepath.Join(dir, "wrappers"))
+	mustWriteFile(t, filepath.Join(dir, "wrappers", "npm.sh"), "#!/bin/sh\n")
+	mustWriteFile(t, filepath.Join(dir, "launcher"), "launcher")
+
+	cfg := Config{
+		Commands: map[string]CommandRule{
+			"npm": {Kind: CommandRuleScript, Value: "artifact:wrappers/npm.sh"},
+			"rm":  {Kind: CommandRuleBlock},
+		},
+		Artifacts: &ArtifactsConfig{Dir: "~/policy", Launcher: "launcher"},
+	}
+
+	err := resolveArtifacts(t.Context(), &cfg, map[string]string{}, home)
+	if err != nil {
+		t.Fatalf("resolveArtifacts: %v", err)
+	}
+
+	want := map[string]CommandRule{
+		"npm": {Kind: CommandRuleScript, Value: filepath.Join(dir, "wrappers", "npm.sh")},
+		"rm":  {Kind: CommandRuleBlock},
+	}
+
+	if diff := cmp.Diff(want, cfg.Commands); diff != "" {
+		t.Errorf("commands mismatch (-want +got):\n%s", diff)
+	}
+
+	if cfg.ArtifactLauncher != filepath.Join(dir, "launcher") {
+		t.Errorf("ArtifactLauncher = %q", cfg.ArtifactLauncher)
+	}
+}
+
 // =============================================================================
 // Errors
 // =============================================================================
@@ -502,50 +930,112 @@ func Test_LoadConfig_Error_Message_Includes_File_Path(t *testing.T) {
 	}).run(t)
 }
 
-func Test_LoadConfig_Returns_Error_When_Top_Level_Field_Is_Unknown(t *testing.T) {
+func Test_LoadConfig_Returns_Error_When_Top_Level_Field_Is_Unknown_And_Strict(t *testing.T) {
 	t.Parallel()
 
 	(&configTestCase{
 		files: map[string]string{
 			".agent-sandbox.json": `{"readonly": ["Makefile"]}`,
 		},
+		strict:  true,
 		wantErr: `unknown field "readonly"`,
 	}).run(t)
 }
 
-func Test_LoadConfig_Returns_Error_When_Global_Config_Has_Unknown_Field(t *testing.T) {
+func Test_LoadConfig_Returns_Error_When_Global_Config_Has_Unknown_Field_And_Strict(t *testing.T) {
 	t.Parallel()
 
 	(&configTestCase{
 		globalFiles: map[string]string{
 			"agent-sandbox/config.json": `{"unknown_option": true}`,
 		},
+		strict:  true,
 		wantErr: `unknown field "unknown_option"`,
 	}).run(t)
 }
 
-func Test_LoadConfig_Returns_Error_When_Filesystem_Has_Unknown_Nested_Field(t *testing.T) {
+func Test_LoadConfig_Returns_Error_When_Filesystem_Has_Unknown_Nested_Field_And_Strict(t *testing.T) {
 	t.Parallel()
 
 	(&configTestCase{
 		files: map[string]string{
 			".agent-sandbox.json": `{"filesystem": {"readonly": ["Makefile"]}}`,
 		},
+		strict:  true,
 		wantErr: `unknown field "readonly"`,
 	}).run(t)
 }
 
-func Test_LoadConfig_Returns_Error_When_Field_Name_Is_Misspelled(t *testing.T) {
+func Test_LoadConfig_Returns_Error_When_Field_Name_Is_Misspelled_And_Strict(t *testing.T) {
 	t.Parallel()
 
 	(&configTestCase{
 		files: map[string]string{
 			".agent-sandbox.json": `{"netwrok": true}`,
 		},
+		strict:  true,
 		wantErr: `unknown field "netwrok"`,
 	}).run(t)
 }
 
+func Test_LoadConfig_Warns_When_Field_Is_Unknown(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"network": false, "filesystem": {"readonly": ["Makefile"]}}`,
+		},
+		want: Config{
+			Network:  networkPtr(false),
+			Docker:   boolPtr(false),
+			Commands: defaultCommands(),
+		},
+		wantWarning: `unknown field "filesystem.readonly"`,
+	}).run(t)
+}
+
+// =============================================================================
+// Versioning
+// =============================================================================
+
+func Test_LoadConfig_Accepts_Current_Version(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"version": 1, "docker": true}`,
+		},
+		strict: true,
+		want: Config{
+			Network:  networkPtr(true),
+			Docker:   boolPtr(true),
+			Commands: defaultCommands(),
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Returns_Error_When_Version_Is_Newer_Than_Supported(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"version": 99}`,
+		},
+		wantErr: "version 99 is newer than the supported version",
+	}).run(t)
+}
+
+func Test_LoadConfig_Returns_Error_When_Version_Is_Not_An_Integer(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"version": "1"}`,
+		},
+		wantErr: "version must be a non-negative integer",
+	}).run(t)
+}
+
 // =============================================================================
 // Metadata Tracking (path-dependent, tested separately)
 // =============================================================================
@@ -623,6 +1113,93 @@ func Test_LoadConfig_Tracks_Explicit_Config_And_Skips_Project(t *testing.T) {
 	}
 }
 
+func Test_LoadConfig_Project_Extends_Base_Configs_Recursively(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/team.jsonc": `{"docker": true, "filesystem": {"ro": ["~/team"]}}`,
+		},
+		files: map[string]string{
+			"shared/.agent-sandbox.jsonc": `{
+				// Named config from the global config directory
+				"extends": "team",
+				"network": false,
+				"filesystem": {"rw": ["shared-rw"]},
+				"commands": {"rm": false}
+			}`,
+			".agent-sandbox.json": `{"extends": "./shared", "network": true, "filesystem": {"rw": ["own-rw"]}}`,
+		},
+		want: Config{
+			Network: networkPtr(true),
+			Docker:  boolPtr(true),
+			Filesystem: FilesystemConfig{
+				Ro: []string{"~/team"},
+				Rw: []string{"shared-rw", "own-rw"},
+			},
+			Commands: map[string]CommandRule{
+				"git": {Kind: CommandRulePreset, Value: "@git"},
+				"rm":  {Kind: CommandRuleBlock},
+			},
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Rejects_Extends_Cycle(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"extends": "a.json"}`,
+			"a.json":              `{"extends": "./b.jsonc"}`,
+			"b.jsonc":             `{"extends": ".agent-sandbox.json"}`,
+		},
+		wantErr: "config extends cycle",
+	}).run(t)
+}
+
+func Test_LoadConfig_Rejects_Missing_Extends(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"extends": "no-such-team"}`,
+		},
+		wantErr: `extends "no-such-team": no no-such-team.json or no-such-team.jsonc`,
+	}).run(t)
+}
+
+func Test_LoadConfig_Tracks_Extended_Config_Paths(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	xdgConfigHome := t.TempDir()
+
+	basePath := filepath.Join(workDir, "base", "shared.jsonc")
+	mustMkdir(t, filepath.Dir(basePath))
+	mustWriteFile(t, basePath, `{}`)
+
+	explicitPath := filepath.Join(workDir, "explicit.json")
+	mustWriteFile(t, explicitPath, `{"extends": "base/shared.jsonc"}`)
+
+	got, err := LoadConfig(LoadConfigInput{
+		WorkDirOverride: workDir,
+		ConfigPath:      "explicit.json",
+		EnvVars:         map[string]string{"XDG_CONFIG_HOME": xdgConfigHome},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{basePath}, got.ExtendedConfigFiles["explicit"]); diff != "" {
+		t.Errorf("ExtendedConfigFiles[explicit] mismatch (-want +got):\n%s", diff)
+	}
+
+	if got.Extends != "" {
+		t.Errorf("Extends = %q, want empty after loading", got.Extends)
+	}
+}
+
 func Test_LoadConfig_Tracks_Global_And_Project_Filesystem_Separately(t *testing.T) {
 	t.Parallel()
 
@@ -659,13 +1236,182 @@ func Test_LoadConfig_Tracks_Global_And_Project_Filesystem_Separately(t *testing.
 	}
 }
 
+// =============================================================================
+// Profiles
+// =============================================================================
+
+func Test_LoadConfig_Applies_Selected_Profile_On_Top_Of_Top_Level_Config(t *testing.T) {
+	t.Parallel()
+
+	ci := Config{Network: networkPtr(false), Filesystem: FilesystemConfig{Presets: []string{"!@lint/all"}}}
+	dev := Config{Docker: boolPtr(true), Filesystem: FilesystemConfig{Rw: []string{"global-dev-rw", "dev-rw"}}}
+
+	(&configTestCase{
+		globalFiles: map[string]string{
+			"agent-sandbox/config.json": `{"profiles": {"dev": {"filesystem": {"rw": ["global-dev-rw"]}}}}`,
+		},
+		files: map[string]string{
+			".agent-sandbox.jsonc": `{
+				"profile": "dev",
+				"filesystem": {"rw": ["own-rw"]},
+				"profiles": {
+					"ci": {"network": false, "filesystem": {"presets": ["!@lint/all"]}},
+					"dev": {"docker": true, "filesystem": {"rw": ["dev-rw"]}}
+				}
+			}`,
+		},
+		want: Config{
+			Network:    networkPtr(true),
+			Docker:     boolPtr(true),
+			Filesystem: FilesystemConfig{Rw: []string{"own-rw", "global-dev-rw", "dev-rw"}},
+			Commands:   defaultCommands(),
+			Profile:    "dev",
+			Profiles:   map[string]Config{"ci": ci, "dev": dev},
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Profile_Flag_Overrides_Config_Profile(t *testing.T) {
+	t.Parallel()
+
+	ci := Config{Network: networkPtr(false)}
+	dev := Config{Docker: boolPtr(true)}
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"profile": "dev", "profiles": {"ci": {"network": false}, "dev": {"docker": true}}}`,
+		},
+		profile: "ci",
+		want: Config{
+			Network:  networkPtr(false),
+			Docker:   boolPtr(false),
+			Commands: defaultCommands(),
+			Profile:  "ci",
+			Profiles: map[string]Config{"ci": ci, "dev": dev},
+		},
+	}).run(t)
+}
+
+func Test_LoadConfig_Rejects_Undefined_Profile(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"profiles": {"ci": {"network": false}, "dev": {}}}`,
+		},
+		profile: "prod",
+		wantErr: `profile "prod" is not defined (defined profiles: ci, dev)`,
+	}).run(t)
+}
+
+func Test_LoadConfig_Rejects_Nested_Profiles(t *testing.T) {
+	t.Parallel()
+
+	(&configTestCase{
+		files: map[string]string{
+			".agent-sandbox.json": `{"profiles": {"ci": {"profiles": {"inner": {}}}}}`,
+		},
+		wantErr: `profile "ci" must not select or define profiles`,
+	}).run(t)
+}
+
+func Test_LoadConfig_Tracks_Profile_Filesystem_Separately(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+
+	mustWriteFile(t, filepath.Join(workDir, ".agent-sandbox.json"),
+		`{"filesystem": {"ro": ["/project/path"]}, "profiles": {"ci": {"filesystem": {"ro": ["/profile/path"]}}}}`)
+
+	got, err := LoadConfig(LoadConfigInput{
+		WorkDirOverride: workDir,
+		EnvVars:         map[string]string{"XDG_CONFIG_HOME": t.TempDir()},
+		Profile:         "ci",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(FilesystemConfig{Ro: []string{"/project/path"}}, got.ProjectFilesystem); diff != "" {
+		t.Errorf("ProjectFilesystem mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(FilesystemConfig{Ro: []string{"/profile/path"}}, got.ProfileFilesystem); diff != "" {
+		t.Errorf("ProfileFilesystem mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_LoadConfig_Tracks_Command_Rule_Sources(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	projectConfig := filepath.Join(workDir, ".agent-sandbox.json")
+
+	mustWriteFile(t, projectConfig, `{"commands": {"rm": false, "curl": false}}`)
+
+	flags := newFlagSet()
+	addRunFlags(flags)
+
+	err := flags.Parse([]string{"--block", "curl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadConfig(LoadConfigInput{
+		WorkDirOverride: workDir,
+		EnvVars:         map[string]string{"XDG_CONFIG_HOME": t.TempDir()},
+		CLIFlags:        flags,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"rm": "project config " + projectConfig, "curl": "--block flag"}
+	if diff := cmp.Diff(want, got.CommandSources); diff != "" {
+		t.Errorf("CommandSources mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_LoadConfig_Merges_Labels_By_Key_With_Label_Flag_Last(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	xdgConfigHome := t.TempDir()
+
+	mustMkdir(t, filepath.Join(xdgConfigHome, "agent-sandbox"))
+	mustWriteFile(t, filepath.Join(xdgConfigHome, "agent-sandbox", "config.json"), `{"labels": {"agent": "coder", "team": "infra"}}`)
+	mustWriteFile(t, filepath.Join(workDir, ".agent-sandbox.json"), `{"labels": {"team": "web", "task-id": "T-1"}}`)
+
+	flags := newFlagSet()
+	addRunFlags(flags)
+
+	err := flags.Parse([]string{"--label", "task-id=T-2", "--label", "run-id=r=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadConfig(LoadConfigInput{
+		WorkDirOverride: workDir,
+		EnvVars:         map[string]string{"XDG_CONFIG_HOME": xdgConfigHome},
+		CLIFlags:        flags,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"agent": "coder", "team": "web", "task-id": "T-2", "run-id": "r=1"}
+	if diff := cmp.Diff(want, got.Labels); diff != "" {
+		t.Errorf("Labels mismatch (-want +got):\n%s", diff)
+	}
+}
+
 // =============================================================================
 // Test Helpers
 // =============================================================================
 
 // cmpConfig compares Config structs, ignoring fields that vary per test (paths, etc.)
 var cmpConfig = cmp.Options{
-	cmpopts.IgnoreFields(Config{}, "EffectiveCwd", "LoadedConfigFiles", "GlobalFilesystem", "ProjectFilesystem"),
+	cmpopts.IgnoreFields(Config{}, "EffectiveCwd", "LoadedConfigFiles", "ExtendedConfigFiles", "GlobalFilesystem", "ProjectFilesystem", "ProfileFilesystem", "CommandSources", "Warnings"),
 }
 
 // configTestCase defines a single LoadConfig test.
@@ -673,8 +1419,11 @@ type configTestCase struct {
 	files       map[string]string // relative to workDir
 	globalFiles map[string]string // relative to XDG_CONFIG_HOME
 	configPath  string            // --config flag
+	strict      bool              // --strict-config flag
+	profile     string            // --profile flag
 	want        Config
 	wantErr     string
+	wantWarning string // substring of a Config.Warnings entry
 }
 
 // run executes the test case.
@@ -700,6 +1449,8 @@ func (tc *configTestCase) run(t *testing.T) {
 		WorkDirOverride: workDir,
 		ConfigPath:      tc.configPath,
 		EnvVars:         map[string]string{"XDG_CONFIG_HOME": xdgConfigHome},
+		StrictConfig:    tc.strict,
+		Profile:         tc.profile,
 	})
 
 	if tc.wantErr != "" {
@@ -721,6 +1472,18 @@ func (tc *configTestCase) run(t *testing.T) {
 	if diff := cmp.Diff(tc.want, got, cmpConfig); diff != "" {
 		t.Errorf("Config mismatch (-want +got):\n%s", diff)
 	}
+
+	if tc.wantWarning == "" {
+		if len(got.Warnings) > 0 {
+			t.Errorf("unexpected warnings: %q", got.Warnings)
+		}
+
+		return
+	}
+
+	if !slices.ContainsFunc(got.Warnings, func(w string) bool { return strings.Contains(w, tc.wantWarning) }) {
+		t.Errorf("want warning containing %q, got %q", tc.wantWarning, got.Warnings)
+	}
 }
 
 // defaultCommands returns the default commands config for test expectations.
@@ -733,3 +1496,7 @@ func defaultCommands() map[string]CommandRule {
 func boolPtr(b bool) *bool {
 	return &b
 }
+
+func networkPtr(enabled bool) *NetworkConfig {
+	return &NetworkConfig{Enabled: enabled}
+}
@@ -78,29 +78,40 @@ func (d *DebugLogger) Config(cfg *Config, flags FlagChecker) {
 	} else {
 		if path, ok := cfg.LoadedConfigFiles["global"]; ok {
 			d.Logf("Global config: %s", path)
+			d._logExtended(cfg.ExtendedConfigFiles["global"])
 		} else {
 			d.Logf("Global config: (not found)")
 		}
 
 		if path, ok := cfg.LoadedConfigFiles["explicit"]; ok {
 			d.Logf("Explicit config (--config): %s", path)
+			d._logExtended(cfg.ExtendedConfigFiles["explicit"])
 		} else if path, ok := cfg.LoadedConfigFiles["project"]; ok {
 			d.Logf("Project config: %s", path)
+			d._logExtended(cfg.ExtendedConfigFiles["project"])
 		} else {
 			d.Logf("Project config: (not found)")
 		}
 	}
 
+	if cfg.Profile != "" {
+		d.Logf("Profile: %s", cfg.Profile)
+	}
+
 	d.Phase("config-merge")
 
 	networkSource := _configSource(cfg.LoadedConfigFiles, "network", flags)
 
 	networkVal := true
 	if cfg.Network != nil {
-		networkVal = *cfg.Network
+		networkVal = cfg.Network.Enabled
 	}
 
-	d.Logf("network: %t (%s)", networkVal, networkSource)
+	if cfg.Network != nil && cfg.Network.Zone != "" {
+		d.Logf("network: zone %q allow=%v (%s)", cfg.Network.Zone, cfg.NetworkAllow, networkSource)
+	} else {
+		d.Logf("network: %t (%s)", networkVal, networkSource)
+	}
 
 	dockerSource := _configSource(cfg.LoadedConfigFiles, "docker", flags)
 
@@ -124,6 +135,12 @@ func (d *DebugLogger) Config(cfg *Config, flags FlagChecker) {
 	}
 }
 
+func (d *DebugLogger) _logExtended(extended []string) {
+	for _, path := range extended {
+		d.Logf("  extends: %s", path)
+	}
+}
+
 func (d *DebugLogger) LogSandboxCommand(commands map[string]CommandRule, args []string) {
 	if d == nil || !d.Enabled() {
 		return
@@ -174,6 +191,8 @@ func _commandRuleDescription(rule CommandRule) string {
 		return rule.Value + " (built-in wrapper)"
 	case CommandRuleScript:
 		return rule.Value + " (custom script)"
+	case CommandRuleInline:
+		return fmt.Sprintf("inline script, %d bytes", len(rule.Value))
 	default:
 		return "unknown"
 	}
@@ -0,0 +1,146 @@
+package main
+
+// This file implements denial reports: after a command exits, every blocked
+// command it invoked is explained on stderr with the config layer that
+// blocked it and a config fragment that allows it.
+//
+// Blocked-command shims append the command name to the block log (see
+// sandbox.Commands.BlockLog), which is read once the command has exited.
+// With "run --json" the report is printed as JSON lines instead.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// denialLog is the block log of one run.
+type denialLog struct {
+	dir string
+}
+
+func newDenialLog() (*denialLog, error) {
+	dir, err := os.MkdirTemp("", "agent-sandbox-denials-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating denial log: %w", err)
+	}
+
+	log := &denialLog{dir: dir}
+
+	err = os.WriteFile(log.path(), nil, 0o600)
+	if err != nil {
+		_ = log.close()
+
+		return nil, fmt.Errorf("creating denial log: %w", err)
+	}
+
+	return log, nil
+}
+
+func (d *denialLog) path() string {
+	return filepath.Join(d.dir, "blocked.log")
+}
+
+func (d *denialLog) close() error {
+	return os.RemoveAll(d.dir)
+}
+
+// denial explains one blocked command.
+type denial struct {
+	Command string `json:"command"`
+
+	// Count is the number of blocked invocations.
+	Count int `json:"count"`
+
+	// Source is the config layer whose rule blocked the command.
+	Source string `json:"source"`
+
+	// Suggestion is a config fragment that allows the command.
+	Suggestion string `json:"suggestion"`
+}
+
+// readDenials returns the blocked commands recorded in the block log at path,
+// sorted by name. Names without a block rule in cfg (e.g. from an outer
+// sandbox) are skipped.
+func readDenials(path string, cfg *Config) ([]denial, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading denials: %w", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	counts := make(map[string]int)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name := scanner.Text()
+		if rule, ok := cfg.Commands[name]; ok && rule.Kind == CommandRuleBlock {
+			counts[name]++
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("reading denials: %w", err)
+	}
+
+	denials := make([]denial, 0, len(counts))
+
+	for name, count := range counts {
+		source := cfg.CommandSources[name]
+		if source == "" {
+			source = "default config"
+		}
+
+		fragment, err := json.Marshal(map[string]map[string]bool{"commands": {name: true}})
+		if err != nil {
+			return nil, fmt.Errorf("reading denials: %w", err)
+		}
+
+		denials = append(denials, denial{Command: name, Count: count, Source: source, Suggestion: string(fragment)})
+	}
+
+	slices.SortFunc(denials, func(a, b denial) int { return strings.Compare(a.Command, b.Command) })
+
+	return denials, nil
+}
+
+// writeDenials prints denials to out, as JSON lines if asJSON is set.
+func writeDenials(out io.Writer, denials []denial, asJSON bool) {
+	if asJSON {
+		for _, d := range denials {
+			line, err := json.Marshal(d)
+			if err == nil {
+				fprintf(out, "%s\n", line)
+			}
+		}
+
+		return
+	}
+
+	label, hint, reset := "", "", ""
+	if colorEnabled(out) {
+		label, hint, reset = "\033[33m", "\033[2m", "\033[0m"
+	}
+
+	for _, d := range denials {
+		times := "once"
+		if d.Count > 1 {
+			times = fmt.Sprintf("%d times", d.Count)
+		}
+
+		fprintf(out, "%sagent-sandbox: blocked:%s %s (%s) by %s\n", label, reset, d.Command, times, d.Source)
+		fprintf(out, "%s  to allow it, add to your config:%s %s\n", hint, reset, d.Suggestion)
+	}
+}
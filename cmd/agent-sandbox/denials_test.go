@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Denials_Explain_Blocked_Commands_With_Source_And_Suggestion(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("FORCE_COLOR", "")
+
+	path := filepath.Join(t.TempDir(), "blocked.log")
+
+	err := os.WriteFile(path, []byte("rm\ncurl\nrm\nwrapped\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Commands: map[string]CommandRule{
+			"rm":      {Kind: CommandRuleBlock},
+			"curl":    {Kind: CommandRuleBlock},
+			"wrapped": {Kind: CommandRuleScript, Value: "/bin/wrapper"},
+		},
+		CommandSources: map[string]string{"rm": "project config /repo/.agent-sandbox.json"},
+	}
+
+	denials, err := readDenials(path, cfg)
+	if err != nil {
+		t.Fatalf("readDenials: %v", err)
+	}
+
+	var out bytes.Buffer
+
+	writeDenials(&out, denials, false)
+
+	AssertContains(t, out.String(), "agent-sandbox: blocked: curl (once) by default config")
+	AssertContains(t, out.String(), "agent-sandbox: blocked: rm (2 times) by project config /repo/.agent-sandbox.json")
+	AssertContains(t, out.String(), `{"commands":{"rm":true}}`)
+
+	if strings.Contains(out.String(), "wrapped") || strings.Contains(out.String(), "\033[") {
+		t.Errorf("expected only blocked commands and no colors, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	writeDenials(&out, denials, true)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one JSON line per command, got:\n%s", out.String())
+	}
+
+	var got denial
+
+	err = json.Unmarshal([]byte(lines[1]), &got)
+	if err != nil || got.Command != "rm" || got.Count != 2 || got.Suggestion != `{"commands":{"rm":true}}` {
+		t.Errorf("unexpected JSON denial %+v (err=%v)", got, err)
+	}
+
+	t.Setenv("FORCE_COLOR", "1")
+	out.Reset()
+	writeDenials(&out, denials, false)
+
+	if !strings.Contains(out.String(), "\033[33magent-sandbox: blocked:") {
+		t.Errorf("expected FORCE_COLOR to color the report, got:\n%s", out.String())
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	out.Reset()
+	writeDenials(&out, denials, false)
+
+	if strings.Contains(out.String(), "\033[") {
+		t.Errorf("expected NO_COLOR to win over FORCE_COLOR, got:\n%s", out.String())
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
 )
 
 func runBinaryAtPathWithEnv(t *testing.T, binary string, env map[string]string, args ...string) (string, string, int) {
@@ -251,8 +253,8 @@ func Test_Git_Preset_Blocks_Checkout_When_Enabled(t *testing.T) {
 
 	_, stderr, code := RunBinaryWithEnv(t, env, "-C", workDir, "git", "checkout", ".")
 
-	if code == 0 {
-		t.Error("checkout should be blocked")
+	if code != sandbox.ExitDenied {
+		t.Errorf("checkout should be blocked with exit code %d, got %d", sandbox.ExitDenied, code)
 	}
 
 	if !strings.Contains(stderr, "blocked") {
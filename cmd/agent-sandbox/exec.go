@@ -10,8 +10,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"testing/fstest"
+	"time"
 
 	"github.com/calvinalkan/agent-sandbox/sandbox"
 )
@@ -45,6 +48,25 @@ type ExecuteSandboxInput struct {
 	Args   []string
 	Debug  *DebugLogger
 	DryRun bool
+
+	// Verbose prints the resolved sandbox plan (mounts and command) to Stderr
+	// before running.
+	Verbose bool
+
+	// Learn runs the command in audit mode and prints a suggested config for
+	// what the policy would have denied to Stdout (see learn.go). The
+	// command's own stdout goes to Stderr.
+	Learn bool
+
+	// GitHubActions applies sandbox.FromGitHubActions and appends step
+	// outputs to $GITHUB_OUTPUT after the command exits (see github.go).
+	GitHubActions bool
+
+	// JSON prints the report of blocked commands as JSON lines instead of
+	// text (see denials.go). With DryRun, it prints the bwrap arguments
+	// grouped by planning step (see sandbox.Sandbox.DebugArgs) instead of
+	// the command line.
+	JSON bool
 }
 
 func ExecuteSandbox(ctx context.Context, input *ExecuteSandboxInput) (int, error) {
@@ -87,18 +109,69 @@ func ExecuteSandbox(ctx context.Context, input *ExecuteSandboxInput) (int, error
 		HomeDir: homeDir,
 		WorkDir: cfg.EffectiveCwd,
 		HostEnv: withAgentSandboxOnPath(env),
+		WSL:     sandbox.DetectWSL(),
 	}
 
 	if debug != nil && debug.Enabled() {
 		debug.Phase("sandbox")
 	}
 
-	sb, err := newSandbox(cfg, sandboxEnv, debug)
+	err = resolveArtifacts(ctx, cfg, env, homeDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var learn *learnSession
+
+	if input.Learn {
+		learn, err = newLearnSession()
+		if err != nil {
+			return 0, err
+		}
+
+		defer func() { _ = learn.close() }()
+
+		// The suggestion is written to stdout; keep it separate from the
+		// command's output.
+		stdout = stderr
+	}
+
+	var gha *gitHubActionsRun
+
+	if input.GitHubActions {
+		gha, err = newGitHubActionsRun()
+		if err != nil {
+			return 0, err
+		}
+
+		defer func() { _ = gha.close() }()
+	}
+
+	// Learn reports blocked commands as a suggestion, and --github-actions
+	// has its own block log.
+	var denials *denialLog
+
+	if learn == nil && gha == nil && hasBlockRule(cfg.Commands) {
+		denials, err = newDenialLog()
+		if err != nil {
+			return 0, err
+		}
+
+		defer func() { _ = denials.close() }()
+	}
+
+	missingConfigs := missingPaths(protectedConfigPaths(cfg, sandboxEnv.WorkDir))
+
+	sb, err := newSandbox(cfg, sandboxEnv, debug, learn, gha, denials)
 	if err != nil {
 		return 0, err
 	}
 
-	cmd, cleanup, err := sb.Command(ctx, args)
+	if !dryRun {
+		defer removeConfigMountPoints(missingConfigs)
+	}
+
+	cmd, cleanup, err := sb.CommandSpec(ctx, sandbox.RunSpec{Argv: args, DefaultACL: cfg.DefaultACL != "" && !dryRun})
 	if err != nil {
 		if cleanup != nil {
 			cleanupErr := cleanup()
@@ -126,25 +199,150 @@ func ExecuteSandbox(ctx context.Context, input *ExecuteSandboxInput) (int, error
 	args = cmd.Args
 	debug.LogSandboxCommand(cfg.Commands, args)
 
+	if input.Verbose {
+		printSandboxPlan(stderr, sb, args)
+	}
+
 	if debug != nil && debug.Enabled() {
 		debug.Phase("process")
 		debug.Logf("starting")
 	}
 
+	if dryRun && input.JSON {
+		groups, err := sb.DebugArgs(ctx, input.Args)
+		if err != nil {
+			return 0, fmt.Errorf("preparing sandbox command: %w", err)
+		}
+
+		return writeJSON(stdout, stderr, groups), nil
+	}
+
 	if dryRun {
 		fprintf(stdout, "%s\n", strings.Join(args, " "))
 
 		return 0, nil
 	}
 
+	started := time.Now()
+
+	if learn != nil {
+		learn.start()
+	}
+
 	exitCode, err := runBwrapProcess(ctx, cmd, stderr, debug)
+
+	if cfg.History != nil && *cfg.History {
+		recordHistory(stderr, sb, sandbox.HistoryEntry{
+			Time:     started,
+			Argv:     input.Args,
+			WorkDir:  cfg.EffectiveCwd,
+			Policy:   sb.Fingerprint(),
+			Duration: time.Since(started),
+			ExitCode: exitCode,
+			Labels:   sb.Labels(),
+		}, err)
+	}
+
 	if err != nil {
 		return 0, err
 	}
 
+	if learn == nil {
+		reportDenials(stderr, cfg, denials, gha, input.JSON)
+	}
+
+	if gha != nil {
+		err = gha.writeOutputs(env, exitCode)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if learn != nil {
+		err = learn.writeSuggestion(input.Stdout, sb, cfg, input.Args, homeDir)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	return exitCode, nil
 }
 
+// reportDenials prints the blocked commands of the run, read from the block
+// log of denials or gha. Failures only warn.
+func reportDenials(stderr io.Writer, cfg *Config, denials *denialLog, gha *gitHubActionsRun, asJSON bool) {
+	var path string
+
+	switch {
+	case denials != nil:
+		path = denials.path()
+	case gha != nil:
+		path = gha.blockLogPath()
+	default:
+		return
+	}
+
+	report, err := readDenials(path, cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: could not report blocked commands: %v\n", err)
+
+		return
+	}
+
+	writeDenials(stderr, report, asJSON)
+}
+
+// hasBlockRule reports whether commands blocks any command.
+func hasBlockRule(commands map[string]CommandRule) bool {
+	for _, rule := range commands {
+		if rule.Kind == CommandRuleBlock {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordHistory appends entry to the history file in the project state
+// directory. Failures only warn: a broken history file must not fail the
+// command.
+func recordHistory(stderr io.Writer, sb *sandbox.Sandbox, entry sandbox.HistoryEntry, runErr error) {
+	if runErr != nil {
+		entry.ExitCode = -1
+		entry.Error = runErr.Error()
+	}
+
+	stateDir, err := sb.StateDir()
+	if err == nil {
+		err = sandbox.AppendHistory(filepath.Join(stateDir, sandbox.HistoryFileName), entry)
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: could not record command history: %v\n", err)
+	}
+}
+
+// printSandboxPlan writes the resolved mounts, in the order bwrap applies
+// them, followed by the full command line.
+func printSandboxPlan(out io.Writer, sb *sandbox.Sandbox, args []string) {
+	mounts := sb.Mounts()
+
+	fprintf(out, "plan: %d mounts\n", len(mounts))
+
+	for _, m := range mounts {
+		switch {
+		case m.Src != "":
+			fprintf(out, "  %-13s %s -> %s\n", m.Kind, m.Src, m.Dst)
+		case m.Kind == sandbox.MountRoBindData || m.Perms != 0:
+			fprintf(out, "  %-13s %s (%04o)\n", m.Kind, m.Dst, m.Perms.Perm())
+		default:
+			fprintf(out, "  %-13s %s\n", m.Kind, m.Dst)
+		}
+	}
+
+	fprintf(out, "plan: command: %s\n", strings.Join(args, " "))
+}
+
 // runBwrapProcess starts the bwrap process and handles shutdown signals.
 func runBwrapProcess(ctx context.Context, cmd *exec.Cmd, stderr io.Writer, _ *DebugLogger) (int, error) {
 	if ctx.Err() != nil {
@@ -223,39 +421,55 @@ func runBwrapProcess(ctx context.Context, cmd *exec.Cmd, stderr io.Writer, _ *De
 	}
 }
 
-func newSandbox(cfg *Config, env sandbox.Environment, debug *DebugLogger) (*sandbox.Sandbox, error) {
+func newSandbox(cfg *Config, env sandbox.Environment, debug *DebugLogger, learn *learnSession, gha *gitHubActionsRun, denials *denialLog) (*sandbox.Sandbox, error) {
 	if cfg == nil {
 		return nil, errors.New("nil config")
 	}
 
+	var network *bool
+
+	if cfg.Network != nil {
+		network = &cfg.Network.Enabled
+	}
+
 	selfBinary, err := getSelfBinary()
 	if err != nil {
 		return nil, err
 	}
 
+	if cfg.ArtifactLauncher != "" {
+		selfBinary = cfg.ArtifactLauncher
+	}
+
 	mounts := make([]sandbox.Mount, 0, 32)
 
 	// Filesystem policy mounts in precedence order.
 	//
-	// We intentionally keep global/project config filesystem paths separate so that
+	// We intentionally keep global/project/profile config filesystem paths separate so that
 	// later config layers reliably override earlier ones, even when access levels
 	// differ (e.g. global "rw" vs project "ro").
 	mounts = append(mounts, mountsFromConfig(&cfg.GlobalFilesystem)...)
 	mounts = append(mounts, mountsFromConfig(&cfg.ProjectFilesystem)...)
+	mounts = append(mounts, mountsFromConfig(&cfg.ProfileFilesystem)...)
 	mounts = append(mounts, mountsFromConfig(&cfg.CLIFilesystem)...)
 
-	for _, p := range getLoadedConfigPaths(cfg) {
+	masks, err := maskMounts(cfg.Filesystem.Masks, env.HomeDir, env.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts = append(mounts, masks...)
+
+	// Protect config files from modification by sandboxed processes. The
+	// policy entries keep them visible (and in audit findings); the late binds
+	// enforce read-only even if a later rule grants "rw" on them.
+	configPaths := protectedConfigPaths(cfg, env.WorkDir)
+
+	for _, p := range configPaths {
 		mounts = append(mounts, sandbox.ROTry(p))
 	}
 
-	// Protect project config files from modification by sandboxed processes.
-	//
-	// These live under the (typically RW) workdir mount, so they must be
-	// re-mounted read-only explicitly.
-	mounts = append(mounts,
-		sandbox.ROTry(filepath.Join(env.WorkDir, ".agent-sandbox.json")),
-		sandbox.ROTry(filepath.Join(env.WorkDir, ".agent-sandbox.jsonc")),
-	)
+	mounts = append(mounts, configProtectionMounts(configPaths)...)
 
 	runtimeRoot := filepath.Dir(sandboxBinaryPath)
 
@@ -289,12 +503,16 @@ func newSandbox(cfg *Config, env sandbox.Environment, debug *DebugLogger) (*sand
 	}
 
 	sbCfg := sandbox.Config{
-		Network: cfg.Network,
-		Docker:  cfg.Docker,
-		TempDir: os.TempDir(),
+		Network:      network,
+		NetworkAllow: cfg.NetworkAllow,
+		Docker:       cfg.Docker,
+		TempDir:      os.TempDir(),
 		Filesystem: sandbox.Filesystem{
-			Presets: effectivePresetsForCLI(cfg.Filesystem.Presets),
-			Mounts:  mounts,
+			Presets:       effectivePresetsForCLI(cfg.Filesystem.Presets),
+			ExcludeNotice: cfg.Filesystem.ExcludeNotice != nil && *cfg.Filesystem.ExcludeNotice,
+			Mounts:        mounts,
+
+			AllowDangerousMounts: cfg.Filesystem.AllowDangerousMounts != nil && *cfg.Filesystem.AllowDangerousMounts,
 		},
 		Commands: sandbox.Commands{
 			Block:     block,
@@ -302,12 +520,40 @@ func newSandbox(cfg *Config, env sandbox.Environment, debug *DebugLogger) (*sand
 			Launcher:  selfBinary,
 			MountPath: agentSandboxRuntimeRoot,
 		},
+		SandboxInfo: true,
+		DefaultACL:  sandbox.DefaultACL(cfg.DefaultACL),
+		MapSubIDs:   cfg.MapSubIDs != nil && *cfg.MapSubIDs,
+		Labels:      cfg.Labels,
+	}
+
+	if cfg.Umask != "" {
+		umask, err := parseUmask(cfg.Umask)
+		if err != nil {
+			return nil, err
+		}
+
+		sbCfg.Umask = &umask
 	}
 
 	if debug != nil && debug.Enabled() {
 		sbCfg.Debugf = debug.Logf
 	}
 
+	if learn != nil {
+		learn.configure(&sbCfg)
+	}
+
+	if denials != nil {
+		sbCfg.Commands.BlockLog = denials.path()
+	}
+
+	if gha != nil {
+		err = gha.configure(&sbCfg, &env)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	sb, err := sandbox.NewWithEnvironment(&sbCfg, env)
 	if err != nil {
 		return nil, fmt.Errorf("creating sandbox: %w", err)
@@ -390,6 +636,41 @@ func mountsFromConfig(fs *FilesystemConfig) []sandbox.Mount {
 	return out
 }
 
+// maskMounts turns config masks into data mounts. Paths are resolved like
+// policy paths: "~" against homeDir and relative paths against workDir.
+func maskMounts(masks []MaskConfig, homeDir, workDir string) ([]sandbox.Mount, error) {
+	if len(masks) == 0 {
+		return nil, nil
+	}
+
+	fsys := fstest.MapFS{}
+	out := make([]sandbox.Mount, 0, len(masks))
+
+	for i, mask := range masks {
+		data, err := inlineContent{ContentBase64: mask.ContentBase64}.decode()
+		if err != nil {
+			return nil, fmt.Errorf("filesystem.masks[%d] (%s): %w", i, mask.Path, err)
+		}
+
+		dst := mask.Path
+
+		switch {
+		case dst == "~":
+			dst = homeDir
+		case strings.HasPrefix(dst, "~/"):
+			dst = filepath.Join(homeDir, dst[2:])
+		case !filepath.IsAbs(dst):
+			dst = filepath.Join(workDir, dst)
+		}
+
+		name := "mask/" + strconv.Itoa(i)
+		fsys[name] = &fstest.MapFile{Data: data}
+		out = append(out, sandbox.MaskFS(filepath.Clean(dst), fsys, name))
+	}
+
+	return out, nil
+}
+
 func buildSandboxCommandRules(commands map[string]CommandRule) ([]string, map[string]sandbox.Wrapper, error) {
 	if len(commands) == 0 {
 		return nil, nil, nil
@@ -407,6 +688,8 @@ func buildSandboxCommandRules(commands map[string]CommandRule) ([]string, map[st
 			block = append(block, cmdName)
 		case CommandRuleScript:
 			wrappers[cmdName] = sandbox.Wrap(rule.Value)
+		case CommandRuleInline:
+			wrappers[cmdName] = sandbox.Wrapper{InlineScript: rule.Value}
 		case CommandRulePreset:
 			switch rule.Value {
 			case "@git":
@@ -431,6 +714,62 @@ func buildSandboxCommandRules(commands map[string]CommandRule) ([]string, map[st
 	return block, wrappers, nil
 }
 
+// resolveArtifacts fetches and verifies the artifacts referenced by cfg,
+// replacing "artifact:NAME" command rules with host paths and setting
+// cfg.ArtifactLauncher. It is a no-op when no artifacts are configured.
+func resolveArtifacts(ctx context.Context, cfg *Config, env map[string]string, homeDir string) error {
+	if cfg.Artifacts == nil {
+		return nil
+	}
+
+	dir := cfg.Artifacts.Dir
+
+	switch {
+	case dir == "":
+		cacheHome := env["XDG_CACHE_HOME"]
+		if cacheHome == "" {
+			cacheHome = filepath.Join(homeDir, ".cache")
+		}
+
+		dir = filepath.Join(cacheHome, "agent-sandbox", "artifacts")
+	case dir == "~":
+		dir = homeDir
+	case strings.HasPrefix(dir, "~/"):
+		dir = filepath.Join(homeDir, dir[2:])
+	}
+
+	store := &sandbox.ArtifactStore{
+		Dir:     dir,
+		BaseURL: cfg.Artifacts.URL,
+		SHA256:  cfg.Artifacts.SHA256,
+	}
+
+	if cfg.Artifacts.Launcher != "" {
+		path, err := store.Path(ctx, cfg.Artifacts.Launcher)
+		if err != nil {
+			return fmt.Errorf("resolving launcher: %w", err)
+		}
+
+		cfg.ArtifactLauncher = path
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Commands)) {
+		rule := cfg.Commands[name]
+		if rule.Kind != CommandRuleScript || !strings.HasPrefix(rule.Value, artifactRulePrefix) {
+			continue
+		}
+
+		path, err := store.Path(ctx, strings.TrimPrefix(rule.Value, artifactRulePrefix))
+		if err != nil {
+			return fmt.Errorf("resolving wrapper for %q: %w", name, err)
+		}
+
+		cfg.Commands[name] = CommandRule{Kind: CommandRuleScript, Value: path}
+	}
+
+	return nil
+}
+
 // getLoadedConfigPaths returns the paths of all loaded config files.
 // This is used to protect config files from modification inside the sandbox.
 func getLoadedConfigPaths(cfg *Config) []string {
@@ -446,6 +785,85 @@ func getLoadedConfigPaths(cfg *Config) []string {
 	return paths
 }
 
+// protectedConfigPaths returns the config files a sandboxed process must not
+// modify: every loaded config file and the files they extend, plus the
+// project config files in workDir (which may not exist yet). Paths are
+// sorted and deduplicated.
+func protectedConfigPaths(cfg *Config, workDir string) []string {
+	paths := getLoadedConfigPaths(cfg)
+
+	if cfg != nil {
+		for _, extended := range cfg.ExtendedConfigFiles {
+			paths = append(paths, extended...)
+		}
+	}
+
+	paths = append(paths,
+		filepath.Join(workDir, ".agent-sandbox.json"),
+		filepath.Join(workDir, ".agent-sandbox.jsonc"),
+	)
+
+	slices.Sort(paths)
+
+	return slices.Compact(paths)
+}
+
+// configProtectionMounts returns read-only binds of paths onto themselves,
+// pinned to [sandbox.PhaseLate].
+//
+// Project config files live under the (typically RW) workdir, and an explicit
+// "rw" rule on a config file would otherwise win over the read-only policy
+// entry. Late mounts are emitted after all policy, wrapper and docker mounts,
+// so the files stay read-only regardless of policy.
+//
+// Missing files are masked with an empty read-only file, so a sandboxed
+// process cannot create a config that the next run would load. bwrap creates
+// the mount point on the host; [removeConfigMountPoints] removes it again.
+func configProtectionMounts(paths []string) []sandbox.Mount {
+	mounts := make([]sandbox.Mount, 0, len(paths))
+
+	for _, p := range paths {
+		_, err := os.Lstat(p)
+		if err != nil {
+			mounts = append(mounts, sandbox.MaskFS(p, emptyConfigFS, "empty"))
+
+			continue
+		}
+
+		mounts = append(mounts, sandbox.RoBind(p, p).Phase(sandbox.PhaseLate))
+	}
+
+	return mounts
+}
+
+// emptyConfigFS backs the masks of missing config files.
+var emptyConfigFS = fstest.MapFS{"empty": &fstest.MapFile{}}
+
+// missingPaths returns the paths that do not exist.
+func missingPaths(paths []string) []string {
+	var missing []string
+
+	for _, p := range paths {
+		_, err := os.Lstat(p)
+		if err != nil {
+			missing = append(missing, p)
+		}
+	}
+
+	return missing
+}
+
+// removeConfigMountPoints removes the empty files bwrap created on the host
+// as mount points for the masks of the missing config files paths.
+func removeConfigMountPoints(paths []string) {
+	for _, p := range paths {
+		info, err := os.Lstat(p)
+		if err == nil && info.Mode().IsRegular() && info.Size() == 0 {
+			_ = os.Remove(p)
+		}
+	}
+}
+
 func getHomeDir(env map[string]string) (string, error) {
 	// Escape hatch for our env abstraction (os.UserHomeDir() checks $HOME aswell)
 	if home := env["HOME"]; home != "" {
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
 )
 
 func Test_Exec_Accepts_Network_Flag_When_Implicit_Mode(t *testing.T) {
@@ -666,6 +671,169 @@ func Test_GetLoadedConfigPaths_Returns_Paths_When_Config_Map_Has_Paths(t *testin
 	}
 }
 
+func Test_ProtectedConfigPaths_Include_Loaded_Extended_And_Project_Config_Files(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	cfg := &Config{
+		LoadedConfigFiles: map[string]string{
+			"global":  "/home/user/.config/agent-sandbox/config.json",
+			"project": filepath.Join(workDir, ".agent-sandbox.json"),
+		},
+		ExtendedConfigFiles: map[string][]string{
+			"project": {filepath.Join(workDir, "team.jsonc"), "/etc/agent-sandbox/base.json"},
+		},
+	}
+
+	got := protectedConfigPaths(cfg, workDir)
+	want := []string{
+		"/etc/agent-sandbox/base.json",
+		filepath.Join(workDir, "team.jsonc"),
+		"/home/user/.config/agent-sandbox/config.json",
+		filepath.Join(workDir, ".agent-sandbox.json"),
+		filepath.Join(workDir, ".agent-sandbox.jsonc"),
+	}
+
+	slices.Sort(want)
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("protectedConfigPaths() = %v, want %v", got, want)
+	}
+}
+
+func Test_ConfigProtectionMounts_Keep_Config_Files_ReadOnly_When_Policy_Grants_RW(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	configPath := filepath.Join(workDir, ".agent-sandbox.json")
+
+	err := os.WriteFile(configPath, []byte("{}"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := protectedConfigPaths(&Config{}, workDir)
+
+	mounts := []sandbox.Mount{sandbox.RW(workDir), sandbox.RW(configPath)}
+	mounts = append(mounts, configProtectionMounts(paths)...)
+
+	sb, err := sandbox.NewWithEnvironment(&sandbox.Config{
+		Filesystem: sandbox.Filesystem{Presets: []string{"!@all"}, Mounts: mounts},
+	}, sandbox.Environment{HomeDir: t.TempDir(), WorkDir: workDir, HostEnv: map[string]string{}})
+	if err != nil {
+		t.Fatalf("NewWithEnvironment: %v", err)
+	}
+
+	cmd, cleanup, err := sb.Command(t.Context(), []string{"true"})
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+
+	t.Cleanup(func() { _ = cleanup() })
+
+	args := cmd.Args
+	lastRW, lastRO := -1, -1
+
+	for i := 0; i+2 < len(args); i++ {
+		if args[i+1] != configPath || args[i+2] != configPath {
+			continue
+		}
+
+		switch args[i] {
+		case "--bind", "--bind-try":
+			lastRW = i
+		case "--ro-bind", "--ro-bind-try":
+			lastRO = i
+		}
+	}
+
+	if lastRO == -1 {
+		t.Fatalf("no read-only bind of %s in args: %v", configPath, args)
+	}
+
+	if lastRW > lastRO {
+		t.Fatalf("config file is bound read-write after the read-only bind: %v", args)
+	}
+
+	// The project .jsonc file does not exist: it is masked with an empty
+	// read-only file, so the command cannot create it, and planning does
+	// not create it on the host.
+	jsoncPath := filepath.Join(workDir, ".agent-sandbox.jsonc")
+	if i := slices.Index(args, jsoncPath); i < 2 || args[i-2] != "--ro-bind-data" {
+		t.Fatalf("expected missing %s to be masked with --ro-bind-data: %v", jsoncPath, args)
+	}
+
+	_, err = os.Stat(jsoncPath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected missing .agent-sandbox.jsonc not to be created on the host, stat err=%v", err)
+	}
+}
+
+func Test_ConfigProtectionMounts_Keep_Extended_Config_ReadOnly(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	xdgConfigHome := t.TempDir()
+
+	basePath := filepath.Join(workDir, "shared", "base.jsonc")
+	mustMkdir(t, filepath.Dir(basePath))
+	mustWriteFile(t, basePath, `{}`)
+	mustWriteFile(t, filepath.Join(workDir, ".agent-sandbox.json"), `{"extends": "./shared/base.jsonc"}`)
+
+	cfg, err := LoadConfig(LoadConfigInput{
+		WorkDirOverride: workDir,
+		EnvVars:         map[string]string{"XDG_CONFIG_HOME": xdgConfigHome},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := protectedConfigPaths(&cfg, workDir)
+	if !slices.Contains(paths, basePath) {
+		t.Fatalf("protectedConfigPaths() = %v, want it to include the extended %s", paths, basePath)
+	}
+
+	mounts := []sandbox.Mount{sandbox.RW(workDir)}
+	mounts = append(mounts, configProtectionMounts(paths)...)
+
+	sb, err := sandbox.NewWithEnvironment(&sandbox.Config{
+		Filesystem: sandbox.Filesystem{Presets: []string{"!@all"}, Mounts: mounts},
+	}, sandbox.Environment{HomeDir: t.TempDir(), WorkDir: workDir, HostEnv: map[string]string{}})
+	if err != nil {
+		t.Fatalf("NewWithEnvironment: %v", err)
+	}
+
+	mounts = sb.Mounts()
+
+	last := slices.IndexFunc(mounts, func(m sandbox.Mount) bool { return m.Dst == basePath && m.Kind == sandbox.MountRoBind })
+	if last == -1 || last < slices.IndexFunc(mounts, func(m sandbox.Mount) bool { return m.Dst == workDir && m.Kind == sandbox.MountBind }) {
+		t.Fatalf("expected a read-only bind of %s after the writable workdir: %+v", basePath, mounts)
+	}
+}
+
+func Test_RemoveConfigMountPoints_Removes_Only_Empty_Files(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	empty := filepath.Join(dir, ".agent-sandbox.jsonc")
+	written := filepath.Join(dir, ".agent-sandbox.json")
+
+	mustWriteFile(t, empty, "")
+	mustWriteFile(t, written, "{}")
+
+	removeConfigMountPoints([]string{empty, written, filepath.Join(dir, "missing.json")})
+
+	_, err := os.Stat(empty)
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected empty mount point to be removed, stat err=%v", err)
+	}
+
+	_, err = os.Stat(written)
+	if err != nil {
+		t.Fatalf("expected non-empty config to be kept: %v", err)
+	}
+}
+
 func Test_NotLinuxMessage_Contains_Hint_When_Platform_Is_Not_Linux(t *testing.T) {
 	t.Parallel()
 
@@ -998,3 +1166,70 @@ func Test_Exec_Allows_More_Restrictive_Filesystem_When_Running_Nested_Sandbox(t
 
 	AssertContains(t, strings.ToLower(stderr), "read-only")
 }
+
+func Test_DryRun_Run_Subcommand_Applies_Policy_Flags_When_Verbose(t *testing.T) {
+	t.Parallel()
+
+	c := NewCLITester(t)
+
+	stdout, stderr, code := c.Run("run", "--dry-run", "--verbose", "--preset", "!@lint/all", "--block", "git", "--", "echo", "hello")
+
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr: %s)", code, stderr)
+	}
+
+	AssertContains(t, stderr, "plan: ")
+	AssertContains(t, stderr, "plan: command: ")
+	AssertContains(t, stdout, "echo hello")
+}
+
+func Test_DryRun_Run_Subcommand_Prints_Grouped_Args_When_JSON(t *testing.T) {
+	t.Parallel()
+
+	c := NewCLITester(t)
+
+	stdout, stderr, code := c.Run("run", "--dry-run", "--json", "--", "echo", "hello")
+
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr: %s)", code, stderr)
+	}
+
+	var groups []sandbox.ArgGroup
+
+	err := json.Unmarshal([]byte(stdout), &groups)
+	if err != nil {
+		t.Fatalf("expected JSON argument groups, got %q: %v", stdout, err)
+	}
+
+	if len(groups) < 2 || groups[0].Name != sandbox.ArgGroupBase || !slices.Contains(groups[0].Args, "--die-with-parent") {
+		t.Fatalf("expected the base group first, got %+v", groups)
+	}
+
+	if last := groups[len(groups)-1]; last.Name != sandbox.ArgGroupCommand || !slices.Equal(last.Args, []string{"--", "echo", "hello"}) {
+		t.Fatalf("expected the command group last, got %+v", last)
+	}
+}
+
+func Test_MaskMounts_Resolves_Paths_And_Content(t *testing.T) {
+	t.Parallel()
+
+	mounts, err := maskMounts([]MaskConfig{
+		{Path: "~/.npmrc", ContentBase64: "YQ=="},
+		{Path: "sub/.env", ContentBase64: ""},
+	}, "/home/u", "/work")
+	if err != nil {
+		t.Fatalf("maskMounts: %v", err)
+	}
+
+	want := []string{"/home/u/.npmrc", "/work/sub/.env"}
+	for i, mnt := range mounts {
+		if mnt.Kind != sandbox.MountRoBindData || mnt.Dst != want[i] {
+			t.Fatalf("mount %d = %+v, want ro-bind-data at %s", i, mnt, want[i])
+		}
+	}
+
+	data, err := fs.ReadFile(mounts[0].FS, mounts[0].Src)
+	if err != nil || string(data) != "a" {
+		t.Fatalf("mask content = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+// This file implements the --github-actions run mode:
+//
+//	agent-sandbox run --github-actions -- <command> [args]
+//
+// The sandbox is adjusted with sandbox.FromGitHubActions and, after the
+// command exits, step outputs are appended to $GITHUB_OUTPUT:
+//
+//	exit-code=<code>
+//	blocked-commands=<number of blocked command invocations>
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
+)
+
+// gitHubActionsRun holds the per-run state of --github-actions.
+type gitHubActionsRun struct {
+	dir string
+}
+
+func newGitHubActionsRun() (*gitHubActionsRun, error) {
+	dir, err := os.MkdirTemp("", "agent-sandbox-github-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating github actions directory: %w", err)
+	}
+
+	run := &gitHubActionsRun{dir: dir}
+
+	err = os.WriteFile(run.blockLogPath(), nil, 0o600)
+	if err != nil {
+		_ = run.close()
+
+		return nil, fmt.Errorf("creating block log: %w", err)
+	}
+
+	return run, nil
+}
+
+func (g *gitHubActionsRun) blockLogPath() string {
+	return filepath.Join(g.dir, "blocked.log")
+}
+
+// configure applies the GitHub Actions defaults and points blocked commands
+// at the run's block log.
+func (g *gitHubActionsRun) configure(cfg *sandbox.Config, env *sandbox.Environment) error {
+	err := sandbox.FromGitHubActions(cfg, env)
+	if err != nil {
+		return err
+	}
+
+	cfg.Commands.BlockLog = g.blockLogPath()
+
+	return nil
+}
+
+// blockedCount returns the number of blocked command invocations.
+func (g *gitHubActionsRun) blockedCount() (int, error) {
+	data, err := os.ReadFile(g.blockLogPath())
+	if err != nil {
+		return 0, fmt.Errorf("reading block log: %w", err)
+	}
+
+	return bytes.Count(data, []byte("\n")), nil
+}
+
+// writeOutputs appends the step outputs to the file named by GITHUB_OUTPUT.
+// Without GITHUB_OUTPUT nothing is written.
+func (g *gitHubActionsRun) writeOutputs(env map[string]string, exitCode int) error {
+	path := env["GITHUB_OUTPUT"]
+	if path == "" {
+		return nil
+	}
+
+	blocked, err := g.blockedCount()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("writing step outputs: %w", err)
+	}
+
+	_, err = fmt.Fprintf(f, "exit-code=%d\nblocked-commands=%d\n", exitCode, blocked)
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("writing step outputs: %w", err)
+	}
+
+	return nil
+}
+
+func (g *gitHubActionsRun) close() error {
+	return os.RemoveAll(g.dir)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_GitHubActions_Appends_Step_Outputs(t *testing.T) {
+	t.Parallel()
+
+	gha, err := newGitHubActionsRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = gha.close() })
+
+	// Blocked commands append their name to the block log inside the sandbox.
+	mustWriteFile(t, gha.blockLogPath(), "rm\ncurl\nrm\n")
+
+	outputPath := filepath.Join(t.TempDir(), "github_output")
+	mustWriteFile(t, outputPath, "earlier=1\n")
+
+	err = gha.writeOutputs(map[string]string{"GITHUB_OUTPUT": outputPath}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(data), "earlier=1\nexit-code=2\nblocked-commands=3\n"; got != want {
+		t.Fatalf("GITHUB_OUTPUT = %q, want %q", got, want)
+	}
+
+	// Without GITHUB_OUTPUT nothing is written.
+	err = gha.writeOutputs(map[string]string{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Run_GitHubActions_Requires_GitHub_Actions_Environment(t *testing.T) {
+	t.Parallel()
+
+	c := NewCLITester(t)
+
+	_, stderr, code := c.Run("run", "--github-actions", "--", "true")
+	if code == 0 {
+		t.Fatal("expected non-zero exit code outside GitHub Actions")
+	}
+
+	AssertContains(t, stderr, "not running in GitHub Actions")
+}
@@ -0,0 +1,127 @@
+package main
+
+// This file implements the history subcommand:
+//
+//	agent-sandbox history [--json] [--all] [-n N] [--command NAME] [--since DURATION]
+//
+// It prints commands recorded while "history": true is set in the config.
+// History is kept in the state directory of each project; --all merges every
+// project.
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
+)
+
+const historySubcommandName = "history"
+
+func runHistoryCommand(stdout, stderr io.Writer, args []string, env map[string]string, workDirOverride string) int {
+	flags := flag.NewFlagSet(historySubcommandName, flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.SetOutput(&strings.Builder{})
+	asJSON := flags.Bool("json", false, "Print entries as JSON")
+	all := flags.Bool("all", false, "Show entries of all projects")
+	limit := flags.IntP("limit", "n", 20, "Show at most N most recent entries (0: all)")
+	command := flags.String("command", "", "Only show entries for this command")
+	since := flags.Duration("since", 0, "Only show entries newer than this (e.g. 24h)")
+
+	err := flags.Parse(args)
+	if err == nil && flags.NArg() != 0 {
+		err = errors.New("usage: agent-sandbox history [--json] [--all] [-n N] [--command NAME] [--since DURATION]")
+	}
+
+	if err != nil {
+		fprintError(stderr, err)
+
+		return 1
+	}
+
+	homeDir, err := getHomeDir(env)
+	if err != nil {
+		fprintError(stderr, err)
+
+		return 1
+	}
+
+	filter := sandbox.HistoryFilter{Command: *command, Limit: *limit}
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+
+	workDir, err := resolveWorkDir(workDirOverride)
+	if err != nil {
+		fprintError(stderr, err)
+
+		return 1
+	}
+
+	entries, err := readHistory(sandbox.Environment{HomeDir: homeDir, WorkDir: workDir, HostEnv: env}, filter, *all)
+	if err != nil {
+		fprintError(stderr, err)
+
+		return 1
+	}
+
+	if *asJSON {
+		if entries == nil {
+			entries = []sandbox.HistoryEntry{}
+		}
+
+		return writeJSON(stdout, stderr, entries)
+	}
+
+	for _, e := range entries {
+		status := "exit " + strconv.Itoa(e.ExitCode)
+		if e.Error != "" {
+			status = "error"
+		}
+
+		fprintf(stdout, "%s  %-8s  %8s  %s  %s\n",
+			e.Time.Local().Format(time.DateTime), status, e.Duration.Round(time.Millisecond), e.Policy, strings.Join(e.Argv, " "))
+	}
+
+	return 0
+}
+
+// readHistory returns the history of the project at env.WorkDir, or of all
+// projects if all is set.
+func readHistory(env sandbox.Environment, filter sandbox.HistoryFilter, all bool) ([]sandbox.HistoryEntry, error) {
+	paths := []string{sandbox.HistoryPath(env)}
+
+	if all {
+		matches, err := filepath.Glob(filepath.Join(sandbox.StateHome(env), "*", sandbox.HistoryFileName))
+		if err != nil {
+			return nil, err
+		}
+
+		paths = matches
+	}
+
+	var entries []sandbox.HistoryEntry
+
+	for _, path := range paths {
+		projectEntries, err := sandbox.History(path, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, projectEntries...)
+	}
+
+	slices.SortStableFunc(entries, func(a, b sandbox.HistoryEntry) int { return a.Time.Compare(b.Time) })
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+
+	return entries, nil
+}
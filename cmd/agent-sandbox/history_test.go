@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
+)
+
+func Test_History_Prints_Recorded_Commands(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	workDir := t.TempDir()
+	env := map[string]string{"HOME": t.TempDir(), "XDG_STATE_HOME": stateDir}
+	path := sandbox.HistoryPath(sandbox.Environment{WorkDir: workDir, HostEnv: env})
+
+	for _, entry := range []sandbox.HistoryEntry{
+		{Time: time.Now().Add(-time.Minute), Argv: []string{"git", "status"}, WorkDir: workDir, Policy: "abc", ExitCode: 0},
+		{Time: time.Now(), Argv: []string{"npm", "test"}, WorkDir: workDir, Policy: "abc", ExitCode: 1},
+	} {
+		err := sandbox.AppendHistory(path, entry)
+		if err != nil {
+			t.Fatalf("AppendHistory: %v", err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	code := runHistoryCommand(&stdout, &stderr, []string{"--command", "npm"}, env, workDir)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr.String())
+	}
+
+	AssertContains(t, stdout.String(), "exit 1")
+	AssertContains(t, stdout.String(), "npm test")
+
+	if strings.Contains(stdout.String(), "git status") {
+		t.Errorf("expected git entry to be filtered out, got:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+
+	code = runHistoryCommand(&stdout, &stderr, []string{"--json", "-n", "1"}, env, workDir)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr.String())
+	}
+
+	var got []sandbox.HistoryEntry
+
+	err := json.Unmarshal(stdout.Bytes(), &got)
+	if err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout.String())
+	}
+
+	if len(got) != 1 || got[0].Argv[0] != "npm" {
+		t.Errorf("unexpected entries: %+v", got)
+	}
+}
+
+func Test_History_Prints_Empty_JSON_Array_When_No_History(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+
+	env := map[string]string{"HOME": t.TempDir(), "XDG_STATE_HOME": t.TempDir()}
+
+	code := runHistoryCommand(&stdout, &stderr, []string{"--json"}, env, t.TempDir())
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr.String())
+	}
+
+	if got := strings.TrimSpace(stdout.String()); got != "[]" {
+		t.Errorf("stdout = %q, want []", got)
+	}
+}
+
+func Test_History_Shows_Current_Project_Unless_All(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	env := map[string]string{"HOME": t.TempDir(), "XDG_STATE_HOME": stateDir}
+	projectA, projectB := t.TempDir(), t.TempDir()
+
+	appendEntry := func(path string, entry sandbox.HistoryEntry) {
+		t.Helper()
+
+		err := sandbox.AppendHistory(path, entry)
+		if err != nil {
+			t.Fatalf("AppendHistory: %v", err)
+		}
+	}
+
+	projectPath := func(workDir string) string {
+		return sandbox.HistoryPath(sandbox.Environment{WorkDir: workDir, HostEnv: env})
+	}
+
+	now := time.Now()
+	appendEntry(projectPath(projectA), sandbox.HistoryEntry{Time: now, Argv: []string{"make", "a"}, WorkDir: projectA})
+	appendEntry(projectPath(projectB), sandbox.HistoryEntry{Time: now.Add(-time.Minute), Argv: []string{"make", "b"}, WorkDir: projectB})
+
+	var stdout, stderr bytes.Buffer
+
+	code := runHistoryCommand(&stdout, &stderr, nil, env, projectA)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr.String())
+	}
+
+	AssertContains(t, stdout.String(), "make a")
+
+	if strings.Contains(stdout.String(), "make b") {
+		t.Errorf("expected other projects to be left out, got:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+
+	code = runHistoryCommand(&stdout, &stderr, []string{"--all"}, env, projectA)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr.String())
+	}
+
+	got := stdout.String()
+	AssertContains(t, got, "make b")
+
+	if strings.Index(got, "make b") > strings.Index(got, "make a") {
+		t.Errorf("expected all projects oldest first, got:\n%s", got)
+	}
+}
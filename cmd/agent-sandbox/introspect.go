@@ -0,0 +1,389 @@
+package main
+
+// This file implements the introspection subcommands:
+//
+//	agent-sandbox presets list [--json]
+//	agent-sandbox config schema
+//	agent-sandbox completion bash|zsh|fish
+//
+// They never start a sandbox and only describe what the CLI accepts, for
+// editor tooling and config validation in CI.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
+)
+
+// introspectionCommand returns the handler of an introspection subcommand, or
+// nil. Handlers receive the arguments after the subcommand name and return the
+// exit code.
+func introspectionCommand(name string) func(stdout, stderr io.Writer, args []string) int {
+	switch name {
+	case "presets":
+		return runPresetsCommand
+	case "config":
+		return runConfigCommand
+	case "completion":
+		return runCompletionCommand
+	default:
+		return nil
+	}
+}
+
+// subcommandNames lists all subcommands, for completion.
+func subcommandNames() []string {
+	return []string{runSubcommandName, learnSubcommandName, "presets", "config", "completion", historySubcommandName, cleanSubcommandName}
+}
+
+// presetJSON is the --json output format of "presets list".
+type presetJSON struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Includes    []string `json:"includes,omitempty"`
+	Default     bool     `json:"default"`
+}
+
+func runPresetsCommand(stdout, stderr io.Writer, args []string) int {
+	flags := flag.NewFlagSet("presets", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.SetOutput(&strings.Builder{})
+	asJSON := flags.Bool("json", false, "Print presets as JSON")
+
+	err := flags.Parse(args)
+	if err == nil && (flags.NArg() != 1 || flags.Arg(0) != "list") {
+		err = errors.New("usage: agent-sandbox presets list [--json]")
+	}
+
+	if err != nil {
+		fprintError(stderr, err)
+
+		return 1
+	}
+
+	presets := sandbox.Presets()
+
+	if *asJSON {
+		out := make([]presetJSON, 0, len(presets))
+		for _, p := range presets {
+			out = append(out, presetJSON(p))
+		}
+
+		return writeJSON(stdout, stderr, out)
+	}
+
+	for _, p := range presets {
+		fprintf(stdout, "%-13s %s\n", p.Name, p.Description)
+	}
+
+	return 0
+}
+
+func runConfigCommand(stdout, stderr io.Writer, args []string) int {
+	if len(args) != 1 || args[0] != "schema" {
+		fprintError(stderr, errors.New("usage: agent-sandbox config schema"))
+
+		return 1
+	}
+
+	return writeJSON(stdout, stderr, configSchema())
+}
+
+func writeJSON(stdout, stderr io.Writer, v any) int {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fprintError(stderr, fmt.Errorf("encoding JSON: %w", err))
+
+		return 1
+	}
+
+	fprintf(stdout, "%s\n", data)
+
+	return 0
+}
+
+// configSchema returns the JSON Schema (draft 2020-12) of the config file
+// format. It must be kept in sync with Config; Test_Config_Schema_Covers_All_Config_Fields
+// fails when a serialized field is missing.
+func configSchema() map[string]any {
+	stringList := func(description string) map[string]any {
+		return map[string]any{
+			"type":        "array",
+			"description": description,
+			"items":       map[string]any{"type": "string", "minLength": 1},
+		}
+	}
+
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "agent-sandbox config",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": map[string]any{
+			"$schema": map[string]any{
+				"type":        "string",
+				"description": "JSON Schema reference for editors; ignored by agent-sandbox",
+			},
+			"version": map[string]any{
+				"type":        "integer",
+				"description": "Config format version; older versions are migrated on load",
+				"minimum":     0,
+				"maximum":     configVersion,
+			},
+			"extends": map[string]any{
+				"type":        "string",
+				"description": "Base config merged below this file: a path relative to this file, or the name of a config in the global config directory",
+				"minLength":   1,
+			},
+			"network": map[string]any{
+				"description": "Network access: a boolean, or an object selecting a named zone",
+				"oneOf": []any{
+					map[string]any{"type": "boolean"},
+					map[string]any{
+						"type":                 "object",
+						"additionalProperties": false,
+						"required":             []string{"zone"},
+						"properties": map[string]any{
+							"zone": map[string]any{"type": "string", "minLength": 1},
+						},
+					},
+				},
+			},
+			"docker": map[string]any{
+				"type":        "boolean",
+				"description": "Docker socket access",
+			},
+			"filesystem": map[string]any{
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]any{
+					"presets": map[string]any{
+						"type":        "array",
+						"description": "Filesystem presets; prefix with ! to disable",
+						"items":       map[string]any{"enum": completionPresetNames()},
+					},
+					"ro":      stringList("Read-only paths"),
+					"rw":      stringList("Read-write paths"),
+					"exclude": stringList("Excluded paths"),
+					"excludeNotice": map[string]any{
+						"type":        "boolean",
+						"description": "Place a README-agent-sandbox.txt in excluded directories",
+					},
+					"masks": map[string]any{
+						"type":        "array",
+						"description": "Files replaced with inline read-only content",
+						"items": map[string]any{
+							"type":                 "object",
+							"additionalProperties": false,
+							"required":             []any{"path", "contentBase64"},
+							"properties": map[string]any{
+								"path":          map[string]any{"type": "string", "minLength": 1},
+								"contentBase64": map[string]any{"type": "string", "contentEncoding": "base64"},
+							},
+						},
+					},
+					"allowDangerousMounts": map[string]any{
+						"type":        "boolean",
+						"description": "Allow rw paths such as /, ~, /usr and /etc",
+					},
+				},
+			},
+			"commands": map[string]any{
+				"type":        "object",
+				"description": "Command wrappers: true (allow), false (block), \"@preset\", a wrapper script path or {\"contentBase64\": ...}",
+				"additionalProperties": map[string]any{
+					"oneOf": []any{
+						map[string]any{"type": "boolean"},
+						map[string]any{"type": "string", "minLength": 1},
+						map[string]any{
+							"type":                 "object",
+							"additionalProperties": false,
+							"required":             []any{"contentBase64"},
+							"properties": map[string]any{
+								"contentBase64": map[string]any{"type": "string", "contentEncoding": "base64", "minLength": 1},
+							},
+						},
+					},
+				},
+			},
+			"artifacts": map[string]any{
+				"type":                 "object",
+				"description":          "Cache directory and optional base URL for policy artifacts, referenced as \"artifact:NAME\"",
+				"additionalProperties": false,
+				"properties": map[string]any{
+					"dir":      map[string]any{"type": "string", "minLength": 1},
+					"url":      map[string]any{"type": "string", "pattern": "^https?://"},
+					"launcher": map[string]any{"type": "string", "minLength": 1},
+					"sha256": map[string]any{
+						"type":                 "object",
+						"additionalProperties": map[string]any{"type": "string", "pattern": "^[0-9a-f]{64}$"},
+					},
+				},
+			},
+			"umask": map[string]any{
+				"type":        "string",
+				"description": "Octal umask applied before the command runs, e.g. \"027\"",
+				"pattern":     "^0?[0-7]{1,3}$",
+			},
+			"defaultAcl": map[string]any{
+				"description": "Default ACL set on writable directories below the working directory",
+				"enum":        []string{string(sandbox.DefaultACLGroupRead), string(sandbox.DefaultACLPrivate)},
+			},
+			"mapSubIds": map[string]any{
+				"type":        "boolean",
+				"description": "Map the user's /etc/subuid and /etc/subgid ranges into the sandbox (requires newuidmap/newgidmap)",
+			},
+			"history": map[string]any{
+				"type":        "boolean",
+				"description": "Record every sandboxed command for \"agent-sandbox history\"",
+			},
+			"labels": map[string]any{
+				"type":                 "object",
+				"description":          "Key/value metadata (e.g. run-id, agent, task-id) recorded in history entries",
+				"propertyNames":        map[string]any{"pattern": "^[A-Za-z0-9][A-Za-z0-9._/-]*$"},
+				"additionalProperties": map[string]any{"type": "string"},
+			},
+			"profile": map[string]any{
+				"type":        "string",
+				"description": "Profile applied on top of the merged config files; --profile overrides it",
+				"minLength":   1,
+			},
+			"profiles": map[string]any{
+				"type":                 "object",
+				"description":          "Named partial configs merged on top of the top-level settings when selected",
+				"additionalProperties": map[string]any{"$ref": "#"},
+			},
+			"zones": map[string]any{
+				"type":        "object",
+				"description": "Named network zones, selected via network.zone",
+				"additionalProperties": map[string]any{
+					"type":                 "object",
+					"additionalProperties": false,
+					"required":             []string{"allow"},
+					"properties": map[string]any{
+						"allow": stringList("Reachable hosts; \"*.\" matches any subdomain"),
+					},
+				},
+			},
+		},
+	}
+}
+
+func runCompletionCommand(stdout, stderr io.Writer, args []string) int {
+	if len(args) != 1 {
+		fprintError(stderr, errors.New("usage: agent-sandbox completion bash|zsh|fish"))
+
+		return 1
+	}
+
+	switch args[0] {
+	case "bash":
+		fprintln(stdout, bashCompletion())
+	case "zsh":
+		// zsh loads the bash completion through bashcompinit.
+		fprintln(stdout, "autoload -U +X bashcompinit && bashcompinit")
+		fprintln(stdout, bashCompletion())
+	case "fish":
+		fprintln(stdout, fishCompletion())
+	default:
+		fprintError(stderr, fmt.Errorf("unsupported shell %q: expected bash, zsh or fish", args[0]))
+
+		return 1
+	}
+
+	return 0
+}
+
+// completionFlags returns all long and short flag spellings, including the
+// flags of the "run" subcommand.
+func completionFlags() []*flag.Flag {
+	flags := newFlagSet()
+	addRunFlags(flags)
+
+	var out []*flag.Flag
+
+	flags.VisitAll(func(f *flag.Flag) {
+		out = append(out, f)
+	})
+
+	return out
+}
+
+// completionPresetNames returns all preset names and their negations.
+func completionPresetNames() []string {
+	var names []string
+	for _, p := range sandbox.Presets() {
+		names = append(names, p.Name, "!"+p.Name)
+	}
+
+	return names
+}
+
+func bashCompletion() string {
+	var flagWords []string
+
+	for _, f := range completionFlags() {
+		flagWords = append(flagWords, "--"+f.Name)
+		if f.Shorthand != "" {
+			flagWords = append(flagWords, "-"+f.Shorthand)
+		}
+	}
+
+	var b strings.Builder
+
+	b.WriteString("_agent_sandbox() {\n")
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("    case \"$prev\" in\n")
+	b.WriteString("        --preset) COMPREPLY=($(compgen -W \"" + strings.Join(completionPresetNames(), " ") + "\" -- \"$cur\")); return ;;\n")
+	b.WriteString("        --ro|--rw|--exclude|--cwd|-C|--config|-c) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n")
+	b.WriteString("        --block) COMPREPLY=($(compgen -c -- \"$cur\")); return ;;\n")
+	b.WriteString("    esac\n")
+	b.WriteString("    if [[ \"$cur\" == -* ]]; then\n")
+	b.WriteString("        COMPREPLY=($(compgen -W \"" + strings.Join(flagWords, " ") + "\" -- \"$cur\"))\n")
+	b.WriteString("    elif [[ $COMP_CWORD -eq 1 ]]; then\n")
+	b.WriteString("        COMPREPLY=($(compgen -W \"" + strings.Join(subcommandNames(), " ") + "\" -- \"$cur\") $(compgen -c -- \"$cur\"))\n")
+	b.WriteString("    else\n")
+	b.WriteString("        COMPREPLY=($(compgen -c -- \"$cur\"))\n")
+	b.WriteString("    fi\n")
+	b.WriteString("}\n")
+	b.WriteString("complete -o default -F _agent_sandbox agent-sandbox")
+
+	return b.String()
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+
+	b.WriteString("complete -c agent-sandbox -n __fish_use_subcommand -a '" + strings.Join(subcommandNames(), " ") + "'\n")
+
+	for _, f := range completionFlags() {
+		line := "complete -c agent-sandbox -l " + f.Name
+		if f.Shorthand != "" {
+			line += " -s " + f.Shorthand
+		}
+
+		switch {
+		case f.Name == "preset":
+			line += " -x -a '" + strings.Join(completionPresetNames(), " ") + "'"
+		case f.Value.Type() != "bool":
+			line += " -r"
+		}
+
+		_, usage := flag.UnquoteUsage(f)
+		line += " -d " + shellSingleQuote(usage)
+		b.WriteString(line + "\n")
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// shellSingleQuote quotes s for POSIX shells and fish.
+func shellSingleQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
+)
+
+func Test_Presets_List_Prints_All_Presets(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+
+	code := runPresetsCommand(&stdout, &stderr, []string{"list"})
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr.String())
+	}
+
+	for _, p := range sandbox.Presets() {
+		AssertContains(t, stdout.String(), p.Name)
+	}
+}
+
+func Test_Presets_List_Prints_JSON_When_Json_Flag(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+
+	code := runPresetsCommand(&stdout, &stderr, []string{"list", "--json"})
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr.String())
+	}
+
+	var got []presetJSON
+
+	err := json.Unmarshal(stdout.Bytes(), &got)
+	if err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout.String())
+	}
+
+	if len(got) != len(sandbox.Presets()) {
+		t.Fatalf("got %d presets, want %d", len(got), len(sandbox.Presets()))
+	}
+
+	if got[0].Name != "@all" || !got[0].Default || len(got[0].Includes) == 0 {
+		t.Errorf("unexpected @all entry: %+v", got[0])
+	}
+}
+
+func Test_Presets_Fails_When_Subcommand_Unknown(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+
+	code := runPresetsCommand(&stdout, &stderr, []string{"show"})
+	if code != 1 {
+		t.Fatalf("exit code = %d, want 1", code)
+	}
+
+	AssertContains(t, stderr.String(), "usage: agent-sandbox presets list")
+}
+
+func Test_Config_Schema_Covers_All_Config_Fields(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+
+	code := runConfigCommand(&stdout, &stderr, []string{"schema"})
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr.String())
+	}
+
+	var schema struct {
+		Properties map[string]struct {
+			Properties map[string]any `json:"properties"`
+		} `json:"properties"`
+	}
+
+	err := json.Unmarshal(stdout.Bytes(), &schema)
+	if err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	for _, name := range jsonFieldNames(reflect.TypeFor[Config]()) {
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("schema is missing config field %q", name)
+		}
+	}
+
+	for _, name := range jsonFieldNames(reflect.TypeFor[FilesystemConfig]()) {
+		if _, ok := schema.Properties["filesystem"].Properties[name]; !ok {
+			t.Errorf("schema is missing filesystem field %q", name)
+		}
+	}
+}
+
+func Test_Completion_Includes_Flags_Subcommands_And_Presets(t *testing.T) {
+	t.Parallel()
+
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var stdout, stderr bytes.Buffer
+
+		code := runCompletionCommand(&stdout, &stderr, []string{shell})
+		if code != 0 {
+			t.Fatalf("%s: exit code = %d, want 0 (stderr: %s)", shell, code, stderr.String())
+		}
+
+		for _, want := range []string{"agent-sandbox", "presets", "completion", "dry-run", "preset", "@lint/go"} {
+			AssertContains(t, stdout.String(), want)
+		}
+	}
+}
+
+func Test_Completion_Fails_When_Shell_Unsupported(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+
+	code := runCompletionCommand(&stdout, &stderr, []string{"powershell"})
+	if code != 1 {
+		t.Fatalf("exit code = %d, want 1", code)
+	}
+
+	AssertContains(t, stderr.String(), `unsupported shell "powershell"`)
+}
+
+func Test_Run_Dispatches_Introspection_Subcommands(t *testing.T) {
+	t.Parallel()
+
+	c := NewCLITester(t)
+
+	stdout, stderr, code := c.Run("presets", "list")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0 (stderr: %s)", code, stderr)
+	}
+
+	AssertContains(t, stdout, "@base")
+}
+
+// jsonFieldNames returns the serialized JSON names of t's fields.
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
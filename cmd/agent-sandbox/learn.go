@@ -0,0 +1,203 @@
+package main
+
+// This file implements the learn subcommand:
+//
+//	agent-sandbox learn [flags] -- <command> [args] > suggested.jsonc
+//
+// The command runs in audit mode (see sandbox.ModeAudit): without isolation,
+// with blocked commands replaced by report-only shims. Afterwards learn prints
+// a config fragment that would have let the run succeed:
+//
+//   - "commands": {"<name>": true} for every blocked command that was invoked
+//   - "filesystem": {"rw": [...]} for paths below read-only rules that changed
+//
+// Audit mode is a static policy report and does not trace file accesses, so
+// reads of excluded paths are not detected and changes below read-only rules
+// may come from other processes.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
+)
+
+const learnSubcommandName = "learn"
+
+// learnSession collects what a learn run observed.
+type learnSession struct {
+	dir     string
+	started time.Time
+}
+
+func newLearnSession() (*learnSession, error) {
+	dir, err := os.MkdirTemp("", "agent-sandbox-learn-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating learn directory: %w", err)
+	}
+
+	return &learnSession{dir: dir}, nil
+}
+
+func (l *learnSession) logPath() string {
+	return filepath.Join(l.dir, "blocked.log")
+}
+
+// configure switches cfg to audit mode and points the blocked-command shims
+// at the session log.
+func (l *learnSession) configure(cfg *sandbox.Config) {
+	cfg.Mode = sandbox.ModeAudit
+	cfg.AuditLog = l.logPath()
+}
+
+// start records the time the workload starts; writes before it are ignored.
+func (l *learnSession) start() {
+	l.started = time.Now()
+}
+
+func (l *learnSession) close() error {
+	return os.RemoveAll(l.dir)
+}
+
+// learnSuggestion is the config fragment printed by learn.
+type learnSuggestion struct {
+	Filesystem *learnFilesystem `json:"filesystem,omitempty"`
+	Commands   map[string]bool  `json:"commands,omitempty"`
+}
+
+type learnFilesystem struct {
+	Rw []string `json:"rw"`
+}
+
+// writeSuggestion prints the config fragment for what the run was denied.
+func (l *learnSession) writeSuggestion(out io.Writer, sb *sandbox.Sandbox, cfg *Config, argv []string, homeDir string) error {
+	var suggestion learnSuggestion
+
+	blocked, err := l.blockedInvocations(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range blocked {
+		if suggestion.Commands == nil {
+			suggestion.Commands = make(map[string]bool)
+		}
+
+		suggestion.Commands[name] = true
+	}
+
+	changes, err := sb.AuditChanges(l.started)
+	if err != nil {
+		return fmt.Errorf("detecting changes: %w", err)
+	}
+
+	paths := make([]string, 0, len(changes))
+	for _, c := range changes {
+		paths = append(paths, c.Path)
+	}
+
+	if rw := collapsePaths(paths); len(rw) > 0 {
+		for i, path := range rw {
+			rw[i] = tildePath(path, homeDir)
+		}
+
+		suggestion.Filesystem = &learnFilesystem{Rw: rw}
+	}
+
+	data, err := json.MarshalIndent(suggestion, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding suggestion: %w", err)
+	}
+
+	fprintf(out, "// agent-sandbox learn: suggested config for: %s\n", strings.Join(argv, " "))
+
+	if suggestion.Filesystem == nil && suggestion.Commands == nil {
+		fprintln(out, "// No blocked command ran and nothing changed below read-only rules.")
+	} else {
+		fprintln(out, "// Review before merging into .agent-sandbox.jsonc. Reads of excluded paths")
+		fprintln(out, "// are not detected.")
+	}
+
+	fprintf(out, "%s\n", data)
+
+	return nil
+}
+
+// blockedInvocations returns the sorted, unique names of blocked commands
+// that were invoked. Wrapped commands also go through audit shims and are
+// not reported.
+func (l *learnSession) blockedInvocations(cfg *Config) ([]string, error) {
+	f, err := os.Open(l.logPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading blocked commands: %w", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	var names []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name := scanner.Text()
+		if rule, ok := cfg.Commands[name]; ok && rule.Kind == CommandRuleBlock {
+			names = append(names, name)
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("reading blocked commands: %w", err)
+	}
+
+	slices.Sort(names)
+
+	return slices.Compact(names), nil
+}
+
+// collapsePaths sorts paths and drops those below another path in the list.
+// A new file shows up together with its (changed) parent directory, which is
+// what needs to be writable.
+func collapsePaths(paths []string) []string {
+	sorted := slices.Clone(paths)
+	slices.Sort(sorted)
+
+	var out []string
+
+	for _, path := range sorted {
+		if len(out) > 0 {
+			last := out[len(out)-1]
+			if path == last || strings.HasPrefix(path, last+"/") {
+				continue
+			}
+		}
+
+		out = append(out, path)
+	}
+
+	return out
+}
+
+// tildePath abbreviates paths below homeDir with "~".
+func tildePath(path, homeDir string) string {
+	if path == homeDir {
+		return "~"
+	}
+
+	if rest, ok := strings.CutPrefix(path, homeDir+"/"); ok {
+		return "~/" + rest
+	}
+
+	return path
+}
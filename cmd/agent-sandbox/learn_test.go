@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_Learn_Suggests_Blocked_Commands_And_Written_Paths(t *testing.T) {
+	t.Parallel()
+
+	c := NewCLITester(t)
+	projectDir := filepath.Join(c.Dir, "project")
+	mustMkdir(t, projectDir)
+	mustMkdir(t, filepath.Join(c.Dir, ".config"))
+	mustWriteFile(t, filepath.Join(projectDir, ".agent-sandbox.json"), `{"commands": {"touch": false}}`)
+
+	// Setup changes fall inside the audit timestamp slack otherwise.
+	time.Sleep(50 * time.Millisecond)
+
+	script := "echo workload-output; touch made.txt; echo {} > \"$HOME/.config/tool.json\""
+
+	stdout, stderr, code := c.RunInDir(projectDir, "learn", "--", "sh", "-c", script)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0\nstderr: %s", code, stderr)
+	}
+
+	AssertContains(t, stderr, "workload-output\n")
+	AssertNotContains(t, stdout, "workload-output\n")
+	AssertContains(t, stdout, "// agent-sandbox learn: suggested config for: sh -c")
+	AssertContains(t, stdout, `"touch": true`)
+	AssertContains(t, stdout, `"rw": [
+      "~/.config"
+    ]`)
+}
+
+func Test_CollapsePaths_Drops_Paths_Below_Other_Paths(t *testing.T) {
+	t.Parallel()
+
+	got := collapsePaths([]string{"/h/.config/app/state.json", "/h/.config/app", "/h/.local/bin/tool", "/h/.config/appx"})
+	want := []string{"/h/.config/app", "/h/.config/appx", "/h/.local/bin/tool"}
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("collapsePaths = %q, want %q", got, want)
+	}
+}
+
+func Test_TildePath_Abbreviates_Home(t *testing.T) {
+	t.Parallel()
+
+	for path, want := range map[string]string{
+		"/home/u":          "~",
+		"/home/u/.config":  "~/.config",
+		"/home/user/other": "/home/user/other",
+	} {
+		if got := tildePath(path, "/home/u"); got != want {
+			t.Errorf("tildePath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
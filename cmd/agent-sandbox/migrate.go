@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// configVersion is the current config format version, written as
+// "version" in config files. Files without a version are version 0.
+//
+// To change the format incompatibly, bump configVersion and add the
+// migration from the previous version to configMigrations. Old files keep
+// loading; the migration rewrites their decoded JSON before it is decoded
+// into Config.
+const configVersion = 1
+
+// configMigration upgrades a decoded config file by one version, in place.
+type configMigration func(raw map[string]any) error
+
+// configMigrations[v] upgrades a config from version v to v+1. The array
+// length makes a missing migration a compile error.
+var configMigrations = [configVersion]configMigration{
+	// Version 1 introduced the "version" field; the format is otherwise
+	// unchanged.
+	0: func(map[string]any) error { return nil },
+}
+
+// migrateConfig upgrades raw, a decoded config file, to configVersion.
+// Numbers in raw must be decoded as json.Number.
+func migrateConfig(raw map[string]any) error {
+	version := 0
+
+	if value, ok := raw["version"]; ok {
+		number, isNumber := value.(json.Number)
+
+		parsed, err := strconv.Atoi(number.String())
+		if !isNumber || err != nil || parsed < 0 {
+			return fmt.Errorf("version must be a non-negative integer: got %v", value)
+		}
+
+		if parsed > configVersion {
+			return fmt.Errorf("version %d is newer than the supported version %d; upgrade agent-sandbox", parsed, configVersion)
+		}
+
+		version = parsed
+	}
+
+	for ; version < configVersion; version++ {
+		err := configMigrations[version](raw)
+		if err != nil {
+			return fmt.Errorf("migrating from version %d: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+var jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+
+// unknownConfigFields returns the paths (e.g. "filesystem.readonly") of
+// object keys in raw that do not correspond to a field of t, the type raw is
+// decoded into. Types with their own UnmarshalJSON validate their input and
+// are not inspected.
+func unknownConfigFields(raw any, t reflect.Type, path string) []string {
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+
+	var unknown []string
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return unknownConfigFields(raw, t.Elem(), path)
+	case reflect.Slice:
+		items, _ := raw.([]any)
+		for i, item := range items {
+			unknown = append(unknown, unknownConfigFields(item, t.Elem(), path+"["+strconv.Itoa(i)+"]")...)
+		}
+	case reflect.Map:
+		obj, _ := raw.(map[string]any)
+		for _, key := range slices.Sorted(maps.Keys(obj)) {
+			unknown = append(unknown, unknownConfigFields(obj[key], t.Elem(), joinFieldPath(path, key))...)
+		}
+	case reflect.Struct:
+		obj, _ := raw.(map[string]any)
+		for _, key := range slices.Sorted(maps.Keys(obj)) {
+			field, ok := jsonField(t, key)
+			if !ok {
+				unknown = append(unknown, joinFieldPath(path, key))
+
+				continue
+			}
+
+			unknown = append(unknown, unknownConfigFields(obj[key], field.Type, joinFieldPath(path, key))...)
+		}
+	default:
+	}
+
+	return unknown
+}
+
+// jsonField returns the field of struct type t that encoding/json decodes
+// key into. Like encoding/json, names match case-insensitively.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
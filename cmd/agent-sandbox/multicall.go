@@ -225,7 +225,7 @@ func runGitPreset(ctx context.Context, runtimeRoot string, cmdArgs []string, std
 	}
 
 	if hasInlineAliasConfig(cmdArgs) {
-		return errors.New("git alias overrides via -c/--config-env are blocked; configure aliases outside the sandbox")
+		return denied("git alias overrides via -c/--config-env are blocked; configure aliases outside the sandbox")
 	}
 
 	subcommand, subcommandArgs := parseGitArgs(cmdArgs)
@@ -366,6 +366,20 @@ func parseGitArgs(args []string) (string, []string) {
 	return "", nil
 }
 
+// deniedError reports an invocation that a preset wrapper refuses to run.
+// Run exits with [sandbox.ExitDenied] for it, like for a blocked command.
+type deniedError struct {
+	reason string
+}
+
+func (e *deniedError) Error() string {
+	return e.reason
+}
+
+func denied(reason string) error {
+	return &deniedError{reason: reason}
+}
+
 func isBlockedGitOperation(subcommand string, args []string) error {
 	inTemp, err := isInTempDir()
 	if err != nil {
@@ -378,30 +392,30 @@ func isBlockedGitOperation(subcommand string, args []string) error {
 
 	switch subcommand {
 	case "checkout":
-		return errors.New("git checkout blocked: can discard uncommitted changes; use 'git switch' for branches")
+		return denied("git checkout blocked: can discard uncommitted changes; use 'git switch' for branches")
 	case "restore":
-		return errors.New("git restore blocked: discards uncommitted changes; commit or stash first")
+		return denied("git restore blocked: discards uncommitted changes; commit or stash first")
 	case "reset":
 		if hasFlag(args, "--hard") {
-			return errors.New("git reset --hard blocked: discards commits and changes; use 'git reset --soft' or 'git revert'")
+			return denied("git reset --hard blocked: discards commits and changes; use 'git reset --soft' or 'git revert'")
 		}
 	case "clean":
 		if hasFlag(args, "-f", "--force") {
-			return errors.New("git clean -f blocked: deletes untracked files; review manually")
+			return denied("git clean -f blocked: deletes untracked files; review manually")
 		}
 	case "commit":
 		if hasFlag(args, "--no-verify", "-n") {
-			return errors.New("git commit --no-verify blocked: bypasses safety hooks; fix the hook issues")
+			return denied("git commit --no-verify blocked: bypasses safety hooks; fix the hook issues")
 		}
 	case "stash":
 		if len(args) > 0 {
 			switch args[0] {
 			case "drop":
-				return errors.New("git stash drop blocked: permanently deletes stash; keep stashes or export first")
+				return denied("git stash drop blocked: permanently deletes stash; keep stashes or export first")
 			case "clear":
-				return errors.New("git stash clear blocked: deletes all stashes; export important stashes first")
+				return denied("git stash clear blocked: deletes all stashes; export important stashes first")
 			case "pop":
-				return errors.New("git stash pop blocked: can cause merge conflicts that lose stash; use 'git stash apply'")
+				return denied("git stash pop blocked: can cause merge conflicts that lose stash; use 'git stash apply'")
 			}
 		}
 	case "branch":
@@ -409,7 +423,7 @@ func isBlockedGitOperation(subcommand string, args []string) error {
 
 		forceFlag := hasFlag(args, "-f", "--force")
 		if hasFlag(args, "-D") || (deleteFlag && forceFlag) {
-			return errors.New("git branch -D blocked: force deletes unmerged branch; use 'git branch -d' (safe delete)")
+			return denied("git branch -D blocked: force deletes unmerged branch; use 'git branch -d' (safe delete)")
 		}
 	case "push":
 		forceFlag := hasFlag(args, "--force", "-f")
@@ -417,10 +431,10 @@ func isBlockedGitOperation(subcommand string, args []string) error {
 		forceWithLease := hasFlag(args, "--force-with-lease")
 		if forceFlag {
 			if forceWithLease {
-				return errors.New("git push --force blocked: use 'git push --force-with-lease' without --force/-f")
+				return denied("git push --force blocked: use 'git push --force-with-lease' without --force/-f")
 			}
 
-			return errors.New("git push --force blocked: rewrites remote history; use 'git push --force-with-lease'")
+			return denied("git push --force blocked: rewrites remote history; use 'git push --force-with-lease'")
 		}
 	}
 
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
 )
 
 func Test_MulticallCmd_Not_In_Help_When_Help_Is_Shown(t *testing.T) {
@@ -60,4 +66,34 @@ func Test_IsGitOperationBlocked_Blocks_Reset_Hard_When_Hard_Reset_Is_Requested(t
 	if err == nil {
 		t.Error("reset --hard should be blocked")
 	}
+
+	var deniedErr *deniedError
+	if !errors.As(err, &deniedErr) {
+		t.Errorf("expected a denial error, got %T: %v", err, err)
+	}
+}
+
+func Test_MulticallExitCode_Returns_ExitDenied_When_Git_Preset_Denies(t *testing.T) {
+	t.Parallel()
+
+	runtimeRoot := t.TempDir()
+	mustMkdir(t, filepath.Join(runtimeRoot, "bin"))
+	mustWriteFile(t, filepath.Join(runtimeRoot, "bin", "git"), "#!/bin/sh\nexit 0\n")
+
+	var stdout, stderr bytes.Buffer
+
+	err := runGitPreset(t.Context(), runtimeRoot, []string{"-c", "alias.co=checkout", "co", "."}, strings.NewReader(""), &stdout, &stderr)
+
+	code := multicallExitCode(err, &stderr)
+	if code != sandbox.ExitDenied {
+		t.Fatalf("exit code = %d, want %d (err: %v)", code, sandbox.ExitDenied, err)
+	}
+
+	if !strings.Contains(stderr.String(), "blocked") {
+		t.Errorf("expected the denial to be printed, got %q", stderr.String())
+	}
+
+	if code := multicallExitCode(os.ErrNotExist, &stderr); code != 1 {
+		t.Errorf("exit code for other errors = %d, want 1", code)
+	}
 }
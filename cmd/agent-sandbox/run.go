@@ -13,6 +13,8 @@ import (
 	"time"
 
 	flag "github.com/spf13/pflag"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
 )
 
 const (
@@ -34,6 +36,19 @@ const (
 // Returns exit code.
 // sigCh can be nil if signal handling is not needed (e.g., in tests).
 func Run(stdin io.Reader, stdout, stderr io.Writer, args []string, env map[string]string, sigCh <-chan os.Signal) int {
+	// With a umask configured, the sandbox starts commands through the
+	// launcher as the umask shim, which only returns on failure. It runs
+	// before the prerequisite checks: the sandbox may have no bwrap (or run
+	// as fake root), and in audit and restricted mode it runs on the host.
+	if len(args) > 0 && filepath.Base(args[0]) == sandbox.UmaskShimName {
+		code, shimErr := sandbox.UmaskShim(args[1:])
+		if shimErr != nil {
+			fprintError(stderr, shimErr)
+		}
+
+		return code
+	}
+
 	err := checkPlatformPrerequisites()
 	if err != nil {
 		fprintError(stderr, err)
@@ -59,63 +74,70 @@ func Run(stdin io.Reader, stdout, stderr io.Writer, args []string, env map[strin
 			return 1
 		}
 
-		if invoked != agentSandboxExecutableName && insideSandbox && isWrappedCommandName(invoked) {
-			err = runMulticall(context.Background(), invoked, args[1:], stdin, stdout, stderr, env)
-			if err != nil {
-				var exitErr *exec.ExitError
-				if errors.As(err, &exitErr) {
-					// Pass through errors from scripts without printing to stderr (its already connected)
-					return exitErr.ExitCode()
-				}
+		// With a network zone selected, the sandbox starts commands through
+		// the launcher as the proxy bridge (see sandbox.ProxyBridge).
+		if invoked == sandbox.ProxyBridgeName && insideSandbox {
+			code, bridgeErr := sandbox.ProxyBridge(context.Background(), args[1:], stdin, stdout, stderr)
+			if bridgeErr != nil {
+				fprintError(stderr, bridgeErr)
+			}
 
-				fprintError(stderr, err)
+			return code
+		}
 
-				return 1
-			}
+		if invoked != agentSandboxExecutableName && insideSandbox && isWrappedCommandName(invoked) {
+			err = runMulticall(context.Background(), invoked, args[1:], stdin, stdout, stderr, env)
 
-			return 0
+			return multicallExitCode(err, stderr)
 		}
 	}
 
-	flags := flag.NewFlagSet(agentSandboxExecutableName, flag.ContinueOnError)
-	// Stop parsing at first non-flag (the command),
-	// important, oterhwise we can't find where the "real" command begins.
-	flags.SetInterspersed(false)
-	flags.Usage = func() {}
-	flags.SetOutput(&strings.Builder{})
+	flags := newFlagSet()
 
-	flagHelp := flags.BoolP("help", "h", false, "Show help")
-	flagVersion := flags.BoolP("version", "v", false, "Show version and exit")
-	flagCheck := flags.Bool("check", false, "Check if running inside sandbox and exit")
+	usage := printUsage
 
-	flagCwd := flags.StringP("cwd", "C", "", "Run as if started in `dir`")
-	flagConfig := flags.StringP("config", "c", "", "Use specified config `file`")
+	err = flags.Parse(args[1:])
 
-	flags.Bool("network", true, "Enable network access")
-	flags.Bool("docker", false, "Enable docker socket access")
-	flags.Bool("dry-run", false, "Print bwrap command without executing")
-	flags.Bool("debug", false, "Print sandbox startup details to stderr")
-	flags.StringArray("ro", nil, "Add read-only path")
-	flags.StringArray("rw", nil, "Add read-write path")
-	flags.StringArray("exclude", nil, "Add excluded path")
-	flags.StringArray("cmd", nil, "Command wrapper override (KEY=VALUE, repeatable)")
+	// The "run" and "learn" subcommands accept additional policy flags
+	// (--preset, --block, --verbose) after the global ones. A command literally
+	// named "run" can still be executed with "agent-sandbox -- run".
+	subcommand := ""
+	if err == nil && flags.ArgsLenAtDash() != 0 && (flags.Arg(0) == runSubcommandName || flags.Arg(0) == learnSubcommandName) {
+		subcommand = flags.Arg(0)
+
+		usage = printRunUsage
+		if subcommand == learnSubcommandName {
+			usage = printLearnUsage
+		}
+
+		addRunFlags(flags)
+
+		err = flags.Parse(flags.Args()[1:])
+	}
 
-	err = flags.Parse(args[1:])
 	if err != nil {
 		fprintError(stderr, err)
 		fprintln(stderr)
-		printUsage(stderr)
+		usage(stderr)
 
 		return 1
 	}
 
-	if *flagVersion {
+	flagHelp, _ := flags.GetBool("help")
+	flagVersion, _ := flags.GetBool("version")
+	flagCheck, _ := flags.GetBool("check")
+	flagCwd, _ := flags.GetString("cwd")
+	flagConfig, _ := flags.GetString("config")
+	flagStrictConfig, _ := flags.GetBool("strict-config")
+	flagProfile, _ := flags.GetString("profile")
+
+	if flagVersion {
 		fprintf(stdout, "%s\n", formatVersion())
 
 		return 0
 	}
 
-	if *flagCheck {
+	if flagCheck {
 		inside, insideErr := isInsideSandbox()
 		if insideErr != nil {
 			fprintError(stderr, fmt.Errorf("checking if inside sandbox: %w", insideErr))
@@ -136,17 +158,37 @@ func Run(stdin io.Reader, stdout, stderr io.Writer, args []string, env map[strin
 
 	commandAndArgs := flags.Args()
 
-	if *flagHelp || len(commandAndArgs) == 0 {
-		printUsage(stdout)
+	// Introspection, history and clean subcommands never start a sandbox. As with
+	// "run", "agent-sandbox -- presets" executes a command of that name
+	// instead.
+	if subcommand == "" && !flagHelp && flags.ArgsLenAtDash() != 0 && len(commandAndArgs) > 0 {
+		handler := introspectionCommand(commandAndArgs[0])
+		if handler != nil {
+			return handler(stdout, stderr, commandAndArgs[1:])
+		}
+
+		if commandAndArgs[0] == historySubcommandName {
+			return runHistoryCommand(stdout, stderr, commandAndArgs[1:], env, flagCwd)
+		}
+
+		if commandAndArgs[0] == cleanSubcommandName {
+			return runCleanCommand(stdout, stderr, commandAndArgs[1:], env)
+		}
+	}
+
+	if flagHelp || len(commandAndArgs) == 0 {
+		usage(stdout)
 
 		return 0
 	}
 
 	cfg, err := LoadConfig(LoadConfigInput{
-		WorkDirOverride: *flagCwd,
-		ConfigPath:      *flagConfig,
+		WorkDirOverride: flagCwd,
+		ConfigPath:      flagConfig,
 		EnvVars:         env,
 		CLIFlags:        flags,
+		StrictConfig:    flagStrictConfig,
+		Profile:         flagProfile,
 	})
 	if err != nil {
 		fprintError(stderr, err)
@@ -154,6 +196,10 @@ func Run(stdin io.Reader, stdout, stderr io.Writer, args []string, env map[strin
 		return 1
 	}
 
+	for _, warning := range cfg.Warnings {
+		fprintf(stderr, "warning: %s\n", warning)
+	}
+
 	debugEnabled, _ := flags.GetBool("debug")
 
 	var debug *DebugLogger
@@ -183,17 +229,24 @@ func Run(stdin io.Reader, stdout, stderr io.Writer, args []string, env map[strin
 	done := make(chan sandboxResult, 1)
 
 	dryRun, _ := flags.GetBool("dry-run")
+	verbose, _ := flags.GetBool("verbose")
+	githubActions, _ := flags.GetBool("github-actions")
+	asJSON, _ := flags.GetBool("json")
 
 	go func() {
 		exitCode, execErr := ExecuteSandbox(ctx, &ExecuteSandboxInput{
-			Stdin:  stdin,
-			Stdout: stdout,
-			Stderr: stderr,
-			Config: &cfg,
-			Env:    env,
-			Args:   commandAndArgs,
-			Debug:  debug,
-			DryRun: dryRun,
+			Stdin:         stdin,
+			Stdout:        stdout,
+			Stderr:        stderr,
+			Config:        &cfg,
+			Env:           env,
+			Args:          commandAndArgs,
+			Debug:         debug,
+			DryRun:        dryRun,
+			Verbose:       verbose,
+			Learn:         subcommand == learnSubcommandName,
+			GitHubActions: githubActions,
+			JSON:          asJSON,
 		})
 		done <- sandboxResult{exitCode: exitCode, err: execErr}
 	}()
@@ -259,6 +312,7 @@ Flags:
       --check            Check if running inside sandbox and exit
   -C, --cwd <dir>        Run as if started in <dir>
   -c, --config <file>    Use specified config file
+      --strict-config    Reject unknown config fields instead of warning
       --network          Enable network access (default: true)
       --docker           Enable docker socket access
       --dry-run          Print bwrap command without executing
@@ -268,16 +322,114 @@ Flags:
       --exclude <path>   Exclude path from sandbox (repeatable)
       --cmd <key=value>  Command wrapper override (repeatable)
 
+Commands:
+  run                    Run a command with additional policy flags (see: agent-sandbox run --help)
+  presets list [--json]  List built-in filesystem presets
+  config schema          Print the JSON Schema of the config file format
+  completion <shell>     Print shell completion script (bash, zsh, fish)
+  history [--json]       Show this project's recorded commands (requires "history": true in config)
+  clean [--dry-run]      Remove state of deleted projects and temp dirs of killed runs
+  learn -- <command>     Run without isolation and print a config suggestion for what was denied
+
 Examples:
   agent-sandbox echo hello
   agent-sandbox --network=false bash
   agent-sandbox --ro /data --rw /tmp/out my-script.sh
   agent-sandbox --check`
 
+const runUsageHelp = `agent-sandbox run - run a command in the sandbox
+
+Usage: agent-sandbox run [flags] -- <command> [args]
+
+Accepts all agent-sandbox flags, plus:
+      --preset <name>    Add filesystem preset, e.g. @base or !@lint/all (repeatable)
+      --block <command>  Block command (repeatable, same as --cmd <command>=false)
+      --label <k>=<v>    Attach a label to the history entry (repeatable)
+      --verbose          Print the resolved sandbox plan to stderr
+      --github-actions   Apply GitHub Actions defaults and append exit-code and
+                         blocked-commands to $GITHUB_OUTPUT
+      --json             Report blocked commands as JSON lines on stderr;
+                         with --dry-run, print grouped bwrap args as JSON
+
+The command's exit code is propagated. SIGINT/SIGTERM are forwarded to the
+sandboxed process. After it exits, each blocked command it invoked is
+reported on stderr with the rule that blocked it and a config fragment that
+allows it. Colors follow NO_COLOR and FORCE_COLOR.
+
+Examples:
+  agent-sandbox run --preset @base --rw build/ --block git -- npm test
+  agent-sandbox run --verbose --network=false -- make`
+
+const learnUsageHelp = `agent-sandbox learn - suggest a config from an unsandboxed run
+
+Usage: agent-sandbox learn [flags] -- <command> [args] > suggested.jsonc
+
+Accepts the same flags as "agent-sandbox run". The command runs WITHOUT
+isolation (audit mode); blocked commands still run but are recorded. Its
+stdout is redirected to stderr. Afterwards a config fragment is printed to
+stdout that allows the blocked commands that ran and makes paths writable
+that changed below read-only rules during the run (by any process). File
+accesses are not traced, so reads of excluded paths are not detected.
+
+Examples:
+  agent-sandbox learn -- npm test > suggested.jsonc`
+
+// runSubcommandName is the name of the subcommand with extended policy flags.
+const runSubcommandName = "run"
+
+// newFlagSet returns the global agent-sandbox flags. It is shared by Run and
+// the completion generators so both always agree on the flag set.
+func newFlagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(agentSandboxExecutableName, flag.ContinueOnError)
+	// Stop parsing at first non-flag (the command),
+	// important, oterhwise we can't find where the "real" command begins.
+	flags.SetInterspersed(false)
+	flags.Usage = func() {}
+	flags.SetOutput(&strings.Builder{})
+
+	flags.BoolP("help", "h", false, "Show help")
+	flags.BoolP("version", "v", false, "Show version and exit")
+	flags.Bool("check", false, "Check if running inside sandbox and exit")
+
+	flags.StringP("cwd", "C", "", "Run as if started in `dir`")
+	flags.StringP("config", "c", "", "Use specified config `file`")
+	flags.Bool("strict-config", false, "Reject unknown config fields instead of warning")
+	flags.String("profile", "", "Apply the config profile `name`")
+
+	flags.Bool("network", true, "Enable network access")
+	flags.Bool("docker", false, "Enable docker socket access")
+	flags.Bool("dry-run", false, "Print bwrap command without executing")
+	flags.Bool("debug", false, "Print sandbox startup details to stderr")
+	flags.StringArray("ro", nil, "Add read-only path")
+	flags.StringArray("rw", nil, "Add read-write path")
+	flags.StringArray("exclude", nil, "Add excluded path")
+	flags.StringArray("cmd", nil, "Command wrapper override (KEY=VALUE, repeatable)")
+
+	return flags
+}
+
+// addRunFlags adds the policy flags accepted by the "run" subcommand.
+func addRunFlags(flags *flag.FlagSet) {
+	flags.StringArray("preset", nil, "Add filesystem preset")
+	flags.StringArray("block", nil, "Block command")
+	flags.StringArray("label", nil, "Attach label to history entries (KEY=VALUE, repeatable)")
+	flags.Bool("verbose", false, "Print the resolved sandbox plan to stderr")
+	flags.Bool("github-actions", false, "Apply GitHub Actions defaults and write step outputs")
+	flags.Bool("json", false, "Report blocked commands as JSON lines on stderr; with --dry-run, print grouped bwrap args as JSON")
+}
+
 func printUsage(output io.Writer) {
 	fprintln(output, usageHelp)
 }
 
+func printRunUsage(output io.Writer) {
+	fprintln(output, runUsageHelp)
+}
+
+func printLearnUsage(output io.Writer) {
+	fprintln(output, learnUsageHelp)
+}
+
 func fprintln(out io.Writer, a ...any) {
 	_, _ = fmt.Fprintln(out, a...)
 }
@@ -287,7 +439,7 @@ func fprintf(out io.Writer, format string, a ...any) {
 }
 
 func fprintError(out io.Writer, err error) {
-	if isTerminal() {
+	if colorEnabled(out) {
 		fprintln(out, "\033[31magent-sandbox: error:\033[0m", err)
 	} else {
 		fprintln(out, "agent-sandbox: error:", err)
@@ -302,8 +454,24 @@ func formatVersion() string {
 	return fmt.Sprintf("agent-sandbox %s (%s, %s)", version, commit, date)
 }
 
-func isTerminal() bool {
-	stat, err := os.Stdin.Stat()
+// colorEnabled reports whether output to out may use ANSI colors: never if
+// NO_COLOR is set, always if FORCE_COLOR is set to anything but "0", and
+// otherwise only if out is a terminal.
+func colorEnabled(out io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	if force := os.Getenv("FORCE_COLOR"); force != "" {
+		return force != "0"
+	}
+
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+
+	stat, err := file.Stat()
 	if err != nil {
 		return false
 	}
@@ -327,3 +495,27 @@ func checkPlatformPrerequisites() error {
 
 	return nil
 }
+
+// multicallExitCode returns the exit code of a wrapped command invocation
+// that ended with err, printing err unless it comes from the command itself.
+// Invocations a preset denies exit with [sandbox.ExitDenied].
+func multicallExitCode(err error, stderr io.Writer) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Pass through errors from scripts without printing to stderr (its already connected)
+		return exitErr.ExitCode()
+	}
+
+	fprintError(stderr, err)
+
+	var deniedErr *deniedError
+	if errors.As(err, &deniedErr) {
+		return sandbox.ExitDenied
+	}
+
+	return 1
+}
@@ -197,6 +197,76 @@ func Test_Run_Help_Shows_Network_Flag(t *testing.T) {
 	AssertContains(t, stdout, "--network")
 }
 
+func Test_Run_Global_Help_Lists_Run_Subcommand(t *testing.T) {
+	t.Parallel()
+
+	c := NewCLITester(t)
+	stdout, _, code := c.Run("--help")
+
+	if code != 0 {
+		t.Errorf("exit code = %d, want 0", code)
+	}
+
+	AssertContains(t, stdout, "Commands:")
+	AssertContains(t, stdout, "run --help")
+}
+
+func Test_Run_Subcommand_Help_Shows_Policy_Flags(t *testing.T) {
+	t.Parallel()
+
+	c := NewCLITester(t)
+	stdout, _, code := c.Run("run", "--help")
+
+	if code != 0 {
+		t.Errorf("exit code = %d, want 0", code)
+	}
+
+	AssertContains(t, stdout, "agent-sandbox run - run a command in the sandbox")
+	AssertContains(t, stdout, "--preset")
+	AssertContains(t, stdout, "--block")
+	AssertContains(t, stdout, "--verbose")
+}
+
+func Test_Run_Subcommand_Shows_Help_When_No_Command(t *testing.T) {
+	t.Parallel()
+
+	c := NewCLITester(t)
+	stdout, _, code := c.Run("run")
+
+	if code != 0 {
+		t.Errorf("exit code = %d, want 0", code)
+	}
+
+	AssertContains(t, stdout, "Usage: agent-sandbox run")
+}
+
+func Test_Run_Subcommand_Fails_With_Run_Usage_When_Unknown_Flag(t *testing.T) {
+	t.Parallel()
+
+	c := NewCLITester(t)
+	_, stderr, code := c.Run("run", "--bogus", "--", "true")
+
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+
+	AssertContains(t, stderr, "unknown flag: --bogus")
+	AssertContains(t, stderr, "Usage: agent-sandbox run")
+}
+
+func Test_Run_Policy_Flags_Rejected_Without_Run_Subcommand(t *testing.T) {
+	t.Parallel()
+
+	c := NewCLITester(t)
+	_, stderr, code := c.Run("--block", "git", "true")
+
+	if code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+
+	AssertContains(t, stderr, "unknown flag: --block")
+}
+
 func Test_Config_Uses_Defaults_When_No_Config_File(t *testing.T) {
 	t.Parallel()
 
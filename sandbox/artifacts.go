@@ -0,0 +1,204 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the artifact store for policy tooling.
+//
+// Launcher binaries and wrapper scripts are ordinary host files referenced by
+// [Commands.Launcher] and [Wrapper.Path]. An [ArtifactStore] lets callers
+// resolve them from a shared cache directory instead, optionally populated
+// from an HTTP base URL. Downloads are only accepted when they match a pinned
+// SHA-256 checksum, so a compromised or misconfigured server cannot change
+// policy tooling.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxArtifactSize bounds downloads so a misbehaving server cannot fill the
+// cache directory.
+const maxArtifactSize = 512 << 20
+
+// ArtifactStore resolves named policy artifacts (launcher binaries, wrapper
+// scripts) to verified host paths.
+//
+// The zero value is not usable; Dir must be set.
+type ArtifactStore struct {
+	// Dir is the cache directory. Artifact NAME lives at Dir/NAME.
+	Dir string
+
+	// BaseURL, if set, is where missing or stale artifacts are downloaded
+	// from: NAME is fetched from BaseURL/NAME. Every artifact fetched over HTTP
+	// must have a pinned checksum in SHA256.
+	BaseURL string
+
+	// SHA256 maps artifact names to pinned lowercase hex SHA-256 digests.
+	//
+	// Artifacts with a pinned digest are verified on every [ArtifactStore.Path]
+	// call, including cache hits. Artifacts without one are only served from
+	// Dir, which is then trusted like any other host path.
+	SHA256 map[string]string
+
+	// Client is used for downloads. If nil, [http.DefaultClient] is used.
+	Client *http.Client
+}
+
+// Path returns the host path of artifact name, downloading it into Dir first
+// when it is missing or does not match its pinned checksum.
+//
+// name is a slash-separated path relative to Dir (e.g. "wrappers/git.sh").
+func (s *ArtifactStore) Path(ctx context.Context, name string) (string, error) {
+	if s == nil || s.Dir == "" {
+		return "", errors.New("artifact store: Dir is empty")
+	}
+
+	if !isValidArtifactName(name) {
+		return "", fmt.Errorf("artifact %q: name must be a relative path without '..'", name)
+	}
+
+	want := strings.ToLower(s.SHA256[name])
+	path := filepath.Join(s.Dir, filepath.FromSlash(name))
+
+	_, err := os.Stat(path)
+
+	switch {
+	case err == nil && want == "":
+		return path, nil
+	case err == nil:
+		got, hashErr := fileSHA256(path)
+		if hashErr != nil {
+			return "", fmt.Errorf("artifact %q: %w", name, hashErr)
+		}
+
+		if got == want {
+			return path, nil
+		}
+
+		if s.BaseURL == "" {
+			return "", fmt.Errorf("artifact %q: checksum mismatch: got sha256 %s, want %s", name, got, want)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return "", fmt.Errorf("artifact %q: %w", name, err)
+	case s.BaseURL == "":
+		return "", fmt.Errorf("artifact %q: not found in %s", name, s.Dir)
+	}
+
+	if want == "" {
+		return "", fmt.Errorf("artifact %q: refusing to download without a pinned sha256 checksum", name)
+	}
+
+	err = s.download(ctx, name, path, want)
+	if err != nil {
+		return "", fmt.Errorf("artifact %q: %w", name, err)
+	}
+
+	return path, nil
+}
+
+// download fetches name into a temp file next to path, verifies it and
+// atomically renames it into place.
+func (s *ArtifactStore) download(ctx context.Context, name, path, want string) error {
+	src, err := url.JoinPath(s.BaseURL, name)
+	if err != nil {
+		return fmt.Errorf("building download URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("downloading: %w", err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: unexpected status %s", src, resp.Status)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".download-*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	hash := sha256.New()
+
+	n, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(resp.Body, maxArtifactSize+1))
+
+	closeErr := tmp.Close()
+
+	switch {
+	case err != nil:
+		return fmt.Errorf("downloading %s: %w", src, err)
+	case closeErr != nil:
+		return fmt.Errorf("writing temp file: %w", closeErr)
+	case n > maxArtifactSize:
+		return fmt.Errorf("downloading %s: exceeds %d bytes", src, maxArtifactSize)
+	}
+
+	got := hex.EncodeToString(hash.Sum(nil))
+	if got != want {
+		return fmt.Errorf("downloaded checksum mismatch: got sha256 %s, want %s", got, want)
+	}
+
+	// Artifacts are launchers and scripts; keep them executable but read-only.
+	err = os.Chmod(tmp.Name(), 0o555)
+	if err != nil {
+		return fmt.Errorf("setting permissions: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("installing artifact: %w", err)
+	}
+
+	return nil
+}
+
+func isValidArtifactName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, `\`) {
+		return false
+	}
+
+	for part := range strings.SplitSeq(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+func fileSHA256(path string) (string, error) {
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,356 @@
+//go:build linux
+
+package sandbox
+
+// This file implements audit mode.
+//
+// In audit mode commands run directly on the host, without bwrap. The policy
+// is still planned as usual so that construction fails (or succeeds) exactly
+// as it would when enforcing.
+//
+// Audit mode is a static policy report, not a trace. Findings describe the
+// planned rules (the paths the policy hides or makes read-only and the
+// commands it blocks) and are reported each time a command is prepared,
+// whether or not the command touches them. Nothing observes file accesses.
+// The only runtime signals are the report-only shims of blocked commands,
+// which log each invocation and then exec the real binary, and
+// [Sandbox.AuditChanges], which lists files that changed below read-only
+// paths without knowing who changed them.
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Mode selects whether the sandbox policy is enforced.
+type Mode string
+
+const (
+	// ModeEnforce runs commands inside bwrap with the policy applied.
+	ModeEnforce Mode = "enforce"
+	// ModeAudit runs commands without isolation and reports the policy's
+	// rules via [Config.Audit] as a static report; accesses are not traced.
+	ModeAudit Mode = "audit"
+	// ModeRestricted is partial enforcement for hosts where bwrap cannot run:
+	// commands run without filesystem, network or namespace isolation, but
+	// command rules (through PATH shims) and the environment policy are
+	// applied. The remaining findings are reported like in ModeAudit.
+	ModeRestricted Mode = "restricted"
+)
+
+// AuditKind classifies an [AuditFinding].
+type AuditKind string
+
+const (
+	// AuditExclude reports a path the policy would hide.
+	AuditExclude AuditKind = "exclude"
+	// AuditReadOnly reports a path the policy would make read-only.
+	AuditReadOnly AuditKind = "read-only"
+	// AuditBlock reports a command the policy would block.
+	AuditBlock AuditKind = "block"
+	// AuditChange reports a file that changed below a read-only path; see
+	// [Sandbox.AuditChanges].
+	AuditChange AuditKind = "change"
+)
+
+// maxAuditWalk bounds the number of entries [Sandbox.AuditChanges] inspects
+// below each read-only path.
+const maxAuditWalk = 200_000
+
+// auditTimestampSlack widens the [Sandbox.AuditChanges] window: the kernel
+// stamps files from a coarse clock that can lag time.Now by a scheduler tick.
+const auditTimestampSlack = 20 * time.Millisecond
+
+// AuditFinding is a single policy rule that audit mode reports instead of
+// enforcing. Except for [AuditChange], findings come from the plan, not
+// from accesses the command made.
+type AuditFinding struct {
+	Kind AuditKind
+
+	// Path is the resolved host path. For AuditBlock it is the binary the
+	// command resolves to.
+	Path string
+
+	// Command is the blocked command name (AuditBlock only).
+	Command string
+
+	// Argv is the command being prepared when the finding was reported. It
+	// does not mean the command used Path.
+	Argv []string
+
+	// Labels are the [Config.Labels] of the sandbox.
+	Labels map[string]string
+}
+
+// String formats the finding as a single log line.
+func (f AuditFinding) String() string {
+	switch f.Kind {
+	case AuditBlock:
+		return fmt.Sprintf("audit: policy blocks command %q (%s)", f.Command, f.Path)
+	case AuditChange:
+		return fmt.Sprintf("audit: %s changed below a read-only path", f.Path)
+	}
+
+	return fmt.Sprintf("audit: policy applies %s to %s", f.Kind, f.Path)
+}
+
+func validateMode(mode Mode) []error {
+	switch mode {
+	case "", ModeEnforce, ModeAudit, ModeRestricted:
+		return nil
+	default:
+		return []error{fmt.Errorf("invalid Mode %q (expected %q, %q or %q)", mode, ModeEnforce, ModeAudit, ModeRestricted)}
+	}
+}
+
+// AuditChanges lists entries below the policy's read-only paths whose change
+// time is not before since. Subtrees covered by a more specific read-write or
+// exclude rule are skipped, and at most maxAuditWalk entries are inspected per
+// read-only path.
+//
+// It is a heuristic, not a trace: callers record since before running a
+// command and call AuditChanges after it exits, and every change in that
+// window is reported, including those made by other processes. Reads and
+// failed writes are not detected.
+func (s *Sandbox) AuditChanges(since time.Time) ([]AuditFinding, error) {
+	if s == nil || s.plan == nil {
+		return nil, errors.New("sandbox: uninitialized sandbox (use New or NewWithEnvironment)")
+	}
+
+	// Paths governed by a rule other than read-only.
+	skip := make(map[string]bool, len(s.plan.auditWritable))
+	for _, path := range s.plan.auditWritable {
+		skip[path] = true
+	}
+
+	var roots []string
+
+	for _, f := range s.plan.auditFindings {
+		switch f.Kind {
+		case AuditExclude:
+			skip[f.Path] = true
+		case AuditReadOnly:
+			roots = append(roots, f.Path)
+		}
+	}
+
+	var findings []AuditFinding
+
+	seen := make(map[string]bool)
+	since = since.Add(-auditTimestampSlack)
+
+	for _, root := range roots {
+		visited := 0
+
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return skipUnreadable(err)
+			}
+
+			if path != root && skip[path] {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			visited++
+			if visited > maxAuditWalk {
+				if s.v.cfg.Debugf != nil {
+					s.v.cfg.Debugf("audit: stopped scanning %s after %d entries", root, maxAuditWalk)
+				}
+
+				return filepath.SkipAll
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return skipUnreadable(err)
+			}
+
+			st, ok := info.Sys().(*syscall.Stat_t)
+			if !ok || time.Unix(st.Ctim.Unix()).Before(since) || seen[path] {
+				return nil
+			}
+
+			seen[path] = true
+			findings = append(findings, AuditFinding{Kind: AuditChange, Path: path, Labels: maps.Clone(s.v.cfg.Labels)})
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: audit: scanning %s: %w", root, err)
+		}
+	}
+
+	return findings, nil
+}
+
+// skipUnreadable ignores entries that vanished or cannot be read during a
+// walk; they cannot be attributed to a change.
+func skipUnreadable(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return nil
+	}
+
+	return err
+}
+
+// auditFindingsFromResolved lists the resolved rules that restrict access.
+func auditFindingsFromResolved(resolved []resolvedRule) []AuditFinding {
+	var findings []AuditFinding
+
+	for _, rule := range resolved {
+		switch rule.kind {
+		case MountReadOnly, MountReadOnlyTry:
+			findings = append(findings, AuditFinding{Kind: AuditReadOnly, Path: rule.resolved})
+		case MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir, MountExcludeAuto:
+			findings = append(findings, AuditFinding{Kind: AuditExclude, Path: rule.resolved})
+		default:
+			// Read-write rules deny nothing.
+		}
+	}
+
+	return findings
+}
+
+// auditExecSpec prepares argv to run directly on the host and reports the
+// plan's findings.
+func (s *Sandbox) auditExecSpec(argv []string, leadingFiles []*os.File) (*ExecSpec, func() error, error) {
+	noop := func() error { return nil }
+
+	s.reportAudit(argv)
+
+	env := slices.Clone(s.v.envSlice)
+
+	cleanup := noop
+
+	if len(s.plan.blocked) > 0 {
+		shimDir, shimCleanup, err := writeAuditShims(s.plan.blocked, s.v.cfg.AuditLog)
+		if err != nil {
+			return nil, noop, fmt.Errorf("sandbox: audit shims: %w", err)
+		}
+
+		cleanup = shimCleanup
+		env = prependPath(env, shimDir)
+	}
+
+	path, err := lookPathIn(s.v.env.hostFS(), argv[0], env, s.v.env.WorkDir)
+	if err != nil {
+		return nil, noop, errors.Join(fmt.Errorf("sandbox: audit: %w", err), cleanup())
+	}
+
+	spec := &ExecSpec{
+		Path:       path,
+		Args:       slices.Clone(argv),
+		Env:        env,
+		Dir:        s.v.env.WorkDir,
+		ExtraFiles: slices.Clone(leadingFiles),
+	}
+
+	return spec, cleanup, nil
+}
+
+// reportAudit reports the plan's findings for argv, except those of the
+// enforced kinds.
+func (s *Sandbox) reportAudit(argv []string, enforced ...AuditKind) {
+	report := s.v.cfg.Audit
+	if report == nil {
+		report = func(f AuditFinding) {
+			if s.v.cfg.Debugf != nil {
+				s.v.cfg.Debugf("%s", f)
+			}
+		}
+	}
+
+	for _, finding := range s.plan.auditFindings {
+		if slices.Contains(enforced, finding.Kind) {
+			continue
+		}
+
+		finding.Argv = slices.Clone(argv)
+		finding.Labels = maps.Clone(s.v.cfg.Labels)
+		report(finding)
+	}
+}
+
+// writeAuditShims creates a directory of report-only wrappers, one per blocked
+// command name. Each logs the invocation to stderr and execs the real binary.
+// If logPath is set, each invocation also appends the command name to it.
+// The returned cleanup removes the directory.
+func writeAuditShims(blocked []blockedCommand, logPath string) (string, func() error, error) {
+	dir, cleanup, err := newHostTempDir("audit-*")
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, cmd := range blocked {
+		logLine := ""
+		if logPath != "" {
+			logLine = fmt.Sprintf("printf '%%s\\n' %s >> %s\n", shellQuote(cmd.name), shellQuote(logPath))
+		}
+
+		script := fmt.Sprintf("#!/bin/sh\necho \"agent-sandbox(audit): command '%s' would be blocked\" >&2\n%sexec %s \"$@\"\n", cmd.name, logLine, shellQuote(cmd.target))
+
+		err = os.WriteFile(filepath.Join(dir, cmd.name), []byte(script), 0o755)
+		if err != nil {
+			return "", nil, errors.Join(err, cleanup())
+		}
+	}
+
+	return dir, cleanup, nil
+}
+
+// prependPath returns env with dir prepended to PATH.
+func prependPath(env []string, dir string) []string {
+	for i, kv := range env {
+		if value, ok := strings.CutPrefix(kv, "PATH="); ok {
+			env[i] = "PATH=" + dir + string(os.PathListSeparator) + value
+
+			return env
+		}
+	}
+
+	return append(env, "PATH="+dir)
+}
+
+// lookPathIn resolves name against the PATH in env rather than the current
+// process environment. Relative and empty PATH entries are resolved against
+// workDir, where the command starts, as in wrapper discovery. Candidates are
+// stat'ed in fsys.
+func lookPathIn(fsys HostFS, name string, env []string, workDir string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+
+	pathVar := ""
+
+	for _, kv := range env {
+		if value, ok := strings.CutPrefix(kv, "PATH="); ok {
+			pathVar = value
+		}
+	}
+
+	for _, dir := range filepath.SplitList(pathVar) {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(workDir, dir)
+		}
+
+		candidate := filepath.Join(dir, name)
+
+		info, err := fsys.Stat(candidate)
+		if err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0 {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("%q not found in PATH", name)
+}
@@ -0,0 +1,292 @@
+//go:build linux
+
+package sandbox
+
+// This file implements policy bundles: presets published by third parties as
+// Go packages (see [RegisterBundle]).
+//
+// Bundle presets live in their own namespace ("@acme/strict") so they can
+// never shadow a built-in preset or another bundle. Unlike built-in presets
+// they may contribute masks (see [MaskFS]) and command rules; the mounts are
+// appended after the built-in presets, the command rules are merged into
+// [Config.Commands] during construction.
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// PolicyBundle is a set of presets published under one namespace. A bundle
+// is usually a Go package that registers itself from init:
+//
+//	package strict
+//
+//	func init() {
+//		sandbox.RegisterBundle(sandbox.PolicyBundle{
+//			Namespace: "acme",
+//			Presets: []sandbox.BundlePreset{{
+//				Name:        "strict",
+//				Description: "Cloud credentials excluded, curl blocked",
+//				Mounts: func(sandbox.Environment) []sandbox.Mount {
+//					return []sandbox.Mount{sandbox.ExcludeTry("~/.config/gcloud")}
+//				},
+//				Block: []string{"curl"},
+//			}},
+//		})
+//	}
+//
+// Embedders import the package for its side effect
+// (import _ "github.com/acme/sandbox-policies/strict") and enable the preset
+// by its namespaced name, "@acme/strict", in [Filesystem.Presets].
+type PolicyBundle struct {
+	// Namespace prefixes the names of all presets of the bundle. It must
+	// consist of lowercase letters, digits and dashes, and must not be used
+	// by a built-in preset (such as "agents" or "lint") or another bundle.
+	Namespace string
+
+	// Presets lists the presets of the bundle.
+	Presets []BundlePreset
+}
+
+// BundlePreset is one preset of a [PolicyBundle]. Bundle presets are never
+// enabled by default and are not part of @all.
+type BundlePreset struct {
+	// Name is the preset name within the namespace: "strict" is enabled as
+	// "@acme/strict". The same syntax rules as for namespaces apply.
+	Name string
+
+	// Description is a one-line summary, as in [PresetInfo].
+	Description string
+
+	// Mounts returns the mounts of the preset for env: policy mounts
+	// (RO/RW/Exclude) and masks (see [MaskFS]). It is called on every
+	// construction and may be nil.
+	Mounts func(env Environment) []Mount
+
+	// Block lists commands to block, as [Commands.Block].
+	Block []string
+
+	// Wrappers intercepts commands, as [Commands.Wrappers].
+	Wrappers map[string]Wrapper
+}
+
+// bundleRegistry holds the registered bundle presets by full name, in
+// registration order.
+var bundleRegistry struct {
+	mu      sync.RWMutex
+	names   []string
+	presets map[string]BundlePreset
+}
+
+var bundleNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// RegisterBundle makes the presets of b available in [Filesystem.Presets]
+// and [Presets]. It is meant to be called from init and panics, like
+// database/sql.Register, if b is invalid or a name is already taken by a
+// built-in preset or another bundle.
+func RegisterBundle(b PolicyBundle) {
+	err := registerBundle(b)
+	if err != nil {
+		panic("sandbox: RegisterBundle: " + err.Error())
+	}
+}
+
+func registerBundle(b PolicyBundle) error {
+	if !bundleNamePattern.MatchString(b.Namespace) {
+		return fmt.Errorf("namespace %q: must consist of lowercase letters, digits and dashes", b.Namespace)
+	}
+
+	for _, p := range presetCatalog {
+		if builtinNamespace(p.Name) == b.Namespace {
+			return fmt.Errorf("namespace %q: conflicts with built-in preset %s", b.Namespace, p.Name)
+		}
+	}
+
+	if len(b.Presets) == 0 {
+		return fmt.Errorf("namespace %q: no presets", b.Namespace)
+	}
+
+	prefix := "@" + b.Namespace + "/"
+	names := make([]string, 0, len(b.Presets))
+
+	for _, p := range b.Presets {
+		name := prefix + p.Name
+
+		switch {
+		case !bundleNamePattern.MatchString(p.Name):
+			return fmt.Errorf("preset %q: must consist of lowercase letters, digits and dashes", name)
+		case slices.Contains(names, name):
+			return fmt.Errorf("preset %s: duplicate", name)
+		case strings.TrimSpace(p.Description) == "":
+			return fmt.Errorf("preset %s: no description", name)
+		}
+
+		commands := make(map[string]bool, len(p.Block)+len(p.Wrappers))
+
+		for _, cmd := range slices.Concat(p.Block, slices.Collect(maps.Keys(p.Wrappers))) {
+			switch {
+			case cmd == "" || strings.Contains(cmd, "/"):
+				return fmt.Errorf("preset %s: invalid command name %q", name, cmd)
+			case commands[cmd]:
+				return fmt.Errorf("preset %s: more than one rule for command %q", name, cmd)
+			}
+
+			commands[cmd] = true
+		}
+
+		names = append(names, name)
+	}
+
+	bundleRegistry.mu.Lock()
+	defer bundleRegistry.mu.Unlock()
+
+	for _, name := range bundleRegistry.names {
+		if strings.HasPrefix(name, prefix) {
+			return fmt.Errorf("namespace %q: already registered", b.Namespace)
+		}
+	}
+
+	if bundleRegistry.presets == nil {
+		bundleRegistry.presets = make(map[string]BundlePreset)
+	}
+
+	for i, p := range b.Presets {
+		p.Block = slices.Clone(p.Block)
+		p.Wrappers = maps.Clone(p.Wrappers)
+		bundleRegistry.presets[names[i]] = p
+	}
+
+	bundleRegistry.names = append(bundleRegistry.names, names...)
+
+	return nil
+}
+
+// builtinNamespace returns the namespace a built-in preset name occupies:
+// "@lint/go" occupies "lint", "@base" occupies "base".
+func builtinNamespace(name string) string {
+	namespace, _, _ := strings.Cut(strings.TrimPrefix(name, "@"), "/")
+
+	return namespace
+}
+
+// bundlePresets returns the registered bundle presets as [PresetInfo], in
+// registration order.
+func bundlePresets() []PresetInfo {
+	bundleRegistry.mu.RLock()
+	defer bundleRegistry.mu.RUnlock()
+
+	out := make([]PresetInfo, 0, len(bundleRegistry.names))
+	for _, name := range bundleRegistry.names {
+		out = append(out, PresetInfo{Name: name, Description: bundleRegistry.presets[name].Description})
+	}
+
+	return out
+}
+
+// enabledBundlePresets returns the bundle presets enabled in state, in
+// registration order.
+func enabledBundlePresets(state map[string]bool) ([]string, []BundlePreset) {
+	bundleRegistry.mu.RLock()
+	defer bundleRegistry.mu.RUnlock()
+
+	var (
+		names   []string
+		presets []BundlePreset
+	)
+
+	for _, name := range bundleRegistry.names {
+		if state[name] {
+			names = append(names, name)
+			presets = append(presets, bundleRegistry.presets[name])
+		}
+	}
+
+	return names, presets
+}
+
+// bundleMounts returns the mounts of a bundle preset. A preset with masks
+// always provides protection; otherwise it is empty if none of its policy
+// mounts matches the host.
+func bundleMounts(preset BundlePreset, env Environment, paths pathResolver) ([]Mount, bool) {
+	if preset.Mounts == nil {
+		return nil, false
+	}
+
+	mounts := slices.Clone(preset.Mounts(env))
+	policy, direct := splitFilesystemMounts(mounts)
+
+	return mounts, len(direct) == 0 && !anyMountExists(policy, paths)
+}
+
+// mergeBundleCommands adds the command rules of the bundle presets enabled
+// in cfg to cfg.Commands. Rules configured directly win over bundle rules;
+// two enabled bundle presets with a rule for the same command are an error. Like configured rules, bundle rules require [Commands.Launcher].
+func mergeBundleCommands(cfg *Config) error {
+	state, _, err := resolvePresetToggles(cfg.Filesystem.Presets)
+	if err != nil {
+		return err
+	}
+
+	names, presets := enabledBundlePresets(state)
+
+	configured := make(map[string]bool)
+	for _, cmd := range cfg.Commands.Block {
+		configured[cmd] = true
+	}
+
+	for cmd := range cfg.Commands.Wrappers {
+		configured[cmd] = true
+	}
+
+	owners := make(map[string]string)
+
+	claim := func(cmd, preset string) (bool, error) {
+		if configured[cmd] {
+			return false, nil
+		}
+
+		if owner, ok := owners[cmd]; ok {
+			return false, fmt.Errorf("presets %s and %s: both have a rule for command %q", owner, preset, cmd)
+		}
+
+		owners[cmd] = preset
+
+		return true, nil
+	}
+
+	for i, preset := range presets {
+		for _, cmd := range preset.Block {
+			ok, err := claim(cmd, names[i])
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				cfg.Commands.Block = append(cfg.Commands.Block, cmd)
+			}
+		}
+
+		for _, cmd := range slices.Sorted(maps.Keys(preset.Wrappers)) {
+			ok, err := claim(cmd, names[i])
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				continue
+			}
+
+			if cfg.Commands.Wrappers == nil {
+				cfg.Commands.Wrappers = make(map[string]Wrapper)
+			}
+
+			cfg.Commands.Wrappers[cmd] = preset.Wrappers[cmd]
+		}
+	}
+
+	return nil
+}
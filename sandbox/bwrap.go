@@ -13,6 +13,8 @@ package sandbox
 // Sandbox.Command.
 import (
 	"fmt"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
@@ -25,6 +27,7 @@ import (
 //
 // It is intentionally small:
 //   - bwrapArgs drive command construction (Command)
+//   - mounts mirror the mount operations in bwrapArgs (Sandbox.Mounts)
 type plan struct {
 	// bwrapArgs are the deterministic arguments passed to `bwrap` for this
 	// sandbox (everything before the "-- <argv...>" separator).
@@ -38,13 +41,71 @@ type plan struct {
 	// materialize them.
 	needsEmptyFile bool
 
+	// needsUserNS indicates that bwrapArgs carry userNSFDPlaceholder, which
+	// Command() replaces with a user namespace that maps subordinate ids (see
+	// [Config.MapSubIDs]).
+	needsUserNS bool
+
 	// wrapperMounts are per-command `--ro-bind-data` mounts for command wrappers.
 	// They require exec.Cmd.ExtraFiles and are materialized by Command() at
 	// runtime.
 	wrapperMounts []roBindDataMount
 
+	// pinned lists the bind mounts whose sources Command() passes to bwrap as
+	// FDs, in argument order (see [Config.PinMountSources]).
+	pinned []pinnedSource
+
+	// dirFDs are the caller-held directories whose bind sources Command()
+	// passes through an inherited FD (see [Environment.WorkDirFD]).
+	dirFDs []dirFDSource
+
 	// chmods are bwrap --chmod operations applied after wrapper mounts.
 	chmods []chmodMount
+
+	// envOps are the --setenv/--unsetenv operations in bwrapArgs, in order
+	// (see [Sandbox.Env]).
+	envOps []envOp
+
+	// identityEnvOps is the number of envOps up to and including the
+	// Identity step, where [RunSpec.Identity] is applied.
+	identityEnvOps int
+
+	// mounts are the low-level mounts in the order they appear in bwrapArgs.
+	//
+	// Excluded-file masks carry emptyDataFD; wrapperMounts are not included
+	// (they are appended by Command()).
+	mounts []Mount
+
+	// auditFindings lists the rules that restrict access. Only audit mode
+	// reports them; see [ModeAudit].
+	auditFindings []AuditFinding
+
+	// blocked lists blocked command names with their host binaries, for audit
+	// mode's report-only interposition.
+	blocked []blockedCommand
+
+	// auditWritable lists the resolved read-write policy paths, which
+	// [Sandbox.AuditChanges] does not report.
+	auditWritable []string
+
+	// dockerSocket and dockerSocketSrc are the sandbox path of the docker
+	// socket mount and its host source (empty when masked); see
+	// [Sandbox.DockerSocket].
+	dockerSocket    string
+	dockerSocketSrc string
+
+	// dnsDirs lists the resolver directories bound into /run; see
+	// [Sandbox.DNSDirs].
+	dnsDirs []string
+
+	// networkAllow is the parsed [Config.NetworkAllow]. When set, Command()
+	// starts an allowlist proxy per command and runs argv through the
+	// bridge.
+	networkAllow *allowList
+
+	// argGroups partition bwrapArgs by planning step, in order (see
+	// [Sandbox.DebugArgs]).
+	argGroups []argGroupMark
 }
 
 type chmodMount struct {
@@ -57,6 +118,10 @@ type chmodMount struct {
 type mountSpec struct {
 	mount     Mount
 	pathDepth int
+
+	// group is the [ArgGroup] of the mount's arguments, if it differs from
+	// the current one.
+	group string
 }
 
 // mountPlan is the intermediate product of filesystem planning.
@@ -76,21 +141,28 @@ type mountPlan struct {
 type pathResolver struct {
 	homeDir string
 	workDir string
+	wsl     bool
+	hostFS  HostFS
 }
 
 func newPathResolver(env Environment) pathResolver {
-	return pathResolver{homeDir: env.HomeDir, workDir: env.WorkDir}
+	return pathResolver{homeDir: env.HomeDir, workDir: env.WorkDir, wsl: env.WSL != WSLNone, hostFS: env.hostFS()}
 }
 
 // Resolve converts a caller-supplied path/pattern into an absolute, cleaned host path.
 //
 // - "~" and "~/..." are expanded using Environment.HomeDir
+// - on WSL, Windows paths ("C:\...") are translated to /mnt/<drive>/...
 // - relative paths are interpreted relative to Environment.WorkDir.
 func (p pathResolver) Resolve(path string) string {
 	if path == "" {
 		return ""
 	}
 
+	if p.wsl {
+		path, _ = wslTranslatePath(path)
+	}
+
 	switch {
 	case path == "~":
 		path = p.homeDir
@@ -123,6 +195,21 @@ type planner struct {
 
 	args []string
 	plan plan
+
+	// groupMarks record where each [ArgGroup] starts in args.
+	groupMarks []argGroupMark
+
+	// excludedDirs are the directories masked by exclude policy rules. Their
+	// tmpfs mounts do not count as accessible for [planner.dirAccessible].
+	excludedDirs map[string]bool
+
+	// dangerous caches [dangerousMountPaths] for
+	// [planner.checkDangerousMount].
+	dangerous map[string]string
+
+	// guarded maps the destinations of command wrapper and docker socket
+	// mounts to what they are, for [planner.checkExtraBwrapMounts].
+	guarded map[string]string
 }
 
 func (p *planner) debugf(format string, args ...any) {
@@ -146,14 +233,23 @@ func (p *planner) build() (*plan, error) {
 	// returning a cleanup function.
 	p.plan = plan{}
 	p.args = make([]string, 0, 64)
+	p.guarded = make(map[string]string)
+	p.group(ArgGroupBase)
 
-	p.appendArgs("--die-with-parent", "--unshare-all")
+	networkEnabled := (p.cfg.Network == nil || *p.cfg.Network) && len(p.cfg.NetworkAllow) == 0
 
-	networkEnabled := p.cfg.Network == nil || *p.cfg.Network
-	if networkEnabled {
-		p.appendArgs("--share-net")
+	p.wslWarnings()
+
+	p.appendArgs("--die-with-parent")
+
+	userNS := ""
+	if p.cfg.MapSubIDs {
+		p.plan.needsUserNS = true
+		userNS = userNSFDPlaceholder
 	}
 
+	p.appendNamespaceArgs(networkEnabled, userNS)
+
 	dockerEnabled := p.cfg.Docker != nil && *p.cfg.Docker
 
 	rootMode := p.cfg.BaseFS
@@ -163,19 +259,50 @@ func (p *planner) build() (*plan, error) {
 
 	p.debugf("start workDir=%q homeDir=%q rootMode=%q network=%t docker=%t", p.env.WorkDir, p.env.HomeDir, rootMode, networkEnabled, dockerEnabled)
 
+	var rootMount Mount
+
 	switch rootMode {
 	case BaseFSHost:
-		p.appendMount("--ro-bind", "/", "/")
+		rootMount = RoBind("/", "/")
 	case BaseFSEmpty:
-		p.appendTmpfs("/")
+		rootMount = Tmpfs("/")
 	default:
 		// BaseFS is validated at construction time.
 		return nil, internalErrorf("planner.build", "unknown BaseFS %q", rootMode)
 	}
 
+	err := p.appendMount(rootMount)
+	if err != nil {
+		return nil, err
+	}
+
+	if rootMode == BaseFSEmpty && p.cfg.Etc.ReadOnly {
+		err = p.appendMount(RoBind("/etc", "/etc"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	p.appendArgs("--dev", "/dev")
+
+	dev := p.cfg.Dev
+	if dev == nil {
+		dev = browserDev(p.cfg.Filesystem.Presets)
+	}
+
+	if dev != nil {
+		err = p.appendDev(dev)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	p.appendArgs("--proc", "/proc")
-	p.appendTmpfs("/run")
+
+	err = p.appendMount(Tmpfs("/run"))
+	if err != nil {
+		return nil, err
+	}
 
 	// DNS (systemd-resolved) compatibility: on many systems /etc/resolv.conf is a
 	// symlink into /run. Since we mount /run as a fresh tmpfs, we need to bind-mount
@@ -183,8 +310,17 @@ func (p *planner) build() (*plan, error) {
 	//
 	// Only do this when network is enabled.
 	if networkEnabled {
-		if dnsArgs := dnsResolverArgs(p.debugf); len(dnsArgs) > 0 {
-			p.appendArgs(dnsArgs...)
+		p.group(ArgGroupDNS)
+
+		for _, m := range dnsResolverMounts(p.debugf) {
+			err = p.appendMount(m)
+			if err != nil {
+				return nil, err
+			}
+
+			if m.Kind == MountRoBind {
+				p.plan.dnsDirs = append(p.plan.dnsDirs, m.Src)
+			}
 		}
 	}
 
@@ -193,17 +329,43 @@ func (p *planner) build() (*plan, error) {
 	// behavior regardless of the host's TMPDIR setting.
 	//
 	// Added early so user/preset mounts can override (e.g., exclude subdirs of /tmp).
+	p.group(ArgGroupEnv)
+
 	if p.cfg.TempDir != "" {
 		p.debugf("tempDir=%q -> /tmp", p.cfg.TempDir)
-		p.appendMount("--bind", p.cfg.TempDir, "/tmp")
-		p.appendArgs("--setenv", "TMPDIR", "/tmp")
+		err = p.appendMount(Bind(p.cfg.TempDir, "/tmp"))
+		if err != nil {
+			return nil, err
+		}
+
+		p.appendEnvArgs("--setenv", "TMPDIR", "/tmp")
 	}
 
-	presetMounts, err := expandPresets(p.cfg.Filesystem.Presets, p.env)
+	if identityArgs := p.cfg.Identity.setenvArgs(); len(identityArgs) > 0 {
+		p.debugf("identity home=%q user=%q shell=%q", p.cfg.Identity.Home, p.cfg.Identity.User, p.cfg.Identity.Shell)
+		p.appendEnvArgs(identityArgs...)
+	}
+
+	p.plan.identityEnvOps = len(p.plan.envOps)
+
+	if p.cfg.NormalizeEnv {
+		p.debugf("normalize env overrides=%d", len(p.cfg.NormalizeEnvOverrides))
+		p.appendEnvArgs(normalizeEnvArgs(p.cfg.NormalizeEnvOverrides)...)
+	}
+
+	presetMounts, emptyPresets, err := expandPresets(p.cfg.Filesystem.Presets, p.env, p.cfg.Filesystem.CachePresets)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(emptyPresets) > 0 {
+		if p.cfg.Filesystem.StrictPresets {
+			return nil, fmt.Errorf("presets provide no protection (no matching paths): %s", strings.Join(emptyPresets, ", "))
+		}
+
+		p.debugf("warning: presets provide no protection (no matching paths): %s", strings.Join(emptyPresets, ", "))
+	}
+
 	presetsLabel := p.cfg.Filesystem.Presets
 	if presetsLabel == nil {
 		presetsLabel = []string{"@all"}
@@ -223,7 +385,39 @@ func (p *planner) build() (*plan, error) {
 
 	p.debugf("resolved filesystem rules=%d", len(resolvedRules))
 
-	fsPlan, err := mountPlanFromResolved(resolvedRules)
+	p.excludedDirs = make(map[string]bool)
+
+	for _, rule := range resolvedRules {
+		switch rule.kind {
+		case MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir, MountExcludeAuto:
+			if rule.isDir {
+				p.excludedDirs[rule.resolved] = true
+			}
+		}
+	}
+
+	p.plan.auditFindings = auditFindingsFromResolved(resolvedRules)
+
+	presetPolicyMounts, _ := splitFilesystemMounts(presetMounts)
+
+	for _, rule := range resolvedRules {
+		if rule.kind != MountReadWrite && rule.kind != MountReadWriteTry {
+			continue
+		}
+
+		p.plan.auditWritable = append(p.plan.auditWritable, rule.resolved)
+
+		// Globs and symlinks can reach a dangerous path that the mount
+		// does not name; presets never grant such access.
+		if rule.index >= len(presetPolicyMounts) {
+			err = p.checkDangerousMount(fmt.Sprintf("%s mount resolving to %q", mountKindName(rule.kind), rule.resolved), rule.resolved)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	fsPlan, err := mountPlanFromResolved(resolvedRules, len(presetPolicyMounts))
 	if err != nil {
 		return nil, err
 	}
@@ -235,20 +429,13 @@ func (p *planner) build() (*plan, error) {
 		return nil, err
 	}
 
-	if len(extraMounts) > 0 {
-		var extraPlan mountPlan
-
-		extraPlan, err = mountPlanFromExtra(extraMounts, p.paths)
-		if err != nil {
-			return nil, err
-		}
+	extraMounts, lateMounts := splitLateMounts(extraMounts)
 
-		p.debugf("extra mount plan specs=%d", len(extraPlan.specs))
+	p.group(ArgGroupDirect)
 
-		err = p.appendMountPlan(extraPlan)
-		if err != nil {
-			return nil, err
-		}
+	err = p.appendExtraMounts("extra", extraMounts)
+	if err != nil {
+		return nil, err
 	}
 
 	wrapperPlan, err := buildCommandWrapperPlan(p.cfg.Commands, p.env, p.paths, p.debugf)
@@ -263,47 +450,142 @@ func (p *planner) build() (*plan, error) {
 	}
 
 	if !wrapperPlan.isEmpty() {
-		for _, dirMount := range wrapperPlan.dirs {
-			if dirMount.Kind == MountDir && dirMount.Perms != 0 {
-				p.plan.chmods = append(p.plan.chmods, chmodMount{path: dirMount.Dst, perms: dirMount.Perms})
-			}
+		p.group(ArgGroupWrappers)
 
-			var args []string
+		err = p.protectLauncherTargetDirs(wrapperPlan.launcherMounts)
+		if err != nil {
+			return nil, err
+		}
 
-			args, err = mountToArgs(dirMount)
+		for _, m := range slices.Concat(wrapperPlan.dirs, wrapperPlan.logMounts, wrapperPlan.realBinaryMounts, wrapperPlan.launcherMounts) {
+			err = p.appendMount(m)
 			if err != nil {
-				return nil, fmt.Errorf("mountToArgs for %s src=%q dst=%q fd=%d perms=%#o: %w", mountKindName(dirMount.Kind), dirMount.Src, dirMount.Dst, dirMount.FD, uint32(dirMount.Perms.Perm()), err)
+				return nil, err
 			}
 
-			p.args = append(p.args, args...)
+			p.guarded[m.Dst] = "command wrapper mount"
 		}
 
-		for _, m := range wrapperPlan.realBinaryMounts {
-			p.appendMount("--ro-bind", m.Src, m.Dst)
+		p.plan.wrapperMounts = append(p.plan.wrapperMounts, wrapperPlan.dataMounts...)
+		p.plan.blocked = wrapperPlan.blocked
+
+		for _, cmd := range wrapperPlan.blocked {
+			p.plan.auditFindings = append(p.plan.auditFindings, AuditFinding{Kind: AuditBlock, Path: cmd.target, Command: cmd.name})
+		}
+	}
+
+	// This is appended after direct mounts so that caller-provided mounts cannot
+	// accidentally re-expose the docker socket. Only mounts explicitly pinned
+	// to PhaseLate follow it.
+	dockerPlan, err := dockerSocketMountPlan(dockerEnabled, p.env, p.paths, p.debugf)
+	if err != nil {
+		return nil, err
+	}
+
+	p.group(ArgGroupDocker)
+
+	err = p.appendMountPlan(dockerPlan)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, spec := range dockerPlan.specs {
+		p.guarded[spec.mount.Dst] = "docker socket mount"
+	}
+
+	p.plan.dockerSocket, p.plan.dockerSocketSrc = dockerSocketFromPlan(dockerPlan)
+
+	p.group(ArgGroupDirect)
+
+	err = p.appendExtraMounts("late", lateMounts)
+	if err != nil {
+		return nil, err
+	}
+
+	p.group(ArgGroupSynthesized)
+
+	if len(p.cfg.Etc.Overrides) > 0 || p.cfg.Etc.SynthesizePasswd {
+		err = p.appendEtcOverrides()
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		for _, m := range wrapperPlan.launcherMounts {
-			p.appendMount("--ro-bind", m.Src, m.Dst)
+	if len(p.cfg.ExtraCACerts) > 0 {
+		err = p.appendCABundle()
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		p.plan.wrapperMounts = append(p.plan.wrapperMounts, wrapperPlan.dataMounts...)
+	if len(p.cfg.NetworkAllow) > 0 {
+		err = p.appendNetworkProxy()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// This is appended last so that caller-provided mounts cannot accidentally
-	// re-expose the docker socket.
-	dockerPlan, err := dockerSocketMountPlan(dockerEnabled, p.env.HostEnv, p.paths, p.debugf)
+	if p.cfg.Umask != nil {
+		p.debugf("umask %04o via %s", *p.cfg.Umask, UmaskShimPath)
+
+		err = p.appendMount(RoBind(p.cfg.Commands.Launcher, UmaskShimPath))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.cfg.SSH.enabled() {
+		err = p.appendSSH()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.cfg.Clock.Time.IsZero() {
+		err = p.appendClock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.cfg.SandboxInfo {
+		err = p.appendSandboxInfo()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.cfg.Filesystem.ExcludeNotice {
+		p.appendExcludeNotices()
+	}
+
+	err = p.maskHostTempRoot()
 	if err != nil {
 		return nil, err
 	}
 
-	err = p.appendMountPlan(dockerPlan)
+	chdir, err := p.chdirTarget()
 	if err != nil {
 		return nil, err
 	}
 
-	p.appendChdir(p.env.WorkDir)
+	p.group(ArgGroupChdir)
+	p.appendChdir(chdir)
+
+	if len(p.cfg.ExtraBwrapArgs) > 0 {
+		p.debugf("extra bwrap args %q", p.cfg.ExtraBwrapArgs)
+
+		err = p.checkExtraBwrapMounts()
+		if err != nil {
+			return nil, err
+		}
+
+		p.group(ArgGroupExtra)
+		p.appendEnvArgs(p.cfg.ExtraBwrapArgs...)
+	}
 
 	p.plan.bwrapArgs = p.args
+	p.plan.argGroups = p.argGroups()
 
 	return &p.plan, nil
 }
@@ -312,12 +594,179 @@ func (p *planner) appendArgs(parts ...string) {
 	p.args = append(p.args, parts...)
 }
 
-func (p *planner) appendMount(flag, src, dst string) {
-	p.args = append(p.args, flag, src, dst)
+// appendMount emits the bwrap arguments for a low-level mount and records it
+// in plan.mounts.
+func (p *planner) appendMount(mnt Mount) error {
+	if p.cfg.BaseFS == BaseFSEmpty {
+		err := p.appendParentDirs(mnt.Dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	if mnt.Kind == MountDir && mnt.Perms != 0 {
+		p.plan.chmods = append(p.plan.chmods, chmodMount{path: mnt.Dst, perms: mnt.Perms})
+	}
+
+	args, err := mountToArgs(mnt)
+	if err != nil {
+		return fmt.Errorf("mountToArgs for %s src=%q dst=%q fd=%d perms=%#o: %w", mountKindName(mnt.Kind), mnt.Src, mnt.Dst, mnt.FD, uint32(mnt.Perms.Perm()), err)
+	}
+
+	switch mnt.Kind {
+	case MountRoBind, MountRoBindTry, MountBind, MountBindTry:
+		if p.cfg.PinMountSources {
+			err = p.pinSource(len(p.args), mnt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	p.args = append(p.args, args...)
+	p.plan.mounts = append(p.plan.mounts, mnt)
+
+	return nil
+}
+
+// appendParentDirs adds `--dir` mounts for the missing ancestors of dst that
+// lie on a tmpfs or directory created by the sandbox, so that mounts over
+// [BaseFSEmpty] do not depend on where bwrap can create parents implicitly.
+// Ancestors inside host bind mounts are left alone: they either exist on the
+// host or cannot be created.
+func (p *planner) appendParentDirs(dst string) error {
+	if len(p.plan.mounts) == 0 || !filepath.IsAbs(dst) {
+		return nil
+	}
+
+	var ancestors []string
+	for dir := filepath.Dir(filepath.Clean(dst)); dir != "/"; dir = filepath.Dir(dir) {
+		ancestors = append(ancestors, dir)
+	}
+
+	for _, dir := range slices.Backward(ancestors) {
+		mnt, rel, ok := p.coveringMount(dir)
+		if !ok || rel == "." || (mnt.Kind != MountTmpfs && mnt.Kind != MountDir) {
+			continue
+		}
+
+		p.debugf("parent dir %q for %q", dir, dst)
+
+		err := p.appendMount(Dir(dir))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chdirTarget returns the directory the sandboxed command starts in:
+// [Environment.WorkDir] when it is accessible, otherwise the directory selected
+// by [Config.ChdirFallback].
+func (p *planner) chdirTarget() (string, error) {
+	workDir := p.env.WorkDir
+	if p.dirAccessible(workDir) {
+		return workDir, nil
+	}
+
+	var dir string
+
+	switch fallback := p.cfg.ChdirFallback; fallback {
+	case "", ChdirError:
+		return "", fmt.Errorf("workdir %q is not accessible inside the sandbox (excluded or not mounted); mount it or set ChdirFallback", workDir)
+	case ChdirHome:
+		dir = p.env.HomeDir
+	case ChdirRoot:
+		dir = "/"
+	default:
+		dir = filepath.Clean(string(fallback))
+	}
+
+	if !p.dirAccessible(dir) {
+		return "", fmt.Errorf("workdir %q is not accessible inside the sandbox, and neither is ChdirFallback %q (%s)", workDir, p.cfg.ChdirFallback, dir)
+	}
+
+	p.debugf("workdir %q not accessible, chdir %q (ChdirFallback=%q)", workDir, dir, p.cfg.ChdirFallback)
+
+	return dir, nil
 }
 
-func (p *planner) appendTmpfs(dst string) {
-	p.args = append(p.args, "--tmpfs", dst)
+// dirAccessible reports whether dir exists as a directory inside the sandbox.
+//
+// The decision is made by the last planned mount at or above dir: bind mounts
+// expose dir if the corresponding host path is a directory; tmpfs and Dir
+// mounts only create their own destination (and excluded directories do not
+// count). Any other mount hides dir.
+func (p *planner) dirAccessible(dir string) bool {
+	mnt, rel, ok := p.coveringMount(dir)
+	if !ok {
+		return false
+	}
+
+	switch mnt.Kind {
+	case MountRoBind, MountRoBindTry, MountBind, MountBindTry:
+		info, err := p.paths.hostFS.Stat(filepath.Join(mnt.Src, rel))
+
+		return err == nil && info.IsDir()
+	case MountTmpfs:
+		return rel == "." && !p.excludedDirs[dir]
+	case MountDir:
+		return rel == "."
+	default:
+		return false
+	}
+}
+
+// coveringMount returns the last planned mount whose destination is path or
+// one of its ancestors, and path relative to that destination.
+func (p *planner) coveringMount(path string) (Mount, string, bool) {
+	for i := len(p.plan.mounts) - 1; i >= 0; i-- {
+		mnt := p.plan.mounts[i]
+
+		rel, err := filepath.Rel(mnt.Dst, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		return mnt, rel, true
+	}
+
+	return Mount{}, "", false
+}
+
+// excludeNoticeName is the file placed in excluded directories when
+// [Filesystem.ExcludeNotice] is set.
+const excludeNoticeName = "README-agent-sandbox.txt"
+
+// appendExcludeNotices adds a read-only notice file to every excluded
+// directory that is still backed by its masking tmpfs. Directories that a
+// later mount replaced are skipped: the notice would be created inside a host
+// bind mount.
+func (p *planner) appendExcludeNotices() {
+	for _, dir := range slices.Sorted(maps.Keys(p.excludedDirs)) {
+		notice := filepath.Join(dir, excludeNoticeName)
+
+		mnt, _, ok := p.coveringMount(notice)
+		if !ok || mnt.Kind != MountTmpfs || mnt.Dst != dir {
+			p.debugf("exclude notice skipped for %q: directory is not masked by its own tmpfs", dir)
+
+			continue
+		}
+
+		p.plan.wrapperMounts = append(p.plan.wrapperMounts, roBindDataMount{
+			dst:   notice,
+			perms: 0o444,
+			data:  excludeNoticeText(dir),
+		})
+	}
+}
+
+func excludeNoticeText(dir string) string {
+	return "This directory (" + dir + ") is hidden by the agent-sandbox policy.\n" +
+		"Its contents exist on the host but are not available inside the sandbox.\n" +
+		"Retrying or searching elsewhere will not help; if these files are required,\n" +
+		"ask the user to change the sandbox configuration.\n"
 }
 
 func (p *planner) appendChdir(dir string) {
@@ -326,16 +775,14 @@ func (p *planner) appendChdir(dir string) {
 
 func (p *planner) appendMountPlan(plan mountPlan) error {
 	for _, spec := range plan.specs {
-		if spec.mount.Kind == MountDir && spec.mount.Perms != 0 {
-			p.plan.chmods = append(p.plan.chmods, chmodMount{path: spec.mount.Dst, perms: spec.mount.Perms})
+		if spec.group != "" {
+			p.group(spec.group)
 		}
 
-		args, err := mountToArgs(spec.mount)
+		err := p.appendMount(spec.mount)
 		if err != nil {
-			return fmt.Errorf("mountToArgs for %s src=%q dst=%q fd=%d perms=%#o: %w", mountKindName(spec.mount.Kind), spec.mount.Src, spec.mount.Dst, spec.mount.FD, uint32(spec.mount.Perms.Perm()), err)
+			return err
 		}
-
-		p.args = append(p.args, args...)
 	}
 
 	if plan.needsEmptyFile {
@@ -345,6 +792,93 @@ func (p *planner) appendMountPlan(plan mountPlan) error {
 	return nil
 }
 
+// appendExtraMounts plans and appends direct mounts. label names the phase
+// in debug output.
+func (p *planner) appendExtraMounts(label string, mounts []Mount) error {
+	if len(mounts) == 0 {
+		return nil
+	}
+
+	mounts, err := p.appendFSDataMounts(mounts)
+	if err != nil {
+		return err
+	}
+
+	mounts, err = p.expandCollectMounts(mounts)
+	if err != nil {
+		return err
+	}
+
+	extraPlan, err := mountPlanFromExtra(mounts, p.paths)
+	if err != nil {
+		return err
+	}
+
+	p.debugf("%s mount plan specs=%d", label, len(extraPlan.specs))
+
+	for _, spec := range extraPlan.specs {
+		err = p.checkMountDst(spec.mount.Dst)
+		if err != nil {
+			return err
+		}
+
+		if spec.mount.Kind == MountBind || spec.mount.Kind == MountBindTry {
+			err = p.checkDangerousMount(fmt.Sprintf("%s %s mount %q -> %q", label, mountKindName(spec.mount.Kind), spec.mount.Src, spec.mount.Dst), spec.mount.Src)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = p.appendMount(spec.mount)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendFSDataMounts reads the content of [MaskFS] mounts and injects it with
+// the runtime data mounts. It returns the remaining mounts.
+func (p *planner) appendFSDataMounts(mounts []Mount) ([]Mount, error) {
+	rest := make([]Mount, 0, len(mounts))
+
+	for _, mnt := range mounts {
+		if mnt.FS == nil {
+			rest = append(rest, mnt)
+
+			continue
+		}
+
+		data, err := fs.ReadFile(mnt.FS, mnt.Src)
+		if err != nil {
+			return nil, fmt.Errorf("direct mount %s dst=%q: reading %q from FS: %w", mountKindName(mnt.Kind), mnt.Dst, mnt.Src, err)
+		}
+
+		err = p.checkMountDst(mnt.Dst)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.cfg.BaseFS == BaseFSEmpty {
+			err = p.appendParentDirs(mnt.Dst)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		p.debugf("fs data mount %q -> %q bytes=%d", mnt.Src, mnt.Dst, len(data))
+
+		p.plan.wrapperMounts = append(p.plan.wrapperMounts, roBindDataMount{
+			dst:   mnt.Dst,
+			perms: mnt.Perms,
+			data:  string(data),
+		})
+	}
+
+	return rest, nil
+}
+
 // emptyDataFD is a sentinel used for file exclusions that are materialized at
 // Command() time.
 //
@@ -413,8 +947,7 @@ func resolveAndDedupRules(mounts []Mount, paths pathResolver, debugf Debugf) ([]
 
 		allowMissing := false
 		useTry := false
-		forceType := false
-		forceIsDir := false
+		singlePath := false
 
 		switch mount.Kind {
 		case MountReadOnlyTry, MountReadWriteTry:
@@ -422,14 +955,10 @@ func resolveAndDedupRules(mounts []Mount, paths pathResolver, debugf Debugf) ([]
 			useTry = true
 		case MountExcludeTry:
 			allowMissing = true
-		case MountExcludeFile:
-			forceType = true
-			forceIsDir = false
-		case MountExcludeDir:
-			forceType = true
-			forceIsDir = true
+		case MountExcludeFile, MountExcludeDir, MountExcludeAuto:
+			singlePath = true
 		default:
-			// Other mount kinds use default values (allowMissing=false, useTry=false, forceType=false)
+			// Other mount kinds use default values (allowMissing=false, useTry=false, singlePath=false)
 		}
 
 		expanded := paths.Resolve(pat)
@@ -441,8 +970,21 @@ func resolveAndDedupRules(mounts []Mount, paths pathResolver, debugf Debugf) ([]
 			return nil, fmt.Errorf("resolved path %q for mount %d (%q) is not absolute", expanded, i, pat)
 		}
 
-		if forceType {
-			resolved := filepath.Clean(expanded)
+		if singlePath {
+			resolved, isDir, ok, err := excludeMaskTarget(paths.hostFS, mount.Kind, mount.Missing, filepath.Clean(expanded))
+			if err != nil {
+				return nil, fmt.Errorf("policy mount %d (%s) %q: %w", i, mountKindName(mount.Kind), mount.Dst, err)
+			}
+
+			if !ok {
+				skippedMissingTotal++
+
+				if len(missingExamples) < cap(missingExamples) {
+					missingExamples = append(missingExamples, expanded)
+				}
+
+				continue
+			}
 
 			depth := paths.Depth(resolved)
 			if depth > 32767 {
@@ -460,7 +1002,7 @@ func resolveAndDedupRules(mounts []Mount, paths pathResolver, debugf Debugf) ([]
 				kind:      mount.Kind,
 				useTry:    false,
 				isExact:   true,
-				isDir:     forceIsDir,
+				isDir:     isDir,
 			}
 
 			if prev, ok := winners[resolved]; !ok || beatsRule(cand, prev) {
@@ -475,7 +1017,7 @@ func resolveAndDedupRules(mounts []Mount, paths pathResolver, debugf Debugf) ([]
 		var matches []string
 
 		if isGlob {
-			ms, err := filepath.Glob(expanded)
+			ms, err := globIn(paths.hostFS, expanded)
 			if err != nil {
 				return nil, fmt.Errorf("invalid glob pattern %q at index %d: %w", expanded, i, err)
 			}
@@ -504,7 +1046,7 @@ func resolveAndDedupRules(mounts []Mount, paths pathResolver, debugf Debugf) ([]
 				continue
 			}
 
-			resolved, err := filepath.EvalSymlinks(match)
+			resolved, err := evalSymlinksIn(paths.hostFS, match)
 			if err != nil {
 				if os.IsNotExist(err) {
 					skippedMissingTotal++
@@ -529,7 +1071,7 @@ func resolveAndDedupRules(mounts []Mount, paths pathResolver, debugf Debugf) ([]
 				return nil, fmt.Errorf("policy mount %d (%s) targets reserved path %q", i, mountKindName(mount.Kind), resolved)
 			}
 
-			info, err := os.Stat(resolved)
+			info, err := paths.hostFS.Stat(resolved)
 			if err != nil {
 				if os.IsNotExist(err) {
 					skippedMissingTotal++
@@ -585,6 +1127,65 @@ func resolveAndDedupRules(mounts []Mount, paths pathResolver, debugf Debugf) ([]
 	return all, nil
 }
 
+// excludeMaskTarget decides how a single-path exclusion (ExcludeFile,
+// ExcludeDir, ExcludeAuto) is masked.
+//
+// It returns the host path to mask (an ancestor of path for MissingMaskParent),
+// whether the mask is a directory, and ok=false if the exclusion is skipped.
+func excludeMaskTarget(fsys HostFS, kind MountKind, missing MissingMode, path string) (string, bool, bool, error) {
+	// Forced mask types apply regardless of the host path; no stat needed.
+	if missing == MissingDefault && kind != MountExcludeAuto {
+		return path, kind == MountExcludeDir, true, nil
+	}
+
+	info, err := fsys.Stat(path)
+	if err == nil {
+		switch kind {
+		case MountExcludeFile:
+			return path, false, true, nil
+		case MountExcludeDir:
+			return path, true, true, nil
+		default:
+			return path, info.IsDir(), true, nil
+		}
+	}
+
+	if !os.IsNotExist(err) {
+		return "", false, false, fmt.Errorf("stat %q: %w", path, err)
+	}
+
+	if missing == MissingDefault {
+		switch kind {
+		case MountExcludeFile:
+			missing = MissingMaskFile
+		case MountExcludeDir:
+			missing = MissingMaskDir
+		default:
+			missing = MissingSkip
+		}
+	}
+
+	switch missing {
+	case MissingSkip:
+		return "", false, false, nil
+	case MissingMaskFile:
+		return path, false, true, nil
+	case MissingMaskDir:
+		return path, true, true, nil
+	case MissingMaskParent:
+		for parent := filepath.Dir(path); parent != "/"; parent = filepath.Dir(parent) {
+			parentInfo, statErr := fsys.Stat(parent)
+			if statErr == nil && parentInfo.IsDir() {
+				return parent, true, true, nil
+			}
+		}
+
+		return "", false, false, fmt.Errorf("cannot mask parent of missing path %q: no existing ancestor other than /", path)
+	default:
+		return "", false, false, internalErrorf("excludeMaskTarget", "unknown missing mode %d for %q", missing, path)
+	}
+}
+
 func beatsRule(ruleA, ruleB resolvedRule) bool {
 	// Exact beats glob regardless of rule order.
 	if ruleA.isExact && !ruleB.isExact {
@@ -612,7 +1213,7 @@ func splitFilesystemMounts(mounts []Mount) ([]Mount, []Mount) {
 
 	for _, m := range mounts {
 		switch m.Kind {
-		case MountReadOnly, MountReadOnlyTry, MountReadWrite, MountReadWriteTry, MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir:
+		case MountReadOnly, MountReadOnlyTry, MountReadWrite, MountReadWriteTry, MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir, MountExcludeAuto:
 			policy = append(policy, m)
 		default:
 			extra = append(extra, m)
@@ -622,6 +1223,23 @@ func splitFilesystemMounts(mounts []Mount) ([]Mount, []Mount) {
 	return policy, extra
 }
 
+// splitLateMounts partitions direct mounts into those emitted in the direct
+// phase and those pinned to [PhaseLate].
+func splitLateMounts(mounts []Mount) ([]Mount, []Mount) {
+	direct := make([]Mount, 0, len(mounts))
+	late := make([]Mount, 0)
+
+	for _, m := range mounts {
+		if m.MountPhase == PhaseLate {
+			late = append(late, m)
+		} else {
+			direct = append(direct, m)
+		}
+	}
+
+	return direct, late
+}
+
 // mountPlanFromResolved translates resolved policy rules into concrete mounts.
 //
 // Excluded directories are implemented as tmpfs mounts. Excluded files are
@@ -630,12 +1248,19 @@ func splitFilesystemMounts(mounts []Mount) ([]Mount, []Mount) {
 // The planner emits placeholder `--ro-bind-data` arguments, and Command()
 // supplies an always-empty inherited FD (currently /dev/null) to materialize
 // them.
-func mountPlanFromResolved(resolved []resolvedRule) (mountPlan, error) {
+//
+// Rules from the first presetRules policy mounts came from presets; their
+// mounts are grouped as [ArgGroupPresets].
+func mountPlanFromResolved(resolved []resolvedRule, presetRules int) (mountPlan, error) {
 	specs := make([]mountSpec, 0, len(resolved))
 	needsEmptyFile := false
 
 	for _, rule := range resolved {
-		spec := mountSpec{pathDepth: rule.pathDepth}
+		spec := mountSpec{pathDepth: rule.pathDepth, group: ArgGroupPolicy}
+		if rule.index < presetRules {
+			spec.group = ArgGroupPresets
+		}
+
 		switch rule.kind {
 		case MountReadOnly, MountReadOnlyTry:
 			kind := MountRoBind
@@ -651,7 +1276,9 @@ func mountPlanFromResolved(resolved []resolvedRule) (mountPlan, error) {
 			}
 
 			spec.mount = Mount{Kind: kind, Src: rule.resolved, Dst: rule.resolved}
-		case MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir:
+		case MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir, MountExcludeAuto:
+			spec.group = ArgGroupExcludes
+
 			if rule.isDir {
 				spec.mount = Mount{Kind: MountTmpfs, Dst: rule.resolved}
 
@@ -672,7 +1299,7 @@ func mountPlanFromResolved(resolved []resolvedRule) (mountPlan, error) {
 					parentDepth--
 				}
 
-				specs = append(specs, mountSpec{mount: Mount{Kind: MountDir, Dst: parent}, pathDepth: parentDepth})
+				specs = append(specs, mountSpec{mount: Mount{Kind: MountDir, Dst: parent}, pathDepth: parentDepth, group: ArgGroupExcludes})
 			}
 
 			spec.mount = Mount{Kind: MountRoBindData, FD: emptyDataFD, Perms: 0o000, Dst: rule.resolved}
@@ -686,21 +1313,41 @@ func mountPlanFromResolved(resolved []resolvedRule) (mountPlan, error) {
 	// Sort from shallowest destination to deepest so that parent mounts are applied
 	// before child mounts. This is crucial for correctness: later mounts can
 	// re-expose paths inside excluded directories.
+	//
+	// A parent Dir can share its destination with a rule's mount; it goes
+	// first, so the order does not depend on map iteration in
+	// resolveAndDedupRules.
 	sort.Slice(specs, func(i, j int) bool {
 		if specs[i].pathDepth != specs[j].pathDepth {
 			return specs[i].pathDepth < specs[j].pathDepth
 		}
 
-		return specs[i].mount.Dst < specs[j].mount.Dst
+		if specs[i].mount.Dst != specs[j].mount.Dst {
+			return specs[i].mount.Dst < specs[j].mount.Dst
+		}
+
+		return specs[i].mount.Kind == MountDir && specs[j].mount.Kind != MountDir
 	})
 
 	return mountPlan{specs: specs, needsEmptyFile: needsEmptyFile}, nil
 }
 
+// extraMountKey identifies a direct mount for deduplication. Mounts are only
+// collapsed if they are identical, so one source can be bound at several
+// destinations with independent modes.
+type extraMountKey struct {
+	kind  MountKind
+	src   string
+	dst   string
+	fd    int
+	perms os.FileMode
+}
+
 // mountPlanFromExtra converts direct mounts into a mountPlan.
 //
 // Direct mounts are sorted to ensure deterministic output and to avoid accidental
-// shadowing (parents are mounted before children).
+// shadowing (parents are mounted before children). Identical mounts are
+// emitted once.
 func mountPlanFromExtra(mounts []Mount, paths pathResolver) (mountPlan, error) {
 	extra := slices.Clone(mounts)
 	sort.Slice(extra, func(left, right int) bool {
@@ -721,7 +1368,16 @@ func mountPlanFromExtra(mounts []Mount, paths pathResolver) (mountPlan, error) {
 	})
 
 	specs := make([]mountSpec, 0, len(extra))
+	seen := make(map[extraMountKey]bool, len(extra))
+
 	for _, mount := range extra {
+		key := extraMountKey{kind: mount.Kind, src: mount.Src, dst: mount.Dst, fd: mount.FD, perms: mount.Perms}
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+
 		spec, err := mountSpecFromExtra(mount, paths)
 		if err != nil {
 			return mountPlan{}, fmt.Errorf("direct mount %s src=%q dst=%q fd=%d perms=%#o: %w", mountKindName(mount.Kind), mount.Src, mount.Dst, mount.FD, uint32(mount.Perms.Perm()), err)
@@ -729,7 +1385,7 @@ func mountPlanFromExtra(mounts []Mount, paths pathResolver) (mountPlan, error) {
 
 		switch mount.Kind {
 		case MountRoBind, MountRoBindTry, MountBind, MountBindTry:
-			_, statErr := os.Stat(mount.Src)
+			_, statErr := paths.hostFS.Stat(mount.Src)
 			if statErr != nil {
 				if os.IsNotExist(statErr) {
 					if mount.Kind == MountRoBindTry || mount.Kind == MountBindTry {
@@ -762,7 +1418,7 @@ func mountSpecFromExtra(mnt Mount, paths pathResolver) (mountSpec, error) {
 	}
 
 	switch mnt.Kind {
-	case MountReadOnly, MountReadOnlyTry, MountReadWrite, MountReadWriteTry, MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir:
+	case MountReadOnly, MountReadOnlyTry, MountReadWrite, MountReadWriteTry, MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir, MountExcludeAuto:
 		return mountSpec{}, internalErrorf("mountSpecFromExtra", "called on policy mount kind=%s dst=%q", mountKindName(mnt.Kind), mnt.Dst)
 	case MountRoBind, MountRoBindTry:
 		if strings.TrimSpace(mnt.Src) == "" || !filepath.IsAbs(mnt.Src) {
@@ -823,6 +1479,8 @@ func mountKindName(kind MountKind) string {
 		return "exclude-file"
 	case MountExcludeDir:
 		return "exclude-dir"
+	case MountExcludeAuto:
+		return "exclude-auto"
 	case MountRoBind:
 		return "ro-bind"
 	case MountRoBindTry:
@@ -837,6 +1495,8 @@ func mountKindName(kind MountKind) string {
 		return "dir"
 	case MountRoBindData:
 		return "ro-bind-data"
+	case MountCollect:
+		return "collect"
 	default:
 		return fmt.Sprintf("unknown(%d)", kind)
 	}
@@ -848,7 +1508,7 @@ func mountKindName(kind MountKind) string {
 // concrete mounts first.
 func mountToArgs(mnt Mount) ([]string, error) {
 	switch mnt.Kind {
-	case MountReadOnly, MountReadOnlyTry, MountReadWrite, MountReadWriteTry, MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir:
+	case MountReadOnly, MountReadOnlyTry, MountReadWrite, MountReadWriteTry, MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir, MountExcludeAuto:
 		return nil, internalErrorf("mountToArgs", "called on policy mount kind=%s dst=%q", mountKindName(mnt.Kind), mnt.Dst)
 	case MountRoBind:
 		return []string{"--ro-bind", mnt.Src, mnt.Dst}, nil
@@ -0,0 +1,160 @@
+//go:build linux
+
+package sandbox
+
+// This file implements a fallback for bubblewrap releases that predate
+// `--perms` and `--chmod` (added in 0.5.0).
+//
+// On such versions the planner's `--perms P --ro-bind-data FD DST` masks and
+// wrapper injections cannot be expressed. Instead, Command() writes the content
+// to files in a private host temp directory and mounts them with `--ro-bind`.
+// Files keep at most owner read/execute permissions, so they stay read-only
+// inside the sandbox; execute-only runtime directory hardening (--chmod) is
+// skipped.
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// bwrapPermsVersion is the first bubblewrap release with --perms and --chmod.
+var bwrapPermsVersion = [3]int{0, 5, 0}
+
+// bwrapVersionByPath caches parsed `bwrap --version` output per bwrap path.
+var bwrapVersionByPath sync.Map
+
+type bwrapVersion struct {
+	version [3]int
+	ok      bool
+}
+
+// bwrapLacksPerms reports whether the bwrap binary at path is older than
+// [bwrapPermsVersion]. Unknown or unparsable versions are treated as modern.
+func bwrapLacksPerms(path string) bool {
+	return bwrapOlderThan(path, bwrapPermsVersion)
+}
+
+// bwrapOlderThan reports whether the bwrap binary at path is older than
+// version. Unknown or unparsable versions are treated as modern.
+func bwrapOlderThan(path string, version [3]int) bool {
+	cached, ok := bwrapVersionByPath.Load(path)
+	if !ok {
+		var detected bwrapVersion
+
+		out, err := exec.Command(path, "--version").Output()
+		if err == nil {
+			detected.version, detected.ok = parseBwrapVersion(string(out))
+		}
+
+		cached, _ = bwrapVersionByPath.LoadOrStore(path, detected)
+	}
+
+	detected, _ := cached.(bwrapVersion)
+
+	return detected.ok && compareVersions(detected.version, version) < 0
+}
+
+// parseBwrapVersion parses `bwrap --version` output ("bubblewrap 0.4.1").
+func parseBwrapVersion(out string) ([3]int, bool) {
+	var version [3]int
+
+	fields := strings.Fields(out)
+	if len(fields) < 2 || fields[0] != "bubblewrap" {
+		return version, false
+	}
+
+	parts := strings.SplitN(fields[1], ".", 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return version, false
+		}
+
+		version[i] = n
+	}
+
+	return version, true
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// legacyDataArgs rewrites empty-file masks in args to `--ro-bind` mounts and
+// appends `--ro-bind` mounts for wrapperMounts, backed by files in a private
+// temp directory. The returned cleanup removes the directory.
+func legacyDataArgs(args []string, wrapperMounts []roBindDataMount) ([]string, func() error, error) {
+	dir, cleanup, err := newHostTempDir("data-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("create ro-bind-data fallback dir: %w", err)
+	}
+
+	fail := func(cause error) ([]string, func() error, error) {
+		return nil, nil, errors.Join(cause, cleanup())
+	}
+
+	emptyPath := filepath.Join(dir, "empty")
+
+	out := make([]string, 0, len(args)+len(wrapperMounts)*3)
+
+	for i := 0; i < len(args); i++ {
+		isMask := args[i] == "--perms" && i+4 < len(args) && args[i+2] == "--ro-bind-data" && args[i+3] == emptyDataFDPlaceholder
+		if !isMask {
+			out = append(out, args[i])
+
+			continue
+		}
+
+		out = append(out, "--ro-bind", emptyPath, args[i+4])
+		i += 4
+	}
+
+	err = writeLegacyDataFile(emptyPath, "", 0)
+	if err != nil {
+		return fail(err)
+	}
+
+	for i, mount := range wrapperMounts {
+		src := filepath.Join(dir, "data-"+strconv.Itoa(i))
+
+		err = writeLegacyDataFile(src, mount.data, mount.perms)
+		if err != nil {
+			return fail(fmt.Errorf("ro-bind-data fallback for %q (mount %d): %w", mount.dst, i, err))
+		}
+
+		out = append(out, "--ro-bind", src, mount.dst)
+	}
+
+	return out, cleanup, nil
+}
+
+// writeLegacyDataFile writes data to path, then restricts it to the owner
+// read/execute bits of perms.
+func writeLegacyDataFile(path, data string, perms os.FileMode) error {
+	err := os.WriteFile(path, []byte(data), 0o600)
+	if err != nil {
+		return fmt.Errorf("write %q: %w", path, err)
+	}
+
+	err = os.Chmod(path, perms.Perm()&0o500)
+	if err != nil {
+		return fmt.Errorf("chmod %q: %w", path, err)
+	}
+
+	return nil
+}
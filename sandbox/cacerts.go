@@ -0,0 +1,116 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the merged CA bundle (see [Config.ExtraCACerts]).
+//
+// The bundle is assembled once during planning from the host bundle and the
+// extra PEM files, then injected over CABundlePath with `--ro-bind-data` like
+// wrapper scripts, so the host bundle is never modified.
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CABundlePath is the sandbox path of the CA bundle replaced when
+// [Config.ExtraCACerts] is set (the Debian/Ubuntu bundle location).
+const CABundlePath = "/etc/ssl/certs/ca-certificates.crt"
+
+// caBundleEnvVars point TLS clients that ignore the system bundle (or only add
+// to their own) at the merged bundle.
+var caBundleEnvVars = []string{"SSL_CERT_FILE", "NODE_EXTRA_CA_CERTS"}
+
+func validateExtraCACerts(paths []string) []error {
+	var errs []error
+
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			errs = append(errs, fmt.Errorf("extra CA cert %q is not absolute", path))
+		}
+	}
+
+	return errs
+}
+
+// caBundle returns the host bundle followed by the extra certificates. Each
+// extra file must contain at least one PEM certificate.
+func caBundle(hostBundle string, extra []string, requireHost bool) ([]byte, error) {
+	var out bytes.Buffer
+
+	data, err := os.ReadFile(hostBundle)
+	if err != nil && (requireHost || !errors.Is(err, os.ErrNotExist)) {
+		return nil, fmt.Errorf("extra CA certs: reading host bundle: %w", err)
+	}
+
+	out.Write(data)
+
+	for _, path := range extra {
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("extra CA certs: %w", err)
+		}
+
+		if !hasPEMCertificate(data) {
+			return nil, fmt.Errorf("extra CA certs: %s contains no PEM certificate", path)
+		}
+
+		if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+			out.WriteByte('\n')
+		}
+
+		out.Write(data)
+	}
+
+	return out.Bytes(), nil
+}
+
+func hasPEMCertificate(data []byte) bool {
+	for {
+		var block *pem.Block
+
+		block, data = pem.Decode(data)
+		if block == nil {
+			return false
+		}
+
+		if block.Type == "CERTIFICATE" {
+			return true
+		}
+	}
+}
+
+// appendCABundle injects the merged bundle at CABundlePath and points
+// caBundleEnvVars at it. With [BaseFSHost] the host bundle must exist, since
+// bwrap cannot create the mount point on the read-only root.
+func (p *planner) appendCABundle() error {
+	bundle, err := caBundle(CABundlePath, p.cfg.ExtraCACerts, p.cfg.BaseFS != BaseFSEmpty)
+	if err != nil {
+		return err
+	}
+
+	p.debugf("ca bundle extra=%d bytes=%d", len(p.cfg.ExtraCACerts), len(bundle))
+
+	if p.cfg.BaseFS == BaseFSEmpty {
+		err = p.appendParentDirs(CABundlePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.plan.wrapperMounts = append(p.plan.wrapperMounts, roBindDataMount{
+		dst:   CABundlePath,
+		perms: 0o444,
+		data:  string(bundle),
+	})
+
+	for _, name := range caBundleEnvVars {
+		p.appendEnvArgs("--setenv", name, CABundlePath)
+	}
+
+	return nil
+}
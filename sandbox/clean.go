@@ -0,0 +1,194 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Clean], the janitor for state agent-sandbox leaves
+// on the host: project state directories (see [ProjectStateDir]) and host
+// temp directories of killed processes (see [ReapOrphans]).
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CleanKind is the kind of a [CleanItem].
+type CleanKind string
+
+const (
+	// CleanProjectState is a project state directory.
+	CleanProjectState CleanKind = "project-state"
+
+	// CleanTempDir is a host temp directory of a process that is gone.
+	CleanTempDir CleanKind = "temp-dir"
+)
+
+// CleanOptions selects what [Clean] removes. The zero value removes the
+// state of projects whose directory no longer exists and orphaned temp
+// directories.
+type CleanOptions struct {
+	// OlderThan, if positive, also removes project state directories that
+	// have not been written for at least this long.
+	OlderThan time.Duration
+
+	// AllProjects removes every project state directory.
+	AllProjects bool
+
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool
+}
+
+// CleanItem is a directory removed (or, with [CleanOptions.DryRun], to be
+// removed) by [Clean].
+type CleanItem struct {
+	Kind CleanKind
+	Path string
+
+	// Project is the project directory of a [CleanProjectState] item, if
+	// recorded.
+	Project string
+
+	// Reason says why the directory is removed.
+	Reason string
+}
+
+// Clean removes leftover host state as selected by opts and returns what it
+// removed, state directories first. Errors for single directories do not stop
+// the others; they are joined into the returned error.
+func Clean(env Environment, opts CleanOptions) ([]CleanItem, error) {
+	items, errs := cleanProjectStates(env, opts, time.Now())
+
+	temps, err := reapOrphans(opts.DryRun)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, path := range temps {
+		items = append(items, CleanItem{Kind: CleanTempDir, Path: path, Reason: "owner process exited"})
+	}
+
+	if len(errs) > 0 {
+		return items, fmt.Errorf("sandbox: clean: %w", errors.Join(errs...))
+	}
+
+	return items, nil
+}
+
+// cleanProjectStates removes the project state directories selected by opts.
+func cleanProjectStates(env Environment, opts CleanOptions, now time.Time) ([]CleanItem, []error) {
+	entries, err := os.ReadDir(StateHome(env))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var (
+		items []CleanItem
+		errs  []error
+	)
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(StateHome(env), entry.Name())
+
+		project, reason, err := projectStateStale(dir, opts, now)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if reason == "" {
+			continue
+		}
+
+		if !opts.DryRun {
+			err = os.RemoveAll(dir)
+			if err != nil {
+				errs = append(errs, err)
+
+				continue
+			}
+		}
+
+		items = append(items, CleanItem{Kind: CleanProjectState, Path: dir, Project: project, Reason: reason})
+	}
+
+	return items, errs
+}
+
+// projectStateStale returns the project of the state directory dir and why
+// it should be removed, or "" to keep it.
+func projectStateStale(dir string, opts CleanOptions, now time.Time) (string, string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ProjectStateFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", "", err
+	}
+
+	project := strings.TrimSpace(string(data))
+
+	if opts.AllProjects {
+		return project, "all projects", nil
+	}
+
+	if filepath.IsAbs(project) {
+		_, err = os.Stat(project)
+		if errors.Is(err, fs.ErrNotExist) {
+			return project, "project directory removed", nil
+		}
+	}
+
+	if opts.OlderThan <= 0 {
+		return project, "", nil
+	}
+
+	modified, err := lastModified(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	if age := now.Sub(modified); age >= opts.OlderThan {
+		return project, "unused for " + age.Truncate(time.Minute).String(), nil
+	}
+
+	return project, "", nil
+}
+
+// lastModified returns the newest modification time of dir and its direct
+// entries. Appending to a file does not touch the directory itself.
+func lastModified(dir string) (time.Time, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	newest := info.ModTime()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+
+	return newest, nil
+}
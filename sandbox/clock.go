@@ -0,0 +1,145 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the fake clock (see [Config.Clock]).
+//
+// Linux time namespaces only offset CLOCK_MONOTONIC and CLOCK_BOOTTIME, not
+// the wall clock, and bubblewrap cannot create them. The clock is therefore
+// faked in-process with libfaketime, preloaded via LD_PRELOAD, plus
+// SOURCE_DATE_EPOCH for tools that follow the reproducible-builds convention.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// faketimeLibraryPaths are the locations searched for libfaketime when
+// [Clock.Library] is empty (Debian/Ubuntu multiarch, Fedora, Arch, local
+// builds).
+var faketimeLibraryPaths = []string{
+	"/usr/lib/x86_64-linux-gnu/faketime/libfaketime.so.1",
+	"/usr/lib/aarch64-linux-gnu/faketime/libfaketime.so.1",
+	"/usr/lib64/faketime/libfaketime.so.1",
+	"/usr/lib/faketime/libfaketime.so.1",
+	"/usr/local/lib/faketime/libfaketime.so.1",
+}
+
+// faketimeFormat is the absolute date format understood by FAKETIME.
+const faketimeFormat = "2006-01-02 15:04:05"
+
+// Clock fakes the wall clock seen by sandboxed processes, so commands that
+// embed timestamps (build stamps, test snapshots, archives) produce the same
+// output on every run.
+//
+// The clock is faked with libfaketime, which must be installed on the host.
+// It only affects dynamically linked programs that read the time through
+// libc; statically linked binaries (most Go programs) and direct syscalls
+// see the real time. SOURCE_DATE_EPOCH is set as well, which many build
+// tools honour without libfaketime.
+type Clock struct {
+	// Time is the wall clock time at command start. The zero value leaves
+	// the clock untouched and disables the other fields.
+	Time time.Time
+
+	// Frozen stops the clock at Time. By default it advances from Time in
+	// real time.
+	Frozen bool
+
+	// FakeMonotonic also shifts CLOCK_MONOTONIC. By default the monotonic
+	// clock is real, so timeouts and sleeps keep working when Frozen.
+	FakeMonotonic bool
+
+	// Library is the absolute host path of libfaketime.so.1. If empty,
+	// well-known install locations are searched during construction. With
+	// [BaseFSEmpty] the library is mounted read-only at the same path.
+	Library string
+}
+
+func validateClock(clock Clock) []error {
+	if clock.Library != "" && !filepath.IsAbs(clock.Library) {
+		return []error{fmt.Errorf("clock library %q is not absolute", clock.Library)}
+	}
+
+	return nil
+}
+
+// faketimeLibrary returns the configured libfaketime path, or the first
+// well-known location that exists.
+func (c Clock) faketimeLibrary() (string, error) {
+	candidates := faketimeLibraryPaths
+	if c.Library != "" {
+		candidates = []string{c.Library}
+	}
+
+	for _, path := range candidates {
+		info, err := os.Stat(path)
+		if err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("clock: %w", err)
+		}
+	}
+
+	if c.Library != "" {
+		return "", fmt.Errorf("clock: libfaketime %q does not exist", c.Library)
+	}
+
+	return "", errors.New("clock: libfaketime not found (install faketime or set Clock.Library)")
+}
+
+// setenvArgs returns the `--setenv` bwrap arguments that preload library.
+// hostPreload is the caller's LD_PRELOAD, which is kept after libfaketime.
+func (c Clock) setenvArgs(library, hostPreload string) []string {
+	utc := c.Time.UTC()
+
+	faketime := "@" + utc.Format(faketimeFormat)
+	if c.Frozen {
+		faketime = utc.Format(faketimeFormat)
+	}
+
+	preload := library
+	if hostPreload != "" {
+		preload += " " + hostPreload
+	}
+
+	args := []string{
+		"--setenv", "LD_PRELOAD", preload,
+		"--setenv", "FAKETIME", faketime,
+		"--setenv", "FAKETIME_DONT_RESET", "1",
+		"--setenv", "SOURCE_DATE_EPOCH", strconv.FormatInt(utc.Unix(), 10),
+	}
+
+	if !c.FakeMonotonic {
+		args = append(args, "--setenv", "DONT_FAKE_MONOTONIC", "1")
+	}
+
+	return args
+}
+
+// appendClock preloads libfaketime with the configured time.
+func (p *planner) appendClock() error {
+	library, err := p.cfg.Clock.faketimeLibrary()
+	if err != nil {
+		return err
+	}
+
+	p.debugf("clock time=%s frozen=%t library=%q", p.cfg.Clock.Time.UTC().Format(time.RFC3339), p.cfg.Clock.Frozen, library)
+
+	if p.cfg.BaseFS == BaseFSEmpty {
+		err = p.appendMount(RoBind(library, library))
+		if err != nil {
+			return err
+		}
+	}
+
+	p.appendEnvArgs(p.cfg.Clock.setenvArgs(library, p.env.HostEnv["LD_PRELOAD"])...)
+
+	return nil
+}
@@ -0,0 +1,188 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Collect] mounts.
+//
+// A collect mount is expanded during planning into a tmpfs at the
+// destination and one read-only file bind per match, so the sandbox sees a
+// sparse mirror of the host tree: the matched files at their relative
+// paths and nothing else. Globs support "**" for any number of directories,
+// which filepath.Glob does not.
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxCollectMatches bounds the files a single [Collect] mount binds; every
+// match is a separate bind mount.
+const maxCollectMatches = 10_000
+
+// Collect binds every regular file matching glob read-only below dstDir (an
+// absolute sandbox path), preserving each file's path relative to the
+// glob's directory prefix. For example, Collect("~/mono/**/package.json",
+// "/meta") makes ~/mono/web/package.json readable as /meta/web/package.json,
+// so a tool can analyze project metadata without access to the sources.
+//
+// glob may be absolute, relative, or "~"-prefixed, like [RO]. "**" matches
+// any number of directories, including none; other elements follow
+// filepath.Match. Symlinks are neither followed nor bound. dstDir is an
+// empty writable tmpfs apart from the matched files; if nothing matches, it
+// stays empty. Matches are resolved during construction.
+func Collect(glob, dstDir string) Mount {
+	return Mount{Kind: MountCollect, Src: glob, Dst: dstDir}
+}
+
+// expandCollectMounts replaces the collect mounts in mounts with the tmpfs
+// and bind mounts that implement them.
+func (p *planner) expandCollectMounts(mounts []Mount) ([]Mount, error) {
+	out := make([]Mount, 0, len(mounts))
+
+	for _, mnt := range mounts {
+		if mnt.Kind != MountCollect {
+			out = append(out, mnt)
+
+			continue
+		}
+
+		pattern := p.paths.Resolve(mnt.Src)
+		base := globBase(pattern)
+
+		files, err := collectMatches(p.paths.hostFS, base, splitPath(strings.TrimPrefix(pattern, base)))
+		if err != nil {
+			return nil, fmt.Errorf("direct mount collect %q dst=%q: %w", mnt.Src, mnt.Dst, err)
+		}
+
+		p.debugf("collect %q: %d files below %s", pattern, len(files), mnt.Dst)
+
+		dst := filepath.Clean(mnt.Dst)
+		out = append(out, Mount{Kind: MountTmpfs, Dst: dst, MountPhase: mnt.MountPhase})
+
+		for _, file := range files {
+			rel, err := filepath.Rel(base, file)
+			if err != nil {
+				return nil, internalErrorf("expandCollectMounts", "match %q is not below %q", file, base)
+			}
+
+			out = append(out, Mount{Kind: MountRoBind, Src: file, Dst: filepath.Join(dst, rel), MountPhase: mnt.MountPhase})
+		}
+	}
+
+	return out, nil
+}
+
+// globBase returns the longest directory prefix of pattern without glob
+// metacharacters, or the parent of pattern if it has none.
+func globBase(pattern string) string {
+	base := "/"
+
+	for _, elem := range splitPath(pattern) {
+		if hasGlobMeta(elem) {
+			return base
+		}
+
+		base = filepath.Join(base, elem)
+	}
+
+	return filepath.Dir(pattern)
+}
+
+// splitPath returns the elements of a slash-separated path.
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+}
+
+// collectMatches returns the regular files below dir matching the pattern
+// elements, sorted.
+func collectMatches(fsys HostFS, dir string, elems []string) ([]string, error) {
+	for _, elem := range elems {
+		if elem == "**" {
+			continue
+		}
+
+		_, err := filepath.Match(elem, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob element %q: %w", elem, err)
+		}
+	}
+
+	switch {
+	case len(elems) == 0:
+		return nil, nil
+	case elems[len(elems)-1] == "**":
+		// A trailing "**" matches every file below.
+		elems = append(slices.Clone(elems), "*")
+	}
+
+	seen := make(map[string]bool)
+
+	var walk func(dir string, elems []string) error
+
+	walk = func(dir string, elems []string) error {
+		if len(seen) > maxCollectMatches {
+			return fmt.Errorf("more than %d matches", maxCollectMatches)
+		}
+
+		if elems[0] == "**" {
+			err := walk(dir, elems[1:])
+			if err != nil {
+				return err
+			}
+		}
+
+		entries, err := fsys.ReadDir(dir)
+		if err != nil {
+			// Unreadable directories are skipped, like filepath.Glob does.
+			return nil
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+
+			if elems[0] == "**" {
+				if entry.Type().IsDir() {
+					err = walk(path, elems)
+					if err != nil {
+						return err
+					}
+				}
+
+				continue
+			}
+
+			ok, _ := filepath.Match(elems[0], entry.Name())
+			if !ok {
+				continue
+			}
+
+			switch {
+			case len(elems) > 1 && entry.Type().IsDir():
+				err = walk(path, elems[1:])
+				if err != nil {
+					return err
+				}
+			case len(elems) == 1 && entry.Type().IsRegular():
+				seen[path] = true
+			}
+		}
+
+		return nil
+	}
+
+	err := walk(dir, elems)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(seen))
+	for path := range seen {
+		files = append(files, path)
+	}
+
+	slices.Sort(files)
+
+	return files, nil
+}
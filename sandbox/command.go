@@ -25,7 +25,103 @@ const firstExtraFD = 3
 //
 // The returned *[exec.Cmd] is NOT started. Callers may set Stdin/Stdout/Stderr and
 // then call Run/Start/Wait.
+//
+// Stdin/Stdout/Stderr that are *[os.File] values (host files, pipes, the
+// caller's own stdio) are inherited by bwrap and the sandboxed command
+// directly, so large inputs and outputs do not pass through the calling
+// process. Other readers and writers are copied through a pipe by
+// [exec.Cmd]; open the file and assign it directly to avoid the copy.
 func (s *Sandbox) Command(ctx context.Context, argv []string) (*exec.Cmd, func() error, error) {
+	return s.command(ctx, argv, nil, execOptions{})
+}
+
+// ExecSpec is a fully prepared sandbox invocation, independent of [exec.Cmd].
+//
+// It is meant for embedders that start processes through their own launcher
+// (a forkserver, a posix_spawn wrapper, a container runtime shim) while
+// reusing the sandbox policy. Launching Path with Args, Env and Dir, and
+// inheriting ExtraFiles[i] as FD 3+i, is equivalent to running the
+// [exec.Cmd] returned by [Sandbox.Command].
+type ExecSpec struct {
+	// Path is the program to execute as resolved from PATH (bwrap, or
+	// systemd-run when [Config.Systemd] is set and available).
+	Path string
+
+	// Args is the full argv including Args[0].
+	Args []string
+
+	// Env is the environment as KEY=VALUE pairs.
+	Env []string
+
+	// Dir is the working directory for the launched process.
+	Dir string
+
+	// ExtraFiles must be inherited by the child, ExtraFiles[i] as FD 3+i.
+	ExtraFiles []*os.File
+}
+
+// ExecSpec prepares the invocation that would run argv inside the sandbox
+// without constructing an [exec.Cmd]. The returned cleanup function must be
+// called once the child has been started (or on failure) to release the
+// parent's copies of ExtraFiles.
+func (s *Sandbox) ExecSpec(argv []string) (*ExecSpec, func() error, error) {
+	return s.execSpec(argv, nil, execOptions{})
+}
+
+// execOptions are per-command settings for [Sandbox.execSpec]. They are
+// ignored in [ModeAudit] and [ModeRestricted], which do not run bwrap.
+type execOptions struct {
+	// bwrapFlags are appended to the bwrap options.
+	bwrapFlags []string
+
+	// readOnly turns every read-write bind into a read-only bind.
+	readOnly bool
+
+	// passFDs are inherited by the command at fixed FD numbers after the
+	// sandbox's own FDs (see [RunSpec.PassFDs]).
+	passFDs map[int]*os.File
+
+	// fifos are created for the command and bound at [FifoDir].
+	fifos []*NamedPipe
+
+	// identity overrides [Config.Identity] for the command (see
+	// [RunSpec.Identity]).
+	identity *Identity
+}
+
+// command builds the bwrap invocation for argv as an [exec.Cmd].
+func (s *Sandbox) command(ctx context.Context, argv []string, leadingFiles []*os.File, opts execOptions) (*exec.Cmd, func() error, error) {
+	spec, cleanup, err := s.execSpec(argv, leadingFiles, opts)
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	spec.ExtraFiles, err = placePassFDs(spec.ExtraFiles, opts.passFDs)
+	if err != nil {
+		return nil, func() error { return nil }, errors.Join(fmt.Errorf("sandbox: %w", err), cleanup())
+	}
+
+	if s.v.cfg.SanitizeWrites {
+		release := cleanup
+		cleanup = func() error { return errors.Join(release(), s.SanitizeWrites()) }
+	}
+
+	cmd := exec.CommandContext(ctx, spec.Path, spec.Args[1:]...)
+	cmd.Args = spec.Args
+	cmd.Dir = spec.Dir
+	cmd.Env = spec.Env
+
+	if len(spec.ExtraFiles) > 0 {
+		cmd.ExtraFiles = spec.ExtraFiles
+	}
+
+	return cmd, cleanup, nil
+}
+
+// execSpec builds the bwrap invocation for argv. leadingFiles are inherited
+// first, starting at [firstExtraFD], ahead of any planner-managed FDs. The
+// caller keeps ownership of leadingFiles.
+func (s *Sandbox) execSpec(argv []string, leadingFiles []*os.File, opts execOptions) (*ExecSpec, func() error, error) {
 	if s == nil || s.v == nil {
 		return nil, func() error { return nil }, errors.New("sandbox: uninitialized sandbox (use New or NewWithEnvironment)")
 	}
@@ -39,11 +135,50 @@ func (s *Sandbox) Command(ctx context.Context, argv []string) (*exec.Cmd, func()
 		return nil, func() error { return nil }, errors.New("sandbox: uninitialized sandbox plan (use New or NewWithEnvironment)")
 	}
 
+	envOps := plan.envOps
+	if opts.identity != nil {
+		envOps = s.runEnvOps(*opts.identity)
+	}
+
+	switch s.v.cfg.Mode {
+	case ModeAudit:
+		return s.hostUmaskSpec(s.auditExecSpec(argv, leadingFiles))
+	case ModeRestricted:
+		return s.hostUmaskSpec(s.restrictedExecSpec(argv, leadingFiles, envOps))
+	}
+
+	if s.v.cfg.Umask != nil {
+		argv = umaskArgv(UmaskShimPath, *s.v.cfg.Umask, argv)
+	}
+
+	if plan.networkAllow != nil {
+		argv = proxyBridgeArgv(argv)
+	}
+
+	if s.launcher != nil {
+		err := s.launcher.verify()
+		if err != nil {
+			return nil, func() error { return nil }, fmt.Errorf("sandbox: %w", err)
+		}
+	}
+
 	bwrapPath, err := exec.LookPath("bwrap")
 	if err != nil {
 		return nil, func() error { return nil }, fmt.Errorf("sandbox: bwrap not found in PATH: %w", err)
 	}
 
+	escalation := s.v.cfg.Escalation
+	escalated := escalation.PkexecPrompt != ""
+
+	launcher, err := escalation.launcher(bwrapPath)
+	if err != nil {
+		return nil, func() error { return nil }, fmt.Errorf("sandbox: %w", err)
+	}
+
+	if escalated && (len(leadingFiles) > 0 || len(opts.passFDs) > 0 || len(plan.dirFDs) > 0) {
+		return nil, func() error { return nil }, errors.New("sandbox: escalation: pkexec and sudo close inherited FDs; Start, pipelines, PassFDs and WorkDirFD are unsupported with PkexecPrompt")
+	}
+
 	debugf := s.v.cfg.Debugf
 
 	var cleanupFuncs []func() error
@@ -63,15 +198,68 @@ func (s *Sandbox) Command(ctx context.Context, argv []string) (*exec.Cmd, func()
 
 	bwrapArgs := slices.Clone(plan.bwrapArgs)
 
-	var extraFiles []*os.File
+	if opts.readOnly {
+		readOnlyBinds(bwrapArgs)
+	}
+
+	extraFiles := slices.Clone(leadingFiles)
+
+	if len(plan.pinned) > 0 {
+		if bwrapOlderThan(bwrapPath, bwrapBindFDVersion) {
+			return nil, func() error { return nil }, fmt.Errorf("sandbox: PinMountSources requires bubblewrap %d.%d.%d or newer (%s)", bwrapBindFDVersion[0], bwrapBindFDVersion[1], bwrapBindFDVersion[2], bwrapPath)
+		}
+
+		pinArgs, files, err := pinnedArgs(bwrapArgs, plan.pinned, firstExtraFD+len(extraFiles))
+		if err != nil {
+			return nil, func() error { return nil }, err
+		}
 
-	if plan.needsEmptyFile {
+		bwrapArgs = pinArgs
+		extraFiles = append(extraFiles, files...)
+		cleanupFuncs = append(cleanupFuncs, closeFilesOnce(files))
+	}
+
+	if len(plan.dirFDs) > 0 {
+		for _, dir := range plan.dirFDs {
+			if dir.file.Fd() == ^uintptr(0) {
+				cleanupErr := cleanupAll()
+
+				return nil, func() error { return nil }, errors.Join(fmt.Errorf("sandbox: the FD for %s was closed", dir.path), cleanupErr)
+			}
+		}
+
+		// The files stay owned by the caller and are not closed on cleanup.
+		extraFiles = append(extraFiles, dirFDArgs(bwrapArgs, plan.dirFDs, firstExtraFD+len(extraFiles))...)
+	}
+
+	// pkexec and sudo close the data FDs, so escalated commands take the
+	// host temp file path as well.
+	legacy := escalated || bwrapLacksPerms(bwrapPath)
+	if legacy {
+		if debugf != nil {
+			debugf("sandbox(command): %s predates --perms or runs escalated; using host temp files for ro-bind-data and skipping %d chmods", bwrapPath, len(plan.chmods))
+		}
+
+		legacyArgs, legacyCleanup, err := legacyDataArgs(bwrapArgs, plan.wrapperMounts)
+		if err != nil {
+			cleanupErr := cleanupAll()
+
+			return nil, func() error { return nil }, errors.Join(err, cleanupErr)
+		}
+
+		bwrapArgs = legacyArgs
+		cleanupFuncs = append(cleanupFuncs, legacyCleanup)
+	}
+
+	if plan.needsEmptyFile && !legacy {
 		// Excluded files are masked by mounting an unreadable empty file over them.
 		// The planner emits a placeholder FD in the bwrap argv, and we substitute it
 		// here with an inherited FD that always reads as empty.
 		devNullFile, err := os.Open(os.DevNull)
 		if err != nil {
-			return nil, func() error { return nil }, fmt.Errorf("open %s for empty exclusion source: %w", os.DevNull, err)
+			cleanupErr := cleanupAll()
+
+			return nil, func() error { return nil }, errors.Join(fmt.Errorf("open %s for empty exclusion source: %w", os.DevNull, err), cleanupErr)
 		}
 
 		extraFiles = append(extraFiles, devNullFile)
@@ -87,7 +275,32 @@ func (s *Sandbox) Command(ctx context.Context, argv []string) (*exec.Cmd, func()
 		}
 	}
 
-	if len(plan.wrapperMounts) > 0 {
+	if plan.needsUserNS {
+		mapping, err := lookupSubIDMapping()
+		if err != nil {
+			cleanupErr := cleanupAll()
+
+			return nil, func() error { return nil }, errors.Join(fmt.Errorf("sandbox: %w", err), cleanupErr)
+		}
+
+		userNS, err := mapping.userNamespace()
+		if err != nil {
+			cleanupErr := cleanupAll()
+
+			return nil, func() error { return nil }, errors.Join(fmt.Errorf("sandbox: %w", err), cleanupErr)
+		}
+
+		extraFiles = append(extraFiles, userNS)
+		cleanupFuncs = append(cleanupFuncs, closeFilesOnce([]*os.File{userNS}))
+
+		replaceArg(bwrapArgs, userNSFDPlaceholder, strconv.Itoa(firstExtraFD+(len(extraFiles)-1)))
+
+		if debugf != nil {
+			debugf("sandbox(command): mapping subordinate uids %v and gids %v", mapping.uids, mapping.gids)
+		}
+	}
+
+	if len(plan.wrapperMounts) > 0 && !legacy {
 		wrapperArgs, files, err := roBindDataArgs(plan.wrapperMounts, firstExtraFD+len(extraFiles))
 		if err != nil {
 			cleanupErr := cleanupAll()
@@ -100,31 +313,84 @@ func (s *Sandbox) Command(ctx context.Context, argv []string) (*exec.Cmd, func()
 		cleanupFuncs = append(cleanupFuncs, closeFilesOnce(files))
 	}
 
-	if len(plan.chmods) > 0 {
+	if len(plan.chmods) > 0 && !legacy {
 		for _, chmod := range plan.chmods {
 			permString := fmt.Sprintf("%04o", chmod.perms.Perm())
 			bwrapArgs = append(bwrapArgs, "--chmod", permString, chmod.path)
 		}
 	}
 
-	args := make([]string, 0, len(bwrapArgs)+1+len(argv))
+	if len(opts.fifos) > 0 {
+		pipeArgs, fifoCleanup, err := fifoArgs(opts.fifos)
+		if err != nil {
+			cleanupErr := cleanupAll()
+
+			return nil, func() error { return nil }, errors.Join(err, cleanupErr)
+		}
+
+		bwrapArgs = append(bwrapArgs, pipeArgs...)
+		cleanupFuncs = append(cleanupFuncs, fifoCleanup)
+
+		if debugf != nil {
+			debugf("sandbox(command): %d fifos at %s", len(opts.fifos), FifoDir)
+		}
+	}
+
+	if plan.networkAllow != nil {
+		netArgs, proxyCleanup, err := proxyArgs(*plan.networkAllow, debugf)
+		if err != nil {
+			cleanupErr := cleanupAll()
+
+			return nil, func() error { return nil }, errors.Join(err, cleanupErr)
+		}
+
+		bwrapArgs = append(bwrapArgs, netArgs...)
+		cleanupFuncs = append(cleanupFuncs, proxyCleanup)
+	}
+
+	if opts.identity != nil {
+		// Replaying the operations from the Identity step on keeps the
+		// precedence of the later steps over the per-command identity.
+		bwrapArgs = append(bwrapArgs, envOpArgs(envOps[plan.identityEnvOps:])...)
+	}
+
+	bwrapArgs = append(bwrapArgs, opts.bwrapFlags...)
+
+	if escalation.enabled() {
+		bwrapArgs = append(escalation.escalationArgs(s.v.env.HostEnv), bwrapArgs...)
+	}
+
+	args := make([]string, 0, len(launcher)+len(bwrapArgs)+1+len(argv))
+	args = append(args, launcher[1:]...)
 	args = append(args, bwrapArgs...)
 	args = append(args, "--")
 	args = append(args, argv...)
 
-	cmd := exec.CommandContext(ctx, bwrapPath, args...)
-	cmd.Dir = s.v.env.WorkDir
+	name := launcher[0]
 
-	cmd.Env = slices.Clone(s.v.envSlice)
-	if len(extraFiles) > 0 {
-		cmd.ExtraFiles = extraFiles
+	if scope := s.v.cfg.Systemd; scope != nil {
+		runPath, scopeErr := systemdRunPath(s.v.env.hostFS(), s.v.env.HostEnv, s.v.envSlice, s.v.env.WorkDir)
+		if scopeErr == nil {
+			args = scope.wrapArgs(name, args)
+			name = runPath
+		} else if debugf != nil {
+			debugf("sandbox(command): systemd scope unavailable, starting bwrap directly: %v", scopeErr)
+		}
+	}
+
+	spec := &ExecSpec{
+		Path:       name,
+		Args:       append([]string{name}, args...),
+		Env:        slices.Clone(s.v.envSlice),
+		Dir:        s.v.env.WorkDir,
+		ExtraFiles: extraFiles,
 	}
 
 	if debugf != nil {
 		debugf("sandbox(command): argv0=%q bwrap=%q bwrapArgs=%d extraFiles=%d wrapperMounts=%d chmods=%d", argv[0], bwrapPath, len(bwrapArgs), len(extraFiles), len(plan.wrapperMounts), len(plan.chmods))
 	}
 
-	return cmd, cleanupAll, nil
+	return spec, cleanupAll, nil
 }
 
 // envMapToSliceSorted converts a map env to a sorted KEY=VALUE slice.
@@ -236,15 +502,21 @@ func roBindDataArgs(mounts []roBindDataMount, firstChildFD int) ([]string, []*os
 }
 
 func newRoBindDataBackingFile() (*os.File, error) {
+	return newAnonymousFile("sandbox-ro-bind-data")
+}
+
+// newAnonymousFile returns a read-write file that has no path on the host.
+// Children only ever see it through an inherited FD.
+func newAnonymousFile(name string) (*os.File, error) {
 	// Prefer an anonymous in-memory file when possible to avoid filesystem I/O.
-	fd, err := unix.MemfdCreate("sandbox-ro-bind-data", unix.MFD_CLOEXEC)
+	fd, err := unix.MemfdCreate(name, unix.MFD_CLOEXEC)
 	if err == nil {
-		memFile := os.NewFile(uintptr(fd), "sandbox-ro-bind-data")
+		memFile := os.NewFile(uintptr(fd), name)
 		if memFile == nil {
 			closeErr := unix.Close(fd)
 
 			return nil, errors.Join(
-				internalErrorf("newRoBindDataBackingFile", "os.NewFile returned nil"),
+				internalErrorf("newAnonymousFile", "os.NewFile returned nil"),
 				closeErr,
 			)
 		}
@@ -254,7 +526,7 @@ func newRoBindDataBackingFile() (*os.File, error) {
 
 	// Fall back to an unlinked temp file. bwrap reads the content via the
 	// inherited FD, not by path.
-	tempFile, tmpErr := os.CreateTemp("", "sandbox-ro-bind-data-*")
+	tempFile, tmpErr := os.CreateTemp("", name+"-*")
 	if tmpErr != nil {
 		return nil, errors.Join(
 			fmt.Errorf("memfd_create: %w", err),
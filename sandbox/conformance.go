@@ -0,0 +1,251 @@
+//go:build linux && conformance
+
+package sandbox
+
+// This file implements the policy conformance suite ([RunConformanceTests]).
+//
+// It is only built with the "conformance" build tag, so that the package
+// does not import "testing" for regular users:
+//
+//	go test -tags conformance ./...
+//
+// The corpus lives in conformance/*.json. Each case describes a small host
+// tree, a filesystem policy and the access a sandboxed process must get to
+// each file. The expectations are the golden output of this package's
+// planner; other backends (OCI runtimes, Landlock, macOS sandbox profiles) or
+// forks run the same cases to check that they implement identical policy
+// semantics.
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+//go:embed conformance/*.json
+var conformanceFS embed.FS
+
+// ConformanceAccess is the access a sandboxed process has to a host file.
+type ConformanceAccess string
+
+const (
+	// ConformanceHidden means the file's host content cannot be read.
+	ConformanceHidden ConformanceAccess = "hidden"
+
+	// ConformanceReadOnly means the file can be read but not written.
+	ConformanceReadOnly ConformanceAccess = "ro"
+
+	// ConformanceReadWrite means the file can be read and written.
+	ConformanceReadWrite ConformanceAccess = "rw"
+)
+
+// ConformanceMount is a policy mount of a [ConformanceCase].
+type ConformanceMount struct {
+	// Kind is "ro", "rw" or "exclude", optionally with a "-try" suffix
+	// (see [RO], [RW], [Exclude] and their Try variants).
+	Kind string `json:"kind"`
+
+	// Path is the policy path: "~"-prefixed, relative to the working
+	// directory, or a glob of either.
+	Path string `json:"path"`
+}
+
+// ConformanceCase is one case of the conformance corpus.
+//
+// Paths in Files and Expect start with "~/" for the home directory and are
+// otherwise relative to the working directory.
+type ConformanceCase struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	// Presets and Mounts are the [Filesystem] policy under test.
+	Presets []string           `json:"presets"`
+	Mounts  []ConformanceMount `json:"mounts"`
+
+	// Files are created, empty, before the sandbox is built.
+	Files []string `json:"files"`
+
+	// Expect maps files to the access a sandboxed process must get.
+	Expect map[string]ConformanceAccess `json:"expect"`
+}
+
+// Config returns the configuration under test.
+func (c ConformanceCase) Config() (Config, error) {
+	kinds := map[string]func(string) Mount{
+		"ro": RO, "rw": RW, "exclude": Exclude,
+		"ro-try": ROTry, "rw-try": RWTry, "exclude-try": ExcludeTry,
+	}
+
+	cfg := Config{Filesystem: Filesystem{Presets: slices.Clone(c.Presets)}}
+
+	for _, m := range c.Mounts {
+		mount, ok := kinds[m.Kind]
+		if !ok {
+			return Config{}, fmt.Errorf("conformance case %s: unknown mount kind %q", c.Name, m.Kind)
+		}
+
+		cfg.Filesystem.Mounts = append(cfg.Filesystem.Mounts, mount(m.Path))
+	}
+
+	return cfg, nil
+}
+
+// ConformanceCases returns the conformance corpus, sorted by name.
+func ConformanceCases() ([]ConformanceCase, error) {
+	names, err := fs.Glob(conformanceFS, "conformance/*.json")
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: listing conformance cases: %w", err)
+	}
+
+	cases := make([]ConformanceCase, 0, len(names))
+
+	for _, name := range names {
+		data, err := conformanceFS.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: reading conformance case: %w", err)
+		}
+
+		var c ConformanceCase
+
+		err = json.Unmarshal(data, &c)
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: parsing conformance case %s: %w", name, err)
+		}
+
+		cases = append(cases, c)
+	}
+
+	slices.SortFunc(cases, func(a, b ConformanceCase) int { return strings.Compare(a.Name, b.Name) })
+
+	return cases, nil
+}
+
+// ConformanceBackend is a sandbox implementation checked by
+// [RunConformanceTests].
+type ConformanceBackend interface {
+	// Access returns the access a process sandboxed with cfg in env gets to
+	// each of paths, which are absolute host paths below env.HomeDir or
+	// env.WorkDir.
+	Access(cfg *Config, env Environment, paths []string) ([]ConformanceAccess, error)
+}
+
+// PlanConformanceBackend is the reference [ConformanceBackend]. It derives
+// access from the planned bwrap mounts, the same way [Sandbox.ReadFile] and
+// [Sandbox.WriteFile] do, so it does not need bwrap.
+type PlanConformanceBackend struct{}
+
+// Access implements [ConformanceBackend].
+func (PlanConformanceBackend) Access(cfg *Config, env Environment, paths []string) ([]ConformanceAccess, error) {
+	s, err := NewWithEnvironment(cfg, env)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]ConformanceAccess, len(paths))
+
+	for i, p := range paths {
+		_, mnt, err := s.resolveHostPath(p)
+
+		switch {
+		case errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist):
+			out[i] = ConformanceHidden
+		case err != nil:
+			return nil, err
+		case mnt.Kind == MountBind || mnt.Kind == MountBindTry:
+			out[i] = ConformanceReadWrite
+		default:
+			out[i] = ConformanceReadOnly
+		}
+	}
+
+	return out, nil
+}
+
+// RunConformanceTests runs every case of the conformance corpus against
+// backend, each as a subtest in a fresh home and working directory.
+func RunConformanceTests(t *testing.T, backend ConformanceBackend) {
+	t.Helper()
+
+	cases, err := ConformanceCases()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			root, err := filepath.EvalSymlinks(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			env := Environment{
+				HomeDir: filepath.Join(root, "home"),
+				WorkDir: filepath.Join(root, "work"),
+			}
+			env.HostEnv = map[string]string{"HOME": env.HomeDir, "PATH": "/usr/bin:/bin"}
+
+			hostPath := func(p string) string {
+				if rest, ok := strings.CutPrefix(p, "~/"); ok {
+					return filepath.Join(env.HomeDir, filepath.FromSlash(rest))
+				}
+
+				return filepath.Join(env.WorkDir, filepath.FromSlash(p))
+			}
+
+			for _, dir := range []string{env.HomeDir, env.WorkDir} {
+				err = os.MkdirAll(dir, 0o755)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, f := range c.Files {
+				p := hostPath(f)
+
+				err = os.MkdirAll(filepath.Dir(p), 0o755)
+				if err == nil {
+					err = os.WriteFile(p, nil, 0o644)
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg, err := c.Config()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files := slices.Sorted(maps.Keys(c.Expect))
+
+			paths := make([]string, len(files))
+			for i, f := range files {
+				paths[i] = hostPath(f)
+			}
+
+			got, err := backend.Access(&cfg, env, paths)
+			if err != nil {
+				t.Fatalf("%s: %v", c.Description, err)
+			}
+
+			if len(got) != len(paths) {
+				t.Fatalf("%s: backend returned %d results for %d paths", c.Description, len(got), len(paths))
+			}
+
+			for i, f := range files {
+				if got[i] != c.Expect[f] {
+					t.Errorf("%s: %s: got %q, want %q", c.Description, f, got[i], c.Expect[f])
+				}
+			}
+		})
+	}
+}
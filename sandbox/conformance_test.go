@@ -0,0 +1,15 @@
+//go:build linux && conformance
+
+package sandbox_test
+
+import (
+	"testing"
+
+	"github.com/calvinalkan/agent-sandbox/sandbox"
+)
+
+func Test_Sandbox_Planner_Passes_Conformance_Suite(t *testing.T) {
+	t.Parallel()
+
+	sandbox.RunConformanceTests(t, sandbox.PlanConformanceBackend{})
+}
@@ -0,0 +1,207 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Sandbox.DebugArgs].
+//
+// The planner marks where each planning step starts appending bwrap
+// arguments, so the flat argument list can be split back into groups
+// without re-parsing it.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Argument groups reported by [Sandbox.DebugArgs], in the order they appear
+// in the bwrap argv. Groups without arguments are omitted.
+const (
+	// ArgGroupBase holds the core flags: namespaces, the root filesystem,
+	// /dev, /proc and /run.
+	ArgGroupBase = "base"
+
+	// ArgGroupDNS holds the resolver directories bound into /run.
+	ArgGroupDNS = "dns"
+
+	// ArgGroupEnv holds /tmp and the environment set by TempDir, Identity
+	// and NormalizeEnv.
+	ArgGroupEnv = "env"
+
+	// ArgGroupPresets holds the mounts of preset policy rules.
+	ArgGroupPresets = "presets"
+
+	// ArgGroupPolicy holds the mounts of configured policy rules (RO/RW).
+	ArgGroupPolicy = "policy"
+
+	// ArgGroupExcludes holds the masks of Exclude rules, from presets and
+	// configuration.
+	ArgGroupExcludes = "excludes"
+
+	// ArgGroupDirect holds direct mounts.
+	ArgGroupDirect = "direct"
+
+	// ArgGroupWrappers holds the command wrapper and block mounts.
+	ArgGroupWrappers = "wrappers"
+
+	// ArgGroupDocker holds the docker socket mount or mask.
+	ArgGroupDocker = "docker"
+
+	// ArgGroupSynthesized holds synthesized files: /etc overrides, the CA
+	// bundle, SSH and clock files, sandbox info.
+	ArgGroupSynthesized = "synthesized"
+
+	// ArgGroupChdir holds the working directory.
+	ArgGroupChdir = "chdir"
+
+	// ArgGroupExtra holds [Config.ExtraBwrapArgs].
+	ArgGroupExtra = "extra"
+
+	// ArgGroupData holds the per-command injected files (wrapper scripts,
+	// [MaskFS] content), permission changes and the allowlist proxy socket.
+	ArgGroupData = "data"
+
+	// ArgGroupCommand holds the "--" separator and the command.
+	ArgGroupCommand = "command"
+)
+
+// debugFD stands in for inherited FD numbers in [Sandbox.DebugArgs], which
+// are only assigned when a command is constructed.
+const debugFD = "<fd>"
+
+// debugProxyDir stands in for the host directory of the allowlist proxy
+// socket in [Sandbox.DebugArgs], which is only created per command.
+const debugProxyDir = "<proxy>"
+
+// ArgGroup is a run of consecutive bwrap arguments added by one planning
+// step (see [Sandbox.DebugArgs]).
+type ArgGroup struct {
+	// Name is one of the ArgGroup* constants. A name can occur more than
+	// once, for example presets and configured policy mounts interleave by
+	// path depth.
+	Name string `json:"name"`
+
+	Args []string `json:"args"`
+}
+
+// argGroupMark records that the [ArgGroup] name starts at index start of
+// the planned bwrap arguments.
+type argGroupMark struct {
+	name  string
+	start int
+}
+
+// group starts a new argument group: arguments appended from now on belong
+// to name. Marks without arguments are dropped, and consecutive marks with
+// the same name are merged.
+func (p *planner) group(name string) {
+	for n := len(p.groupMarks); n > 0 && p.groupMarks[n-1].start == len(p.args); n-- {
+		p.groupMarks = p.groupMarks[:n-1]
+	}
+
+	if n := len(p.groupMarks); n > 0 && p.groupMarks[n-1].name == name {
+		return
+	}
+
+	p.groupMarks = append(p.groupMarks, argGroupMark{name: name, start: len(p.args)})
+}
+
+// argGroups returns the marks of the groups that have arguments.
+func (p *planner) argGroups() []argGroupMark {
+	marks := slices.Clone(p.groupMarks)
+	for len(marks) > 0 && marks[len(marks)-1].start == len(p.args) {
+		marks = marks[:len(marks)-1]
+	}
+
+	return marks
+}
+
+// DebugArgs returns the bwrap arguments that would run argv, split into the
+// groups that produced them (see the ArgGroup* constants), for tooling that
+// answers questions like "why is --share-net missing" without reading the
+// flat argv. The groups are the planned arguments [Sandbox.Command] starts
+// from, followed by the per-command ones.
+//
+// No command is constructed: inherited FD numbers are shown as "<fd>", the
+// allowlist proxy directory (see [Config.NetworkAllow]) as "<proxy>",
+// pinned mount sources (see [Config.PinMountSources]) and caller directory
+// FDs (see [Environment.WorkDirFD]) by their paths, and a systemd-run prefix
+// is omitted. Sandboxes in [ModeAudit] or [ModeRestricted] do not run bwrap
+// and return an error.
+func (s *Sandbox) DebugArgs(ctx context.Context, argv []string) ([]ArgGroup, error) {
+	if s == nil || s.v == nil || s.plan == nil {
+		return nil, errors.New("sandbox: uninitialized sandbox (use New or NewWithEnvironment)")
+	}
+
+	if len(argv) == 0 {
+		return nil, errors.New("sandbox: no command provided")
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: %w", err)
+	}
+
+	if mode := s.v.cfg.Mode; mode == ModeAudit || mode == ModeRestricted {
+		return nil, fmt.Errorf("sandbox: debug args: mode %q does not run bwrap", mode)
+	}
+
+	if s.v.cfg.Umask != nil {
+		argv = umaskArgv(UmaskShimPath, *s.v.cfg.Umask, argv)
+	}
+
+	plan := s.plan
+	groups := make([]ArgGroup, 0, len(plan.argGroups)+2)
+
+	for i, mark := range plan.argGroups {
+		end := len(plan.bwrapArgs)
+		if i+1 < len(plan.argGroups) {
+			end = plan.argGroups[i+1].start
+		}
+
+		groups = append(groups, ArgGroup{Name: mark.name, Args: debugArgs(plan.bwrapArgs[mark.start:end])})
+	}
+
+	var data []string
+
+	for _, mount := range plan.wrapperMounts {
+		mountArgs, err := mountToArgs(Mount{Kind: MountRoBindData, Dst: mount.dst, FD: emptyDataFD, Perms: mount.perms})
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: %w", err)
+		}
+
+		data = append(data, debugArgs(mountArgs)...)
+	}
+
+	for _, chmod := range plan.chmods {
+		data = append(data, "--chmod", fmt.Sprintf("%04o", chmod.perms.Perm()), chmod.path)
+	}
+
+	if plan.networkAllow != nil {
+		data = append(data, "--ro-bind", debugProxyDir, ProxyDir)
+		argv = proxyBridgeArgv(argv)
+	}
+
+	if len(data) > 0 {
+		groups = append(groups, ArgGroup{Name: ArgGroupData, Args: data})
+	}
+
+	groups = append(groups, ArgGroup{Name: ArgGroupCommand, Args: append([]string{"--"}, argv...)})
+
+	return groups, nil
+}
+
+// debugArgs returns a copy of args with FD placeholders replaced by
+// [debugFD].
+func debugArgs(args []string) []string {
+	out := slices.Clone(args)
+
+	for i, arg := range out {
+		if arg == emptyDataFDPlaceholder || arg == userNSFDPlaceholder {
+			out[i] = debugFD
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Config.Dev].
+//
+// bwrap's `--dev /dev` creates a small tmpfs with null, zero, full, random,
+// urandom and tty bound from the host, a private devpts and an empty shm
+// directory. Dev adjusts that set: /dev/tty is masked like an excluded file,
+// /dev/shm becomes its own tmpfs, and extra host devices are added with
+// `--dev-bind`.
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// Dev controls the contents of /dev inside the sandbox. Without a Dev
+// ([Config.Dev] is nil), /dev is bwrap's minimal device set, which includes
+// /dev/tty and an empty /dev/shm directory.
+type Dev struct {
+	// AllowTTY keeps /dev/tty. If false, /dev/tty is masked, so sandboxed
+	// commands cannot open the controlling terminal, for example to prompt
+	// for a password behind a redirected stdin.
+	AllowTTY bool
+
+	// AllowShm mounts a dedicated tmpfs at /dev/shm for POSIX shared memory,
+	// as Chromium and Playwright need. If false, /dev/shm is the empty
+	// directory on bwrap's /dev tmpfs.
+	AllowShm bool
+
+	// Extra lists host device nodes or device directories below /dev that
+	// are bound at the same path with `--dev-bind`, for example "/dev/kvm"
+	// or "/dev/dri". Each must exist on the host.
+	Extra []string
+}
+
+func validateDev(dev *Dev) []error {
+	if dev == nil {
+		return nil
+	}
+
+	var errs []error
+
+	for _, path := range dev.Extra {
+		switch {
+		case !strings.HasPrefix(path, "/dev/") || filepath.Clean(path) != path:
+			errs = append(errs, fmt.Errorf("dev: extra device %q must be a clean path below /dev", path))
+		case path == "/dev/shm" || path == "/dev/tty" || path == "/dev/pts" || strings.HasPrefix(path, "/dev/pts/"):
+			errs = append(errs, fmt.Errorf("dev: extra device %q is managed by the sandbox", path))
+		}
+	}
+
+	return errs
+}
+
+// appendDev applies dev ([Config.Dev], or the @browser default) on top of
+// the `--dev /dev` mount.
+func (p *planner) appendDev(dev *Dev) error {
+	p.debugf("dev tty=%t shm=%t extra=%q", dev.AllowTTY, dev.AllowShm, dev.Extra)
+
+	if !dev.AllowTTY {
+		p.plan.needsEmptyFile = true
+
+		err := p.appendMount(Mount{Kind: MountRoBindData, FD: emptyDataFD, Perms: 0o000, Dst: "/dev/tty"})
+		if err != nil {
+			return err
+		}
+	}
+
+	if dev.AllowShm {
+		err := p.appendMount(Tmpfs("/dev/shm"))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, path := range dev.Extra {
+		info, err := p.paths.hostFS.Stat(path)
+		if err != nil {
+			return fmt.Errorf("dev: extra device: %w", err)
+		}
+
+		if info.Mode()&fs.ModeDevice == 0 && !info.IsDir() {
+			return fmt.Errorf("dev: extra device %q is neither a device nor a directory", path)
+		}
+
+		p.appendArgs("--dev-bind", path, path)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+//go:build linux
+
+package sandbox
+
+// This file implements policy fix suggestions for failed commands (see
+// [Diagnostics.SuggestFixes]).
+//
+// File accesses are not traced. Instead, the output of a failed command is
+// scanned for paths on lines that report EACCES, ENOENT or EROFS, and each
+// path is looked up in the resolved policy: if the deepest rule covering it
+// hides it or makes it read-only, the failure is likely caused by the policy.
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Diagnostics configures failure diagnostics.
+type Diagnostics struct {
+	// SuggestFixes makes [Sandbox.ExplainFailure] attach policy hints to
+	// command errors.
+	SuggestFixes bool
+}
+
+// PolicyHint suggests a policy change for a path a failed command could not
+// access.
+type PolicyHint struct {
+	// Path is the path reported by the command.
+	Path string
+
+	// Kind is [AuditExclude] or [AuditReadOnly].
+	Kind AuditKind
+
+	// Rule is the resolved policy path whose rule applies to Path.
+	Rule string
+
+	// Suggestion is a mount that would allow the access, in Go syntax.
+	Suggestion string
+}
+
+// String formats the hint as a single line.
+func (h PolicyHint) String() string {
+	verb := "was excluded"
+	if h.Kind == AuditReadOnly {
+		verb = "is read-only"
+	}
+
+	if h.Rule == h.Path {
+		return fmt.Sprintf("hint: path %s %s by the sandbox policy; consider adding %s", h.Path, verb, h.Suggestion)
+	}
+
+	return fmt.Sprintf("hint: path %s %s by the sandbox policy (rule on %s); consider adding %s", h.Path, verb, h.Rule, h.Suggestion)
+}
+
+// PolicyHintError is a command error with policy hints attached by
+// [Sandbox.ExplainFailure].
+type PolicyHintError struct {
+	Err   error
+	Hints []PolicyHint
+}
+
+func (e *PolicyHintError) Error() string {
+	var b strings.Builder
+
+	b.WriteString(e.Err.Error())
+
+	for _, hint := range e.Hints {
+		b.WriteString("\n")
+		b.WriteString(hint.String())
+	}
+
+	return b.String()
+}
+
+func (e *PolicyHintError) Unwrap() error {
+	return e.Err
+}
+
+// accessErrorLine matches error messages for denied or missing paths as
+// printed by libc (strerror) and most runtimes.
+var accessErrorLine = regexp.MustCompile(`(?i)permission denied|no such file or directory|read-only file system|operation not permitted|\bEACCES\b|\bENOENT\b|\bEROFS\b|\bEPERM\b`)
+
+// absolutePath matches absolute paths in an error message.
+var absolutePath = regexp.MustCompile(`/[^\s'"` + "`" + `:,;()\[\]{}<>]+`)
+
+// ExplainFailure returns err with policy hints for the paths that output
+// (typically the command's stderr) reports as inaccessible, when
+// [Diagnostics.SuggestFixes] is enabled and the policy hides the path or
+// makes it read-only. The result is a *[PolicyHintError] wrapping err.
+//
+// It returns err unchanged if err is nil, SuggestFixes is disabled or no
+// reported path is affected by the policy.
+func (s *Sandbox) ExplainFailure(err error, output []byte) error {
+	if err == nil || s == nil || s.v == nil || s.plan == nil || !s.v.cfg.Diagnostics.SuggestFixes {
+		return err
+	}
+
+	hints := s.policyHints(string(output))
+	if len(hints) == 0 {
+		return err
+	}
+
+	return &PolicyHintError{Err: err, Hints: hints}
+}
+
+func (s *Sandbox) policyHints(output string) []PolicyHint {
+	var hints []PolicyHint
+
+	seen := make(map[string]bool)
+
+	for line := range strings.Lines(output) {
+		if !accessErrorLine.MatchString(line) {
+			continue
+		}
+
+		for _, path := range absolutePath.FindAllString(line, -1) {
+			path = strings.TrimRight(path, ".")
+			if seen[path] {
+				continue
+			}
+
+			seen[path] = true
+
+			hint, ok := s.policyHint(path)
+			if ok {
+				hints = append(hints, hint)
+			}
+		}
+	}
+
+	return hints
+}
+
+// policyHint looks up the deepest policy rule covering path.
+func (s *Sandbox) policyHint(path string) (PolicyHint, bool) {
+	best := PolicyHint{Path: path}
+
+	for _, finding := range s.plan.auditFindings {
+		if (finding.Kind == AuditExclude || finding.Kind == AuditReadOnly) && isPathWithin(path, finding.Path) && len(finding.Path) > len(best.Rule) {
+			best.Kind = finding.Kind
+			best.Rule = finding.Path
+		}
+	}
+
+	if best.Rule == "" {
+		return PolicyHint{}, false
+	}
+
+	for _, writable := range s.plan.auditWritable {
+		if isPathWithin(path, writable) && len(writable) > len(best.Rule) {
+			return PolicyHint{}, false
+		}
+	}
+
+	if best.Kind == AuditExclude {
+		best.Suggestion = fmt.Sprintf("RO(%q) or RW(%q)", best.Rule, best.Rule)
+	} else {
+		best.Suggestion = fmt.Sprintf("RW(%q)", best.Rule)
+	}
+
+	return best, true
+}
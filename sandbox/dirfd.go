@@ -0,0 +1,144 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Environment.WorkDirFD] and [Environment.HomeDirFD].
+//
+// Planning still works on paths: the directory an FD refers to is read from
+// /proc/self/fd when the Sandbox is constructed. Command() then rewrites the
+// source of every bind mount at or beneath such a directory to
+// /proc/self/fd/N/<rel>, where N is the FD as inherited by bwrap. bwrap opens
+// sources after it starts, and following the magic link reaches the open
+// directory even if its path has since been renamed or replaced.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// dirFDSource is a host directory held open by the caller.
+type dirFDSource struct {
+	// path is the canonical host path of the directory at construction time.
+	path string
+	file *os.File
+}
+
+// resolveDirFDs fills empty WorkDir and HomeDir from their FDs and checks that
+// non-empty ones name the same directory. It returns the FD-backed
+// directories, deepest first so nested ones take precedence.
+func resolveDirFDs(env *Environment) ([]dirFDSource, error) {
+	var dirs []dirFDSource
+
+	for _, d := range []struct {
+		name string
+		file *os.File
+		path *string
+		err  error
+	}{
+		{"WorkDir", env.WorkDirFD, &env.WorkDir, ErrNoWorkDir},
+		{"HomeDir", env.HomeDirFD, &env.HomeDir, ErrNoHomeDir},
+	} {
+		if d.file == nil {
+			continue
+		}
+
+		var fdStat unix.Stat_t
+
+		err := unix.Fstat(int(d.file.Fd()), &fdStat)
+		if err != nil {
+			return nil, fmt.Errorf("%w: environment %sFD: %w", d.err, d.name, err)
+		}
+
+		if fdStat.Mode&unix.S_IFMT != unix.S_IFDIR {
+			return nil, fmt.Errorf("%w: environment %sFD is not a directory", d.err, d.name)
+		}
+
+		target, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(int(d.file.Fd())))
+		if err != nil {
+			return nil, fmt.Errorf("%w: environment %sFD: %w", d.err, d.name, err)
+		}
+
+		if !filepath.IsAbs(target) || strings.HasSuffix(target, " (deleted)") {
+			return nil, fmt.Errorf("%w: environment %sFD refers to %q, which is not reachable by path", d.err, d.name, target)
+		}
+
+		if *d.path == "" {
+			*d.path = target
+		} else {
+			var pathStat unix.Stat_t
+
+			err = unix.Stat(*d.path, &pathStat)
+			if err != nil || pathStat.Dev != fdStat.Dev || pathStat.Ino != fdStat.Ino {
+				return nil, fmt.Errorf("%w: environment %s %q is not the directory %sFD refers to (%s)", d.err, d.name, *d.path, d.name, target)
+			}
+		}
+
+		dirs = append(dirs, dirFDSource{path: target, file: d.file})
+	}
+
+	if len(dirs) == 2 && len(dirs[1].path) > len(dirs[0].path) {
+		dirs[0], dirs[1] = dirs[1], dirs[0]
+	}
+
+	return dirs, nil
+}
+
+// dirFDArgs rewrites the path sources of bind mounts in args that lie at or
+// beneath one of dirs to go through the directory's FD. It returns the files
+// that must be inherited starting at firstFD, in order; the caller does not
+// own them. args is modified in place.
+func dirFDArgs(args []string, dirs []dirFDSource, firstFD int) []*os.File {
+	var files []*os.File
+
+	childFD := make(map[*os.File]int, len(dirs))
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--bind", "--bind-try", "--ro-bind", "--ro-bind-try", "--dev-bind", "--dev-bind-try":
+			if i+1 >= len(args) {
+				return files
+			}
+
+			for _, dir := range dirs {
+				rel, ok := relativeTo(args[i+1], dir.path)
+				if !ok {
+					continue
+				}
+
+				fd, ok := childFD[dir.file]
+				if !ok {
+					fd = firstFD + len(files)
+					childFD[dir.file] = fd
+					files = append(files, dir.file)
+				}
+
+				args[i+1] = filepath.Join("/proc/self/fd", strconv.Itoa(fd), rel)
+
+				break
+			}
+		}
+
+		i += bwrapOptionArity[args[i]]
+	}
+
+	return files
+}
+
+// relativeTo returns path relative to dir if path is dir or lies beneath it.
+func relativeTo(path, dir string) (string, bool) {
+	if path == dir {
+		return ".", true
+	}
+
+	rest, ok := strings.CutPrefix(path, dir)
+	if !ok || (dir != "/" && !strings.HasPrefix(rest, "/")) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(rest, "/"), true
+}
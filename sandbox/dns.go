@@ -5,16 +5,17 @@ package sandbox
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
-// dnsResolverArgs returns bwrap args to preserve DNS resolution when /etc/resolv.conf
-// is a symlink into /run (common with systemd-resolved).
+// dnsResolverMounts returns mounts that preserve DNS resolution when
+// /etc/resolv.conf is a symlink into /run (common with systemd-resolved).
 //
 // The sandbox mounts /run as a fresh tmpfs, which would otherwise break such
 // symlinks. We fix this by bind-mounting the symlink target's parent directory
 // from the host into /run inside the sandbox.
-func dnsResolverArgs(debugf Debugf) []string {
+func dnsResolverMounts(debugf Debugf) []Mount {
 	const resolvConf = "/etc/resolv.conf"
 
 	linkTarget, err := os.Readlink(resolvConf)
@@ -51,8 +52,20 @@ func dnsResolverArgs(debugf Debugf) []string {
 		debugf("dns: resolv.conf is symlink to %q (resolved=%q); bind-mounting %q", linkTarget, resolvedPath, parentDir)
 	}
 
-	return []string{
-		"--dir", parentDir,
-		"--ro-bind", parentDir, parentDir,
+	return []Mount{
+		Dir(parentDir),
+		RoBind(parentDir, parentDir),
 	}
 }
+
+// DNSDirs returns the host directories bound into the sandbox's /run so that
+// a /etc/resolv.conf symlink into /run (systemd-resolved, NetworkManager)
+// keeps resolving. It is empty when network access is disabled or
+// resolv.conf is a regular file.
+func (s *Sandbox) DNSDirs() []string {
+	if s == nil || s.plan == nil {
+		return nil
+	}
+
+	return slices.Clone(s.plan.dnsDirs)
+}
@@ -20,7 +20,9 @@ import (
 )
 
 // dockerSocketMountPlan returns a mountPlan that either exposes or masks the docker socket.
-func dockerSocketMountPlan(dockerEnabled bool, hostEnv map[string]string, paths pathResolver, debugf Debugf) (mountPlan, error) {
+func dockerSocketMountPlan(dockerEnabled bool, env Environment, paths pathResolver, debugf Debugf) (mountPlan, error) {
+	hostEnv := env.HostEnv
+
 	dockerHost := ""
 	if hostEnv != nil {
 		dockerHost = hostEnv["DOCKER_HOST"]
@@ -61,6 +63,10 @@ func dockerSocketMountPlan(dockerEnabled bool, hostEnv map[string]string, paths
 		return mountPlan{}, fmt.Errorf("docker socket path %q is too deeply nested (%d)", dstPath, depth)
 	}
 
+	if !dockerEnabled && env.WSL != WSLNone && dockerHost == "" {
+		return wslDockerMaskPlan(socketPath, dstPath, depth, paths, debugf), nil
+	}
+
 	if !dockerEnabled {
 		if debugf != nil {
 			if dstPath != socketPath {
@@ -102,6 +108,35 @@ func dockerSocketMountPlan(dockerEnabled bool, hostEnv map[string]string, paths
 	return mountPlan{specs: []mountSpec{spec}}, nil
 }
 
+// dockerSocketFromPlan returns the socket mount of a docker mount plan: the
+// sandbox path and, when the socket is exposed, its host source. Both are
+// empty when nothing is mounted at the socket path.
+func dockerSocketFromPlan(plan mountPlan) (string, string) {
+	for _, spec := range plan.specs {
+		switch spec.mount.Kind {
+		case MountBind:
+			return spec.mount.Dst, spec.mount.Src
+		case MountRoBind:
+			return spec.mount.Dst, ""
+		}
+	}
+
+	return "", ""
+}
+
+// DockerSocket returns where the sandbox mounts the docker socket, after
+// resolving DOCKER_HOST and symlinked parent directories (such as
+// /var/run -> /run) on the host. src is the resolved host socket when
+// [Config.Docker] is enabled and empty when the socket is masked. Both are
+// empty when no mount is planned (for example on WSL without a socket).
+func (s *Sandbox) DockerSocket() (dst, src string) {
+	if s == nil || s.plan == nil {
+		return "", ""
+	}
+
+	return s.plan.dockerSocket, s.plan.dockerSocketSrc
+}
+
 // dockerSocketPathFromEnv extracts a unix socket path from DOCKER_HOST.
 //
 // Non-unix schemes are ignored.
@@ -124,3 +159,45 @@ func dockerSocketPathFromEnv(hostEnv map[string]string) string {
 		return ""
 	}
 }
+
+// wslDockerMaskPlan masks the docker socket on WSL. Docker Desktop keeps its
+// sockets in a directory shared by all integrated distributions and links
+// /var/run/docker.sock to it only in distributions with integration enabled.
+// The shared directory is masked when it exists, and the default socket only
+// when it exists: bwrap cannot create a mount point for it on the read-only
+// root.
+func wslDockerMaskPlan(socketPath, dstPath string, depth int, paths pathResolver, debugf Debugf) mountPlan {
+	var plan mountPlan
+
+	info, err := os.Stat(wslDockerDesktopSockets)
+	if err == nil && info.IsDir() {
+		if debugf != nil {
+			debugf("docker: masking docker desktop sockets at %q", wslDockerDesktopSockets)
+		}
+
+		plan.specs = append(plan.specs, mountSpec{
+			mount:     Mount{Kind: MountTmpfs, Dst: wslDockerDesktopSockets},
+			pathDepth: paths.Depth(wslDockerDesktopSockets),
+		})
+	}
+
+	_, err = os.Lstat(socketPath)
+	if err != nil {
+		if debugf != nil {
+			debugf("docker: wsl: skipping mask of missing socket %q", socketPath)
+		}
+
+		return plan
+	}
+
+	if debugf != nil {
+		debugf("docker: masking socket at %q", socketPath)
+	}
+
+	plan.specs = append(plan.specs, mountSpec{
+		mount:     Mount{Kind: MountRoBind, Src: "/dev/null", Dst: dstPath},
+		pathDepth: depth,
+	})
+
+	return plan
+}
@@ -0,0 +1,142 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the environment contract of sandboxed commands (see
+// [Sandbox.Env]).
+//
+// bwrap starts the command with its own environment (HostEnv, passed sorted by
+// name) and then applies --setenv/--unsetenv in argument order, so the last
+// operation on a variable wins. The planner emits them in a fixed order, from
+// lowest to highest precedence:
+//
+//  1. HostEnv
+//  2. TMPDIR=/tmp (TempDir)
+//  3. HOME, USER, LOGNAME, SHELL (Identity, with [RunSpec.Identity] merged
+//     over it for one command)
+//  4. locale, time zone and terminal (NormalizeEnv, by name), then removal of
+//     color-forcing variables
+//  5. CA bundle variables (ExtraCACerts)
+//  6. libfaketime variables (Clock)
+//  7. --setenv/--unsetenv in ExtraBwrapArgs, in the order given
+//
+// Command wrapper Env applies on top of all of these, to the wrapped command
+// only.
+
+import (
+	"maps"
+	"slices"
+)
+
+// envOp is one --setenv or --unsetenv operation of the plan.
+type envOp struct {
+	name  string
+	value string
+	unset bool
+}
+
+// appendEnvArgs appends bwrap arguments and records the --setenv and
+// --unsetenv operations among them for [Sandbox.Env].
+func (p *planner) appendEnvArgs(args ...string) {
+	p.appendArgs(args...)
+	p.plan.envOps = append(p.plan.envOps, parseEnvOps(args)...)
+}
+
+// parseEnvOps returns the environment operations in bwrap arguments, skipping
+// the operands of other options. args must be valid bwrap options.
+func parseEnvOps(args []string) []envOp {
+	var ops []envOp
+
+	for i := 0; i < len(args); i++ {
+		arity := bwrapOptionArity[args[i]]
+		if i+arity >= len(args) {
+			break
+		}
+
+		switch args[i] {
+		case "--setenv":
+			ops = append(ops, envOp{name: args[i+1], value: args[i+2]})
+		case "--unsetenv":
+			ops = append(ops, envOp{name: args[i+1], unset: true})
+		}
+
+		i += arity
+	}
+
+	return ops
+}
+
+// Env returns the environment a sandboxed command starts with, as KEY=VALUE
+// pairs sorted by name: [Environment.HostEnv] with the variables set and
+// removed by the configuration applied in a fixed precedence order (TempDir,
+// Identity, NormalizeEnv, ExtraCACerts, Clock, then ExtraBwrapArgs; later
+// wins). The result is the same for every Sandbox built from equal Config
+// and Environment, so it can be compared against golden files.
+//
+// In [ModeAudit] commands run directly on the host and Env returns HostEnv.
+// In [ModeRestricted] it returns the host environment restricted commands
+// start with. It returns nil for an uninitialized Sandbox.
+func (s *Sandbox) Env() []string {
+	if s == nil || s.v == nil || s.plan == nil {
+		return nil
+	}
+
+	switch s.v.cfg.Mode {
+	case ModeAudit:
+		return envMapToSliceSorted(s.v.env.HostEnv)
+	case ModeRestricted:
+		return envMapToSliceSorted(s.restrictedEnv(s.plan.envOps))
+	}
+
+	return envMapToSliceSorted(applyEnvOps(s.v.env.HostEnv, s.plan.envOps, nil))
+}
+
+// runEnvOps returns the plan's environment operations for a command whose
+// [RunSpec.Identity] is identity: the identity, merged over [Config.Identity],
+// is applied at the Identity step, so later steps still take precedence.
+func (s *Sandbox) runEnvOps(identity Identity) []envOp {
+	idx := s.plan.identityEnvOps
+
+	ops := slices.Clone(s.plan.envOps[:idx])
+	ops = append(ops, parseEnvOps(s.v.cfg.Identity.merge(identity).setenvArgs())...)
+
+	return append(ops, s.plan.envOps[idx:]...)
+}
+
+// envOpArgs returns ops as bwrap arguments.
+func envOpArgs(ops []envOp) []string {
+	args := make([]string, 0, 3*len(ops))
+
+	for _, op := range ops {
+		if op.unset {
+			args = append(args, "--unsetenv", op.name)
+		} else {
+			args = append(args, "--setenv", op.name, op.value)
+		}
+	}
+
+	return args
+}
+
+// applyEnvOps returns a copy of env with ops applied in order. Operations for
+// which skip returns true are ignored; skip may be nil.
+func applyEnvOps(env map[string]string, ops []envOp, skip func(envOp) bool) map[string]string {
+	out := maps.Clone(env)
+	if out == nil {
+		out = map[string]string{}
+	}
+
+	for _, op := range ops {
+		if skip != nil && skip(op) {
+			continue
+		}
+
+		if op.unset {
+			delete(out, op.name)
+		} else {
+			out[op.name] = op.value
+		}
+	}
+
+	return out
+}
@@ -2,6 +2,21 @@
 
 package sandbox
 
+import (
+	"errors"
+	"os"
+)
+
+var (
+	// ErrNoHomeDir reports that no usable home directory could be determined
+	// or that [Environment.HomeDir] is empty or relative.
+	ErrNoHomeDir = errors.New("no usable home directory")
+
+	// ErrNoWorkDir reports that no usable working directory could be
+	// determined or that [Environment.WorkDir] is empty or relative.
+	ErrNoWorkDir = errors.New("no usable working directory")
+)
+
 // Environment describes the host process environment used to resolve and build a sandbox.
 type Environment struct {
 	// HomeDir is the host home directory.
@@ -14,4 +29,90 @@ type Environment struct {
 	// also used as the environment for the command executed inside the sandbox.
 	// If HostEnv is nil, an empty environment is used.
 	HostEnv map[string]string
+
+	// WorkDirFD and HomeDirFD optionally hold open directories (for example
+	// O_PATH descriptors received over a socket) for WorkDir and HomeDir. When
+	// set, bind mounts whose source is the directory or lies beneath it are
+	// handed to bwrap through the inherited FD (as /proc/self/fd/N/...)
+	// instead of by path, so a directory renamed or replaced after
+	// construction is not what gets mounted. An empty WorkDir or HomeDir is
+	// filled in from the FD; a non-empty one must name the same directory.
+	//
+	// The caller keeps ownership: the Sandbox never closes the files, and they
+	// must stay open while commands are created. Audit and restricted modes
+	// run on the host and ignore them.
+	WorkDirFD *os.File
+	HomeDirFD *os.File
+
+	// FS is the host filesystem view used while planning: resolving policy
+	// paths, globs and symlinks, and finding commands on PATH. If nil, the
+	// real filesystem ([OSHostFS]) is used. Tests can pass a [MemHostFS].
+	FS HostFS
+
+	// WSL is the Windows Subsystem for Linux version of the host, as reported
+	// by [DetectWSL]. On WSL, policy paths may be Windows paths ("C:\src"),
+	// which resolve to their /mnt/<drive> mount, and unsupported features
+	// are reported via [Config.Debugf].
+	WSL WSLVersion
+}
+
+// Validate checks the invariants [NewWithEnvironment] requires of env: HomeDir
+// and WorkDir are absolute and HostEnv holds valid variable names and values.
+// Errors wrap [ErrNoHomeDir] or [ErrNoWorkDir] where they apply.
+//
+// The check is purely syntactic, so environments collected on another machine
+// can be validated before use. Whether the directories exist is only
+// checked when a sandbox is constructed.
+func (env Environment) Validate() error {
+	return errors.Join(validateEnvironment(env)...)
+}
+
+// Identity overrides identity-related environment variables seen by the
+// sandboxed process, independent of the host values in [Environment.HostEnv].
+//
+// This is useful when tools derive paths or behavior from HOME/USER/SHELL and
+// should behave the same across developer machines and CI.
+//
+// Overrides only affect the sandboxed process. Host-side planning (for example
+// "~" expansion in policy mounts) always uses [Environment.HomeDir].
+//
+// Empty fields leave the corresponding host value unchanged.
+type Identity struct {
+	// Home overrides HOME. Must be absolute when set.
+	Home string
+
+	// User overrides both USER and LOGNAME.
+	User string
+
+	// Shell overrides SHELL. Must be absolute when set.
+	Shell string
+}
+
+// merge returns id with the non-empty fields of over applied.
+func (id Identity) merge(over Identity) Identity {
+	return Identity{
+		Home:  mergeString(id.Home, over.Home),
+		User:  mergeString(id.User, over.User),
+		Shell: mergeString(id.Shell, over.Shell),
+	}
+}
+
+// setenvArgs returns the `--setenv` bwrap arguments for the configured overrides
+// in a fixed order (HOME, USER, LOGNAME, SHELL).
+func (id Identity) setenvArgs() []string {
+	var args []string
+
+	if id.Home != "" {
+		args = append(args, "--setenv", "HOME", id.Home)
+	}
+
+	if id.User != "" {
+		args = append(args, "--setenv", "USER", id.User, "--setenv", "LOGNAME", id.User)
+	}
+
+	if id.Shell != "" {
+		args = append(args, "--setenv", "SHELL", id.Shell)
+	}
+
+	return args
 }
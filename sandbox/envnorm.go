@@ -0,0 +1,69 @@
+//go:build linux
+
+package sandbox
+
+// This file implements environment normalization (see [Config.NormalizeEnv]).
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// normalizedEnv lists the variables [Config.NormalizeEnv] sets, so command
+// output parsed by agents does not depend on the host locale, time zone or
+// terminal.
+var normalizedEnv = map[string]string{
+	"LC_ALL":   "C.UTF-8",
+	"LANG":     "C.UTF-8",
+	"TZ":       "UTC",
+	"TERM":     "dumb",
+	"COLUMNS":  "80",
+	"LINES":    "24",
+	"NO_COLOR": "1",
+}
+
+// colorForcingEnv lists the variables [Config.NormalizeEnv] removes because
+// they force colored output even when TERM is dumb or output is not a TTY.
+var colorForcingEnv = []string{
+	"CLICOLOR_FORCE",
+	"COLORTERM",
+	"FORCE_COLOR",
+	"GIT_PAGER_IN_USE",
+	"PY_COLORS",
+	"TERM_PROGRAM",
+}
+
+func validateNormalizeEnvOverrides(overrides map[string]string) []error {
+	var errs []error
+
+	for _, name := range slices.Sorted(maps.Keys(overrides)) {
+		if !isShellName(name) {
+			errs = append(errs, fmt.Errorf("normalize env override %q is not a valid variable name", name))
+		}
+	}
+
+	return errs
+}
+
+// normalizeEnvArgs returns the `--setenv`/`--unsetenv` bwrap arguments for
+// [Config.NormalizeEnv] in name order. overrides replace values of
+// normalizedEnv or keep colorForcingEnv variables.
+func normalizeEnvArgs(overrides map[string]string) []string {
+	values := maps.Clone(normalizedEnv)
+	maps.Copy(values, overrides)
+
+	var args []string
+
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		args = append(args, "--setenv", name, values[name])
+	}
+
+	for _, name := range colorForcingEnv {
+		if _, ok := values[name]; !ok {
+			args = append(args, "--unsetenv", name)
+		}
+	}
+
+	return args
+}
@@ -0,0 +1,255 @@
+//go:build linux
+
+package sandbox
+
+// This file implements privilege escalation for hosts where bwrap cannot
+// create user namespaces as an unprivileged user (see [Config.Escalation]),
+// and [Doctor], which describes the host's situation.
+//
+// Some distributions disable unprivileged user namespaces (Debian's
+// kernel.unprivileged_userns_clone, user.max_user_namespaces=0, Ubuntu's
+// AppArmor restriction) and ship bwrap setuid root instead. Others need bwrap
+// started as root through pkexec or sudo. Both change what bwrap sees:
+//
+//   - glibc drops "unsecure" variables such as LD_LIBRARY_PATH from the
+//     environment of setuid programs, so they are passed back with --setenv.
+//   - pkexec and sudo reset the environment and close inherited FDs, so only
+//     a few identity variables are passed (as --setenv, which other users can
+//     read in the process list) and per-command files use host temp files.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Escalation selects how bwrap gets the privileges to create namespaces on
+// hosts without unprivileged user namespaces. The zero value starts bwrap
+// as the calling user. Use [Doctor] to find out what the host needs.
+//
+// Escalation is ignored in [ModeAudit] and [ModeRestricted], which do not
+// run bwrap.
+type Escalation struct {
+	// UseSetuidBwrap requires the bwrap found in PATH to be setuid root and
+	// fails commands if it is not, instead of letting them fail inside bwrap.
+	// Environment variables glibc removes for setuid programs (LD_PRELOAD,
+	// LD_LIBRARY_PATH, TMPDIR, ...) are passed back with --setenv, so the
+	// command sees the same environment. Setuid bwrap rejects --userns, so
+	// [Config.MapSubIDs] cannot be combined with it.
+	UseSetuidBwrap bool
+
+	// PkexecPrompt, if set, starts bwrap as root through pkexec, or through
+	// sudo when pkexec is not in PATH. sudo shows PkexecPrompt when it asks
+	// for the password; pkexec authenticates through the polkit agent, which
+	// shows its own message. If authentication fails or is dismissed, the
+	// command exits before bwrap starts (pkexec with 126 or 127, sudo with 1).
+	//
+	// The command runs with the caller's uid and gid in a new user namespace
+	// and without capabilities, but bwrap itself runs as root: files the
+	// command creates in writable mounts are owned by root on the host.
+	// Prefer a setuid bwrap where one is available.
+	//
+	// pkexec and sudo reset the environment. Only PATH, HOME, USER, LOGNAME,
+	// SHELL, TERM, LANG, LC_ALL and TZ from [Environment.HostEnv] are passed
+	// on, as bwrap arguments; other variables are dropped rather than
+	// exposed in the process list. They also close inherited FDs, so
+	// [Config.PinMountSources], [Config.MapSubIDs], [Environment.WorkDirFD]
+	// and [RunSpec.PassFDs] cannot be used, and injected files are staged in
+	// host temp files.
+	PkexecPrompt string
+}
+
+func (e Escalation) enabled() bool {
+	return e.UseSetuidBwrap || e.PkexecPrompt != ""
+}
+
+// setuidUnsecureEnv lists the variables glibc removes from the environment
+// of setuid programs (unsecvars.h).
+var setuidUnsecureEnv = []string{
+	"GCONV_PATH", "GETCONF_DIR", "GLIBC_TUNABLES", "HOSTALIASES", "LD_AUDIT",
+	"LD_DEBUG", "LD_DEBUG_OUTPUT", "LD_DYNAMIC_WEAK", "LD_HWCAP_MASK",
+	"LD_LIBRARY_PATH", "LD_ORIGIN_PATH", "LD_PRELOAD", "LD_PROFILE",
+	"LD_SHOW_AUXV", "LD_USE_LOAD_BIAS", "LOCALDOMAIN", "LOCPATH",
+	"MALLOC_TRACE", "NIS_PATH", "NLSPATH", "RESOLV_HOST_CONF", "RES_OPTIONS",
+	"TMPDIR", "TZDIR",
+}
+
+// escalatedEnv lists the variables passed through pkexec and sudo.
+var escalatedEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "LANG", "LC_ALL", "TZ"}
+
+func validateEscalation(e Escalation, mapSubIDs, pinSources bool) []error {
+	var errs []error
+
+	if e.UseSetuidBwrap && e.PkexecPrompt != "" {
+		errs = append(errs, errors.New("escalation: UseSetuidBwrap and PkexecPrompt are mutually exclusive"))
+	}
+
+	if e.enabled() && mapSubIDs {
+		errs = append(errs, errors.New("escalation: MapSubIDs needs --userns, which setuid bwrap rejects and pkexec/sudo cannot pass"))
+	}
+
+	if e.PkexecPrompt != "" && pinSources {
+		errs = append(errs, errors.New("escalation: PinMountSources needs inherited FDs, which pkexec and sudo close"))
+	}
+
+	if strings.ContainsRune(e.PkexecPrompt, 0) {
+		errs = append(errs, errors.New("escalation: PkexecPrompt contains a NUL byte"))
+	}
+
+	return errs
+}
+
+// escalationArgs returns the bwrap arguments that restore the environment
+// lost by escalation, to be placed before the planned arguments so the
+// planner's own --setenv and --unsetenv win.
+func (e Escalation) escalationArgs(hostEnv map[string]string) []string {
+	names := setuidUnsecureEnv
+	if e.PkexecPrompt != "" {
+		names = escalatedEnv
+	}
+
+	var args []string
+
+	for _, name := range names {
+		if value, ok := hostEnv[name]; ok {
+			args = append(args, "--setenv", name, value)
+		}
+	}
+
+	if e.PkexecPrompt != "" {
+		args = append(args,
+			"--unshare-user",
+			"--uid", strconv.Itoa(os.Getuid()),
+			"--gid", strconv.Itoa(os.Getgid()),
+			"--cap-drop", "ALL",
+		)
+	}
+
+	return args
+}
+
+// launcher returns the argv prefix that starts bwrapPath as configured:
+// bwrapPath itself, or pkexec or sudo followed by bwrapPath. With
+// UseSetuidBwrap it checks that bwrapPath is setuid root.
+func (e Escalation) launcher(bwrapPath string) ([]string, error) {
+	if e.UseSetuidBwrap {
+		if !setuidRoot(bwrapPath) {
+			return nil, fmt.Errorf("escalation: %s is not setuid root (install the setuid bwrap, or see Doctor)", bwrapPath)
+		}
+
+		return []string{bwrapPath}, nil
+	}
+
+	if e.PkexecPrompt == "" {
+		return []string{bwrapPath}, nil
+	}
+
+	pkexec, err := exec.LookPath("pkexec")
+	if err == nil {
+		return []string{pkexec, bwrapPath}, nil
+	}
+
+	sudo, err := exec.LookPath("sudo")
+	if err == nil {
+		return []string{sudo, "-p", e.PkexecPrompt, "--", bwrapPath}, nil
+	}
+
+	return nil, errors.New("escalation: neither pkexec nor sudo found in PATH")
+}
+
+// setuidRoot reports whether path is a setuid executable owned by root.
+func setuidRoot(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSetuid == 0 {
+		return false
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	return ok && stat.Uid == 0
+}
+
+// HostReport describes whether and how bwrap can create namespaces on this
+// host, as returned by [Doctor].
+type HostReport struct {
+	// Bwrap is the bwrap binary in PATH, or "" if there is none.
+	Bwrap string
+
+	// BwrapSetuid reports whether Bwrap is setuid root.
+	BwrapSetuid bool
+
+	// UserNamespaces reports whether unprivileged user namespaces are
+	// enabled. It is a static check of the kernel settings; a security
+	// module can still deny them.
+	UserNamespaces bool
+
+	// UserNamespacesReason says why user namespaces are unavailable, or is
+	// empty.
+	UserNamespacesReason string
+
+	// Pkexec and Sudo are the escalation commands in PATH, or "".
+	Pkexec string
+	Sudo   string
+
+	// Advice is a sentence on which configuration works on this host.
+	Advice string
+}
+
+// Doctor inspects the host and describes how the sandbox can run on it:
+// unprivileged (the default), with a setuid bwrap
+// ([Escalation.UseSetuidBwrap]), through pkexec or sudo
+// ([Escalation.PkexecPrompt]), or not at all ([ModeRestricted]).
+func Doctor() HostReport {
+	var report HostReport
+
+	report.Bwrap, _ = exec.LookPath("bwrap")
+	report.Pkexec, _ = exec.LookPath("pkexec")
+	report.Sudo, _ = exec.LookPath("sudo")
+
+	if report.Bwrap != "" {
+		report.BwrapSetuid = setuidRoot(report.Bwrap)
+	}
+
+	report.UserNamespacesReason = userNamespacesDisabled()
+	report.UserNamespaces = report.UserNamespacesReason == ""
+
+	switch {
+	case report.Bwrap == "":
+		report.Advice = "bwrap is not installed; install bubblewrap, or use ModeRestricted for partial enforcement"
+	case report.UserNamespaces:
+		report.Advice = "unprivileged user namespaces are available; no escalation is needed"
+	case report.BwrapSetuid:
+		report.Advice = "user namespaces are disabled (" + report.UserNamespacesReason + "), but bwrap is setuid root; set Escalation.UseSetuidBwrap"
+	case report.Pkexec != "" || report.Sudo != "":
+		report.Advice = "user namespaces are disabled (" + report.UserNamespacesReason + "); enable them, install a setuid bwrap, or set Escalation.PkexecPrompt to start bwrap as root"
+	default:
+		report.Advice = "user namespaces are disabled (" + report.UserNamespacesReason + ") and there is no setuid bwrap, pkexec or sudo; enable them, or use ModeRestricted for partial enforcement"
+	}
+
+	return report
+}
+
+// userNamespacesDisabled returns the kernel setting that disables
+// unprivileged user namespaces, or "".
+func userNamespacesDisabled() string {
+	for _, setting := range []struct {
+		path, disabled string
+	}{
+		{"/proc/sys/kernel/unprivileged_userns_clone", "0"},
+		{"/proc/sys/user/max_user_namespaces", "0"},
+		{"/proc/sys/kernel/apparmor_restrict_unprivileged_userns", "1"},
+	} {
+		data, err := os.ReadFile(setting.path)
+		if err == nil && strings.TrimSpace(string(data)) == setting.disabled {
+			name := strings.ReplaceAll(strings.TrimPrefix(setting.path, "/proc/sys/"), "/", ".")
+
+			return name + "=" + setting.disabled
+		}
+	}
+
+	return ""
+}
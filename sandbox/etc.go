@@ -0,0 +1,152 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the /etc mirror (see [Config.Etc]).
+//
+// Assembling a believable /etc is the hardest part of [BaseFSEmpty]: name
+// service, TLS, locale and user lookups all read it. Etc binds the host /etc
+// read-only as part of the base filesystem and then overlays synthesized files
+// with `--ro-bind-data`, like wrapper scripts, so the host files are never
+// modified.
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// Etc configures the sandbox /etc.
+type Etc struct {
+	// ReadOnly binds the host /etc read-only at /etc when BaseFS is
+	// [BaseFSEmpty]. With [BaseFSHost], /etc is already part of the read-only
+	// root and ReadOnly has no effect.
+	ReadOnly bool
+
+	// Overrides replaces files below /etc with the given content, keyed by
+	// path relative to /etc (for example "hosts", "nsswitch.conf" or
+	// "ssl/openssl.cnf"). Files are read-only (0444) and are mounted after all
+	// other mounts, so they win over policy and direct mounts. See
+	// [PasswdEntry] for a fake user entry.
+	//
+	// With [BaseFSHost] the overridden file must exist on the host, since
+	// bwrap cannot create mount points on the read-only root.
+	Overrides map[string][]byte
+
+	// SynthesizePasswd replaces /etc/passwd and /etc/group with minimal files
+	// containing root, nobody and the sandbox user: the caller's uid and gid
+	// (0 with [Config.MapSubIDs]), named after [Identity.User] or USER, with
+	// the sandbox HOME and SHELL. Tools such as ssh, some JVMs and anything
+	// calling getpwuid fail when the current uid has no entry. Explicit
+	// Overrides of "passwd" or "group" take precedence.
+	SynthesizePasswd bool
+}
+
+// PasswdEntry returns a passwd(5) line for a user, for use in
+// Etc.Overrides["passwd"]. Tools such as ssh and git look up the current uid
+// and fail when it has no entry.
+func PasswdEntry(user string, uid, gid int, home, shell string) string {
+	return user + ":x:" + strconv.Itoa(uid) + ":" + strconv.Itoa(gid) + "::" + home + ":" + shell + "\n"
+}
+
+func validateEtc(etc Etc) []error {
+	var errs []error
+
+	for _, name := range slices.Sorted(maps.Keys(etc.Overrides)) {
+		if name == "." || !fs.ValidPath(name) {
+			errs = append(errs, fmt.Errorf("etc override %q is not a path relative to /etc", name))
+		}
+	}
+
+	return errs
+}
+
+// synthesizedPasswd returns minimal passwd and group files for the sandbox
+// user.
+func (p *planner) synthesizedPasswd() (passwd, group string) {
+	uid, gid := os.Getuid(), os.Getgid()
+	if p.cfg.MapSubIDs {
+		uid, gid = 0, 0
+	}
+
+	name := p.cfg.Identity.User
+	if name == "" {
+		name = p.env.HostEnv["USER"]
+	}
+
+	if name == "" || uid == 0 {
+		name = "root"
+	}
+
+	home := p.cfg.Identity.Home
+	if home == "" {
+		home = p.env.HomeDir
+	}
+
+	shell := p.cfg.Identity.Shell
+	if shell == "" {
+		shell = p.env.HostEnv["SHELL"]
+	}
+
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	if uid != 0 {
+		passwd = PasswdEntry("root", 0, 0, "/root", "/bin/sh")
+	}
+
+	passwd += PasswdEntry(name, uid, gid, home, shell)
+	passwd += PasswdEntry("nobody", 65534, 65534, "/nonexistent", "/usr/sbin/nologin")
+
+	group = "root:x:0:\n"
+	if gid != 0 {
+		group += name + ":x:" + strconv.Itoa(gid) + ":\n"
+	}
+
+	group += "nogroup:x:65534:\n"
+
+	return passwd, group
+}
+
+// appendEtcOverrides injects Etc.Overrides (and the synthesized passwd and
+// group files) below /etc.
+func (p *planner) appendEtcOverrides() error {
+	overrides := p.cfg.Etc.Overrides
+
+	if p.cfg.Etc.SynthesizePasswd {
+		passwd, group := p.synthesizedPasswd()
+		overrides = map[string][]byte{"passwd": []byte(passwd), "group": []byte(group)}
+		maps.Copy(overrides, p.cfg.Etc.Overrides)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(overrides)) {
+		dst := filepath.Join("/etc", name)
+
+		if p.cfg.BaseFS == BaseFSEmpty {
+			err := p.appendParentDirs(dst)
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err := os.Lstat(dst)
+			if err != nil {
+				return fmt.Errorf("etc override %q: %w", name, err)
+			}
+		}
+
+		p.debugf("etc override %q bytes=%d", dst, len(overrides[name]))
+
+		p.plan.wrapperMounts = append(p.plan.wrapperMounts, roBindDataMount{
+			dst:   dst,
+			perms: 0o444,
+			data:  string(overrides[name]),
+		})
+	}
+
+	return nil
+}
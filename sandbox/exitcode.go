@@ -0,0 +1,87 @@
+//go:build linux
+
+package sandbox
+
+// This file defines how sandbox failures and command failures are reported
+// (see [ErrSetupFailed] and [ExitError]).
+//
+// The exit status of the process started by exec.Cmd is bwrap's: bwrap
+// exits with 1 when it cannot set up the sandbox and otherwise propagates the
+// command's exit code, so a plain [Sandbox.Command] cannot tell "bwrap failed"
+// from "the command exited with 1". [Proc] can, because bwrap reports through
+// --json-status-fd whether it started the command and how it exited.
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// Exit codes the sandbox itself produces inside the sandbox. They follow the
+// shell's conventions, so scripts running in the sandbox see a blocked
+// command like one they are not permitted to execute.
+const (
+	// ExitDenied is the exit code of a command blocked by [Commands.Block],
+	// a preset or the [ModeRestricted] shims.
+	ExitDenied = 126
+
+	// ExitNotFound is the exit code of a command that was not found, as
+	// reported by the shell running it.
+	ExitNotFound = 127
+)
+
+// ErrSetupFailed reports that the sandbox could not be set up, so the command
+// never ran: bwrap or the escalation launcher failed to start, or bwrap
+// exited before starting the command (for example because a mount failed or
+// authentication was refused). Errors returned by [Proc.Start], [Proc.Run]
+// and [Proc.Wait] match it with errors.Is.
+var ErrSetupFailed = errors.New("sandbox setup failed")
+
+// ExitError reports that the sandboxed command ran and exited unsuccessfully.
+// [Proc.Wait] and [Proc.Run] return it instead of the *exec.ExitError of
+// bwrap, which it wraps.
+type ExitError struct {
+	// Code is the exit code of the sandboxed command as reported by bwrap.
+	// A command killed by a signal exits with 128 plus the signal number.
+	Code int
+
+	// Err is the exit error of the process started by exec.Cmd (bwrap or
+	// systemd-run). Its ProcessState holds bwrap's resource usage, which
+	// includes the sandboxed command.
+	Err *exec.ExitError
+}
+
+func (e *ExitError) Error() string {
+	switch e.Code {
+	case ExitDenied:
+		return fmt.Sprintf("sandbox: command exited with %d (blocked or not executable)", e.Code)
+	case ExitNotFound:
+		return fmt.Sprintf("sandbox: command exited with %d (not found)", e.Code)
+	default:
+		return fmt.Sprintf("sandbox: command exited with %d", e.Code)
+	}
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Denied reports whether the command exited with [ExitDenied].
+func (e *ExitError) Denied() bool {
+	return e.Code == ExitDenied
+}
+
+// NotFound reports whether the command exited with [ExitNotFound].
+func (e *ExitError) NotFound() bool {
+	return e.Code == ExitNotFound
+}
+
+// setupFailed wraps err, which ended the sandbox before the command ran, so
+// that it matches [ErrSetupFailed].
+func setupFailed(msg string, err error) error {
+	if err == nil {
+		return fmt.Errorf("sandbox: %w: %s", ErrSetupFailed, msg)
+	}
+
+	return fmt.Errorf("sandbox: %w: %s: %w", ErrSetupFailed, msg, err)
+}
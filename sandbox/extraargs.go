@@ -0,0 +1,255 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Config.ExtraBwrapArgs].
+//
+// Extra arguments are parsed with bwrap's option arities so each flag is
+// checked together with its operands. Flags that would undo a guarantee of
+// the planner (network isolation, a read-only host root, the user namespace
+// it sets up) or that need FDs the package does not pass are rejected.
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// bwrapOptionArity maps the bwrap options accepted in ExtraBwrapArgs to their
+// number of operands.
+var bwrapOptionArity = map[string]int{
+	"--unshare-user": 0, "--unshare-user-try": 0, "--unshare-ipc": 0,
+	"--unshare-pid": 0, "--unshare-net": 0, "--unshare-uts": 0,
+	"--unshare-cgroup": 0, "--unshare-cgroup-try": 0, "--unshare-all": 0,
+	"--share-net": 0, "--die-with-parent": 0, "--as-pid-1": 0,
+	"--new-session": 0, "--clearenv": 0, "--disable-userns": 0,
+	"--assert-userns-disabled": 0,
+
+	"--uid": 1, "--gid": 1, "--hostname": 1, "--chdir": 1, "--unsetenv": 1,
+	"--lock-file": 1, "--remount-ro": 1, "--dev": 1, "--proc": 1,
+	"--tmpfs": 1, "--mqueue": 1, "--dir": 1, "--cap-add": 1, "--cap-drop": 1,
+	"--perms": 1, "--size": 1, "--argv0": 1, "--exec-label": 1,
+	"--file-label": 1, "--userns": 1, "--userns2": 1, "--pidns": 1,
+	"--seccomp": 1, "--add-seccomp-fd": 1, "--info-fd": 1,
+	"--json-status-fd": 1, "--block-fd": 1, "--userns-block-fd": 1,
+	"--sync-fd": 1, "--args": 1, "--overlay-src": 1, "--tmp-overlay": 1,
+	"--ro-overlay": 1,
+
+	"--setenv": 2, "--bind": 2, "--bind-try": 2, "--ro-bind": 2,
+	"--ro-bind-try": 2, "--dev-bind": 2, "--dev-bind-try": 2,
+	"--symlink": 2, "--chmod": 2, "--file": 2, "--bind-data": 2,
+	"--ro-bind-data": 2, "--bind-fd": 2, "--ro-bind-fd": 2,
+
+	"--overlay": 3,
+}
+
+// blockedBwrapOptions maps options rejected in ExtraBwrapArgs to the reason.
+var blockedBwrapOptions = map[string]string{
+	"--userns":           "replaces the sandbox user namespace",
+	"--userns2":          "replaces the sandbox user namespace",
+	"--pidns":            "joins another PID namespace",
+	"--cap-add":          "grants capabilities",
+	"--args":             "reads arguments that bypass this check",
+	"--seccomp":          "needs an inherited FD",
+	"--add-seccomp-fd":   "needs an inherited FD",
+	"--info-fd":          "needs an inherited FD",
+	"--json-status-fd":   "needs an inherited FD (use Sandbox.Proc)",
+	"--block-fd":         "needs an inherited FD",
+	"--userns-block-fd":  "needs an inherited FD",
+	"--sync-fd":          "needs an inherited FD",
+	"--file":             "needs an inherited FD",
+	"--bind-data":        "needs an inherited FD",
+	"--ro-bind-data":     "needs an inherited FD",
+	"--bind-fd":          "needs an inherited FD",
+	"--ro-bind-fd":       "needs an inherited FD",
+	"--dev-bind":         "exposes host devices",
+	"--dev-bind-try":     "exposes host devices",
+	"--overlay-src":      "overlays are not supported",
+	"--overlay":          "overlays are not supported",
+	"--tmp-overlay":      "overlays are not supported",
+	"--ro-overlay":       "overlays are not supported",
+	"--unshare-user":     "conflicts with the planned user namespace",
+	"--unshare-user-try": "conflicts with the planned user namespace",
+	"--unshare-all":      "conflicts with the planned namespaces",
+}
+
+func validateExtraBwrapArgs(args []string, networkEnabled bool) []error {
+	var errs []error
+
+	for i := 0; i < len(args); i++ {
+		flag := args[i]
+
+		arity, ok := bwrapOptionArity[flag]
+		if !ok {
+			errs = append(errs, fmt.Errorf("extra bwrap arg %d %q is not a known bwrap option", i, flag))
+
+			continue
+		}
+
+		if i+arity >= len(args) {
+			errs = append(errs, fmt.Errorf("extra bwrap arg %d %q needs %d operand(s)", i, flag, arity))
+
+			break
+		}
+
+		operands := args[i+1 : i+1+arity]
+		i += arity
+
+		if reason, blocked := blockedBwrapOptions[flag]; blocked {
+			errs = append(errs, fmt.Errorf("extra bwrap arg %q is not allowed: %s", flag, reason))
+
+			continue
+		}
+
+		switch flag {
+		case "--share-net":
+			if !networkEnabled {
+				errs = append(errs, fmt.Errorf("extra bwrap arg %q is not allowed: network is disabled", flag))
+			}
+		case "--bind", "--bind-try":
+			if filepath.Clean(operands[0]) == "/" || filepath.Clean(operands[1]) == "/" {
+				errs = append(errs, fmt.Errorf("extra bwrap arg %q %q %q is not allowed: mounts the root read-write", flag, operands[0], operands[1]))
+			}
+		}
+	}
+
+	return errs
+}
+
+// extraMountOperands maps the ExtraBwrapArgs options that create or change
+// a mount to the operand naming the host source (-1 for none) and the one
+// naming the sandbox path they act on.
+var extraMountOperands = map[string]struct{ src, dst int }{
+	"--bind": {0, 1}, "--bind-try": {0, 1}, "--ro-bind": {0, 1}, "--ro-bind-try": {0, 1},
+	"--tmpfs": {-1, 0}, "--dir": {-1, 0}, "--dev": {-1, 0}, "--proc": {-1, 0},
+	"--mqueue": {-1, 0}, "--symlink": {-1, 1}, "--chmod": {-1, 1}, "--remount-ro": {-1, 0},
+}
+
+// checkExtraBwrapMounts checks the mount options in ExtraBwrapArgs against
+// the planned mounts. The arguments were validated by
+// [validateExtraBwrapArgs]. Extra arguments are applied after the planned
+// mounts, so a mount on a planned path replaces it.
+//
+// A bind source must not expose an excluded path, the masked docker socket
+// or the host temp directory (see [hostTempRoot]), and a read-write bind must not make a dangerous host path
+// writable (see [planner.checkDangerousMount]). The path an option acts on
+// must not cover an excluded path, a command wrapper, a docker socket mount
+// or a file the sandbox injects, nor lie inside an excluded directory or
+// the runtime directory. --chmod and --remount-ro must not change anything
+// inside those either. A read-write bind must also not cover a read-only
+// planned path.
+func (p *planner) checkExtraBwrapMounts() error {
+	args := p.cfg.ExtraBwrapArgs
+
+	for i := 0; i < len(args); i += 1 + bwrapOptionArity[args[i]] {
+		flag := args[i]
+
+		operands, ok := extraMountOperands[flag]
+		if !ok {
+			continue
+		}
+
+		opArgs := args[i+1 : i+1+bwrapOptionArity[flag]]
+		desc := fmt.Sprintf("extra bwrap arg %q %q", flag, opArgs)
+		writable := flag == "--bind" || flag == "--bind-try"
+
+		if operands.src >= 0 {
+			err := p.checkExtraMountSource(desc, opArgs[operands.src], writable)
+			if err != nil {
+				return err
+			}
+		}
+
+		dst := filepath.Join("/", opArgs[operands.dst])
+		inside := flag == "--chmod" || flag == "--remount-ro"
+
+		err := p.checkExtraMountDst(desc, dst, inside, writable)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkExtraMountSource checks the host source of an ExtraBwrapArgs bind.
+func (p *planner) checkExtraMountSource(desc, src string, writable bool) error {
+	if writable {
+		err := p.checkDangerousMount(desc, src)
+		if err != nil {
+			return err
+		}
+	}
+
+	src = filepath.Join("/", src)
+
+	// A missing source (such as a socket that is not created yet) is
+	// compared through its resolved parent.
+	resolved, err := evalSymlinksIn(p.paths.hostFS, src)
+	if err == nil {
+		src = resolved
+	} else if dir, dirErr := evalSymlinksIn(p.paths.hostFS, filepath.Dir(src)); dirErr == nil {
+		src = filepath.Join(dir, filepath.Base(src))
+	}
+
+	overlaps := func(path string) bool {
+		return isPathWithin(path, src) || isPathWithin(src, path)
+	}
+
+	for _, m := range p.plan.mounts {
+		if p.isExcludeMask(m) && overlaps(m.Dst) {
+			return fmt.Errorf("%s is not allowed: exposes excluded %q", desc, m.Dst)
+		}
+	}
+
+	if root, _ := hostTempRoot(); overlaps(root) {
+		return fmt.Errorf("%s is not allowed: exposes the host temp directory %q", desc, root)
+	}
+
+	if p.plan.dockerSocket != "" && p.plan.dockerSocketSrc == "" && overlaps(p.plan.dockerSocket) {
+		return fmt.Errorf("%s is not allowed: exposes the masked docker socket %q", desc, p.plan.dockerSocket)
+	}
+
+	return nil
+}
+
+// checkExtraMountDst checks the sandbox path an ExtraBwrapArgs mount option
+// acts on. inside also rejects paths inside guarded mounts; writable also
+// rejects covering read-only mounts.
+func (p *planner) checkExtraMountDst(desc, dst string, inside, writable bool) error {
+	if isReservedRuntimePath(dst) || isPathWithin("/run/agent-sandbox", dst) {
+		return fmt.Errorf("%s is not allowed: covers the sandbox runtime directory %q", desc, "/run/agent-sandbox")
+	}
+
+	guarded := func(path string) bool {
+		return isPathWithin(path, dst) || (inside && isPathWithin(dst, path))
+	}
+
+	for _, m := range p.plan.mounts {
+		switch {
+		case p.isExcludeMask(m) && guarded(m.Dst):
+			return fmt.Errorf("%s is not allowed: covers excluded %q", desc, m.Dst)
+		case p.guarded[m.Dst] != "" && guarded(m.Dst):
+			return fmt.Errorf("%s is not allowed: covers %s %q", desc, p.guarded[m.Dst], m.Dst)
+		case writable && isPathWithin(m.Dst, dst) && (m.Kind == MountRoBind || m.Kind == MountRoBindTry || m.Kind == MountRoBindData):
+			return fmt.Errorf("%s is not allowed: makes read-only %q writable", desc, m.Dst)
+		}
+	}
+
+	for _, m := range p.plan.wrapperMounts {
+		if guarded(m.dst) {
+			return fmt.Errorf("%s is not allowed: covers injected file %q", desc, m.dst)
+		}
+	}
+
+	covering, _, ok := p.coveringBindMount(dst)
+	if ok && covering.Kind == MountTmpfs && p.excludedDirs[covering.Dst] {
+		return fmt.Errorf("%s is not allowed: lies inside excluded %q", desc, covering.Dst)
+	}
+
+	return nil
+}
+
+// isExcludeMask reports whether m masks a path excluded by a policy rule.
+func (p *planner) isExcludeMask(m Mount) bool {
+	return (m.Kind == MountTmpfs && p.excludedDirs[m.Dst]) || (m.Kind == MountRoBindData && m.FD == emptyDataFD)
+}
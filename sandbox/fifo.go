@@ -0,0 +1,152 @@
+//go:build linux
+
+package sandbox
+
+// This file implements named pipes shared with one command (see [Fifo]).
+//
+// The FIFOs are created in a host temp directory per command, which is bound
+// read-only at [FifoDir]. Opening a FIFO for writing is not a filesystem
+// write, so the read-only bind does not get in the way, but the sandboxed
+// command cannot create other files there.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// FifoDir is the sandbox directory holding the named pipes of a command (see
+// [Fifo]).
+const FifoDir = "/run/agent-sandbox/fifo"
+
+// NamedPipe is a FIFO shared between the host and one sandboxed command,
+// created by [Fifo] and passed in [RunSpec.Fifos].
+//
+// [Sandbox.CommandSpec] creates the FIFO and sets Read and Write to the host
+// ends. Both ends are open, so opening the FIFO inside the sandbox never
+// blocks; close the end the host does not use, or reading never sees EOF
+// when the command closes its end. The ends are closed by the cleanup
+// function of the command.
+type NamedPipe struct {
+	// Dst is the sandbox path of the FIFO, below [FifoDir].
+	Dst string
+
+	// Read is the host end for reading what the command writes. It is nil
+	// until the command is constructed.
+	Read *os.File
+
+	// Write is the host end for writing what the command reads. It is nil
+	// until the command is constructed.
+	Write *os.File
+}
+
+// Fifo returns a named pipe at dst, a path below [FifoDir] such as
+// "/run/agent-sandbox/fifo/events", for streaming protocols between the host
+// and an agent without a network or a world-visible socket. A NamedPipe
+// belongs to one command.
+func Fifo(dst string) *NamedPipe {
+	return &NamedPipe{Dst: dst}
+}
+
+// validateFifos reports whether fifos can be created for one command.
+func validateFifos(fifos []*NamedPipe) error {
+	seen := make(map[string]bool, len(fifos))
+
+	for _, fifo := range fifos {
+		switch {
+		case fifo == nil:
+			return errors.New("fifo: nil named pipe")
+		case !strings.HasPrefix(fifo.Dst, FifoDir+"/") || filepath.Clean(fifo.Dst) != fifo.Dst:
+			return fmt.Errorf("fifo %q: must be a clean path below %s", fifo.Dst, FifoDir)
+		case fifo.Read != nil || fifo.Write != nil:
+			return fmt.Errorf("fifo %q: already used by another command", fifo.Dst)
+		case seen[fifo.Dst]:
+			return fmt.Errorf("fifo %q: duplicate", fifo.Dst)
+		}
+
+		seen[fifo.Dst] = true
+	}
+
+	for dst := range seen {
+		for dir := filepath.Dir(dst); dir != FifoDir; dir = filepath.Dir(dir) {
+			if seen[dir] {
+				return fmt.Errorf("fifo %q: below fifo %q", dst, dir)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fifoArgs creates fifos in a host temp directory, opens their host ends and
+// returns the bwrap args that bind the directory at [FifoDir]. The returned
+// cleanup closes the ends and removes the directory.
+func fifoArgs(fifos []*NamedPipe) ([]string, func() error, error) {
+	dir, removeDir, err := newHostTempDir("fifo-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("sandbox: fifo: %w", err)
+	}
+
+	files := make([]*os.File, 0, 2*len(fifos))
+
+	cleanup := func() error {
+		var errs []error
+
+		// The caller may already have closed the end it does not use.
+		for _, file := range files {
+			err := file.Close()
+			if err != nil && !errors.Is(err, os.ErrClosed) {
+				errs = append(errs, err)
+			}
+		}
+
+		return errors.Join(append(errs, removeDir())...)
+	}
+
+	for _, fifo := range fifos {
+		path := filepath.Join(dir, strings.TrimPrefix(fifo.Dst, FifoDir+"/"))
+
+		read, write, err := openFifo(path)
+		if err != nil {
+			return nil, nil, errors.Join(fmt.Errorf("sandbox: fifo %q: %w", fifo.Dst, err), cleanup())
+		}
+
+		files = append(files, read, write)
+	}
+
+	for i, fifo := range fifos {
+		fifo.Read, fifo.Write = files[2*i], files[2*i+1]
+	}
+
+	return []string{"--ro-bind", dir, FifoDir}, cleanup, nil
+}
+
+// openFifo creates a FIFO at path and opens both ends. The read end is
+// opened first and non-blocking, so opening the write end does not wait for
+// a reader.
+func openFifo(path string) (*os.File, *os.File, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = syscall.Mkfifo(path, 0o600)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "mkfifo", Path: path, Err: err}
+	}
+
+	read, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	write, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, nil, errors.Join(err, read.Close())
+	}
+
+	return read, write, nil
+}
@@ -0,0 +1,313 @@
+//go:build linux
+
+package sandbox
+
+// This file implements file access through the sandbox policy
+// ([Sandbox.ReadFile] and [Sandbox.WriteFile]).
+//
+// Paths are resolved against the planned mounts instead of a running sandbox:
+// each path is walked component by component in the sandbox view, symlinks and
+// ".." are followed the way the sandboxed process would follow them, and the
+// final path is translated to the bind mount that covers it. The file is then
+// opened beneath the mount's host source without following symlinks, so a
+// component swapped for a symlink after resolution fails the open instead of
+// leading outside the policy.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxSymlinkHops matches the kernel's limit on symlinks followed in one
+// lookup.
+const maxSymlinkHops = 40
+
+// ReadFile reads the file at path as a sandboxed command would see it. path
+// is a sandbox path; relative paths are relative to [Environment.WorkDir].
+//
+// Only files backed by a host bind mount can be read. Excluded paths,
+// tmpfs and directories created by the sandbox, injected files (wrappers,
+// sandbox info) and /dev and /proc are refused with an error wrapping
+// [fs.ErrPermission]. No process is started.
+//
+// The policy is that of the planned mounts in every [Mode].
+func (s *Sandbox) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	mnt, rel, err := s.resolveMount(path)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: read %s: %w", path, err)
+	}
+
+	f, err := openBeneath(mnt.Src, rel, unix.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: read %s: %w", path, err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: read %s: %w", path, err)
+	}
+
+	return data, nil
+}
+
+// WriteFile writes data to the file at path as a sandboxed command would,
+// creating it with perm (before umask) if it does not exist. path is
+// resolved like in [Sandbox.ReadFile], and the file must be covered by a
+// read-write bind mount.
+func (s *Sandbox) WriteFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	mnt, rel, err := s.resolveMount(path)
+	if err != nil {
+		return fmt.Errorf("sandbox: write %s: %w", path, err)
+	}
+
+	if mnt.Kind != MountBind && mnt.Kind != MountBindTry {
+		return fmt.Errorf("sandbox: write %s: %w: read-only mount %s", path, fs.ErrPermission, mnt.Dst)
+	}
+
+	f, err := openBeneath(mnt.Src, rel, unix.O_WRONLY|unix.O_CREAT|unix.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("sandbox: write %s: %w", path, err)
+	}
+
+	_, err = f.Write(data)
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("sandbox: write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// resolveHostPath resolves the sandbox path to the host path behind it,
+// following symlinks in the sandbox view, and returns the bind mount that
+// covers it. The final component may not exist.
+func (s *Sandbox) resolveHostPath(path string) (string, Mount, error) {
+	mnt, rel, err := s.resolveMount(path)
+	if err != nil {
+		return "", Mount{}, err
+	}
+
+	return filepath.Join(mnt.Src, rel), mnt, nil
+}
+
+// resolveMount resolves the sandbox path like [Sandbox.resolveHostPath] and
+// returns the bind mount that covers it and the path relative to the mount.
+func (s *Sandbox) resolveMount(path string) (Mount, string, error) {
+	if s == nil || s.v == nil || s.plan == nil {
+		return Mount{}, "", errors.New("uninitialized sandbox (use New or NewWithEnvironment)")
+	}
+
+	resolved, err := s.resolveSandboxPath(path)
+	if err != nil {
+		return Mount{}, "", err
+	}
+
+	return s.coveringHostMount(resolved)
+}
+
+// resolveSandboxPath returns the symlink-free sandbox path that path names.
+// Components are resolved one at a time, so ".." applies to the directory a
+// symlink before it resolved to, as in the kernel, not to the symlink itself.
+func (s *Sandbox) resolveSandboxPath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = s.v.env.WorkDir + "/" + path
+	}
+
+	resolved := "/"
+	pending := strings.Split(path, "/")
+	hops := 0
+
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+
+			continue
+		}
+
+		current := filepath.Join(resolved, part)
+
+		hostPath, _, err := s.hostPath(current)
+		if err != nil {
+			// Directories created by the sandbox on the way to a bind
+			// mount are not symlinks.
+			resolved = current
+
+			continue
+		}
+
+		info, err := os.Lstat(hostPath)
+		if errors.Is(err, fs.ErrNotExist) && isLastComponent(pending) {
+			return current, nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = current
+
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", syscall.ELOOP
+		}
+
+		target, err := os.Readlink(hostPath)
+		if err != nil {
+			return "", err
+		}
+
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+
+	return resolved, nil
+}
+
+// isLastComponent reports whether the path components in pending name no
+// further file.
+func isLastComponent(pending []string) bool {
+	for _, part := range pending {
+		if part != "" && part != "." {
+			return false
+		}
+	}
+
+	return true
+}
+
+// openBeneath opens rel below the host directory src with flags, refusing
+// symlinks in every component of rel and paths that leave src. rel "."
+// opens src itself, which must not be a symlink. The file is created with
+// perm if flags include O_CREAT.
+func openBeneath(src, rel string, flags int, perm os.FileMode) (*os.File, error) {
+	if rel == "." {
+		return os.OpenFile(src, flags|unix.O_NOFOLLOW, perm)
+	}
+
+	dirFD, err := unix.Open(src, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: src, Err: err}
+	}
+
+	defer func() { _ = unix.Close(dirFD) }()
+
+	hostPath := filepath.Join(src, rel)
+
+	fd, err := unix.Openat2(dirFD, rel, &unix.OpenHow{
+		Flags:   uint64(flags) | unix.O_CLOEXEC,
+		Mode:    uint64(perm.Perm()),
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "openat2", Path: hostPath, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), hostPath), nil
+}
+
+// hostPath translates a symlink-free sandbox path to its host path using the
+// last planned mount that covers it. Only bind mounts are host-backed.
+func (s *Sandbox) hostPath(path string) (string, Mount, error) {
+	mnt, rel, err := s.coveringHostMount(path)
+	if err != nil {
+		return "", Mount{}, err
+	}
+
+	return filepath.Join(mnt.Src, rel), mnt, nil
+}
+
+// coveringHostMount returns the bind mount that decides access to the
+// symlink-free sandbox path, and the path relative to it.
+func (s *Sandbox) coveringHostMount(path string) (Mount, string, error) {
+	for _, mnt := range s.plan.wrapperMounts {
+		if mnt.dst == path {
+			return Mount{}, "", fmt.Errorf("%w: %s is injected by the sandbox", fs.ErrPermission, path)
+		}
+	}
+
+	var (
+		covering Mount
+		rel      string
+		found    bool
+	)
+
+	for i := len(s.plan.mounts) - 1; i >= 0 && !found; i-- {
+		mnt := s.plan.mounts[i]
+
+		// --dir only creates a missing directory; it does not hide what
+		// an earlier mount put there.
+		if mnt.Kind == MountDir {
+			continue
+		}
+
+		r, err := filepath.Rel(mnt.Dst, path)
+		if err == nil && r != ".." && !strings.HasPrefix(r, "../") {
+			covering, rel, found = mnt, r, true
+		}
+	}
+
+	if !found {
+		return Mount{}, "", fmt.Errorf("%w: %s is not mounted", fs.ErrPermission, path)
+	}
+
+	// /dev and /proc are emitted as bwrap --dev/--proc, not as planned
+	// mounts; the covering host root bind does not describe them.
+	for _, virtual := range []string{"/dev", "/proc"} {
+		if isPathWithin(path, virtual) && !isPathWithin(covering.Dst, virtual) {
+			return Mount{}, "", fmt.Errorf("%w: %s is not a host file", fs.ErrPermission, path)
+		}
+	}
+
+	switch covering.Kind {
+	case MountRoBind, MountRoBindTry, MountBind, MountBindTry:
+		return covering, rel, nil
+	case MountRoBindData:
+		return Mount{}, "", fmt.Errorf("%w: %s is excluded", fs.ErrPermission, path)
+	default:
+		return Mount{}, "", fmt.Errorf("%w: %s is on a sandbox %s, not a host file", fs.ErrPermission, path, mountKindName(covering.Kind))
+	}
+}
+
+// isPathWithin reports whether path is dir or below it.
+func isPathWithin(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
@@ -0,0 +1,314 @@
+//go:build linux
+
+package sandbox
+
+// This file implements file creation mode policies: [Config.Umask],
+// [Config.DefaultACL] and [Config.SanitizeWrites].
+//
+// bwrap has no umask option and exec.Cmd cannot set one without changing the
+// umask of the whole process, so the launcher, bound at [UmaskShimPath], sets
+// it inside the sandbox and execs the command (see [UmaskShim]); no shell is
+// needed in the sandbox. Default ACLs are set on the host only when a run asks
+// for them ([Sandbox.ApplyDefaultACL]) and stay in place after the sandbox
+// exits. The permission sweep runs on the host after the command, since
+// nothing inside the sandbox outlives it.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultACL selects a default POSIX ACL that is set on writable directories
+// under [Environment.WorkDir], so files created there get predictable
+// permissions no matter which umask the creating process uses.
+type DefaultACL string
+
+const (
+	// DefaultACLNone leaves ACLs unchanged (the default).
+	DefaultACLNone DefaultACL = ""
+
+	// DefaultACLGroupRead makes new files readable by their group
+	// (user::rwx, group::r-x, other::---).
+	DefaultACLGroupRead DefaultACL = "group-read"
+
+	// DefaultACLPrivate makes new files accessible to their owner only
+	// (user::rwx, group::---, other::---), i.e. 0600 for regular files.
+	DefaultACLPrivate DefaultACL = "private"
+)
+
+const (
+	// UmaskShimName is the name the launcher is invoked by to apply
+	// [Config.Umask] before exec'ing the command (see [UmaskShim]).
+	UmaskShimName = "agent-sandbox-umask"
+
+	// UmaskShimPath is the sandbox path the launcher is bound at as
+	// [UmaskShimName].
+	UmaskShimPath = "/run/agent-sandbox/" + UmaskShimName
+)
+
+// umaskArgv returns argv started through the umask shim at shim.
+func umaskArgv(shim string, umask int, argv []string) []string {
+	return append([]string{shim, fmt.Sprintf("%04o", umask)}, argv...)
+}
+
+// hostUmaskSpec starts spec, which runs on the host in [ModeAudit] and
+// [ModeRestricted], through the launcher as the umask shim.
+func (s *Sandbox) hostUmaskSpec(spec *ExecSpec, cleanup func() error, err error) (*ExecSpec, func() error, error) {
+	if err != nil || s.v.cfg.Umask == nil {
+		return spec, cleanup, err
+	}
+
+	err = s.launcher.verify()
+	if err != nil {
+		return nil, func() error { return nil }, errors.Join(fmt.Errorf("sandbox: %w", err), cleanup())
+	}
+
+	spec.Args = umaskArgv(UmaskShimName, *s.v.cfg.Umask, append([]string{spec.Path}, spec.Args[1:]...))
+	spec.Path = s.v.cfg.Commands.Launcher
+
+	return spec, cleanup, nil
+}
+
+// UmaskShim sets the umask and execs a command. Launchers call it when invoked
+// as [UmaskShimName] (at [UmaskShimPath]), passing their arguments as argv:
+// the octal umask followed by the command and its arguments.
+//
+// It returns only on failure: [ExitNotFound] if the command cannot be found,
+// 1 for a malformed argv and [ExitDenied] for other failures.
+func UmaskShim(argv []string) (int, error) {
+	if len(argv) < 2 {
+		return 1, errors.New("umask shim: expected a umask and a command")
+	}
+
+	umask, err := strconv.ParseUint(argv[0], 8, 32)
+	if err != nil || umask > 0o777 {
+		return 1, fmt.Errorf("umask shim: invalid umask %q", argv[0])
+	}
+
+	path, err := exec.LookPath(argv[1])
+	if err != nil {
+		return ExitNotFound, fmt.Errorf("umask shim: %w", err)
+	}
+
+	unix.Umask(int(umask))
+
+	err = unix.Exec(path, argv[1:], os.Environ())
+
+	return ExitDenied, fmt.Errorf("umask shim: exec %s: %w", path, err)
+}
+
+func validateUmask(umask *int, launcher string) []error {
+	if umask == nil {
+		return nil
+	}
+
+	if *umask < 0 || *umask > 0o777 {
+		return []error{fmt.Errorf("invalid Umask %#o (expected 0 to 0777)", *umask)}
+	}
+
+	if launcher == "" {
+		return []error{errors.New("umask: Umask requires Commands.Launcher, which applies it in the sandbox")}
+	}
+
+	return nil
+}
+
+func validateDefaultACL(acl DefaultACL) []error {
+	switch acl {
+	case DefaultACLNone, DefaultACLGroupRead, DefaultACLPrivate:
+		return nil
+	default:
+		return []error{fmt.Errorf("unknown DefaultACL %q (expected %q or %q)", acl, DefaultACLGroupRead, DefaultACLPrivate)}
+	}
+}
+
+// POSIX ACL xattr encoding (see linux/posix_acl_xattr.h).
+const (
+	aclXattrVersion = 2
+	aclUserObj      = 0x01
+	aclGroupObj     = 0x04
+	aclOther        = 0x20
+	aclUndefinedID  = 0xffffffff
+	aclDefaultXattr = "system.posix_acl_default"
+)
+
+// xattr returns the system.posix_acl_default value for acl.
+func (acl DefaultACL) xattr() []byte {
+	groupPerms := uint16(0)
+	if acl == DefaultACLGroupRead {
+		groupPerms = 0o5
+	}
+
+	entries := [][2]uint16{
+		{aclUserObj, 0o7},
+		{aclGroupObj, groupPerms},
+		{aclOther, 0},
+	}
+
+	buf := binary.LittleEndian.AppendUint32(nil, aclXattrVersion)
+	for _, e := range entries {
+		buf = binary.LittleEndian.AppendUint16(buf, e[0])
+		buf = binary.LittleEndian.AppendUint16(buf, e[1])
+		buf = binary.LittleEndian.AppendUint32(buf, aclUndefinedID)
+	}
+
+	return buf
+}
+
+// ApplyDefaultACL sets [Config.DefaultACL] on every directory of the planned
+// read-write bind mounts at or below [Environment.WorkDir]. It changes the
+// host and is not undone when the sandbox exits, so construction never calls
+// it: [RunSpec.DefaultACL] applies it before a command, or callers call it
+// themselves. It errors if Config.DefaultACL is not set.
+func (s *Sandbox) ApplyDefaultACL() error {
+	if s == nil || s.plan == nil {
+		return errors.New("sandbox: uninitialized sandbox (use New or NewWithEnvironment)")
+	}
+
+	if s.v.cfg.DefaultACL == DefaultACLNone {
+		return errors.New("sandbox: DefaultACL is not set")
+	}
+
+	err := applyDefaultACL(s.v.cfg.DefaultACL, s.plan.mounts, s.v.env.WorkDir, s.v.cfg.Debugf)
+	if err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+
+	return nil
+}
+
+// applyDefaultACL sets acl on every directory inside the planned read-write
+// bind mounts that are at or below workDir. Existing files keep their modes.
+func applyDefaultACL(acl DefaultACL, mounts []Mount, workDir string, debugf Debugf) error {
+	if acl == DefaultACLNone {
+		return nil
+	}
+
+	value := acl.xattr()
+
+	for _, mnt := range mounts {
+		if mnt.Kind != MountBind && mnt.Kind != MountBindTry {
+			continue
+		}
+
+		rel, err := filepath.Rel(workDir, mnt.Src)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		if debugf != nil {
+			debugf("default ACL %q on %q", acl, mnt.Src)
+		}
+
+		err = filepath.WalkDir(mnt.Src, func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			if err != nil || !d.IsDir() {
+				return err
+			}
+
+			err = unix.Setxattr(path, aclDefaultXattr, value, 0)
+			if err != nil {
+				return fmt.Errorf("setting default ACL on %q: %w", path, err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SanitizeWrites removes modes from the read-write bind mounts that let files
+// created in the sandbox escalate or persist once the host uses them:
+// setuid and setgid bits on files, setuid bits on directories, write
+// permission for others, and device nodes, which are deleted. Symlinks are
+// not followed. Entries the caller cannot change (for example files owned by
+// subordinate ids) are skipped and reported via Debugf.
+//
+// With [Config.SanitizeWrites] set, the cleanup function of [Sandbox.Command]
+// calls it. Callers of [Sandbox.ExecSpec] call it after the child exits.
+func (s *Sandbox) SanitizeWrites() error {
+	if s == nil || s.plan == nil {
+		return errors.New("sandbox: uninitialized sandbox (use New or NewWithEnvironment)")
+	}
+
+	for _, mnt := range s.plan.mounts {
+		if mnt.Kind != MountBind && mnt.Kind != MountBindTry {
+			continue
+		}
+
+		err := sanitizeTree(mnt.Src, s.v.cfg.Debugf)
+		if err != nil {
+			return fmt.Errorf("sandbox: sanitizing %s: %w", mnt.Src, err)
+		}
+	}
+
+	return nil
+}
+
+// sanitizeTree applies the [Sandbox.SanitizeWrites] rules below root.
+func sanitizeTree(root string, debugf Debugf) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return skipUnreadable(err)
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return skipUnreadable(err)
+		}
+
+		mode := info.Mode()
+
+		if mode&fs.ModeDevice != 0 {
+			if debugf != nil {
+				debugf("sanitize: removing device %q", path)
+			}
+
+			return skipUnreadable(os.Remove(path))
+		}
+
+		strip := fs.FileMode(0o002) | fs.ModeSetuid
+		if !mode.IsDir() {
+			strip |= fs.ModeSetgid
+		}
+
+		if mode&strip == 0 {
+			return nil
+		}
+
+		if debugf != nil {
+			debugf("sanitize: %q mode %v -> %v", path, mode, mode&^strip)
+		}
+
+		err = os.Chmod(path, (mode&^strip)&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+		if errors.Is(err, fs.ErrPermission) {
+			if debugf != nil {
+				debugf("sanitize: cannot change %q: %v", path, err)
+			}
+
+			return nil
+		}
+
+		return skipUnreadable(err)
+	})
+}
@@ -0,0 +1,263 @@
+//go:build linux
+
+package sandbox
+
+// This file contains fuzzing entry points for path-policy resolution (see
+// resolve.go). They take plain strings and return an error for a violated
+// invariant, so the `go test -fuzz` targets in this package and fuzzers in
+// other modules drive the same checks:
+//
+//	func FuzzPolicy(f *testing.F) {
+//		f.Fuzz(func(t *testing.T, fsSpec, rules, presets string) {
+//			if err := sandbox.FuzzPolicy(fsSpec, rules, presets); err != nil {
+//				t.Fatal(err)
+//			}
+//		})
+//	}
+//
+// Inputs that do not parse, or that resolution rejects with an error, pass:
+// rejecting a malformed pattern or a symlink loop is correct behavior. A
+// returned error or a panic is a bug.
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The host directories the fuzzing entry points resolve against.
+const (
+	FuzzHomeDir = "/home/dev"
+	FuzzWorkDir = "/home/dev/project"
+)
+
+// policyKinds are the [MountKind]s accepted in [FuzzPolicy] rules.
+var policyKinds = []MountKind{
+	MountReadOnly, MountReadOnlyTry, MountReadWrite, MountReadWriteTry,
+	MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir, MountExcludeAuto,
+}
+
+// FuzzPolicy resolves rules on the host described by fsSpec (see
+// [ParseMemHostFS]) with [ResolvePolicy], in [FuzzHomeDir] and
+// [FuzzWorkDir], and reports how the result is wrong:
+//
+//   - resolving twice gives different mounts
+//   - [CheckPolicy] fails
+//   - a bind mount is writable although no rule is read-write
+//   - an Exclude rule on an exact path is not the mount that decides
+//     access to the path it resolves to, although no later exact rule
+//     resolves there
+//
+// rules has one policy mount per line, "KIND PATTERN", where KIND is the
+// name of a policy [MountKind] ("read-only", "exclude-file", ...; see
+// [MountKind.String]). presets is a comma-separated [Filesystem.Presets]
+// list; an empty string selects no presets.
+func FuzzPolicy(fsSpec, rules, presets string) error {
+	fsys, err := ParseMemHostFS(fsSpec)
+	if err != nil {
+		return nil
+	}
+
+	mounts, ok := parseFuzzRules(rules)
+	if !ok {
+		return nil
+	}
+
+	presetList := []string{}
+	if presets != "" {
+		presetList = strings.Split(presets, ",")
+	}
+
+	got, err := ResolvePolicy(fsys, FuzzHomeDir, FuzzWorkDir, presetList, mounts)
+
+	again, againErr := ResolvePolicy(fsys, FuzzHomeDir, FuzzWorkDir, presetList, mounts)
+	if fmt.Sprint(err) != fmt.Sprint(againErr) || !slices.Equal(got, again) {
+		return fmt.Errorf("sandbox: resolution is not deterministic:\n%v (%v)\n%v (%v)", got, err, again, againErr)
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	err = CheckPolicy(got)
+	if err != nil {
+		return err
+	}
+
+	writable := len(presetList) > 0 || slices.ContainsFunc(mounts, func(m Mount) bool {
+		return m.Kind == MountReadWrite || m.Kind == MountReadWriteTry
+	})
+
+	for _, m := range got {
+		if !writable && (m.Kind == MountBind || m.Kind == MountBindTry) {
+			return fmt.Errorf("sandbox: %s mount of %q without a read-write rule", mountKindName(m.Kind), m.Dst)
+		}
+	}
+
+	paths := newPathResolver(Environment{HomeDir: FuzzHomeDir, WorkDir: FuzzWorkDir, FS: fsys})
+
+	targets := make([]string, len(mounts))
+	for i, m := range mounts {
+		targets[i] = fuzzExactTarget(paths, m)
+	}
+
+	for i, m := range mounts {
+		if m.Kind != MountExclude || targets[i] == "" || slices.Contains(targets[i+1:], targets[i]) {
+			continue
+		}
+
+		err = checkMasked(got, targets[i])
+		if err != nil {
+			return fmt.Errorf("sandbox: rule %d (exclude %q): %w", i, m.Dst, err)
+		}
+	}
+
+	return nil
+}
+
+// FuzzCommand resolves the command name on pathVar, a PATH value, on the
+// host described by fsSpec (see [ParseMemHostFS]) with [ResolveCommand], in
+// [FuzzWorkDir], and reports how the result is wrong:
+//
+//   - resolving twice gives different targets
+//   - a target is not a clean, symlink-free path to an executable file, or
+//     is listed twice
+//   - an executable that a shell would run for name through some PATH
+//     entry is not among the targets, so blocking name would not cover it
+func FuzzCommand(fsSpec, name, pathVar string) error {
+	fsys, err := ParseMemHostFS(fsSpec)
+	if err != nil {
+		return nil
+	}
+
+	got, err := ResolveCommand(fsys, name, pathVar, FuzzWorkDir)
+
+	again, againErr := ResolveCommand(fsys, name, pathVar, FuzzWorkDir)
+	if fmt.Sprint(err) != fmt.Sprint(againErr) || !slices.Equal(got, again) {
+		return fmt.Errorf("sandbox: command resolution is not deterministic: %q (%v), %q (%v)", got, err, again, againErr)
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	for i, target := range got {
+		if !filepath.IsAbs(target) || filepath.Clean(target) != target || slices.Contains(got[:i], target) {
+			return fmt.Errorf("sandbox: target %q is not a clean absolute path or is listed twice in %q", target, got)
+		}
+
+		resolved, err := evalSymlinksIn(fsys, target)
+		if err != nil || resolved != target {
+			return fmt.Errorf("sandbox: target %q resolves to %q (%v)", target, resolved, err)
+		}
+
+		if !fuzzExecutable(fsys, target) {
+			return fmt.Errorf("sandbox: target %q is not an executable file", target)
+		}
+	}
+
+	// A shell searches each PATH entry literally; empty and relative
+	// entries are relative to the directory the command starts in.
+	for entry := range strings.SplitSeq(pathVar, ":") {
+		dir := entry
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(FuzzWorkDir, dir)
+		}
+
+		candidate := filepath.Join(dir, name)
+		if !fuzzExecutable(fsys, candidate) {
+			continue
+		}
+
+		resolved, err := evalSymlinksIn(fsys, candidate)
+		if err == nil && !slices.Contains(got, resolved) {
+			return fmt.Errorf("sandbox: PATH entry %q runs %q, which is not among the targets %q", entry, resolved, got)
+		}
+	}
+
+	return nil
+}
+
+// parseFuzzRules parses the rules of [FuzzPolicy].
+func parseFuzzRules(rules string) ([]Mount, bool) {
+	var mounts []Mount
+
+	for line := range strings.Lines(rules) {
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			continue
+		}
+
+		name, pattern, _ := strings.Cut(line, " ")
+
+		i := slices.IndexFunc(policyKinds, func(k MountKind) bool { return mountKindName(k) == name })
+		if i < 0 {
+			return nil, false
+		}
+
+		mounts = append(mounts, Mount{Kind: policyKinds[i], Dst: pattern})
+	}
+
+	return mounts, true
+}
+
+// fuzzExactTarget returns the host path an exact (non-glob) policy mount
+// resolves to, mirroring resolveAndDedupRules, or "" for globs and mounts
+// that are skipped or fail.
+func fuzzExactTarget(paths pathResolver, m Mount) string {
+	path := paths.Resolve(strings.TrimSpace(m.Dst))
+	if path == "" || hasGlobMeta(path) {
+		return ""
+	}
+
+	switch m.Kind {
+	case MountExcludeFile, MountExcludeDir:
+		return path
+	case MountExcludeAuto:
+		_, err := paths.hostFS.Stat(path)
+		if err != nil {
+			return ""
+		}
+
+		return path
+	}
+
+	resolved, err := evalSymlinksIn(paths.hostFS, path)
+	if err != nil {
+		return ""
+	}
+
+	_, err = paths.hostFS.Stat(resolved)
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Clean(resolved)
+}
+
+// checkMasked returns an error unless the last mount covering path masks
+// exactly path.
+func checkMasked(mounts []Mount, path string) error {
+	for _, m := range slices.Backward(mounts) {
+		if m.Kind == MountDir || (m.Dst != "/" && !isPathWithin(path, m.Dst)) {
+			continue
+		}
+
+		if m.Dst != path || (m.Kind != MountTmpfs && m.Kind != MountRoBindData) {
+			return fmt.Errorf("%q is decided by %s mount %q, not a mask", path, mountKindName(m.Kind), m.Dst)
+		}
+
+		return nil
+	}
+
+	return errors.New(path + " is not masked")
+}
+
+// fuzzExecutable reports whether path is a file a shell would execute.
+func fuzzExecutable(fsys HostFS, path string) bool {
+	info, err := fsys.Stat(path)
+
+	return err == nil && !info.IsDir() && info.Mode()&0o111 != 0
+}
@@ -7,13 +7,24 @@ package sandbox
 // The @git and @git-strict presets protect repository metadata from modification.
 // The strict variant additionally makes non-current branch refs read-only while
 // allowing updates to the current branch (so typical git workflows still work).
+//
+// Both presets also mask configured credential helpers (and the stores they
+// read), so `git credential fill` cannot be used to read tokens.
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
+// gitExecPathDirs are the usual locations of git's exec-path, where helpers
+// like git-credential-libsecret are installed.
+var gitExecPathDirs = []string{"/usr/lib/git-core", "/usr/libexec/git-core"}
+
 // gitPresetRules returns policy mounts that protect git metadata.
 //
 // When strict is false, it protects hooks and config.
@@ -254,3 +265,200 @@ func collectBranchRefs(headsDir string) ([]string, error) {
 
 	return refs, nil
 }
+
+// gitCredentialHelperRules returns policy mounts that mask the credential
+// helpers configured for workDir's repository, and the credential stores used
+// by the built-in "store" and "cache" helpers.
+//
+// Config is read from the system, global (XDG and ~/.gitconfig) and repository
+// config files. Helpers given as shell snippets ("!...") cannot be resolved and
+// are left alone. All mounts are best-effort (missing paths are ignored).
+func gitCredentialHelperRules(env Environment) ([]Mount, error) {
+	configPaths := []string{"/etc/gitconfig"}
+
+	xdgConfigHome := env.HostEnv["XDG_CONFIG_HOME"]
+	if xdgConfigHome == "" {
+		xdgConfigHome = filepath.Join(env.HomeDir, ".config")
+	}
+
+	configPaths = append(configPaths,
+		filepath.Join(xdgConfigHome, "git", "config"),
+		filepath.Join(env.HomeDir, ".gitconfig"),
+	)
+
+	gitDir, mainRepo, err := discoverGitDirs(env.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if mainRepo != "" {
+		configPaths = append(configPaths, filepath.Join(mainRepo, ".git", "config"))
+	}
+
+	if gitDir != "" {
+		configPaths = append(configPaths, filepath.Join(gitDir, "config"))
+	}
+
+	var helpers []string
+
+	for _, path := range configPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return nil, fmt.Errorf("read git config %q: %w", path, err)
+		}
+
+		helpers = gitCredentialHelpers(data, helpers)
+	}
+
+	if len(helpers) == 0 {
+		return nil, nil
+	}
+
+	pathDirs, _ := parsePathDirs(env.HostEnv["PATH"], env.WorkDir)
+
+	var mounts []Mount
+
+	for _, helper := range helpers {
+		if strings.HasPrefix(helper, "!") {
+			continue
+		}
+
+		fields := strings.Fields(helper)
+		name, args := fields[0], fields[1:]
+
+		if filepath.IsAbs(name) {
+			mounts = append(mounts, ExcludeTry(name))
+
+			continue
+		}
+
+		binary := "git-credential-" + name
+		for _, dir := range slices.Concat(pathDirs, gitExecPathDirs) {
+			mounts = append(mounts, ExcludeTry(filepath.Join(dir, binary)))
+		}
+
+		switch name {
+		case "store":
+			file := gitHelperOption(args, "--file")
+			if file == "" {
+				mounts = append(mounts,
+					ExcludeTry("~/.git-credentials"),
+					ExcludeTry(filepath.Join(xdgConfigHome, "git", "credentials")),
+				)
+			} else {
+				mounts = append(mounts, ExcludeTry(file))
+			}
+		case "cache":
+			socket := gitHelperOption(args, "--socket")
+			if socket == "" {
+				mounts = append(mounts,
+					ExcludeTry("~/.git-credential-cache"),
+					ExcludeTry("~/.cache/git/credential"),
+				)
+			} else {
+				mounts = append(mounts, ExcludeTry(socket))
+			}
+		}
+	}
+
+	return mounts, nil
+}
+
+// gitCredentialHelpers appends the credential.helper values found in a git
+// config file to helpers. Matches both [credential] and [credential "<url>"]
+// sections. An empty value resets the list, as it does in git.
+func gitCredentialHelpers(data []byte, helpers []string) []string {
+	inCredential := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				inCredential = false
+
+				continue
+			}
+
+			section := strings.TrimSpace(line[1:end])
+			name, _, _ := strings.Cut(section, " ")
+			name, _, _ = strings.Cut(name, ".")
+			inCredential = strings.EqualFold(name, "credential")
+			line = strings.TrimSpace(line[end+1:])
+
+			if line == "" {
+				continue
+			}
+		}
+
+		if !inCredential {
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, "=")
+		if !strings.EqualFold(strings.TrimSpace(key), "helper") {
+			continue
+		}
+
+		value = gitConfigValue(value)
+		if value == "" {
+			helpers = helpers[:0]
+
+			continue
+		}
+
+		helpers = append(helpers, value)
+	}
+
+	return helpers
+}
+
+// gitConfigValue strips comments and quotes from a raw git config value.
+func gitConfigValue(raw string) string {
+	var b strings.Builder
+
+	inQuote := false
+
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+
+		switch {
+		case c == '\\' && i+1 < len(raw):
+			i++
+			b.WriteByte(raw[i])
+		case c == '"':
+			inQuote = !inQuote
+		case (c == '#' || c == ';') && !inQuote:
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+// gitHelperOption returns the value of a "--flag value" or "--flag=value"
+// helper argument.
+func gitHelperOption(args []string, flag string) string {
+	for i, arg := range args {
+		if value, ok := strings.CutPrefix(arg, flag+"="); ok {
+			return value
+		}
+
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the GitHub Actions integration ([FromGitHubActions]).
+
+import (
+	"errors"
+)
+
+// gitHubFileCommandVars name the files through which a step sets
+// environment, PATH, outputs, state and the job summary of later steps.
+var gitHubFileCommandVars = []string{"GITHUB_ENV", "GITHUB_PATH", "GITHUB_OUTPUT", "GITHUB_STATE", "GITHUB_STEP_SUMMARY"}
+
+// FromGitHubActions adjusts cfg and env for a step of a GitHub Actions job.
+// It returns an error unless env.HostEnv has GITHUB_ACTIONS=true.
+//
+//   - An empty env.WorkDir is set to GITHUB_WORKSPACE, which is writable.
+//   - RUNNER_TEMP and RUNNER_TOOL_CACHE (setup-* tool and dependency caches)
+//     are writable.
+//   - The runner's file-command files (GITHUB_ENV, GITHUB_PATH, GITHUB_OUTPUT,
+//     GITHUB_STATE, GITHUB_STEP_SUMMARY) are masked, so sandboxed commands
+//     cannot change the environment or outputs of later steps.
+//   - A nil cfg.Network is set to disabled unless cfg.NetworkAllow is set,
+//     so network access must be enabled explicitly, either fully or for
+//     allowed hosts such as GitHub's.
+//
+// Mounts are appended to cfg.Filesystem.Mounts; the file-command masks come
+// last so they win over broader rules.
+func FromGitHubActions(cfg *Config, env *Environment) error {
+	if cfg == nil || env == nil {
+		return errors.New("sandbox: FromGitHubActions: nil config or environment")
+	}
+
+	if env.HostEnv["GITHUB_ACTIONS"] != "true" {
+		return errors.New(`sandbox: not running in GitHub Actions (GITHUB_ACTIONS is not "true")`)
+	}
+
+	workspace := env.HostEnv["GITHUB_WORKSPACE"]
+	if env.WorkDir == "" {
+		env.WorkDir = workspace
+	}
+
+	if workspace != "" {
+		cfg.Filesystem.Mounts = append(cfg.Filesystem.Mounts, RWTry(workspace))
+	}
+
+	for _, name := range []string{"RUNNER_TEMP", "RUNNER_TOOL_CACHE"} {
+		if dir := env.HostEnv[name]; dir != "" {
+			cfg.Filesystem.Mounts = append(cfg.Filesystem.Mounts, RWTry(dir))
+		}
+	}
+
+	for _, name := range gitHubFileCommandVars {
+		if path := env.HostEnv[name]; path != "" {
+			cfg.Filesystem.Mounts = append(cfg.Filesystem.Mounts, ExcludeFile(path))
+		}
+	}
+
+	if cfg.Network == nil && len(cfg.NetworkAllow) == 0 {
+		disabled := false
+		cfg.Network = &disabled
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the command history store.
+//
+// History is an append-only JSON Lines file: one [HistoryEntry] per line.
+// Writers take an exclusive flock and readers a shared one, so concurrent
+// sandboxes can record into the same file. Lines that fail to parse (e.g. a
+// write cut short by a crash) are skipped on read.
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// HistoryEntry is one recorded sandboxed command.
+type HistoryEntry struct {
+	// Time is when the command started.
+	Time time.Time `json:"time"`
+
+	// Argv is the command as passed to [Sandbox.Command].
+	Argv []string `json:"argv"`
+
+	// WorkDir is [Environment.WorkDir] of the sandbox.
+	WorkDir string `json:"workDir"`
+
+	// Policy is the [Sandbox.Fingerprint] of the sandbox the command ran in.
+	Policy string `json:"policy"`
+
+	// Duration is the wall-clock run time.
+	Duration time.Duration `json:"durationNs"`
+
+	// ExitCode is the exit code of the command, or -1 if it did not exit
+	// normally.
+	ExitCode int `json:"exitCode"`
+
+	// Error describes why the command could not be run or waited for.
+	Error string `json:"error,omitempty"`
+
+	// Labels are the [Sandbox.Labels] of the sandbox the command ran in.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// HistoryFilter selects entries returned by [History]. The zero value
+// selects all entries.
+type HistoryFilter struct {
+	// Command, if set, matches entries whose argv[0] has this base name.
+	Command string
+
+	// Since, if set, drops entries that started before it.
+	Since time.Time
+
+	// Limit, if positive, keeps only the most recent Limit entries.
+	Limit int
+}
+
+// HistoryFileName is the name of the history file in a project state
+// directory (see [Sandbox.StateDir]).
+const HistoryFileName = "history.jsonl"
+
+// HistoryPath returns the history file of the project at
+// [Environment.WorkDir], in its state directory (see [ProjectStateDir]).
+func HistoryPath(env Environment) string {
+	return filepath.Join(ProjectStateDir(env), HistoryFileName)
+}
+
+// Fingerprint returns a short stable digest of the sandbox policy: the bwrap
+// arguments plus the content of injected wrapper files and the network
+// allowlist. Sandboxes built from
+// the same Config and Environment have the same fingerprint.
+func (s *Sandbox) Fingerprint() string {
+	if s == nil || s.plan == nil {
+		return ""
+	}
+
+	hash := sha256.New()
+
+	for _, arg := range s.plan.bwrapArgs {
+		_, _ = fmt.Fprintf(hash, "%d:%s\n", len(arg), arg)
+	}
+
+	for _, mnt := range s.plan.wrapperMounts {
+		_, _ = fmt.Fprintf(hash, "%s %o %d:%s\n", mnt.dst, mnt.perms, len(mnt.data), mnt.data)
+	}
+
+	for _, chmod := range s.plan.chmods {
+		_, _ = fmt.Fprintf(hash, "chmod %o %s\n", chmod.perms, chmod.path)
+	}
+
+	if allow := s.plan.networkAllow; allow != nil {
+		_, _ = fmt.Fprintf(hash, "allow %q %q %q\n", allow.hosts, allow.suffixes, allow.prefixes)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// AppendHistory appends entry to the history file at path, creating the file
+// and its parent directories as needed.
+func AppendHistory(path string, entry HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("history: encoding entry: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("history: locking %s: %w", path, err)
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("history: writing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// History returns the entries of the history file at path that match filter,
+// oldest first. A missing file has no entries.
+func History(path string, filter HistoryFilter) ([]HistoryEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	err = unix.Flock(int(f.Fd()), unix.LOCK_SH)
+	if err != nil {
+		return nil, fmt.Errorf("history: locking %s: %w", path, err)
+	}
+
+	var entries []HistoryEntry
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		var entry HistoryEntry
+
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || len(entry.Argv) == 0 {
+			continue
+		}
+
+		if filter.Command != "" && filepath.Base(entry.Argv[0]) != filter.Command {
+			continue
+		}
+
+		if entry.Time.Before(filter.Since) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("history: reading %s: %w", path, err)
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,463 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the host filesystem view used while planning (see
+// [Environment.FS]).
+//
+// Planning probes the host to resolve policy paths: globs are expanded,
+// symlinks are resolved, sources are stat'ed and commands are searched on
+// PATH. [HostFS] abstracts those probes so embedders and tests can exercise
+// symlink and PATH edge cases against [MemHostFS] instead of real files.
+// Reading files, pinning the launcher and running commands always use the
+// real filesystem.
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSymlinks bounds symlink resolution in [MemHostFS] and other non-OS
+// [HostFS] implementations, like the kernel's limit for a single lookup.
+const maxSymlinks = 255
+
+// HostFS is the view of the host filesystem used to plan a sandbox. All
+// names are absolute host paths.
+//
+// Implementations must report missing paths with errors matching
+// [fs.ErrNotExist]. Stat and ReadDir follow symlinks, Lstat and Readlink do
+// not. ReadDir returns entries sorted by name.
+type HostFS interface {
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+}
+
+// OSHostFS is the [HostFS] backed by the real filesystem. It is used when
+// [Environment.FS] is nil.
+type OSHostFS struct{}
+
+// Stat implements [HostFS].
+func (OSHostFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+
+// Lstat implements [HostFS].
+func (OSHostFS) Lstat(name string) (fs.FileInfo, error) { return os.Lstat(name) }
+
+// Readlink implements [HostFS].
+func (OSHostFS) Readlink(name string) (string, error) { return os.Readlink(name) }
+
+// ReadDir implements [HostFS].
+func (OSHostFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+
+// hostFS returns env.FS, or [OSHostFS] if it is nil.
+func (env Environment) hostFS() HostFS {
+	if env.FS == nil {
+		return OSHostFS{}
+	}
+
+	return env.FS
+}
+
+// evalSymlinksIn is [filepath.EvalSymlinks] for an absolute path in fsys.
+func evalSymlinksIn(fsys HostFS, path string) (string, error) {
+	if _, ok := fsys.(OSHostFS); ok {
+		return filepath.EvalSymlinks(path)
+	}
+
+	if !filepath.IsAbs(path) {
+		return "", &fs.PathError{Op: "evalsymlinks", Path: path, Err: errors.New("path is not absolute")}
+	}
+
+	resolved := "/"
+	rest := strings.Split(path, "/")
+	links := 0
+
+	for len(rest) > 0 {
+		name := rest[0]
+		rest = rest[1:]
+
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+
+		info, err := fsys.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &fs.PathError{Op: "evalsymlinks", Path: path, Err: errors.New("too many levels of symbolic links")}
+		}
+
+		target, err := fsys.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+
+	return resolved, nil
+}
+
+// globIn is [filepath.Glob] for an absolute pattern in fsys. Like
+// filepath.Glob, it ignores I/O errors and only reports malformed patterns.
+func globIn(fsys HostFS, pattern string) ([]string, error) {
+	if _, ok := fsys.(OSHostFS); ok {
+		return filepath.Glob(pattern)
+	}
+
+	_, err := filepath.Match(pattern, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasGlobMeta(pattern) {
+		_, err = fsys.Lstat(pattern)
+		if err == nil {
+			return []string{pattern}, nil
+		}
+
+		return nil, nil
+	}
+
+	dir, file := filepath.Split(pattern)
+	if dir != "/" {
+		dir = strings.TrimSuffix(dir, "/")
+	}
+
+	dirs := []string{dir}
+
+	if hasGlobMeta(dir) {
+		dirs, err = globIn(fsys, dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var matches []string
+
+	for _, d := range dirs {
+		entries, err := fsys.ReadDir(d)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			ok, err := filepath.Match(file, entry.Name())
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				matches = append(matches, filepath.Join(d, entry.Name()))
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// MemHostFS is an in-memory [HostFS] for tests. It starts with an empty root
+// directory; Add* create missing parent directories (mode 0755). Paths must
+// be absolute, and the Add* methods panic otherwise.
+//
+// A MemHostFS is safe for concurrent use.
+type MemHostFS struct {
+	mu    sync.RWMutex
+	nodes map[string]memHostNode
+}
+
+type memHostNode struct {
+	mode   fs.FileMode
+	target string
+}
+
+// NewMemHostFS returns an empty [MemHostFS].
+func NewMemHostFS() *MemHostFS {
+	return &MemHostFS{nodes: map[string]memHostNode{"/": {mode: fs.ModeDir | 0o755}}}
+}
+
+// AddDir adds a directory with permissions perm.
+func (m *MemHostFS) AddDir(path string, perm fs.FileMode) {
+	m.add(path, memHostNode{mode: fs.ModeDir | perm.Perm()})
+}
+
+// AddFile adds a regular file with permissions perm, for example 0o755 for
+// an executable.
+func (m *MemHostFS) AddFile(path string, perm fs.FileMode) {
+	m.add(path, memHostNode{mode: perm.Perm()})
+}
+
+// AddSymlink adds a symlink to target, which may be relative.
+func (m *MemHostFS) AddSymlink(path, target string) {
+	m.add(path, memHostNode{mode: fs.ModeSymlink | 0o777, target: target})
+}
+
+// ParseMemHostFS returns a [MemHostFS] described by spec, one entry per
+// line, so fuzzers and table tests can generate host snapshots as text:
+//
+//	dir 0755 /home/dev
+//	file 0755 /usr/bin/git
+//	link /usr/local/bin/git -> ../../bin/git
+//
+// Permissions are octal. Blank lines and lines starting with "#" are
+// ignored. Later entries replace earlier ones at the same path; the root
+// directory cannot be replaced.
+func ParseMemHostFS(spec string) (*MemHostFS, error) {
+	m := NewMemHostFS()
+
+	for i, line := range strings.Split(spec, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kind, rest, _ := strings.Cut(line, " ")
+
+		var path, target string
+
+		var perm uint64
+
+		switch kind {
+		case "dir", "file":
+			permText, p, ok := strings.Cut(rest, " ")
+			if !ok {
+				return nil, fmt.Errorf("line %d: want %q", i+1, kind+" PERM PATH")
+			}
+
+			var err error
+
+			perm, err = strconv.ParseUint(permText, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: permissions %q: %w", i+1, permText, err)
+			}
+
+			path = p
+		case "link":
+			var ok bool
+
+			path, target, ok = strings.Cut(rest, " -> ")
+			if !ok || target == "" {
+				return nil, fmt.Errorf("line %d: want %q", i+1, "link PATH -> TARGET")
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown entry %q (want dir, file or link)", i+1, kind)
+		}
+
+		if !filepath.IsAbs(path) || filepath.Clean(path) == "/" {
+			return nil, fmt.Errorf("line %d: path %q is not absolute or is the root", i+1, path)
+		}
+
+		switch kind {
+		case "dir":
+			m.AddDir(path, fs.FileMode(perm))
+		case "file":
+			m.AddFile(path, fs.FileMode(perm))
+		default:
+			m.AddSymlink(path, target)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *MemHostFS) add(path string, node memHostNode) {
+	if !filepath.IsAbs(path) {
+		panic(fmt.Sprintf("sandbox: MemHostFS path %q is not absolute", path))
+	}
+
+	path = filepath.Clean(path)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, ok := m.nodes[dir]; !ok {
+			m.nodes[dir] = memHostNode{mode: fs.ModeDir | 0o755}
+		}
+
+		if dir == "/" {
+			break
+		}
+	}
+
+	m.nodes[path] = node
+}
+
+// Stat implements [HostFS].
+func (m *MemHostFS) Stat(name string) (fs.FileInfo, error) {
+	resolved, err := evalSymlinksIn(memHostResolver{m}, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Lstat(resolved)
+}
+
+// Lstat implements [HostFS].
+func (m *MemHostFS) Lstat(name string) (fs.FileInfo, error) {
+	path, node, err := m.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return memHostFileInfo{name: filepath.Base(path), mode: node.mode}, nil
+}
+
+// Readlink implements [HostFS].
+func (m *MemHostFS) Readlink(name string) (string, error) {
+	_, node, err := m.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+
+	if node.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return node.target, nil
+}
+
+// ReadDir implements [HostFS].
+func (m *MemHostFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	dir, err := evalSymlinksIn(memHostResolver{m}, name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, ok := m.nodes[dir]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	var entries []fs.DirEntry
+
+	for path, child := range m.nodes {
+		if path != "/" && filepath.Dir(path) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(memHostFileInfo{name: filepath.Base(path), mode: child.mode}))
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+
+	return entries, nil
+}
+
+// lookup returns the node at name without following a final symlink. The
+// parent directories are resolved.
+func (m *MemHostFS) lookup(op, name string) (string, memHostNode, error) {
+	if !filepath.IsAbs(name) {
+		return "", memHostNode{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	path := filepath.Clean(name)
+	if path != "/" {
+		parent, err := evalSymlinksIn(memHostResolver{m}, filepath.Dir(path))
+		if err != nil {
+			return "", memHostNode{}, err
+		}
+
+		path = filepath.Join(parent, filepath.Base(path))
+	}
+
+	m.mu.RLock()
+	node, ok := m.nodes[path]
+	m.mu.RUnlock()
+
+	if !ok {
+		return "", memHostNode{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return path, node, nil
+}
+
+// memHostResolver is the view of a [MemHostFS] that its methods resolve
+// paths through. evalSymlinksIn only probes paths whose parent is already
+// resolved, so nodes are looked up directly; going through
+// [MemHostFS.Lstat] would resolve every parent again, which takes time
+// exponential in the path depth.
+type memHostResolver struct {
+	m *MemHostFS
+}
+
+func (r memHostResolver) node(op, name string) (memHostNode, error) {
+	r.m.mu.RLock()
+	node, ok := r.m.nodes[name]
+	r.m.mu.RUnlock()
+
+	if !ok {
+		return memHostNode{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return node, nil
+}
+
+func (r memHostResolver) Lstat(name string) (fs.FileInfo, error) {
+	node, err := r.node("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return memHostFileInfo{name: filepath.Base(name), mode: node.mode}, nil
+}
+
+func (r memHostResolver) Readlink(name string) (string, error) {
+	node, err := r.node("readlink", name)
+	if err != nil {
+		return "", err
+	}
+
+	return node.target, nil
+}
+
+func (r memHostResolver) Stat(name string) (fs.FileInfo, error) { return r.m.Stat(name) }
+
+func (r memHostResolver) ReadDir(name string) ([]fs.DirEntry, error) { return r.m.ReadDir(name) }
+
+// memHostFileInfo is the [fs.FileInfo] of a [MemHostFS] node.
+type memHostFileInfo struct {
+	name string
+	mode fs.FileMode
+}
+
+func (i memHostFileInfo) Name() string       { return i.name }
+func (i memHostFileInfo) Size() int64        { return 0 }
+func (i memHostFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i memHostFileInfo) ModTime() time.Time { return time.Time{} }
+func (i memHostFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memHostFileInfo) Sys() any           { return nil }
@@ -0,0 +1,163 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the in-sandbox policy helper (see [Config.SandboxInfo]).
+//
+// The helper is a small shell script generated from the plan and injected via
+// `--ro-bind-data` like wrapper scripts, so it always describes the policy of
+// the sandbox it runs in. It lets agents check what is allowed up front instead
+// of discovering it one denial at a time.
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SandboxInfoPath is the sandbox path of the policy helper mounted when
+// [Config.SandboxInfo] is set.
+const SandboxInfoPath = "/run/agent-sandbox/bin/sandbox-info"
+
+// appendSandboxInfo adds the policy helper and the runtime directories that
+// hold it. It must run after all other mounts are planned so the writable
+// path list reflects the final mount order.
+func (p *planner) appendSandboxInfo() error {
+	for _, dir := range []string{filepath.Dir(filepath.Dir(SandboxInfoPath)), filepath.Dir(SandboxInfoPath)} {
+		// Command wrappers create the same directories when MountPath is
+		// /run/agent-sandbox.
+		if mnt, rel, ok := p.coveringMount(dir); ok && mnt.Kind == MountDir && rel == "." {
+			continue
+		}
+
+		err := p.appendMount(Dir(dir, 0o111))
+		if err != nil {
+			return err
+		}
+	}
+
+	p.plan.wrapperMounts = append(p.plan.wrapperMounts, roBindDataMount{
+		dst:   SandboxInfoPath,
+		perms: 0o555,
+		data:  p.sandboxInfoScript(),
+	})
+
+	return nil
+}
+
+// writablePaths returns the destinations of read-write bind mounts that are
+// not shadowed by a later mount.
+func (p *planner) writablePaths() []string {
+	var out []string
+
+	for _, mnt := range p.plan.mounts {
+		if mnt.Kind != MountBind && mnt.Kind != MountBindTry {
+			continue
+		}
+
+		last, rel, ok := p.coveringMount(mnt.Dst)
+		if ok && rel == "." && (last.Kind == MountBind || last.Kind == MountBindTry) {
+			out = append(out, mnt.Dst)
+		}
+	}
+
+	slices.Sort(out)
+
+	return slices.Compact(out)
+}
+
+func (p *planner) sandboxInfoScript() string {
+	onOff := func(enabled bool) string {
+		if enabled {
+			return "enabled"
+		}
+
+		return "disabled"
+	}
+
+	blocked := slices.Sorted(slices.Values(p.cfg.Commands.Block))
+
+	wrapped := make([]string, 0, len(p.cfg.Commands.Wrappers))
+	for name := range p.cfg.Commands.Wrappers {
+		wrapped = append(wrapped, name)
+	}
+
+	slices.Sort(wrapped)
+
+	network := onOff(p.cfg.Network == nil || *p.cfg.Network)
+	if len(p.cfg.NetworkAllow) > 0 {
+		network = "allowlist " + strings.Join(p.cfg.NetworkAllow, ", ") + " (via HTTP_PROXY)"
+	}
+
+	lines := []string{
+		"network: " + network,
+		"docker: " + onOff(p.cfg.Docker != nil && *p.cfg.Docker),
+	}
+
+	section := func(title string, items []string) {
+		lines = append(lines, title+":")
+		if len(items) == 0 {
+			lines = append(lines, "  (none)")
+		}
+
+		for _, item := range items {
+			lines = append(lines, "  "+item)
+		}
+	}
+
+	section("writable paths", p.writablePaths())
+	section("blocked commands", blocked)
+	section("wrapped commands", wrapped)
+	section("excluded directories", slices.Sorted(maps.Keys(p.excludedDirs)))
+
+	quoted := make([]string, len(lines))
+	for i, line := range lines {
+		quoted[i] = shellQuote(line)
+	}
+
+	return `#!/bin/sh
+# Generated by agent-sandbox: describes the policy of this sandbox.
+blocked=` + shellQuote(" "+strings.Join(blocked, " ")+" ") + `
+wrapped=` + shellQuote(" "+strings.Join(wrapped, " ")+" ") + `
+
+usage() {
+	echo "usage: sandbox-info [which COMMAND...]" >&2
+	exit 2
+}
+
+case "${1:-}" in
+"")
+	printf '%s\n' ` + strings.Join(quoted, " ") + `
+	;;
+which)
+	shift
+	[ $# -gt 0 ] || usage
+	status=0
+	for name in "$@"; do
+		case "$blocked" in *" $name "*)
+			echo "$name: blocked by sandbox policy"
+			status=1
+			continue
+			;;
+		esac
+		if ! path=$(command -v -- "$name"); then
+			echo "$name: not found"
+			status=1
+			continue
+		fi
+		case "$wrapped" in *" $name "*)
+			echo "$name: $path (wrapped by sandbox policy)"
+			continue
+			;;
+		esac
+		echo "$name: $path"
+	done
+	exit "$status"
+	;;
+*)
+	usage
+	;;
+esac
+`
+}
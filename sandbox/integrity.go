@@ -0,0 +1,130 @@
+//go:build linux
+
+package sandbox
+
+// This file implements launcher integrity checks.
+//
+// The launcher binary is bind-mounted from the host each time Command() runs,
+// so whatever is on disk at that moment ends up inside the sandbox. Its
+// SHA-256 is recorded during construction and re-verified before every
+// command. Wrapper scripts need no such check: their content is read once
+// during planning and injected from memory.
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// ErrTampered is returned (wrapped) by [Sandbox.Command], [Sandbox.ExecSpec]
+// and [Sandbox.Pipeline] when the launcher binary changed on disk after the
+// Sandbox was constructed.
+var ErrTampered = errors.New("launcher binary changed since sandbox construction")
+
+// fileDigest is the recorded SHA-256 of a host file.
+//
+// Re-hashing is skipped while the file's stat identity (device, inode, size,
+// mtime and ctime) matches the last successful verification. ctime cannot be
+// set from userspace, so any write or replacement forces a re-hash.
+type fileDigest struct {
+	path string
+	sum  [sha256.Size]byte
+
+	mu       sync.Mutex
+	verified fileStamp
+}
+
+// fileStamp is the stat identity used to skip re-hashing unchanged files.
+type fileStamp struct {
+	dev, ino     uint64
+	size         int64
+	mtime, ctime syscall.Timespec
+}
+
+func newFileDigest(path string) (*fileDigest, error) {
+	stamp, err := statStamp(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileDigest{path: path, sum: sum, verified: stamp}, nil
+}
+
+// verify checks that path still has the recorded digest. It returns an error
+// wrapping [ErrTampered] if the content changed or the file is gone.
+func (d *fileDigest) verify() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stamp, err := statStamp(d.path)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrTampered, d.path, err)
+	}
+
+	if stamp == d.verified {
+		return nil
+	}
+
+	sum, err := hashFile(d.path)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrTampered, d.path, err)
+	}
+
+	if sum != d.sum {
+		return fmt.Errorf("%w: %s: sha256 %x, want %x", ErrTampered, d.path, sum, d.sum)
+	}
+
+	// Same content (e.g. touched or reinstalled identically): cache the new
+	// stamp so the next call can skip hashing again.
+	d.verified = stamp
+
+	return nil
+}
+
+func statStamp(path string) (fileStamp, error) {
+	var st syscall.Stat_t
+
+	err := syscall.Stat(path, &st)
+	if err != nil {
+		return fileStamp{}, fmt.Errorf("stat: %w", err)
+	}
+
+	return fileStamp{
+		dev:   st.Dev,
+		ino:   st.Ino,
+		size:  st.Size,
+		mtime: st.Mtim,
+		ctime: st.Ctim,
+	}, nil
+}
+
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, fmt.Errorf("opening: %w", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	hash := sha256.New()
+
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return sum, fmt.Errorf("hashing: %w", err)
+	}
+
+	copy(sum[:], hash.Sum(nil))
+
+	return sum, nil
+}
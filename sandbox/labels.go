@@ -0,0 +1,51 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Config.Labels].
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"unicode/utf8"
+)
+
+// labelKeyPattern restricts label keys to characters that are valid in OCI
+// annotation keys and need no quoting in log lines.
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// maxLabelValue bounds label values, which are copied into every audit
+// finding and history entry.
+const maxLabelValue = 1024
+
+func validateLabels(labels map[string]string) []error {
+	var errs []error
+
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		value := labels[key]
+
+		switch {
+		case !labelKeyPattern.MatchString(key):
+			errs = append(errs, fmt.Errorf("label key %q is invalid: use letters, digits, '.', '_', '/' and '-'", key))
+		case len(value) > maxLabelValue:
+			errs = append(errs, fmt.Errorf("label %q: value longer than %d bytes", key, maxLabelValue))
+		case !utf8.ValidString(value):
+			errs = append(errs, fmt.Errorf("label %q: value is not valid UTF-8", key))
+		}
+	}
+
+	return errs
+}
+
+// Labels returns a copy of [Config.Labels], or nil if none are set. Callers
+// that record sandbox activity themselves, such as [HistoryEntry] writers,
+// attach it to their records.
+func (s *Sandbox) Labels() map[string]string {
+	if s == nil || s.v == nil {
+		return nil
+	}
+
+	return maps.Clone(s.v.cfg.Labels)
+}
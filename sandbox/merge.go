@@ -0,0 +1,154 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [MergeConfigs].
+
+import (
+	"maps"
+)
+
+// MergeConfigs returns override layered on top of base, following the same
+// rules as the agent-sandbox config loader:
+//
+//   - Pointer fields (Network, Docker, Umask, Systemd) are taken from override
+//     when non-nil.
+//   - String and enum fields (BaseFS, ChdirFallback, TempDir, DefaultACL, Mode,
+//     AuditLog, Commands.Launcher, Commands.MountPath, Commands.BlockLog,
+//     SSH.ConfigSnippet) and
+//     each non-empty Identity field are taken from override when non-empty.
+//   - Function fields (Audit, Debugf) are taken from override when non-nil.
+//   - Clock is taken from override when its Time is set.
+//   - NetworkAllow is taken from override when non-empty, so an allowlist
+//     is replaced rather than widened.
+//   - Plain bool fields (SandboxInfo, MapSubIDs, PinMountSources, NormalizeEnv,
+//     SanitizeWrites, Etc.ReadOnly, Etc.SynthesizePasswd, Namespaces.*,
+//     Diagnostics.SuggestFixes, Filesystem.StrictPresets,
+//     Filesystem.CachePresets, Filesystem.ExcludeNotice,
+//     Filesystem.AllowDangerousMounts, Commands.RejectRelativePath) are
+//     enabled if either config enables them; override cannot disable them.
+//   - Slices are concatenated, base first: Filesystem.Presets (so "!@name"
+//     in override removes a preset base selected), Filesystem.Mounts (later
+//     policy rules win ties), Commands.Block, ExtraCACerts, SSH.KnownHosts
+//     and ExtraBwrapArgs.
+//   - Commands.Wrappers are merged by command name, Etc.Overrides by file
+//     name and NormalizeEnvOverrides by variable name; override wins.
+//
+// Neither argument is modified and the result shares no slices, maps or
+// pointers with them. The result is not validated: for example a command
+// blocked in base and wrapped in override is rejected by [New].
+func MergeConfigs(base, override Config) Config {
+	result := cloneConfig(&base)
+	over := cloneConfig(&override)
+
+	if over.Network != nil {
+		result.Network = over.Network
+	}
+
+	if over.Docker != nil {
+		result.Docker = over.Docker
+	}
+
+	if over.Umask != nil {
+		result.Umask = over.Umask
+	}
+
+	if over.Systemd != nil {
+		result.Systemd = over.Systemd
+	}
+
+	result.BaseFS = mergeString(result.BaseFS, over.BaseFS)
+	result.ChdirFallback = mergeString(result.ChdirFallback, over.ChdirFallback)
+	result.TempDir = mergeString(result.TempDir, over.TempDir)
+	result.DefaultACL = mergeString(result.DefaultACL, over.DefaultACL)
+	result.Mode = mergeString(result.Mode, over.Mode)
+	result.AuditLog = mergeString(result.AuditLog, over.AuditLog)
+
+	result.Identity.Home = mergeString(result.Identity.Home, over.Identity.Home)
+	result.Identity.User = mergeString(result.Identity.User, over.Identity.User)
+	result.Identity.Shell = mergeString(result.Identity.Shell, over.Identity.Shell)
+
+	if !over.Clock.Time.IsZero() {
+		result.Clock = over.Clock
+	}
+
+	if len(over.NetworkAllow) > 0 {
+		result.NetworkAllow = over.NetworkAllow
+	}
+
+	if over.Audit != nil {
+		result.Audit = over.Audit
+	}
+
+	if over.Debugf != nil {
+		result.Debugf = over.Debugf
+	}
+
+	result.SandboxInfo = result.SandboxInfo || over.SandboxInfo
+	result.MapSubIDs = result.MapSubIDs || over.MapSubIDs
+	result.PinMountSources = result.PinMountSources || over.PinMountSources
+	result.Diagnostics.SuggestFixes = result.Diagnostics.SuggestFixes || over.Diagnostics.SuggestFixes
+
+	result.NormalizeEnv = result.NormalizeEnv || over.NormalizeEnv
+	result.SanitizeWrites = result.SanitizeWrites || over.SanitizeWrites
+
+	result.Namespaces.ShareIPC = result.Namespaces.ShareIPC || over.Namespaces.ShareIPC
+	result.Namespaces.ShareUTS = result.Namespaces.ShareUTS || over.Namespaces.ShareUTS
+	result.Namespaces.SharePID = result.Namespaces.SharePID || over.Namespaces.SharePID
+
+	result.ExtraCACerts = append(result.ExtraCACerts, over.ExtraCACerts...)
+	result.SSH.KnownHosts = append(result.SSH.KnownHosts, over.SSH.KnownHosts...)
+	result.SSH.ConfigSnippet = mergeString(result.SSH.ConfigSnippet, over.SSH.ConfigSnippet)
+	result.ExtraBwrapArgs = append(result.ExtraBwrapArgs, over.ExtraBwrapArgs...)
+
+	result.Etc.ReadOnly = result.Etc.ReadOnly || over.Etc.ReadOnly
+	result.Etc.SynthesizePasswd = result.Etc.SynthesizePasswd || over.Etc.SynthesizePasswd
+
+	if len(over.Etc.Overrides) > 0 {
+		if result.Etc.Overrides == nil {
+			result.Etc.Overrides = make(map[string][]byte, len(over.Etc.Overrides))
+		}
+
+		maps.Copy(result.Etc.Overrides, over.Etc.Overrides)
+	}
+
+	if len(over.NormalizeEnvOverrides) > 0 {
+		if result.NormalizeEnvOverrides == nil {
+			result.NormalizeEnvOverrides = make(map[string]string, len(over.NormalizeEnvOverrides))
+		}
+
+		maps.Copy(result.NormalizeEnvOverrides, over.NormalizeEnvOverrides)
+	}
+
+	result.Filesystem.Presets = append(result.Filesystem.Presets, over.Filesystem.Presets...)
+	result.Filesystem.Mounts = append(result.Filesystem.Mounts, over.Filesystem.Mounts...)
+	result.Filesystem.StrictPresets = result.Filesystem.StrictPresets || over.Filesystem.StrictPresets
+	result.Filesystem.CachePresets = result.Filesystem.CachePresets || over.Filesystem.CachePresets
+	result.Filesystem.ExcludeNotice = result.Filesystem.ExcludeNotice || over.Filesystem.ExcludeNotice
+	result.Filesystem.AllowDangerousMounts = result.Filesystem.AllowDangerousMounts || over.Filesystem.AllowDangerousMounts
+
+	result.Commands.Block = append(result.Commands.Block, over.Commands.Block...)
+	result.Commands.RejectRelativePath = result.Commands.RejectRelativePath || over.Commands.RejectRelativePath
+	result.Commands.Launcher = mergeString(result.Commands.Launcher, over.Commands.Launcher)
+	result.Commands.MountPath = mergeString(result.Commands.MountPath, over.Commands.MountPath)
+	result.Commands.BlockLog = mergeString(result.Commands.BlockLog, over.Commands.BlockLog)
+
+	if len(over.Commands.Wrappers) > 0 {
+		if result.Commands.Wrappers == nil {
+			result.Commands.Wrappers = make(map[string]Wrapper, len(over.Commands.Wrappers))
+		}
+
+		maps.Copy(result.Commands.Wrappers, over.Commands.Wrappers)
+	}
+
+	return result
+}
+
+// mergeString returns override if it is non-empty, else base.
+func mergeString[T ~string](base, override T) T {
+	if override != "" {
+		return override
+	}
+
+	return base
+}
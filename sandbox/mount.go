@@ -2,13 +2,16 @@
 
 package sandbox
 
-import "os"
+import (
+	"io/fs"
+	"os"
+)
 
 // Mount describes a mount operation or policy mount.
 //
 // For policy kinds (MountReadOnly, MountReadOnlyTry, MountReadWrite,
 // MountReadWriteTry, MountExclude, MountExcludeTry, MountExcludeFile,
-// MountExcludeDir), Dst is a host path or pattern. It may be absolute, relative
+// MountExcludeDir, MountExcludeAuto), Dst is a host path or pattern. It may be absolute, relative
 // to [Environment.WorkDir], "~"-prefixed, or a glob. During planning, the
 // pattern is expanded and resolved to absolute host paths, and each resolved
 // host path is mounted at the same absolute destination inside the sandbox.
@@ -16,7 +19,10 @@ import "os"
 //
 // For low-level mounts, Src is the host path and Dst is the absolute path inside
 // the sandbox. For mounts that only need a destination (e.g. tmpfs), Src is
-// ignored.
+// ignored. One host path may be bound at several destinations, each with its
+// own kind (for example read-only at /data and read-write at /scratch/data);
+// only identical low-level mounts are collapsed. Policy mounts, in contrast,
+// are deduplicated by resolved host path.
 //
 // MountRoBindData uses FD and Perms to mount file content provided through
 // exec.Cmd.ExtraFiles.
@@ -41,7 +47,9 @@ type Mount struct {
 	// - MountRoBindData: sets the mode of the injected file.
 	// - MountDir: if non-zero, the directory is chmod'd after mounts.
 	//
-	// For other mount kinds it may be ignored.
+	// Bind mounts reject Perms: bwrap cannot strip permissions (e.g. execute
+	// bits) from a bind mount without changing the host file. For other mount
+	// kinds it may be ignored.
 	Perms os.FileMode
 
 	// FD is used for MountRoBindData and refers to the child FD number inside the
@@ -49,4 +57,60 @@ type Mount struct {
 	//
 	// For other mount kinds it must be zero.
 	FD int
+
+	// FS, if set on a MountRoBindData mount, provides the injected content
+	// instead of FD: Src names the file in FS (an [fs.ValidPath]), which is
+	// read during construction, and FD must be zero. See [MaskFS].
+	//
+	// For other mount kinds it must be nil.
+	FS fs.FS
+
+	// Missing controls how MountExcludeFile, MountExcludeDir, and
+	// MountExcludeAuto handle a path that does not exist at planning time.
+	//
+	// For other mount kinds it must be MissingDefault.
+	Missing MissingMode
+
+	// MountPhase selects where a low-level mount is emitted (see [MountPhase]
+	// and [Mount.Phase]).
+	//
+	// For policy mounts it must be PhaseDirect.
+	MountPhase MountPhase
+}
+
+// Mounts returns the low-level mounts the sandbox passes to bwrap, in final
+// order.
+//
+// Policy mounts (RO/RW/Exclude and their variants) appear in resolved form:
+// patterns are expanded, precedence is applied (exact beats glob, later wins),
+// and the result is sorted from shallowest to deepest destination. Direct,
+// command wrapper, docker socket, and late mounts follow in the order they
+// are emitted (see [MountPhase]). Only low-level kinds (MountRoBind, MountBind, MountTmpfs, ...)
+// appear in the result.
+//
+// Mounts whose content is supplied through an inherited FD allocated by
+// [Sandbox.Command] (excluded-file masks and wrapper scripts) are reported as
+// MountRoBindData with FD 0.
+//
+// The returned slice is a copy. It returns nil for an uninitialized Sandbox.
+func (s *Sandbox) Mounts() []Mount {
+	if s == nil || s.plan == nil {
+		return nil
+	}
+
+	out := make([]Mount, 0, len(s.plan.mounts)+len(s.plan.wrapperMounts))
+
+	for _, m := range s.plan.mounts {
+		if m.Kind == MountRoBindData && m.FD == emptyDataFD {
+			m.FD = 0
+		}
+
+		out = append(out, m)
+	}
+
+	for _, m := range s.plan.wrapperMounts {
+		out = append(out, Mount{Kind: MountRoBindData, Dst: m.dst, Perms: m.perms})
+	}
+
+	return out
 }
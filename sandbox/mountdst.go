@@ -0,0 +1,56 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the symlink check for direct mount destinations.
+//
+// bwrap resolves a destination inside the sandbox as it is assembled, so a
+// symlink on the way (for example /lib -> usr/lib under [BaseFSHost] on
+// merged-/usr systems) silently moves the mount elsewhere. What the
+// destination traverses depends on the base filesystem and the mounts
+// planned before it, not on the host: under [BaseFSEmpty], /lib is a
+// directory on the sandbox tmpfs, but it is the host symlink again once the
+// host root or /usr layout is bound in.
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// checkMountDst returns an error if an ancestor of the direct mount
+// destination dst is a symlink in the sandbox as planned so far. Only
+// ancestors backed by a host bind mount can be symlinks; tmpfs and Dir
+// mounts create plain directories.
+func (p *planner) checkMountDst(dst string) error {
+	for dir := filepath.Dir(filepath.Clean(dst)); dir != "/"; dir = filepath.Dir(dir) {
+		mnt, rel, ok := p.coveringMount(dir)
+		if !ok || rel == "." {
+			// A mount at dir itself is a directory: bind mounts follow
+			// a symlinked source.
+			continue
+		}
+
+		switch mnt.Kind {
+		case MountRoBind, MountRoBindTry, MountBind, MountBindTry:
+		default:
+			continue
+		}
+
+		hostPath := filepath.Join(mnt.Src, rel)
+
+		info, err := p.paths.hostFS.Lstat(hostPath)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+
+		target, err := p.paths.hostFS.Readlink(hostPath)
+		if err != nil {
+			target = "?"
+		}
+
+		return fmt.Errorf("mount destination %q traverses symlink %s -> %s inside the sandbox; use the resolved path as destination", dst, dir, target)
+	}
+
+	return nil
+}
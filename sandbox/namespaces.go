@@ -0,0 +1,99 @@
+//go:build linux
+
+package sandbox
+
+// This file implements selective namespace sharing (see [Config.Namespaces]).
+//
+// bwrap has no --share-* flag besides --share-net, so as soon as one namespace
+// is shared the planner lists the namespaces to unshare explicitly instead of
+// passing --unshare-all.
+
+// Namespaces selects namespaces the sandbox shares with the host instead of
+// unsharing them. The zero value unshares all of them (bwrap --unshare-all).
+//
+// Sharing a namespace weakens isolation; each field documents what becomes
+// reachable. The trade-offs are reported via [Config.Debugf] during
+// construction.
+type Namespaces struct {
+	// ShareIPC keeps the host IPC namespace, for tools that use SysV IPC or
+	// POSIX message queues with a host process (some databases, X clients
+	// using MIT-SHM). Sandboxed processes can attach to and modify any SysV
+	// shared memory segment, semaphore or message queue of the same user.
+	ShareIPC bool
+
+	// ShareUTS keeps the host UTS namespace. Sandboxed processes see the
+	// host name and, with CAP_SYS_ADMIN in the host user namespace, could
+	// change it for the host.
+	ShareUTS bool
+
+	// SharePID keeps the host PID namespace, for debugging with host PID
+	// visibility. Sandboxed processes can list host processes and read their
+	// command lines and environments in /proc where permissions allow, and
+	// can signal processes of the same user outside the sandbox.
+	SharePID bool
+}
+
+// namespaceTradeoffs returns a description of the isolation given up by each
+// shared namespace, in flag order.
+func (n Namespaces) namespaceTradeoffs() []string {
+	var notes []string
+
+	if n.ShareIPC {
+		notes = append(notes, "ipc: host SysV IPC objects and POSIX message queues are reachable")
+	}
+
+	if n.SharePID {
+		notes = append(notes, "pid: host processes are visible in /proc and can be signaled")
+	}
+
+	if n.ShareUTS {
+		notes = append(notes, "uts: host name is shared")
+	}
+
+	return notes
+}
+
+// appendNamespaceArgs emits the namespace flags. userNS, when non-empty, is
+// passed to --userns; bwrap rejects it together with --unshare-all (which
+// implies --unshare-user-try), so the other namespaces are listed explicitly.
+func (p *planner) appendNamespaceArgs(networkEnabled bool, userNS string) {
+	ns := p.cfg.Namespaces
+
+	if userNS == "" && ns == (Namespaces{}) {
+		p.appendArgs("--unshare-all")
+
+		if networkEnabled {
+			p.appendArgs("--share-net")
+		}
+
+		return
+	}
+
+	if userNS != "" {
+		p.appendArgs("--userns", userNS)
+	} else {
+		p.appendArgs("--unshare-user-try")
+	}
+
+	if !ns.ShareIPC {
+		p.appendArgs("--unshare-ipc")
+	}
+
+	if !ns.SharePID {
+		p.appendArgs("--unshare-pid")
+	}
+
+	if !ns.ShareUTS {
+		p.appendArgs("--unshare-uts")
+	}
+
+	p.appendArgs("--unshare-cgroup-try")
+
+	if !networkEnabled {
+		p.appendArgs("--unshare-net")
+	}
+
+	for _, note := range ns.namespaceTradeoffs() {
+		p.debugf("warning: sharing host namespace, %s", note)
+	}
+}
@@ -0,0 +1,612 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the network allowlist (see [Config.NetworkAllow]).
+//
+// bwrap can only share the host network namespace or give the sandbox an
+// empty one, so allowlisting happens in a proxy on the host:
+//
+//   - The sandbox gets its own network namespace with only loopback.
+//   - Each command gets an HTTP proxy in this process, listening on a unix
+//     socket in a private host temp directory that is bound read-only at
+//     [ProxyDir] and hidden from every other path of every sandbox, so no
+//     other sandbox can borrow its allowlist and the command cannot replace
+//     the socket.
+//     The proxy forwards plain HTTP requests and CONNECT tunnels to allowed
+//     hosts and answers everything else with 403 Forbidden.
+//   - Clients speak to proxies over TCP, so the command is started through
+//     the launcher as [ProxyBridgePath], which listens on [ProxyAddr] inside
+//     the sandbox, forwards connections to the socket, and runs the command
+//     with HTTP_PROXY and HTTPS_PROXY pointing at it (see [ProxyBridge]).
+//
+// Host names are resolved on the host, so the sandbox needs no DNS.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// ProxyDir is the sandbox directory holding the socket of the allowlist
+	// proxy. It is bound read-only for each command.
+	ProxyDir = "/run/agent-sandbox/proxy"
+
+	// ProxySocketPath is the unix socket of the allowlist proxy inside the
+	// sandbox.
+	ProxySocketPath = ProxyDir + "/proxy.sock"
+
+	// ProxyBridgeName is the name the launcher is invoked by to run a
+	// command behind the allowlist proxy (see [ProxyBridge]).
+	ProxyBridgeName = "agent-sandbox-proxy-bridge"
+
+	// ProxyBridgePath is the sandbox path the launcher is bound at as
+	// [ProxyBridgeName].
+	ProxyBridgePath = "/run/agent-sandbox/" + ProxyBridgeName
+
+	// ProxyAddr is the address the bridge listens on inside the sandbox. The
+	// sandbox has its own network namespace, so the port is always free.
+	ProxyAddr = "127.0.0.1:3128"
+)
+
+// proxyEnv lists the variables pointing clients at [ProxyAddr]. Tools differ
+// in which spelling they read.
+var proxyEnv = []string{"http_proxy", "https_proxy", "HTTP_PROXY", "HTTPS_PROXY"}
+
+// noProxyEnv lists the variables exempting loopback from the proxy.
+var noProxyEnv = []string{"no_proxy", "NO_PROXY"}
+
+// allowList matches destinations against [Config.NetworkAllow].
+type allowList struct {
+	hosts    []string
+	suffixes []string
+	prefixes []netip.Prefix
+}
+
+// parseAllowList parses the entries of [Config.NetworkAllow]: host names,
+// "*." wildcards matching any subdomain, IPv4 or IPv6 addresses, and CIDR
+// prefixes of either family.
+func parseAllowList(entries []string) (allowList, error) {
+	var list allowList
+
+	for _, entry := range entries {
+		addrPart, _, isPrefix := strings.Cut(entry, "/")
+
+		_, addrErr := netip.ParseAddr(addrPart)
+		if isPrefix && addrErr == nil {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil || prefix.Addr().Is4In6() || prefix.Masked() != prefix {
+				return allowList{}, fmt.Errorf("invalid CIDR %q (write it in canonical form, e.g. 10.0.0.0/8)", entry)
+			}
+
+			list.prefixes = append(list.prefixes, prefix)
+
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err == nil {
+			if addr.Zone() != "" || addr.Is4In6() {
+				return allowList{}, fmt.Errorf("invalid address %q (zone IDs and IPv4-mapped IPv6 are not supported)", entry)
+			}
+
+			list.prefixes = append(list.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+
+			continue
+		}
+
+		host, wildcard := strings.CutPrefix(strings.ToLower(entry), "*.")
+		if !validHostName(host) {
+			return allowList{}, fmt.Errorf("invalid host %q (expected a host name, \"*.\" wildcard, IP address or CIDR)", entry)
+		}
+
+		if wildcard {
+			list.suffixes = append(list.suffixes, "."+host)
+		} else {
+			list.hosts = append(list.hosts, host)
+		}
+	}
+
+	return list, nil
+}
+
+// validHostName reports whether host is a DNS host name and not an IP
+// address.
+func validHostName(host string) bool {
+	if host == "" || len(host) > 253 {
+		return false
+	}
+
+	_, err := netip.ParseAddr(host)
+	if err == nil {
+		return false
+	}
+
+	for label := range strings.SplitSeq(host, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// allowsName reports whether the host name matches a host or wildcard entry.
+func (a allowList) allowsName(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	if slices.Contains(a.hosts, host) {
+		return true
+	}
+
+	return slices.ContainsFunc(a.suffixes, func(suffix string) bool { return strings.HasSuffix(host, suffix) })
+}
+
+// allowsAddr reports whether addr lies in an address or CIDR entry.
+func (a allowList) allowsAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	return slices.ContainsFunc(a.prefixes, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+}
+
+// errNotAllowed reports a destination outside the allowlist.
+var errNotAllowed = errors.New("not in the network allowlist")
+
+// dialer dials destinations after checking them against the allowlist. A
+// host name that matches no host entry is allowed if every address it
+// resolves to lies in an address or CIDR entry; the checked address is
+// dialed, so the name cannot resolve differently in between.
+type dialer struct {
+	allow    allowList
+	net      net.Dialer
+	resolver *net.Resolver
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.allow.allowsName(host) {
+		return d.net.DialContext(ctx, network, address)
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err == nil {
+		if !d.allow.allowsAddr(addr) {
+			return nil, fmt.Errorf("%s: %w", host, errNotAllowed)
+		}
+
+		return d.net.DialContext(ctx, network, address)
+	}
+
+	if len(d.allow.prefixes) == 0 {
+		return nil, fmt.Errorf("%s: %w", host, errNotAllowed)
+	}
+
+	// A name that does not resolve cannot be shown to lie in an entry.
+	addrs, err := d.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w (%w)", host, errNotAllowed, err)
+	}
+
+	for _, addr := range addrs {
+		if !d.allow.allowsAddr(addr) {
+			return nil, fmt.Errorf("%s (%s): %w", host, addr, errNotAllowed)
+		}
+	}
+
+	var errs []error
+
+	for _, addr := range addrs {
+		conn, err := d.net.DialContext(ctx, network, net.JoinHostPort(addr.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// networkProxy is the allowlist proxy of one command.
+type networkProxy struct {
+	dialer    *dialer
+	transport *http.Transport
+	forward   *httputil.ReverseProxy
+	server    *http.Server
+	debugf    Debugf
+
+	mu      sync.Mutex
+	tunnels map[net.Conn]struct{}
+	closed  bool
+}
+
+// proxyArgs starts the allowlist proxy for one command on a unix socket in
+// a host temp directory (see [hostTempRoot]) and returns the bwrap args that bind the directory
+// at [ProxyDir]. The returned cleanup stops the proxy, closes open tunnels
+// and removes the directory.
+func proxyArgs(allow allowList, debugf Debugf) ([]string, func() error, error) {
+	dir, removeDir, err := newHostTempDir("proxy-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("sandbox: network proxy: %w", err)
+	}
+
+	listener, err := net.Listen("unix", filepath.Join(dir, filepath.Base(ProxySocketPath)))
+	if err != nil {
+		return nil, nil, errors.Join(fmt.Errorf("sandbox: network proxy: %w", err), removeDir())
+	}
+
+	d := &dialer{allow: allow, net: net.Dialer{Timeout: 30 * time.Second}, resolver: net.DefaultResolver}
+	proxy := &networkProxy{
+		dialer: d,
+		transport: &http.Transport{
+			DialContext:         d.DialContext,
+			MaxIdleConns:        16,
+			IdleConnTimeout:     30 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		debugf:  debugf,
+		tunnels: make(map[net.Conn]struct{}),
+	}
+	proxy.forward = &httputil.ReverseProxy{
+		// Requests to a proxy carry the absolute URL, which the outgoing
+		// request already has; Rewrite only drops hop-by-hop and
+		// X-Forwarded headers.
+		Rewrite:   func(*httputil.ProxyRequest) {},
+		Transport: proxy.transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			proxy.fail(w, r.URL.Host, err)
+		},
+	}
+	proxy.server = &http.Server{Handler: proxy, ReadHeaderTimeout: 30 * time.Second}
+
+	served := make(chan struct{})
+
+	go func() {
+		defer close(served)
+
+		_ = proxy.server.Serve(listener)
+	}()
+
+	cleanup := func() error {
+		err := proxy.close()
+		<-served
+
+		return errors.Join(err, removeDir())
+	}
+
+	return []string{"--ro-bind", dir, ProxyDir}, cleanup, nil
+}
+
+func (p *networkProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+
+		return
+	}
+
+	if r.URL.Host == "" || (r.URL.Scheme != "http" && r.URL.Scheme != "https") {
+		http.Error(w, "agent-sandbox: the network proxy only forwards absolute http:// URLs and CONNECT", http.StatusBadRequest)
+
+		return
+	}
+
+	p.forward.ServeHTTP(w, r)
+}
+
+// tunnel serves a CONNECT request by splicing the client connection to the
+// destination.
+func (p *networkProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dialer.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		p.fail(w, r.Host, err)
+
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = upstream.Close()
+
+		http.Error(w, "agent-sandbox: tunneling not supported", http.StatusInternalServerError)
+
+		return
+	}
+
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		_ = upstream.Close()
+
+		return
+	}
+
+	if !p.track(client, upstream) {
+		_ = client.Close()
+		_ = upstream.Close()
+
+		return
+	}
+
+	defer p.untrack(client, upstream)
+
+	_, err = client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	if err != nil {
+		return
+	}
+
+	// The client may have sent the first bytes of the tunnel along with the
+	// request headers.
+	splice(client, io.MultiReader(io.LimitReader(buffered, int64(buffered.Reader.Buffered())), client), upstream)
+}
+
+// fail answers a request the proxy could not forward: 403 if the
+// destination is not allowed, 502 otherwise.
+func (p *networkProxy) fail(w http.ResponseWriter, host string, err error) {
+	if errors.Is(err, errNotAllowed) {
+		if p.debugf != nil {
+			p.debugf("network proxy: denied %s", host)
+		}
+
+		http.Error(w, "agent-sandbox: "+host+" is not in the network allowlist", http.StatusForbidden)
+
+		return
+	}
+
+	http.Error(w, "agent-sandbox: "+err.Error(), http.StatusBadGateway)
+}
+
+// track records open tunnel connections so close can end them. It returns
+// false if the proxy is already closed.
+func (p *networkProxy) track(conns ...net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+
+	for _, conn := range conns {
+		p.tunnels[conn] = struct{}{}
+	}
+
+	return true
+}
+
+func (p *networkProxy) untrack(conns ...net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, conn := range conns {
+		delete(p.tunnels, conn)
+		_ = conn.Close()
+	}
+}
+
+// close stops accepting requests and closes open tunnels, which
+// [http.Server.Close] does not track once they are hijacked.
+func (p *networkProxy) close() error {
+	p.mu.Lock()
+	p.closed = true
+
+	for conn := range p.tunnels {
+		_ = conn.Close()
+	}
+
+	p.mu.Unlock()
+
+	p.transport.CloseIdleConnections()
+
+	return p.server.Close()
+}
+
+// splice copies from clientInput (the client connection, possibly with
+// buffered bytes in front) to upstream and from upstream to client, until
+// both directions are done.
+func splice(client net.Conn, clientInput io.Reader, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		_, _ = io.Copy(upstream, clientInput)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		_, _ = io.Copy(client, upstream)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+
+	<-done
+	<-done
+}
+
+// closeWrite half-closes conn if it supports it, so the peer sees EOF while
+// the other direction keeps flowing.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+	}
+}
+
+// proxyEnvArgs returns the --setenv args pointing clients at the bridge.
+func proxyEnvArgs() []string {
+	args := make([]string, 0, 3*(len(proxyEnv)+len(noProxyEnv)))
+
+	for _, name := range proxyEnv {
+		args = append(args, "--setenv", name, "http://"+ProxyAddr)
+	}
+
+	for _, name := range noProxyEnv {
+		args = append(args, "--setenv", name, "localhost,127.0.0.1,::1")
+	}
+
+	return args
+}
+
+// appendNetworkProxy binds the launcher at [ProxyBridgePath] and points the
+// proxy variables at [ProxyAddr]. The proxy socket is bound per command.
+func (p *planner) appendNetworkProxy() error {
+	p.debugf("network allowlist: %d entries, bridge %s", len(p.cfg.NetworkAllow), ProxyBridgePath)
+
+	allow, err := parseAllowList(p.cfg.NetworkAllow)
+	if err != nil {
+		// NetworkAllow is validated at construction time.
+		return internalErrorf("planner.appendNetworkProxy", "%v", err)
+	}
+
+	err = p.appendMount(RoBind(p.cfg.Commands.Launcher, ProxyBridgePath))
+	if err != nil {
+		return err
+	}
+
+	p.appendEnvArgs(proxyEnvArgs()...)
+	p.plan.networkAllow = &allow
+
+	return nil
+}
+
+// proxyBridgeArgv returns argv started through the proxy bridge.
+func proxyBridgeArgv(argv []string) []string {
+	return append([]string{ProxyBridgePath}, argv...)
+}
+
+func validateNetworkAllow(allow []string, network *bool, launcher string) []error {
+	if len(allow) == 0 {
+		return nil
+	}
+
+	var errs []error
+
+	if network != nil && !*network {
+		errs = append(errs, errors.New("network allowlist: NetworkAllow requires network access, but Network is false"))
+	}
+
+	if launcher == "" {
+		errs = append(errs, errors.New("network allowlist: NetworkAllow requires Commands.Launcher, which bridges the proxy into the sandbox"))
+	}
+
+	_, err := parseAllowList(allow)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("network allowlist: %w", err))
+	}
+
+	return errs
+}
+
+// ProxyBridge runs argv behind the allowlist proxy of [Config.NetworkAllow]
+// and returns its exit code. Launchers call it when invoked as
+// [ProxyBridgeName] (at [ProxyBridgePath]), passing their arguments as argv.
+//
+// It listens on [ProxyAddr], forwards each connection to [ProxySocketPath],
+// and runs argv as a child process with the bridge's environment, standard
+// streams and forwarded termination signals. If argv cannot be found it
+// returns [ExitNotFound]; other start failures return [ExitDenied] and the
+// error.
+func ProxyBridge(ctx context.Context, argv []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if len(argv) == 0 {
+		return 1, errors.New("proxy bridge: no command provided")
+	}
+
+	listener, err := net.Listen("tcp", ProxyAddr)
+	if err != nil {
+		return 1, fmt.Errorf("proxy bridge: %w", err)
+	}
+
+	defer func() { _ = listener.Close() }()
+
+	go serveProxyBridge(listener, ProxySocketPath)
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+
+	defer signal.Stop(signals)
+
+	err = cmd.Start()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return ExitNotFound, fmt.Errorf("proxy bridge: %w", err)
+		}
+
+		return ExitDenied, fmt.Errorf("proxy bridge: %w", err)
+	}
+
+	waited := make(chan error, 1)
+
+	go func() { waited <- cmd.Wait() }()
+
+	for {
+		select {
+		case sig := <-signals:
+			_ = cmd.Process.Signal(sig)
+		case err := <-waited:
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+					return 128 + int(status.Signal()), nil
+				}
+
+				return exitErr.ExitCode(), nil
+			}
+
+			if err != nil {
+				return 1, fmt.Errorf("proxy bridge: %w", err)
+			}
+
+			return 0, nil
+		}
+	}
+}
+
+// serveProxyBridge forwards connections accepted on listener to the unix
+// socket at socketPath until listener is closed.
+func serveProxyBridge(listener net.Listener, socketPath string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer func() { _ = conn.Close() }()
+
+			upstream, err := net.Dial("unix", socketPath)
+			if err != nil {
+				return
+			}
+
+			defer func() { _ = upstream.Close() }()
+
+			splice(conn, conn, upstream)
+		}()
+	}
+}
@@ -0,0 +1,394 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Sandbox.ExportOCI].
+//
+// The export replays the planned mounts in order on an in-memory tree: a
+// mount replaces everything below its destination, bind mounts contribute the
+// host files below their source, tmpfs and created directories contribute an
+// empty directory, and masks and injected files contribute their content. The
+// tree is then written as a single-layer OCI image (image-spec v1.1 layout).
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OCI media types written by [Sandbox.ExportOCI].
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// OCIFingerprintAnnotation is the manifest annotation that records
+// [Sandbox.Fingerprint] in images written by [Sandbox.ExportOCI].
+const OCIFingerprintAnnotation = "dev.agent-sandbox.fingerprint"
+
+// ociEntry is one file in the exported tree. Regular files are read from
+// hostPath, or taken from data when hostPath is empty.
+type ociEntry struct {
+	mode     fs.FileMode
+	hostPath string
+	data     string
+	link     string
+}
+
+// ociDescriptor is an OCI content descriptor.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ExportOCI writes the filesystem view of the sandbox as an OCI image layout
+// to dir, which must not exist or be empty. The image has a single layer and
+// is tagged "latest"; its manifest records [Sandbox.Fingerprint] under
+// [OCIFingerprintAnnotation], and its image config carries [Config.Labels]
+// as image labels.
+//
+// The export is best-effort and limited to the planned mounts: the host root
+// of [BaseFSHost] is exported as an empty directory, /dev and /proc are
+// omitted, read-only mounts lose their write bits, excluded paths are empty
+// files or directories without permissions, and ownership and timestamps are
+// normalized so exports of the same policy compare equal across machines.
+// Content is read when ExportOCI runs, not when the sandbox was built.
+// Sockets, devices and unreadable host paths are skipped.
+func (s *Sandbox) ExportOCI(dir string) error {
+	if s == nil || s.v == nil || s.plan == nil {
+		return errors.New("sandbox: uninitialized sandbox (use New or NewWithEnvironment)")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("sandbox: export oci: %w", err)
+	}
+
+	if len(entries) > 0 {
+		return fmt.Errorf("sandbox: export oci: %s is not empty", dir)
+	}
+
+	tree := s.ociTree()
+
+	blobs := filepath.Join(dir, "blobs", "sha256")
+
+	err = os.MkdirAll(blobs, 0o755)
+	if err != nil {
+		return fmt.Errorf("sandbox: export oci: %w", err)
+	}
+
+	layer, diffID, err := writeOCILayer(blobs, tree)
+	if err != nil {
+		return fmt.Errorf("sandbox: export oci: %w", err)
+	}
+
+	config := map[string]any{
+		"architecture": runtime.GOARCH,
+		"os":           "linux",
+		"config":       map[string]any{"WorkingDir": s.v.env.WorkDir, "Labels": s.v.cfg.Labels},
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": []string{diffID}},
+	}
+
+	configDesc, err := writeOCIJSON(blobs, ociConfigMediaType, config)
+	if err != nil {
+		return fmt.Errorf("sandbox: export oci: %w", err)
+	}
+
+	manifest := map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestMediaType,
+		"config":        configDesc,
+		"layers":        []ociDescriptor{layer},
+		"annotations":   map[string]string{OCIFingerprintAnnotation: s.Fingerprint()},
+	}
+
+	manifestDesc, err := writeOCIJSON(blobs, ociManifestMediaType, manifest)
+	if err != nil {
+		return fmt.Errorf("sandbox: export oci: %w", err)
+	}
+
+	manifestDesc.Annotations = map[string]string{"org.opencontainers.image.ref.name": "latest"}
+
+	index := map[string]any{
+		"schemaVersion": 2,
+		"manifests":     []ociDescriptor{manifestDesc},
+	}
+
+	for name, v := range map[string]any{"index.json": index, "oci-layout": map[string]string{"imageLayoutVersion": "1.0.0"}} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("sandbox: export oci: %w", err)
+		}
+
+		err = os.WriteFile(filepath.Join(dir, name), data, 0o644)
+		if err != nil {
+			return fmt.Errorf("sandbox: export oci: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ociTree replays the planned mounts and returns the resulting files keyed by
+// absolute sandbox path.
+func (s *Sandbox) ociTree() map[string]ociEntry {
+	tree := map[string]ociEntry{"/": {mode: fs.ModeDir | 0o755}}
+
+	replace := func(dst string) {
+		for path := range tree {
+			if path == dst || strings.HasPrefix(path, dst+"/") {
+				delete(tree, path)
+			}
+		}
+	}
+
+	for _, mnt := range s.plan.mounts {
+		dst := filepath.Clean(mnt.Dst)
+
+		switch mnt.Kind {
+		case MountRoBind, MountRoBindTry, MountBind, MountBindTry:
+			if mnt.Src == "/" && dst == "/" {
+				continue
+			}
+
+			_, err := os.Lstat(mnt.Src)
+			if err != nil {
+				continue
+			}
+
+			replace(dst)
+			addOCIHostTree(tree, mnt.Src, dst, mnt.Kind == MountRoBind || mnt.Kind == MountRoBindTry)
+		case MountTmpfs:
+			replace(dst)
+
+			tree[dst] = ociEntry{mode: fs.ModeDir | 0o755}
+		case MountDir:
+			if _, ok := tree[dst]; !ok {
+				tree[dst] = ociEntry{mode: fs.ModeDir | 0o755}
+			}
+		case MountRoBindData:
+			replace(dst)
+
+			tree[dst] = ociEntry{mode: mnt.Perms.Perm()}
+		}
+	}
+
+	for _, chmod := range s.plan.chmods {
+		if entry, ok := tree[chmod.path]; ok {
+			entry.mode = entry.mode.Type() | chmod.perms.Perm()
+			tree[chmod.path] = entry
+		}
+	}
+
+	for _, mnt := range s.plan.wrapperMounts {
+		replace(mnt.dst)
+
+		tree[mnt.dst] = ociEntry{mode: mnt.perms.Perm(), data: mnt.data}
+	}
+
+	// Parents that no mount created are directories bwrap creates on the way.
+	for path := range tree {
+		for dir := filepath.Dir(path); dir != "/"; dir = filepath.Dir(dir) {
+			if _, ok := tree[dir]; !ok {
+				tree[dir] = ociEntry{mode: fs.ModeDir | 0o755}
+			}
+		}
+	}
+
+	return tree
+}
+
+// addOCIHostTree adds the host files below src at dst. readOnly clears the
+// write bits.
+func addOCIHostTree(tree map[string]ociEntry, src, dst string, readOnly bool) {
+	_ = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		// Unreadable and vanished paths are skipped.
+		if err != nil {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		mode := info.Mode()
+		if readOnly {
+			mode &^= 0o222
+		}
+
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case mode.IsDir():
+			tree[target] = ociEntry{mode: mode}
+		case mode.IsRegular():
+			tree[target] = ociEntry{mode: mode, hostPath: path}
+		case mode&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err == nil {
+				tree[target] = ociEntry{mode: mode, link: link}
+			}
+		}
+
+		return nil
+	})
+}
+
+// writeOCILayer writes tree as a gzipped tar blob and returns its descriptor
+// and the digest of the uncompressed tar (the diff ID).
+func writeOCILayer(blobs string, tree map[string]ociEntry) (ociDescriptor, string, error) {
+	tmp, err := os.CreateTemp(blobs, ".layer-*")
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	defer func() { _ = tmp.Close() }()
+
+	compressed := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(tmp, compressed)}
+	gz := gzip.NewWriter(counter)
+	uncompressed := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(gz, uncompressed))
+
+	for _, path := range slices.Sorted(maps.Keys(tree)) {
+		if path == "/" {
+			continue
+		}
+
+		err = writeOCIEntry(tw, strings.TrimPrefix(path, "/"), tree[path])
+		if err != nil {
+			return ociDescriptor{}, "", fmt.Errorf("layer %s: %w", path, err)
+		}
+	}
+
+	err = errors.Join(tw.Close(), gz.Close(), tmp.Close())
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+
+	digest := hexDigest(compressed)
+
+	err = os.Rename(tmp.Name(), filepath.Join(blobs, digest))
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+
+	return ociDescriptor{MediaType: ociLayerMediaType, Digest: "sha256:" + digest, Size: counter.n}, "sha256:" + hexDigest(uncompressed), nil
+}
+
+// writeOCIEntry writes one tree entry with normalized ownership and time.
+func writeOCIEntry(tw *tar.Writer, name string, entry ociEntry) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(entry.mode.Perm()),
+		ModTime: time.Unix(0, 0),
+		Format:  tar.FormatPAX,
+	}
+
+	switch {
+	case entry.mode.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+
+		return tw.WriteHeader(hdr)
+	case entry.mode&fs.ModeSymlink != 0:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = entry.link
+
+		return tw.WriteHeader(hdr)
+	}
+
+	hdr.Typeflag = tar.TypeReg
+
+	if entry.hostPath == "" {
+		hdr.Size = int64(len(entry.data))
+
+		err := tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(tw, entry.data)
+
+		return err
+	}
+
+	f, err := os.Open(entry.hostPath)
+	if err != nil {
+		// Unreadable host files are skipped like sockets and devices.
+		return nil
+	}
+
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr.Size = info.Size()
+
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(tw, f, hdr.Size)
+
+	return err
+}
+
+// writeOCIJSON writes v as a JSON blob and returns its descriptor.
+func writeOCIJSON(blobs, mediaType string, v any) (ociDescriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	err = os.WriteFile(filepath.Join(blobs, digest), data, 0o644)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(data))}, nil
+}
+
+func hexDigest(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
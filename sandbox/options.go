@@ -0,0 +1,135 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [NewWithOptions] and its [Option] values.
+
+// Option configures a Sandbox constructed with [NewWithOptions].
+//
+// Options are applied in order. Options that add entries (presets, mounts,
+// blocked commands) append; options that set a value replace it.
+type Option func(*options)
+
+type options struct {
+	cfg Config
+	env *Environment
+}
+
+// NewWithOptions constructs a Sandbox from options instead of a [Config]
+// literal. Without options it is equivalent to New(&Config{}).
+//
+// The environment is derived from the current process (see
+// [DefaultEnvironment]) unless [WithEnvironment] is given.
+//
+// Config remains the serializable form; use [WithConfig] to start from one.
+func NewWithOptions(opts ...Option) (*Sandbox, error) {
+	var o options
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.env == nil {
+		return New(&o.cfg)
+	}
+
+	return NewWithEnvironment(&o.cfg, *o.env)
+}
+
+// WithConfig replaces the configuration built so far with a copy of cfg.
+// Pass it first to layer further options on top of a loaded Config.
+func WithConfig(cfg Config) Option {
+	return func(o *options) {
+		o.cfg = cloneConfig(&cfg)
+	}
+}
+
+// WithEnvironment sets the environment, as in [NewWithEnvironment].
+func WithEnvironment(env Environment) Option {
+	return func(o *options) {
+		env = cloneEnvironment(env)
+		o.env = &env
+	}
+}
+
+// WithPresets appends to [Filesystem.Presets]. Calling it with no presets
+// selects none: the result is an empty, non-nil list instead of the "@all"
+// default.
+func WithPresets(presets ...string) Option {
+	return func(o *options) {
+		if o.cfg.Filesystem.Presets == nil {
+			o.cfg.Filesystem.Presets = []string{}
+		}
+
+		o.cfg.Filesystem.Presets = append(o.cfg.Filesystem.Presets, presets...)
+	}
+}
+
+// WithMounts appends to [Filesystem.Mounts].
+func WithMounts(mounts ...Mount) Option {
+	return func(o *options) {
+		o.cfg.Filesystem.Mounts = append(o.cfg.Filesystem.Mounts, mounts...)
+	}
+}
+
+// WithBlock appends to [Commands.Block].
+func WithBlock(commands ...string) Option {
+	return func(o *options) {
+		o.cfg.Commands.Block = append(o.cfg.Commands.Block, commands...)
+	}
+}
+
+// WithWrapper sets the wrapper for command in [Commands.Wrappers].
+func WithWrapper(command string, wrapper Wrapper) Option {
+	return func(o *options) {
+		if o.cfg.Commands.Wrappers == nil {
+			o.cfg.Commands.Wrappers = make(map[string]Wrapper)
+		}
+
+		o.cfg.Commands.Wrappers[command] = wrapper
+	}
+}
+
+// WithLauncher sets [Commands.Launcher], required by [WithBlock] and
+// [WithWrapper].
+func WithLauncher(path string) Option {
+	return func(o *options) {
+		o.cfg.Commands.Launcher = path
+	}
+}
+
+// WithNetwork sets [Config.Network].
+func WithNetwork(enabled bool) Option {
+	return func(o *options) {
+		o.cfg.Network = &enabled
+	}
+}
+
+// WithNetworkAllow sets [Config.NetworkAllow].
+func WithNetworkAllow(destinations ...string) Option {
+	return func(o *options) {
+		o.cfg.NetworkAllow = destinations
+	}
+}
+
+// WithDocker sets [Config.Docker].
+func WithDocker(enabled bool) Option {
+	return func(o *options) {
+		o.cfg.Docker = &enabled
+	}
+}
+
+// WithBaseFS sets [Config.BaseFS].
+func WithBaseFS(baseFS BaseFS) Option {
+	return func(o *options) {
+		o.cfg.BaseFS = baseFS
+	}
+}
+
+// WithIdentity sets [Config.Identity], the HOME, USER/LOGNAME and SHELL
+// seen by sandboxed commands.
+func WithIdentity(identity Identity) Option {
+	return func(o *options) {
+		o.cfg.Identity = identity
+	}
+}
@@ -0,0 +1,410 @@
+//go:build linux
+
+package sandbox
+
+// This file implements crash-safe cleanup of host temp directories (see
+// [ReapOrphans]).
+//
+// Audit and restricted mode shims, fifos, the network proxy socket and the
+// ro-bind-data fallback for old bwrap versions live in host temp directories
+// that are removed by the cleanup returned with each command. If the
+// embedding process is SIGKILLed, that cleanup never runs. Each directory is
+// therefore recorded in a manifest under the XDG runtime directory, together
+// with the PID and start time of the process that created it, and is reaped
+// once that process is gone.
+//
+// The directories back mounts that a sandboxed process, running as the same
+// user, must not change, so they are created in a private root (see
+// [hostTempRoot]) that the planner masks wherever a bind would expose it.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// hostTempDirPrefix prefixes the names of all host temp directories. Manifest
+// entries for other paths are never reaped.
+const hostTempDirPrefix = "agent-sandbox-"
+
+// reapOrphansOnce reaps orphaned temp directories on the first construction
+// in a process.
+var reapOrphansOnce sync.Once
+
+// artifactRecord is a manifest entry for a host temp directory.
+type artifactRecord struct {
+	Path string `json:"path"`
+	PID  int    `json:"pid"`
+
+	// Start is the start time of PID in clock ticks since boot
+	// (/proc/PID/stat field 22), so a reused PID is not mistaken for the
+	// owner. Zero skips the check.
+	Start uint64 `json:"start,omitempty"`
+}
+
+// ReapOrphans removes host temp directories left behind by processes that
+// exited without running the cleanup of their commands, for example because
+// they were SIGKILLed. It returns the removed directories.
+//
+// The manifest lives in $XDG_RUNTIME_DIR/agent-sandbox/artifacts. If
+// XDG_RUNTIME_DIR is unset, temp directories are not recorded and nothing is
+// reaped: the fallback in the temp directory may be writable by sandboxes
+// that bind it, which could plant entries. Only directories directly in
+// the root the package creates them in are removed, and directories of
+// running processes are kept. [NewWithEnvironment] calls ReapOrphans once
+// per process and ignores its errors.
+func ReapOrphans() ([]string, error) {
+	return reapOrphans(false)
+}
+
+// reapOrphans implements [ReapOrphans]. With dryRun, it only reports the
+// directories it would remove.
+func reapOrphans(dryRun bool) ([]string, error) {
+	if _, inRuntimeDir := hostTempRoot(); !inRuntimeDir {
+		return nil, nil
+	}
+
+	dir := artifactManifestDir()
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: reaping orphans: %w", err)
+	}
+
+	err = checkPrivateDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: reaping orphans: %w", err)
+	}
+
+	var (
+		reaped []string
+		errs   []error
+	)
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		entryPath := filepath.Join(dir, entry.Name())
+
+		data, err := os.ReadFile(entryPath)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		var record artifactRecord
+
+		// A malformed entry (for example from a write cut short by the
+		// crash) is dropped; it cannot name anything to reap.
+		if json.Unmarshal(data, &record) == nil && processAlive(record.PID, record.Start) {
+			continue
+		}
+
+		if dryRun {
+			if isHostTempDir(record.Path) {
+				reaped = append(reaped, record.Path)
+			}
+
+			continue
+		}
+
+		if isHostTempDir(record.Path) {
+			err = os.RemoveAll(record.Path)
+			if err != nil {
+				errs = append(errs, err)
+
+				continue
+			}
+
+			reaped = append(reaped, record.Path)
+		}
+
+		err = os.Remove(entryPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return reaped, fmt.Errorf("sandbox: reaping orphans: %w", errors.Join(errs...))
+	}
+
+	return reaped, nil
+}
+
+// reapOrphansAtConstruction runs [ReapOrphans] on the first call in a process
+// and reports the result to debugf.
+func reapOrphansAtConstruction(debugf Debugf) {
+	reapOrphansOnce.Do(func() {
+		reaped, err := ReapOrphans()
+		if debugf == nil {
+			return
+		}
+
+		if len(reaped) > 0 {
+			debugf("sandbox(reap): removed orphaned temp directories %q", reaped)
+		}
+
+		if err != nil {
+			debugf("sandbox(reap): %v", err)
+		}
+	})
+}
+
+// newHostTempDir creates a host temp directory named after pattern (see
+// [os.MkdirTemp]) and records it in the manifest. The returned cleanup removes
+// the directory and its manifest entry.
+//
+// Recording is best effort: if the manifest cannot be written, the directory
+// is still created and only leaks if the process is killed.
+func newHostTempDir(pattern string) (string, func() error, error) {
+	root, err := ensureHostTempRoot()
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := os.MkdirTemp(root, hostTempDirPrefix+pattern)
+	if err != nil {
+		return "", nil, err
+	}
+
+	entryPath := recordHostTempDir(dir)
+
+	cleanup := func() error {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			return err
+		}
+
+		if entryPath != "" {
+			err = os.Remove(entryPath)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return dir, cleanup, nil
+}
+
+// recordHostTempDir writes the manifest entry for dir and returns its path,
+// or "" if it could not be written or XDG_RUNTIME_DIR is unset.
+func recordHostTempDir(dir string) string {
+	if _, inRuntimeDir := hostTempRoot(); !inRuntimeDir {
+		return ""
+	}
+
+	manifestDir := artifactManifestDir()
+
+	err := os.MkdirAll(manifestDir, 0o700)
+	if err != nil || checkPrivateDir(manifestDir) != nil {
+		return ""
+	}
+
+	pid := os.Getpid()
+	start, _ := procStartTime(pid)
+
+	data, err := json.Marshal(artifactRecord{Path: dir, PID: pid, Start: start})
+	if err != nil {
+		return ""
+	}
+
+	entryPath := filepath.Join(manifestDir, filepath.Base(dir)+".json")
+
+	err = os.WriteFile(entryPath, data, 0o600)
+	if err != nil {
+		return ""
+	}
+
+	return entryPath
+}
+
+// artifactManifestDir returns the directory holding the manifest entries.
+func artifactManifestDir() string {
+	root, _ := hostTempRoot()
+
+	return filepath.Join(root, "artifacts")
+}
+
+// hostTempRoot returns the directory that holds the host temp directories
+// (and, in the XDG runtime directory, the manifest):
+// $XDG_RUNTIME_DIR/agent-sandbox, or agent-sandbox-<uid> in the temp
+// directory if XDG_RUNTIME_DIR is unset. inRuntimeDir reports the
+// former. The sandbox mounts a tmpfs on /run, so the XDG runtime directory is
+// only visible through explicit mounts.
+func hostTempRoot() (dir string, inRuntimeDir bool) {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" || !filepath.IsAbs(runtimeDir) {
+		return filepath.Join(os.TempDir(), hostTempDirPrefix+strconv.Itoa(os.Getuid())), false
+	}
+
+	return filepath.Join(runtimeDir, "agent-sandbox"), true
+}
+
+// ensureHostTempRoot creates [hostTempRoot] with mode 0700 if it is missing
+// and checks that it is private.
+func ensureHostTempRoot() (string, error) {
+	root, _ := hostTempRoot()
+
+	err := os.MkdirAll(root, 0o700)
+	if err != nil {
+		return "", err
+	}
+
+	err = checkPrivatePath(root)
+	if err != nil {
+		return "", err
+	}
+
+	return root, nil
+}
+
+// checkPrivateDir checks that dir and its parent are directories owned by the
+// current user and not writable by others, so that manifest entries cannot be
+// planted in a shared temp directory.
+func checkPrivateDir(dir string) error {
+	for _, path := range []string{filepath.Dir(dir), dir} {
+		err := checkPrivatePath(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPrivatePath checks that path is a directory, not a symlink, owned by
+// the current user and not writable by others.
+func checkPrivatePath(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Getuid() || info.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%s is not a private directory", path)
+	}
+
+	return nil
+}
+
+// maskHostTempRoot mounts a tmpfs over [hostTempRoot] wherever a planned
+// bind exposes it, so that sandboxed processes cannot rewrite the files
+// behind the mounts of their own command or reach the sockets of other
+// commands. The root is created if it is missing, since bwrap needs a mount
+// point; if it cannot be created, no host temp directory can be either.
+func (p *planner) maskHostTempRoot() error {
+	root, err := ensureHostTempRoot()
+	if err != nil {
+		p.debugf("host temp root: %v", err)
+
+		return nil
+	}
+
+	for _, mnt := range slices.Clone(p.plan.mounts) {
+		switch mnt.Kind {
+		case MountRoBind, MountRoBindTry, MountBind, MountBindTry:
+		default:
+			continue
+		}
+
+		rel, err := filepath.Rel(mnt.Src, root)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		dst := filepath.Join(mnt.Dst, rel)
+
+		covering, _, ok := p.coveringBindMount(dst)
+		if !ok || covering.Kind != mnt.Kind || covering.Src != mnt.Src || covering.Dst != mnt.Dst {
+			continue
+		}
+
+		p.debugf("host temp root %q is visible at %q; masking", root, dst)
+
+		err = p.appendMount(Tmpfs(dst))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isHostTempDir reports whether path is a directory created by
+// [newHostTempDir], directly in [hostTempRoot], and owned by the current
+// user.
+func isHostTempDir(path string) bool {
+	root, _ := hostTempRoot()
+	if !filepath.IsAbs(path) || filepath.Dir(path) != root || !strings.HasPrefix(filepath.Base(path), hostTempDirPrefix) {
+		return false
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	return ok && int(stat.Uid) == os.Getuid()
+}
+
+// processAlive reports whether the process pid exists and, if start is
+// non-zero, started at start. A process whose state cannot be read counts as
+// alive.
+func processAlive(pid int, start uint64) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	got, err := procStartTime(pid)
+	if err != nil {
+		return !errors.Is(err, fs.ErrNotExist)
+	}
+
+	return start == 0 || got == start
+}
+
+// procStartTime returns the start time of pid in clock ticks since boot.
+func procStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name (field 2) is parenthesized and may contain spaces
+	// and parentheses; the fields after the last ')' start with field 3.
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+
+	fields := strings.Fields(string(data[end+1:]))
+
+	const startTimeField = 22 - 3
+	if len(fields) <= startTimeField {
+		return 0, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+
+	return strconv.ParseUint(fields[startTimeField], 10, 64)
+}
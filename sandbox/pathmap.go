@@ -0,0 +1,85 @@
+//go:build linux
+
+package sandbox
+
+// This file implements translation between host and sandbox paths
+// ([Sandbox.HostToSandboxPath] and [Sandbox.SandboxToHostPath]).
+//
+// Translation is lexical and uses the planned mounts: a path is mapped through
+// the bind mount that covers it, and a host path is only visible at a sandbox
+// path whose covering mount is that bind (a later mount may shadow it).
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// SandboxToHostPath returns the host path behind the sandbox path p. Relative
+// paths are relative to [Environment.WorkDir].
+//
+// Symlinks are not resolved. Paths that are not backed by a host bind mount
+// (excluded paths, sandbox tmpfs, injected files, /dev and /proc) return an
+// error wrapping [fs.ErrPermission].
+func (s *Sandbox) SandboxToHostPath(p string) (string, error) {
+	if s == nil || s.v == nil || s.plan == nil {
+		return "", errors.New("sandbox: uninitialized sandbox (use New or NewWithEnvironment)")
+	}
+
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(s.v.env.WorkDir, p)
+	}
+
+	hostPath, _, err := s.hostPath(filepath.Clean(p))
+	if err != nil {
+		return "", fmt.Errorf("sandbox: translating %s: %w", p, err)
+	}
+
+	return hostPath, nil
+}
+
+// HostToSandboxPath returns the sandbox path at which the host path p is
+// visible, for example to map paths reported by an agent back and forth.
+// Relative paths are relative to [Environment.WorkDir].
+//
+// If p is mounted at several sandbox paths, the one from the latest mount
+// is returned. Host paths that are not visible in the sandbox (not mounted,
+// excluded, or shadowed by a later mount) return an error wrapping
+// [fs.ErrNotExist].
+func (s *Sandbox) HostToSandboxPath(p string) (string, error) {
+	if s == nil || s.v == nil || s.plan == nil {
+		return "", errors.New("sandbox: uninitialized sandbox (use New or NewWithEnvironment)")
+	}
+
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(s.v.env.WorkDir, p)
+	}
+
+	p = filepath.Clean(p)
+
+	for i := len(s.plan.mounts) - 1; i >= 0; i-- {
+		mnt := s.plan.mounts[i]
+
+		switch mnt.Kind {
+		case MountRoBind, MountRoBindTry, MountBind, MountBindTry:
+		default:
+			continue
+		}
+
+		rel, err := filepath.Rel(mnt.Src, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		candidate := filepath.Join(mnt.Dst, rel)
+
+		hostPath, _, err := s.hostPath(candidate)
+		if err == nil && hostPath == p {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("sandbox: translating %s: %w: not visible in the sandbox", p, fs.ErrNotExist)
+}
@@ -0,0 +1,147 @@
+//go:build linux
+
+package sandbox
+
+// This file implements bind mount source pinning (see
+// [Config.PinMountSources]).
+//
+// Sources are resolved when the Sandbox is constructed, but bwrap opens them
+// only when a command starts. In between, a process that can write to a
+// parent directory could replace a component with a symlink and redirect the
+// mount. With pinning, the planner records the identity (device and inode) of
+// each resolved source, and Command() opens the source without following
+// symlinks, checks the identity, and hands bwrap the open O_PATH FD via
+// --bind-fd/--ro-bind-fd, so the checked file is the one that gets mounted.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// bwrapBindFDVersion is the first bubblewrap release with --bind-fd and
+// --ro-bind-fd.
+var bwrapBindFDVersion = [3]int{0, 8, 0}
+
+// pinnedSource is a bind mount whose source is pinned by Command().
+type pinnedSource struct {
+	// arg is the index of the bind flag in plan.bwrapArgs; the source and
+	// destination follow it.
+	arg int
+
+	// resolved is the source with symlinks resolved at planning time. It is
+	// empty if a -try source did not exist, in which case the mount is dropped.
+	resolved string
+
+	dev uint64
+	ino uint64
+}
+
+// pinSource records the identity of the source of the bind mount mnt, whose
+// arguments start at index arg.
+func (p *planner) pinSource(arg int, mnt Mount) error {
+	pin := pinnedSource{arg: arg}
+
+	resolved, err := filepath.EvalSymlinks(mnt.Src)
+	if err != nil {
+		isTry := mnt.Kind == MountRoBindTry || mnt.Kind == MountBindTry
+		if !isTry || !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("pinning mount source %q: %w", mnt.Src, err)
+		}
+
+		p.plan.pinned = append(p.plan.pinned, pin)
+
+		return nil
+	}
+
+	var st unix.Stat_t
+
+	err = unix.Stat(resolved, &st)
+	if err != nil {
+		return fmt.Errorf("pinning mount source %q: %w", mnt.Src, err)
+	}
+
+	pin.resolved = resolved
+	pin.dev = st.Dev
+	pin.ino = st.Ino
+	p.plan.pinned = append(p.plan.pinned, pin)
+
+	return nil
+}
+
+// pinnedArgs rewrites the pinned bind mounts in args to FD-based binds. The
+// returned files must be inherited starting at firstFD.
+func pinnedArgs(args []string, pinned []pinnedSource, firstFD int) ([]string, []*os.File, error) {
+	var files []*os.File
+
+	fail := func(err error) ([]string, []*os.File, error) {
+		for _, f := range files {
+			_ = f.Close()
+		}
+
+		return nil, nil, err
+	}
+
+	out := make([]string, 0, len(args))
+	next := 0
+
+	for _, pin := range pinned {
+		out = append(out, args[next:pin.arg]...)
+		next = pin.arg + 3
+
+		if pin.resolved == "" {
+			continue
+		}
+
+		f, err := openPinned(pin)
+		if err != nil {
+			return fail(err)
+		}
+
+		files = append(files, f)
+
+		flag := "--bind-fd"
+		if args[pin.arg] == "--ro-bind" || args[pin.arg] == "--ro-bind-try" {
+			flag = "--ro-bind-fd"
+		}
+
+		out = append(out, flag, strconv.Itoa(firstFD+len(files)-1), args[pin.arg+2])
+	}
+
+	out = append(out, args[next:]...)
+
+	return out, files, nil
+}
+
+// openPinned opens pin.resolved as O_PATH without following symlinks in any
+// component and checks that it is still the planned file.
+func openPinned(pin pinnedSource) (*os.File, error) {
+	fd, err := unix.Openat2(unix.AT_FDCWD, pin.resolved, &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_NO_SYMLINKS,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: pinning mount source %q (requires Linux 5.6): %w", pin.resolved, err)
+	}
+
+	var st unix.Stat_t
+
+	err = unix.Fstat(fd, &st)
+	if err != nil {
+		_ = unix.Close(fd)
+
+		return nil, fmt.Errorf("sandbox: pinning mount source %q: %w", pin.resolved, err)
+	}
+
+	if st.Dev != pin.dev || st.Ino != pin.ino {
+		_ = unix.Close(fd)
+
+		return nil, fmt.Errorf("sandbox: mount source %q changed since the sandbox was created", pin.resolved)
+	}
+
+	return os.NewFile(uintptr(fd), pin.resolved), nil
+}
@@ -0,0 +1,134 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// pipelineShell runs the generated driver script inside the sandbox.
+const pipelineShell = "/bin/sh"
+
+// PipelineCmd is an unstarted command that runs several steps inside one
+// sandbox. It embeds the underlying [exec.Cmd]; callers use Run/Start/Wait as
+// usual and then inspect per-step results via [PipelineCmd.ExitCodes].
+type PipelineCmd struct {
+	*exec.Cmd
+
+	steps  int
+	status *os.File
+}
+
+// Pipeline constructs an unstarted command that runs steps in order inside a
+// single bwrap invocation, stopping at the first step that exits non-zero.
+//
+// Each step is an argv, exactly as passed to [Sandbox.Command]. Steps share
+// the sandbox, working directory and stdio of the returned command. The exit
+// status of the command is the exit status of the last step that ran.
+//
+// The returned cleanup function must be called to release resources. Call
+// [PipelineCmd.ExitCodes] before cleanup.
+func (s *Sandbox) Pipeline(ctx context.Context, steps [][]string) (*PipelineCmd, func() error, error) {
+	noop := func() error { return nil }
+
+	if len(steps) == 0 {
+		return nil, noop, errors.New("sandbox: no pipeline steps provided")
+	}
+
+	for i, step := range steps {
+		if len(step) == 0 {
+			return nil, noop, fmt.Errorf("sandbox: pipeline step %d has no command", i)
+		}
+	}
+
+	status, err := newAnonymousFile("sandbox-pipeline-status")
+	if err != nil {
+		return nil, noop, fmt.Errorf("sandbox: create pipeline status file: %w", err)
+	}
+
+	closeStatus := closeFilesOnce([]*os.File{status})
+
+	argv := []string{pipelineShell, "-c", pipelineScript(steps, firstExtraFD), "agent-sandbox-pipeline"}
+
+	cmd, cleanup, err := s.command(ctx, argv, []*os.File{status}, execOptions{})
+	if err != nil {
+		return nil, noop, errors.Join(err, closeStatus())
+	}
+
+	cleanupAll := func() error {
+		return errors.Join(cleanup(), closeStatus())
+	}
+
+	return &PipelineCmd{Cmd: cmd, steps: len(steps), status: status}, cleanupAll, nil
+}
+
+// ExitCodes returns the exit code of each step that ran, in step order. A
+// failing step is the last entry; steps after it did not run. Call after the
+// command has exited.
+func (p *PipelineCmd) ExitCodes() ([]int, error) {
+	if p == nil || p.status == nil {
+		return nil, errors.New("sandbox: uninitialized pipeline (use Sandbox.Pipeline)")
+	}
+
+	_, err := p.status.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: rewind pipeline status: %w", err)
+	}
+
+	codes := make([]int, 0, p.steps)
+
+	scanner := bufio.NewScanner(p.status)
+	for scanner.Scan() {
+		code, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: parse pipeline status line %d: %w", len(codes), err)
+		}
+
+		codes = append(codes, code)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: read pipeline status: %w", err)
+	}
+
+	if len(codes) > p.steps {
+		return nil, internalErrorf("PipelineCmd.ExitCodes", "got %d status lines for %d steps", len(codes), p.steps)
+	}
+
+	return codes, nil
+}
+
+// pipelineScript returns a POSIX sh driver that runs steps in order, writes
+// one exit code per line to statusFD, and exits on the first failure.
+func pipelineScript(steps [][]string, statusFD int) string {
+	var b strings.Builder
+
+	for _, step := range steps {
+		quoted := make([]string, len(step))
+		for i, arg := range step {
+			quoted[i] = shellQuote(arg)
+		}
+
+		// Steps don't inherit the status FD.
+		b.WriteString(strings.Join(quoted, " "))
+		fmt.Fprintf(&b, " %d>&-\nrc=$?\n", statusFD)
+		fmt.Fprintf(&b, "echo \"$rc\" >&%d\n", statusFD)
+		b.WriteString("[ \"$rc\" -eq 0 ] || exit \"$rc\"\n")
+	}
+
+	return b.String()
+}
+
+// shellQuote quotes s as a single POSIX sh word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
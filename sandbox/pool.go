@@ -0,0 +1,166 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Pool].
+//
+// A Sandbox is already reusable and safe for concurrent use; construction is
+// the expensive part (preset expansion, globbing, symlink resolution, wrapper
+// discovery, launcher hashing). A Pool constructs sandboxes up front and
+// rebuilds them according to a recycling policy, so servers running many
+// short commands never construct on the request path and still pick up host
+// changes (new paths, a replaced launcher) eventually.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PoolOptions configures a [Pool].
+type PoolOptions struct {
+	// Size is the number of sandboxes constructed up front and the maximum
+	// number leased at once. Must be positive.
+	Size int
+
+	// MaxUses, if positive, rebuilds a sandbox after it was acquired this
+	// many times.
+	MaxUses int
+
+	// MaxAge, if positive, rebuilds a sandbox constructed longer ago than
+	// this when it is acquired.
+	MaxAge time.Duration
+}
+
+// Pool hands out pre-constructed sandboxes with the same Config and
+// Environment.
+//
+// On [Pool.Acquire] each sandbox is health checked: a sandbox whose launcher
+// binary changed on disk (see [ErrTampered]) or that exceeds MaxUses or
+// MaxAge is rebuilt before it is returned. A Pool is safe for concurrent use.
+type Pool struct {
+	cfg  Config
+	env  Environment
+	opts PoolOptions
+
+	idle chan *poolEntry
+
+	mu     sync.Mutex
+	leased map[*Sandbox]*poolEntry
+}
+
+type poolEntry struct {
+	sb      *Sandbox
+	created time.Time
+	uses    int
+}
+
+// NewPool constructs opts.Size sandboxes from cfg and env. cfg and env are
+// deep-copied; errors are those of [NewWithEnvironment].
+func NewPool(cfg *Config, env Environment, opts PoolOptions) (*Pool, error) {
+	if opts.Size <= 0 {
+		return nil, fmt.Errorf("sandbox: pool size %d is not positive", opts.Size)
+	}
+
+	p := &Pool{
+		cfg:    cloneConfig(cfg),
+		env:    cloneEnvironment(env),
+		opts:   opts,
+		idle:   make(chan *poolEntry, opts.Size),
+		leased: make(map[*Sandbox]*poolEntry),
+	}
+
+	for range opts.Size {
+		entry := &poolEntry{}
+
+		err := p.rebuild(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		p.idle <- entry
+	}
+
+	return p, nil
+}
+
+// Acquire returns an idle sandbox, waiting until one is released or ctx is
+// done. The sandbox must be returned with [Pool.Release].
+//
+// If the sandbox has to be rebuilt and construction fails, the error is
+// returned and the slot stays in the pool; a later Acquire retries.
+func (p *Pool) Acquire(ctx context.Context) (*Sandbox, error) {
+	var entry *poolEntry
+
+	select {
+	case entry = <-p.idle:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if p.needsRebuild(entry) {
+		err := p.rebuild(entry)
+		if err != nil {
+			p.idle <- entry
+
+			return nil, err
+		}
+	}
+
+	entry.uses++
+
+	p.mu.Lock()
+	p.leased[entry.sb] = entry
+	p.mu.Unlock()
+
+	return entry.sb, nil
+}
+
+// Release returns sb, obtained from [Pool.Acquire], to the pool. Releasing a
+// sandbox that is not leased from p returns an error.
+func (p *Pool) Release(sb *Sandbox) error {
+	p.mu.Lock()
+	entry, ok := p.leased[sb]
+	delete(p.leased, sb)
+	p.mu.Unlock()
+
+	if !ok {
+		return errors.New("sandbox: releasing a sandbox not leased from this pool")
+	}
+
+	p.idle <- entry
+
+	return nil
+}
+
+func (p *Pool) needsRebuild(entry *poolEntry) bool {
+	switch {
+	case entry.sb == nil:
+		return true
+	case p.opts.MaxUses > 0 && entry.uses >= p.opts.MaxUses:
+		return true
+	case p.opts.MaxAge > 0 && time.Since(entry.created) > p.opts.MaxAge:
+		return true
+	case entry.sb.launcher != nil && entry.sb.launcher.verify() != nil:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Pool) rebuild(entry *poolEntry) error {
+	sb, err := NewWithEnvironment(&p.cfg, p.env)
+	if err != nil {
+		entry.sb = nil
+
+		return err
+	}
+
+	entry.sb = sb
+	entry.created = time.Now()
+	entry.uses = 0
+
+	return nil
+}
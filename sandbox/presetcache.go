@@ -0,0 +1,62 @@
+//go:build linux
+
+package sandbox
+
+// This file implements the static preset cache (see
+// [Filesystem.CachePresets]).
+
+import (
+	"slices"
+	"sync"
+)
+
+// presetCacheKey identifies the expansion of one static preset.
+type presetCacheKey struct {
+	name     string
+	readOnly bool
+	homeDir  string
+	workDir  string
+}
+
+// presetCacheEntry is a cached static preset expansion. empty records whether
+// the preset matched nothing on the host when it was expanded.
+type presetCacheEntry struct {
+	mounts []Mount
+	empty  bool
+}
+
+var presetCache struct {
+	mu      sync.Mutex
+	entries map[presetCacheKey]presetCacheEntry
+}
+
+// InvalidatePresetCache drops all static preset expansions cached for
+// [Filesystem.CachePresets], so the next construction probes the host again.
+// Call it after creating or removing paths that presets cover (for example
+// ~/.cache or a linter config in the work directory).
+func InvalidatePresetCache() {
+	presetCache.mu.Lock()
+	defer presetCache.mu.Unlock()
+
+	presetCache.entries = nil
+}
+
+// cachedPreset returns the cached expansion for key, computing and storing it
+// with build on a miss. The returned mounts are a copy.
+func cachedPreset(key presetCacheKey, build func() presetCacheEntry) presetCacheEntry {
+	presetCache.mu.Lock()
+	defer presetCache.mu.Unlock()
+
+	entry, ok := presetCache.entries[key]
+	if !ok {
+		entry = build()
+
+		if presetCache.entries == nil {
+			presetCache.entries = make(map[presetCacheKey]presetCacheEntry)
+		}
+
+		presetCache.entries[key] = entry
+	}
+
+	return presetCacheEntry{mounts: slices.Clone(entry.mounts), empty: entry.empty}
+}
@@ -7,17 +7,196 @@ package sandbox
 // Presets are convenience bundles of filesystem policy mounts (RO/RW/Exclude)
 // that approximate common "developer sandbox" needs. Presets never emit direct
 // mounts; all output is expressed as policy mounts and then resolved against the
-// host filesystem by the planner.
+// host filesystem by the planner. The planner also gives @browser a /dev/shm
+// tmpfs (see [browserDev]).
 //
 // Presets are applied in a fixed order for determinism.
 
 import (
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
+// PresetInfo describes a built-in filesystem preset, as accepted in
+// [Filesystem.Presets].
+type PresetInfo struct {
+	// Name is the preset name including the "@" prefix.
+	Name string
+
+	// Description is a one-line summary of what the preset mounts.
+	Description string
+
+	// Includes lists the presets a macro expands to. It is nil for presets
+	// that are not macros.
+	Includes []string
+
+	// Default reports whether the preset is enabled when no presets are
+	// configured (via @all).
+	Default bool
+}
+
+// presetCatalog lists all built-in presets in documentation order.
+var presetCatalog = []PresetInfo{
+	{
+		Name:        "@all",
+		Description: "Everything: @base, @caches, @agents, @git, @lint/all",
+		Includes:    []string{"@base", "@caches", "@agents", "@git", "@lint/all"},
+		Default:     true,
+	},
+	{
+		Name:        "@base",
+		Description: "Working directory writable, home read-only, ~/.ssh, ~/.gnupg and ~/.aws excluded",
+		Default:     true,
+	},
+	{
+		Name:        "@caches",
+		Description: "Build tool caches writable (~/.cache, ~/.bun, ~/go, ~/.npm, ~/.cargo)",
+		Default:     true,
+	},
+	{
+		Name:        "@agents",
+		Description: "All coding agent presets combined",
+		Includes:    []string{"@agents/claude", "@agents/codex", "@agents/pi"},
+		Default:     true,
+	},
+	{
+		Name:        "@agents/claude",
+		Description: "Claude Code state writable (~/.claude, ~/.claude.json, ~/.config/claude)",
+		Default:     true,
+	},
+	{
+		Name:        "@agents/codex",
+		Description: "Codex state writable (~/.codex)",
+		Default:     true,
+	},
+	{
+		Name:        "@agents/pi",
+		Description: "Pi state writable (~/.pi)",
+		Default:     true,
+	},
+	{
+		Name:        "@git",
+		Description: "Git hooks, config and credential helpers protected, with worktree support",
+		Default:     true,
+	},
+	{
+		Name:        "@git-strict",
+		Description: "Like @git, plus tags and non-current branch refs read-only",
+	},
+	{
+		Name:        "@repo-toolchains",
+		Description: "In-repo toolchain caches writable (.gradle, .venv, .tox, bazel-*), toolchain pins and lockfiles read-only",
+	},
+	{
+		Name:        "@gitignore-secrets",
+		Description: "Ignored files that look like secrets (.env*, *.pem, *key*, credentials*) in the working directory's .gitignore excluded",
+	},
+	{
+		Name:        "@browser",
+		Description: "Headless browsers: Playwright and Puppeteer browser caches and the fontconfig cache writable, fonts read-only, /dev/shm mounted",
+	},
+	{
+		Name:        "@lint/all",
+		Description: "All lint presets combined",
+		Includes:    []string{"@lint/ts", "@lint/go", "@lint/python"},
+		Default:     true,
+	},
+	{
+		Name:        "@lint/ts",
+		Description: "TypeScript/JavaScript lint configs read-only (biome, eslint, prettier, tsconfig)",
+		Default:     true,
+	},
+	{
+		Name:        "@lint/go",
+		Description: "Go lint configs read-only (golangci)",
+		Default:     true,
+	},
+	{
+		Name:        "@lint/python",
+		Description: "Python lint configs read-only (ruff, flake8, mypy, pylint, pyproject.toml)",
+		Default:     true,
+	},
+}
+
+// Presets returns the built-in filesystem presets, followed by the presets of
+// registered bundles (see [RegisterBundle]). The returned slice is a copy and
+// may be modified by the caller.
+func Presets() []PresetInfo {
+	out := make([]PresetInfo, len(presetCatalog))
+
+	for i, p := range presetCatalog {
+		p.Includes = slices.Clone(p.Includes)
+		out[i] = p
+	}
+
+	return append(out, bundlePresets()...)
+}
+
+// Composite reports whether the preset is a macro that expands to other
+// presets (see Includes).
+func (p PresetInfo) Composite() bool {
+	return len(p.Includes) > 0
+}
+
+// PresetExample is a built-in preset with the policy mounts it expands to in
+// a particular environment.
+type PresetExample struct {
+	PresetInfo
+
+	// Mounts are the policy mounts (RO/RW/Exclude and their Try variants) the
+	// preset contributes, in application order, with "~" and relative paths
+	// resolved against the environment. Glob patterns are kept. Paths that
+	// do not exist are included; planning skips or rejects them.
+	Mounts []Mount
+}
+
+// PresetExamples returns every built-in preset, as [Presets] does, together
+// with the mounts it expands to for env. Presets that probe the host (@git,
+// @git-strict) inspect env.WorkDir, so the examples reflect that checkout.
+//
+// It is meant for help output, generated documentation and configuration
+// UIs, which should not duplicate the preset registry.
+func PresetExamples(env Environment) ([]PresetExample, error) {
+	err := env.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: %w", err)
+	}
+
+	paths := newPathResolver(env)
+	presets := Presets()
+	out := make([]PresetExample, 0, len(presets))
+
+	for _, info := range presets {
+		mounts, _, err := expandPresets([]string{"!@all", info.Name}, env, false)
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: expanding preset %s: %w", info.Name, err)
+		}
+
+		for i := range mounts {
+			mounts[i].Dst = paths.Resolve(mounts[i].Dst)
+		}
+
+		out = append(out, PresetExample{PresetInfo: info, Mounts: mounts})
+	}
+
+	return out, nil
+}
+
+// presetIncludes returns the presets a macro expands to, or nil.
+func presetIncludes(name string) []string {
+	for _, p := range presetCatalog {
+		if p.Name == name {
+			return p.Includes
+		}
+	}
+
+	return nil
+}
+
 // expandPresets expands preset toggles into policy mounts.
 //
 // Supported presets:
@@ -25,75 +204,181 @@ import (
 //   - @base
 //   - @caches
 //   - @agents
+//   - @agents/claude
+//   - @agents/codex
+//   - @agents/pi
 //   - @git
 //   - @git-strict
+//   - @repo-toolchains
+//   - @gitignore-secrets
+//   - @browser
 //   - @lint/all
 //   - @lint/ts
 //   - @lint/go
 //   - @lint/python
 //
+// Presets of registered bundles (see [RegisterBundle]) are accepted by their
+// namespaced name, such as "@acme/strict".
+//
 // Presets can be negated by prefixing with '!'. For example, []string{"!@all"}
 // disables all defaults.
 //
+// The agent presets (@agents and @agents/*) accept a ":ro" suffix that mounts
+// the agent state read-only instead of writable, or ":rw" for the default.
+// For example, []string{"@agents/codex:ro"} keeps other agents writable but
+// only lets them read Codex state.
+//
 // Note: A nil preset slice means "defaults"; use an explicit empty slice
 // (or "!@all") to request no presets.
-func expandPresets(presets []string, env Environment) ([]Mount, error) {
-	enabled, err := resolvePresetToggles(presets)
+//
+// empty lists the enabled presets whose mounts match nothing on the host (for
+// example @git outside a repository), in expansion order. Macros are reported
+// by their underlying preset names.
+func expandPresets(presets []string, env Environment, cache bool) (mounts []Mount, empty []string, err error) {
+	enabled, readOnly, err := resolvePresetToggles(presets)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Emit preset mounts in a fixed order for determinism.
-	var mounts []Mount
+	paths := newPathResolver(env)
+
+	add := func(name string, presetMounts ...Mount) {
+		if !anyMountExists(presetMounts, paths) {
+			empty = append(empty, name)
+		}
+
+		mounts = append(mounts, presetMounts...)
+	}
+
+	// addStatic adds a preset whose mounts depend only on name, HomeDir and
+	// WorkDir, using the preset cache when enabled.
+	addStatic := func(name string, readOnly bool, build func() []Mount) {
+		if !cache {
+			add(name, build()...)
+
+			return
+		}
+
+		entry := cachedPreset(presetCacheKey{name: name, readOnly: readOnly, homeDir: env.HomeDir, workDir: env.WorkDir}, func() presetCacheEntry {
+			presetMounts := build()
+
+			return presetCacheEntry{mounts: presetMounts, empty: !anyMountExists(presetMounts, paths)}
+		})
+
+		if entry.empty {
+			empty = append(empty, name)
+		}
 
+		mounts = append(mounts, entry.mounts...)
+	}
+
+	// Emit preset mounts in a fixed order for determinism.
 	if enabled["@base"] {
-		mounts = append(mounts,
-			RW(env.WorkDir),
-			RO(env.HomeDir),
-			ExcludeTry("~/.ssh"),
-			ExcludeTry("~/.gnupg"),
-			ExcludeTry("~/.aws"),
-		)
+		addStatic("@base", false, func() []Mount {
+			return []Mount{
+				RW(env.WorkDir),
+				RO(env.HomeDir),
+				ExcludeTry("~/.ssh"),
+				ExcludeTry("~/.gnupg"),
+				ExcludeTry("~/.aws"),
+			}
+		})
 	}
 
 	if enabled["@caches"] {
-		mounts = append(mounts,
-			RWTry("~/.cache"),
-			RWTry("~/.bun"),
-			RWTry("~/go"),
-			RWTry("~/.npm"),
-			RWTry("~/.cargo"),
-		)
+		addStatic("@caches", false, func() []Mount {
+			return []Mount{
+				RWTry("~/.cache"),
+				RWTry("~/.bun"),
+				RWTry("~/go"),
+				RWTry("~/.npm"),
+				RWTry("~/.cargo"),
+			}
+		})
 	}
 
-	if enabled["@agents"] {
-		mounts = append(mounts,
-			RWTry("~/.codex"),
-			RWTry("~/.claude"),
-			RWTry("~/.claude.json"),
-			RWTry("~/.pi"),
-		)
+	for _, name := range presetIncludes("@agents") {
+		if !enabled[name] {
+			continue
+		}
+
+		addStatic(name, readOnly[name], func() []Mount {
+			mount := RWTry
+			if readOnly[name] {
+				mount = ROTry
+			}
+
+			var agentMounts []Mount
+			for _, path := range agentStatePaths[name] {
+				agentMounts = append(agentMounts, mount(path))
+			}
+
+			return agentMounts
+		})
 	}
 
 	if enabled["@git"] || enabled["@git-strict"] {
 		gitMounts, err := gitPresetRules(env.WorkDir, enabled["@git-strict"])
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		credentialMounts, err := gitCredentialHelperRules(env)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := "@git"
+		if enabled["@git-strict"] {
+			name = "@git-strict"
+		}
+
+		// Credential helpers are configured outside the repository, so
+		// they do not count towards whether the preset matches.
+		if !anyMountExists(gitMounts, paths) {
+			empty = append(empty, name)
 		}
 
-		mounts = append(mounts, gitMounts...)
+		mounts = append(mounts, append(gitMounts, credentialMounts...)...)
+	}
+
+	if enabled["@repo-toolchains"] {
+		addStatic("@repo-toolchains", false, func() []Mount { return repoToolchainMounts(env.WorkDir) })
+	}
+
+	if enabled["@gitignore-secrets"] {
+		// Not cached: the mounts depend on the content of .gitignore.
+		secretMounts, err := gitignoreSecretMounts(env.WorkDir)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		add("@gitignore-secrets", secretMounts...)
+	}
+
+	if enabled["@browser"] {
+		addStatic("@browser", false, func() []Mount {
+			return []Mount{
+				RWTry("~/.cache/ms-playwright"),
+				RWTry("~/.cache/puppeteer"),
+				RWTry("~/.cache/fontconfig"),
+				ROTry("~/.config/fontconfig"),
+				ROTry("~/.local/share/fonts"),
+				ROTry("~/.fonts"),
+			}
+		})
 	}
 
 	if enabled["@lint/ts"] {
-		mounts = append(mounts, lintTSMounts(env.WorkDir)...)
+		addStatic("@lint/ts", false, func() []Mount { return lintTSMounts(env.WorkDir) })
 	}
 
 	if enabled["@lint/go"] {
-		mounts = append(mounts, lintGoMounts(env.WorkDir)...)
+		addStatic("@lint/go", false, func() []Mount { return lintGoMounts(env.WorkDir) })
 	}
 
 	if enabled["@lint/python"] {
-		mounts = append(mounts, lintPythonMounts(env.WorkDir)...)
+		addStatic("@lint/python", false, func() []Mount { return lintPythonMounts(env.WorkDir) })
 	}
 
 	// Shared lint protection: .editorconfig is protected when any lint preset is enabled.
@@ -101,25 +386,75 @@ func expandPresets(presets []string, env Environment) ([]Mount, error) {
 		mounts = append(mounts, ROTry(filepath.Join(env.WorkDir, ".editorconfig")))
 	}
 
-	return mounts, nil
+	// Bundle presets come last, in registration order. They are not cached:
+	// their mounts may depend on anything in env.
+	names, bundles := enabledBundlePresets(enabled)
+	for i, bundle := range bundles {
+		presetMounts, isEmpty := bundleMounts(bundle, env, paths)
+		if isEmpty {
+			empty = append(empty, names[i])
+		}
+
+		mounts = append(mounts, presetMounts...)
+	}
+
+	return mounts, empty, nil
+}
+
+// browserDev returns the /dev setup for presets: with @browser enabled and no
+// [Config.Dev], /dev/shm gets its own tmpfs, since Chromium keeps its shared
+// memory there. An explicit Config.Dev wins. Preset errors are reported by
+// [expandPresets].
+func browserDev(presets []string) *Dev {
+	enabled, _, err := resolvePresetToggles(presets)
+	if err != nil || !enabled["@browser"] {
+		return nil
+	}
+
+	return &Dev{AllowTTY: true, AllowShm: true}
+}
+
+// anyMountExists reports whether at least one policy mount matches an existing
+// host path (or, for glob patterns, at least one path).
+func anyMountExists(mounts []Mount, paths pathResolver) bool {
+	for _, mnt := range mounts {
+		expanded := paths.Resolve(mnt.Dst)
+
+		if hasGlobMeta(expanded) {
+			matches, err := globIn(paths.hostFS, expanded)
+			if err == nil && len(matches) > 0 {
+				return true
+			}
+
+			continue
+		}
+
+		_, err := paths.hostFS.Lstat(expanded)
+		if err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// agentStatePaths lists the state paths of each agent preset. Both the
+// current and older layouts are listed; paths that do not exist are skipped.
+var agentStatePaths = map[string][]string{
+	"@agents/claude": {"~/.claude", "~/.claude.json", "~/.config/claude"},
+	"@agents/codex":  {"~/.codex"},
+	"@agents/pi":     {"~/.pi"},
 }
 
-// resolvePresetToggles computes the final enabled/disabled state for each preset.
+// resolvePresetToggles computes the final enabled/disabled state for each preset
+// and which agent presets are read-only.
 //
 // Toggle semantics are "last one wins". Macros like @all and @lint/all expand to
 // multiple underlying presets.
-func resolvePresetToggles(presets []string) (map[string]bool, error) {
-	known := map[string]bool{
-		"@all":         true,
-		"@base":        true,
-		"@caches":      true,
-		"@agents":      true,
-		"@git":         true,
-		"@git-strict":  true,
-		"@lint/all":    true,
-		"@lint/ts":     true,
-		"@lint/go":     true,
-		"@lint/python": true,
+func resolvePresetToggles(presets []string) (map[string]bool, map[string]bool, error) {
+	known := make(map[string]bool, len(presetCatalog))
+	for _, p := range Presets() {
+		known[p.Name] = true
 	}
 
 	// Default: @all enabled when presets are not specified.
@@ -130,11 +465,12 @@ func resolvePresetToggles(presets []string) (map[string]bool, error) {
 	}
 
 	state := make(map[string]bool)
+	readOnly := make(map[string]bool)
 
 	for _, name := range presets {
 		name = strings.TrimSpace(name)
 		if name == "" {
-			return nil, errors.New("unknown preset: empty preset name")
+			return nil, nil, errors.New("unknown preset: empty preset name")
 		}
 
 		enable := true
@@ -144,36 +480,153 @@ func resolvePresetToggles(presets []string) (map[string]bool, error) {
 			name = strings.TrimPrefix(name, "!")
 		}
 
+		base, access, hasAccess := strings.Cut(name, ":")
+		if hasAccess {
+			if !known[base] || (base != "@agents" && !strings.HasPrefix(base, "@agents/")) || (access != "ro" && access != "rw") || !enable {
+				return nil, nil, fmt.Errorf("unknown preset: %s (only enabled agent presets accept :ro or :rw)", name)
+			}
+
+			name = base
+		}
+
 		if !known[name] {
-			return nil, fmt.Errorf("unknown preset: %s", name)
+			return nil, nil, fmt.Errorf("unknown preset: %s", name)
 		}
 
 		switch name {
 		case "@all":
 			// @all expands to the default preset set.
-			for _, p := range []string{"@base", "@caches", "@agents", "@git", "@lint/all"} {
+			for _, p := range presetIncludes("@all") {
 				applyPresetMacro(state, p, enable)
 			}
 		default:
 			applyPresetMacro(state, name, enable)
 		}
+
+		if hasAccess {
+			targets := []string{name}
+			if name == "@agents" {
+				targets = presetIncludes(name)
+			}
+
+			for _, p := range targets {
+				readOnly[p] = access == "ro"
+			}
+		}
 	}
 
-	return state, nil
+	return state, readOnly, nil
 }
 
 // applyPresetMacro applies a toggle for a preset name, expanding macros.
 func applyPresetMacro(state map[string]bool, name string, enable bool) {
 	switch name {
-	case "@lint/all":
-		state["@lint/ts"] = enable
-		state["@lint/go"] = enable
-		state["@lint/python"] = enable
+	case "@lint/all", "@agents":
+		for _, p := range presetIncludes(name) {
+			state[p] = enable
+		}
 	default:
 		state[name] = enable
 	}
 }
 
+// repoToolchainMounts makes the caches of hermetic, in-repo toolchains
+// writable (also when the working directory itself is read-only) and
+// protects the files that pin the toolchain version or dependency set, so a
+// sandboxed build cannot swap the toolchain it runs next time.
+func repoToolchainMounts(workDir string) []Mount {
+	caches := []string{
+		".gradle",
+		".venv",
+		".tox",
+		"bazel-*",
+	}
+
+	pins := []string{
+		"gradle/wrapper/gradle-wrapper.properties",
+		"gradle/wrapper/gradle-wrapper.jar",
+		"gradle.lockfile",
+		".bazelversion",
+		"MODULE.bazel.lock",
+		".tool-versions",
+		".python-version",
+		"uv.lock",
+		"poetry.lock",
+		"Pipfile.lock",
+	}
+
+	out := make([]Mount, 0, len(caches)+len(pins))
+	for _, d := range caches {
+		out = append(out, RWTry(filepath.Join(workDir, d)))
+	}
+
+	for _, f := range pins {
+		out = append(out, ROTry(filepath.Join(workDir, f)))
+	}
+
+	return out
+}
+
+// gitignoreSecretPatterns are matched, case-insensitively, against the last
+// path component of each .gitignore entry by @gitignore-secrets.
+var gitignoreSecretPatterns = []string{".env*", "*.pem", "*key*", "credentials*"}
+
+// gitignoreSecretMounts returns ExcludeTry mounts for the entries of
+// workDir/.gitignore that look like secrets. Entries are resolved against
+// workDir, so patterns without a slash (which git matches at any depth) only
+// cover the top level. Negated entries and patterns that are not valid globs
+// are skipped. A missing .gitignore yields no mounts.
+func gitignoreSecretMounts(workDir string) ([]Mount, error) {
+	data, err := os.ReadFile(filepath.Join(workDir, ".gitignore"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("@gitignore-secrets: %w", err)
+	}
+
+	var out []Mount
+
+	for line := range strings.Lines(string(data)) {
+		entry := strings.TrimSpace(line)
+		if entry == "" || strings.HasPrefix(entry, "#") || strings.HasPrefix(entry, "!") {
+			continue
+		}
+
+		// A leading backslash escapes "#" and "!".
+		entry = strings.TrimPrefix(entry, `\`)
+		entry = strings.TrimPrefix(entry, "**/")
+		entry = strings.Trim(entry, "/")
+
+		if entry == "" || strings.Contains(entry, "**") || !isSecretGitignoreEntry(entry) {
+			continue
+		}
+
+		_, err := filepath.Match(entry, "")
+		if err != nil {
+			continue
+		}
+
+		out = append(out, ExcludeTry(filepath.Join(workDir, entry)))
+	}
+
+	return out, nil
+}
+
+func isSecretGitignoreEntry(entry string) bool {
+	base := strings.ToLower(filepath.Base(entry))
+
+	for _, pattern := range gitignoreSecretPatterns {
+		ok, _ := filepath.Match(pattern, base)
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
 func lintTSMounts(workDir string) []Mount {
 	files := []string{
 		"biome.json",
@@ -0,0 +1,260 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Proc], a command that reports bwrap's structured
+// status (`--json-status-fd`).
+//
+// The process started by exec.Cmd is bwrap (or systemd-run), not the
+// sandboxed command: bwrap forks the command into new namespaces and waits for
+// it. With --json-status-fd, bwrap writes one JSON object per event to a pipe:
+// {"child-pid": N, ...namespace ids} once the namespaces are set up and the
+// command is about to exec, and {"exit-code": N} when it exited.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bwrapJSONStatusVersion is the first bubblewrap release with
+// --json-status-fd.
+var bwrapJSONStatusVersion = [3]int{0, 5, 0}
+
+// Proc is an unstarted command that reports the PID and exit code of the
+// sandboxed process as seen by bwrap. It embeds the underlying [exec.Cmd];
+// use its Start, Run and Wait methods, not the embedded Cmd's.
+type Proc struct {
+	*exec.Cmd
+
+	ctx context.Context
+
+	statusRead *os.File
+	closeWrite func() error
+
+	setup chan struct{}
+	done  chan struct{}
+
+	mu       sync.Mutex
+	pid      int
+	exitCode int
+	exited   bool
+	err      error
+}
+
+// bwrapStatus is one --json-status-fd event. Namespace ids are ignored.
+type bwrapStatus struct {
+	ChildPID *int `json:"child-pid"`
+	ExitCode *int `json:"exit-code"`
+}
+
+// Proc constructs an unstarted command like [Sandbox.Command] that also
+// reports the sandboxed process through [Proc.InnerPID] and
+// [Proc.InnerExitCode]. It requires bubblewrap 0.5.0 or newer and is not
+// available in [ModeAudit] or [ModeRestricted].
+//
+// The returned cleanup function must be called to release resources.
+func (s *Sandbox) Proc(ctx context.Context, argv []string) (*Proc, func() error, error) {
+	noop := func() error { return nil }
+
+	if s != nil && s.v != nil && (s.v.cfg.Mode == ModeAudit || s.v.cfg.Mode == ModeRestricted) {
+		return nil, noop, fmt.Errorf("sandbox: Proc is not available in %s mode", s.v.cfg.Mode)
+	}
+
+	bwrapPath, err := exec.LookPath("bwrap")
+	if err == nil && bwrapOlderThan(bwrapPath, bwrapJSONStatusVersion) {
+		return nil, noop, fmt.Errorf("sandbox: Proc requires bubblewrap %d.%d.%d or newer (%s)", bwrapJSONStatusVersion[0], bwrapJSONStatusVersion[1], bwrapJSONStatusVersion[2], bwrapPath)
+	}
+
+	statusRead, statusWrite, err := os.Pipe()
+	if err != nil {
+		return nil, noop, fmt.Errorf("sandbox: create status pipe: %w", err)
+	}
+
+	closeWrite := closeFilesOnce([]*os.File{statusWrite})
+	closeRead := closeFilesOnce([]*os.File{statusRead})
+
+	closeStatus := func() error {
+		return errors.Join(closeWrite(), closeRead())
+	}
+
+	cmd, cleanup, err := s.command(ctx, argv, []*os.File{statusWrite}, execOptions{bwrapFlags: []string{"--json-status-fd", strconv.Itoa(firstExtraFD)}})
+	if err != nil {
+		return nil, noop, errors.Join(err, closeStatus())
+	}
+
+	proc := &Proc{
+		Cmd:        cmd,
+		ctx:        ctx,
+		statusRead: statusRead,
+		closeWrite: closeWrite,
+		setup:      make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	cleanupAll := func() error {
+		return errors.Join(cleanup(), closeStatus())
+	}
+
+	return proc, cleanupAll, nil
+}
+
+// Start starts the command and begins reading bwrap's status events.
+func (p *Proc) Start() error {
+	err := p.Cmd.Start()
+
+	// The child has its own copy; closing ours lets the reader see EOF when
+	// bwrap exits.
+	_ = p.closeWrite()
+
+	if err != nil {
+		// No status will arrive; release Wait and InnerPID right away.
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+
+		close(p.done)
+
+		return setupFailed("start", err)
+	}
+
+	go p.readStatus()
+
+	return nil
+}
+
+// Run starts the command and waits for it to complete.
+func (p *Proc) Run() error {
+	err := p.Start()
+	if err != nil {
+		return err
+	}
+
+	return p.Wait()
+}
+
+// Wait waits for the command to exit and for bwrap's last status event, or
+// until the context passed to [Sandbox.Proc] is done.
+//
+// If bwrap exited before starting the command, the error matches
+// [ErrSetupFailed]. If the command exited unsuccessfully, it is an
+// *[ExitError] with the command's exit code. Other errors, such as bwrap
+// being killed after cancellation, are returned as reported by exec.Cmd.
+func (p *Proc) Wait() error {
+	err := p.Cmd.Wait()
+
+	<-p.done
+
+	if err == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var exitErr *exec.ExitError
+
+	switch {
+	case p.ctx.Err() != nil:
+		return err
+	case p.pid == 0 && p.err != nil:
+		return setupFailed("bwrap exited before starting the command", errors.Join(err, fmt.Errorf("bwrap status: %w", p.err)))
+	case p.pid == 0:
+		return setupFailed("bwrap exited before starting the command", err)
+	case p.exited && errors.As(err, &exitErr):
+		return &ExitError{Code: p.exitCode, Err: exitErr}
+	default:
+		return err
+	}
+}
+
+// InnerPID returns the host PID of the sandboxed process, waiting until
+// bwrap has set up the namespaces and reported it. It fails if bwrap exits
+// before that (for example because a mount failed) or ctx is done.
+//
+// The PID is only meaningful while the process runs; check [Proc.InnerExitCode]
+// to see whether it has exited.
+func (p *Proc) InnerPID(ctx context.Context) (int, error) {
+	select {
+	case <-p.setup:
+	case <-p.done:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pid == 0 {
+		if p.err != nil {
+			return 0, fmt.Errorf("sandbox: bwrap status: %w", p.err)
+		}
+
+		return 0, setupFailed("bwrap exited before starting the command", nil)
+	}
+
+	return p.pid, nil
+}
+
+// InnerExitCode returns the exit code of the sandboxed process as reported
+// by bwrap, and whether it has been reported. Unlike the exit status of the
+// embedded Cmd, it is not affected by bwrap's own failures.
+func (p *Proc) InnerExitCode() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.exitCode, p.exited
+}
+
+func (p *Proc) readStatus() {
+	defer close(p.done)
+
+	// A process that inherited the write end can keep it open after bwrap
+	// exits; cancellation unblocks the reader regardless.
+	stop := context.AfterFunc(p.ctx, func() {
+		_ = p.statusRead.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	decoder := json.NewDecoder(p.statusRead)
+
+	for {
+		var status bwrapStatus
+
+		err := decoder.Decode(&status)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) && p.ctx.Err() != nil {
+				err = p.ctx.Err()
+			}
+
+			if !errors.Is(err, io.EOF) {
+				p.mu.Lock()
+				p.err = err
+				p.mu.Unlock()
+			}
+
+			return
+		}
+
+		p.mu.Lock()
+
+		if status.ChildPID != nil && p.pid == 0 {
+			p.pid = *status.ChildPID
+			close(p.setup)
+		}
+
+		if status.ExitCode != nil {
+			p.exitCode = *status.ExitCode
+			p.exited = true
+		}
+
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,181 @@
+//go:build linux
+
+package sandbox
+
+// This file exposes the path-policy steps of planning as standalone
+// functions: [ResolvePolicy] turns presets and policy mounts into low-level
+// mounts, [ResolveCommand] finds the binaries a blocked or wrapped command
+// name resolves to on PATH, and [CheckPolicy] verifies the invariants every
+// resolved policy satisfies.
+//
+// They run the same code as [New], but only probe the host through a
+// [HostFS], so tools and fuzzers (see fuzz.go) can drive them with a
+// [MemHostFS] snapshot and get the same result on every call.
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ResolvePolicy resolves presets (see [Filesystem.Presets]) and the policy
+// mounts (RO, RW, Exclude and their variants) in mounts the way [New] plans
+// them, and returns the low-level mounts they become, in the order bwrap
+// applies them. "~" resolves against homeDir, relative patterns against
+// workDir, and globs, symlinks and file types against fsys.
+//
+// Like [Sandbox.Mounts], excluded-file masks are reported as
+// [MountRoBindData] with FD 0. Mounts that are not policy mounts are
+// rejected.
+//
+// The result depends only on the arguments and the content of fsys, except
+// for presets that read file contents (@git, @git-strict and
+// @gitignore-secrets), which read them from the real filesystem below
+// workDir.
+func ResolvePolicy(fsys HostFS, homeDir, workDir string, presets []string, mounts []Mount) ([]Mount, error) {
+	if fsys == nil {
+		return nil, errors.New("sandbox: resolve policy: HostFS is nil")
+	}
+
+	env := Environment{HomeDir: homeDir, WorkDir: workDir, FS: fsys}
+
+	errs := validateEnvironment(env)
+	errs = append(errs, validatePresetNames(presets)...)
+	errs = append(errs, validateMounts(mounts)...)
+
+	policyMounts, directMounts := splitFilesystemMounts(mounts)
+	for _, m := range directMounts {
+		errs = append(errs, fmt.Errorf("mount %s dst=%q is not a policy mount", mountKindName(m.Kind), m.Dst))
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: validating: %w", err)
+	}
+
+	presetMounts, _, err := expandPresets(presets, env, false)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: presets: %w", err)
+	}
+
+	presetPolicyMounts, _ := splitFilesystemMounts(presetMounts)
+
+	resolved, err := resolveAndDedupRules(append(presetPolicyMounts, policyMounts...), newPathResolver(env), nil)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: resolving: %w", err)
+	}
+
+	plan, err := mountPlanFromResolved(resolved, len(presetPolicyMounts))
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: resolving: %w", err)
+	}
+
+	out := make([]Mount, 0, len(plan.specs))
+
+	for _, spec := range plan.specs {
+		m := spec.mount
+		if m.Kind == MountRoBindData && m.FD == emptyDataFD {
+			m.FD = 0
+		}
+
+		out = append(out, m)
+	}
+
+	return out, nil
+}
+
+// ResolveCommand returns the host binaries the command name resolves to on
+// pathVar, a PATH value, the way [Commands.Block] and [Commands.Wrappers]
+// discover the targets they overlay: every PATH directory is searched in
+// order, relative and empty entries against workDir, and executables are
+// deduplicated by their symlink-resolved path, which is what is returned.
+// It returns no targets and no error if name is not found.
+func ResolveCommand(fsys HostFS, name, pathVar, workDir string) ([]string, error) {
+	if fsys == nil {
+		return nil, errors.New("sandbox: resolve command: HostFS is nil")
+	}
+
+	if strings.TrimSpace(name) == "" || strings.ContainsAny(name, "/\x00") {
+		return nil, fmt.Errorf("sandbox: resolve command: invalid command name %q", name)
+	}
+
+	if !filepath.IsAbs(workDir) {
+		return nil, fmt.Errorf("sandbox: resolve command: %w: %q is not absolute", ErrNoWorkDir, workDir)
+	}
+
+	dirs, _ := parsePathDirs(pathVar, workDir)
+
+	targets, err := findCommandTargets(fsys, name, dirs)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: resolve command %q: %w", name, err)
+	}
+
+	return targets, nil
+}
+
+// CheckPolicy reports the first invariant mounts, as returned by
+// [ResolvePolicy], violates, or nil:
+//
+//   - destinations are absolute, clean and free of NUL bytes, and none but
+//     the directories created for file masks lie in the sandbox's reserved
+//     runtime paths (/run itself and /run/agent-sandbox)
+//   - bind mounts expose a host path at the same path, and only one mount
+//     other than a created directory targets each path
+//   - mounts are ordered from the shallowest destination to the deepest
+//   - no mount after a mask (a tmpfs or an excluded-file mask) covers the
+//     masked path again
+//
+// A violation is a bug in this package: the plan would not enforce the
+// policy as documented.
+func CheckPolicy(mounts []Mount) error {
+	targets := make(map[string]int, len(mounts))
+	prevDepth := 0
+
+	for i, m := range mounts {
+		if !filepath.IsAbs(m.Dst) || filepath.Clean(m.Dst) != m.Dst || strings.ContainsRune(m.Dst, 0) {
+			return fmt.Errorf("sandbox: mount %d (%s) destination %q is not a clean absolute path", i, mountKindName(m.Kind), m.Dst)
+		}
+
+		depth := pathResolver{}.Depth(m.Dst)
+		if depth < prevDepth {
+			return fmt.Errorf("sandbox: mount %d (%s) %q is shallower than the mount before it", i, mountKindName(m.Kind), m.Dst)
+		}
+
+		prevDepth = depth
+
+		switch m.Kind {
+		case MountDir:
+			continue
+		case MountRoBind, MountRoBindTry, MountBind, MountBindTry:
+			if m.Src != m.Dst {
+				return fmt.Errorf("sandbox: mount %d (%s) binds %q at a different path %q", i, mountKindName(m.Kind), m.Src, m.Dst)
+			}
+		case MountTmpfs, MountRoBindData:
+		default:
+			return fmt.Errorf("sandbox: mount %d has kind %s, which policy resolution does not produce", i, mountKindName(m.Kind))
+		}
+
+		if isReservedRuntimePath(m.Dst) {
+			return fmt.Errorf("sandbox: mount %d (%s) targets reserved path %q", i, mountKindName(m.Kind), m.Dst)
+		}
+
+		if prev, ok := targets[m.Dst]; ok {
+			return fmt.Errorf("sandbox: mounts %d and %d both target %q", prev, i, m.Dst)
+		}
+
+		targets[m.Dst] = i
+
+		if m.Kind != MountTmpfs && m.Kind != MountRoBindData {
+			continue
+		}
+
+		for j, later := range mounts[i+1:] {
+			if later.Kind != MountDir && (later.Dst == "/" || isPathWithin(m.Dst, later.Dst)) {
+				return fmt.Errorf("sandbox: mount %d (%s) %q re-exposes %q masked by mount %d", i+1+j, mountKindName(later.Kind), later.Dst, m.Dst, i)
+			}
+		}
+	}
+
+	return nil
+}
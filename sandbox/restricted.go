@@ -0,0 +1,172 @@
+//go:build linux
+
+package sandbox
+
+// This file implements restricted mode.
+//
+// Restricted mode is for hosts where bwrap cannot run at all (containers
+// without CAP_SYS_ADMIN or user namespaces). Commands run directly on the host
+// like in audit mode, but the parts of the policy that need no namespaces are
+// still enforced: blocked and wrapped commands are interposed with shims in a
+// directory prepended to PATH, and the planned environment is applied. The
+// filesystem policy, network isolation and namespaces are not; their findings
+// are reported through [Config.Audit] like in audit mode.
+//
+// Shims only intercept commands looked up through PATH. Absolute paths and
+// programs that reset PATH reach the real binaries.
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// restrictedNotice is reported for every command in restricted mode.
+const restrictedNotice = "restricted mode: partial enforcement (command rules and environment only; filesystem policy, network and namespaces are not applied)"
+
+// restrictedExecSpec prepares argv to run directly on the host with PATH
+// shims for command rules and the planned environment.
+func (s *Sandbox) restrictedExecSpec(argv []string, leadingFiles []*os.File, envOps []envOp) (*ExecSpec, func() error, error) {
+	noop := func() error { return nil }
+
+	if s.v.cfg.Debugf != nil {
+		s.v.cfg.Debugf("sandbox(command): %s", restrictedNotice)
+	}
+
+	// Blocked commands are enforced by the shims.
+	s.reportAudit(argv, AuditBlock)
+
+	env := envMapToSliceSorted(s.restrictedEnv(envOps))
+
+	cleanup := noop
+
+	if len(s.plan.blocked) > 0 {
+		shimDir, shimCleanup, err := writeRestrictedShims(s.plan.blocked, s.v.cfg.Commands.BlockLog)
+		if err != nil {
+			return nil, noop, fmt.Errorf("sandbox: restricted shims: %w", err)
+		}
+
+		cleanup = shimCleanup
+		env = prependPath(env, shimDir)
+	}
+
+	path, err := lookPathIn(s.v.env.hostFS(), argv[0], env, s.v.env.WorkDir)
+	if err != nil {
+		return nil, noop, errors.Join(fmt.Errorf("sandbox: restricted: %w", err), cleanup())
+	}
+
+	spec := &ExecSpec{
+		Path:       path,
+		Args:       slices.Clone(argv),
+		Env:        env,
+		Dir:        s.v.env.WorkDir,
+		ExtraFiles: slices.Clone(leadingFiles),
+	}
+
+	return spec, cleanup, nil
+}
+
+// restrictedEnv applies the environment operations ops (the plan's, or those
+// of [Sandbox.runEnvOps]) to HostEnv.
+// Values that only exist inside the sandbox are adjusted: TMPDIR is the host
+// TempDir, and variables pointing at injected files (the CA bundle) keep
+// their host value.
+func (s *Sandbox) restrictedEnv(ops []envOp) map[string]string {
+	injected := make(map[string]bool, len(s.plan.wrapperMounts))
+	for _, mnt := range s.plan.wrapperMounts {
+		injected[mnt.dst] = true
+	}
+
+	env := applyEnvOps(s.v.env.HostEnv, ops, func(op envOp) bool {
+		return !op.unset && injected[op.value]
+	})
+
+	if s.v.cfg.TempDir != "" {
+		env["TMPDIR"] = s.v.cfg.TempDir
+	}
+
+	return env
+}
+
+// writeRestrictedShims creates a directory with one shim per blocked or
+// wrapped command name. Blocked commands are denied; wrapped commands run
+// their wrapper script with AGENT_SANDBOX_CMD and AGENT_SANDBOX_REAL set like
+// the launcher does. Built-in preset wrappers need the launcher and are
+// denied. The returned cleanup removes the directory.
+//
+// The shims are written to bin/ of a temporary directory and the wrapper
+// scripts to scripts/, so only shims are on PATH and a script cannot take
+// the name of another command's shim.
+func writeRestrictedShims(commands []blockedCommand, logPath string) (string, func() error, error) {
+	root, cleanup, err := newHostTempDir("restricted-*")
+	if err != nil {
+		return "", nil, err
+	}
+
+	fail := func(err error) (string, func() error, error) {
+		return "", nil, errors.Join(err, cleanup())
+	}
+
+	dir := filepath.Join(root, "bin")
+	scriptDir := filepath.Join(root, "scripts")
+
+	for _, d := range []string{dir, scriptDir} {
+		err = os.Mkdir(d, 0o755)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	for _, cmd := range commands {
+		var shim string
+
+		switch {
+		case cmd.wrapper == nil:
+			shim = restrictedDenyScript(cmd.name, logPath, "is blocked in this sandbox")
+		case strings.HasPrefix(cmd.wrapper.InlineScript, "preset:"):
+			shim = restrictedDenyScript(cmd.name, "", "uses a built-in wrapper, which is not available in restricted mode")
+		default:
+			script := filepath.Join(scriptDir, cmd.name)
+
+			err = os.WriteFile(script, []byte(cmd.wrapper.InlineScript), 0o755)
+			if err != nil {
+				return fail(err)
+			}
+
+			wrapper := *cmd.wrapper
+
+			wrapper.Env = maps.Clone(wrapper.Env)
+			if wrapper.Env == nil {
+				wrapper.Env = map[string]string{}
+			}
+
+			wrapper.Env["AGENT_SANDBOX_CMD"] = cmd.name
+			wrapper.Env["AGENT_SANDBOX_REAL"] = cmd.target
+
+			shim = generateWrapperPrelude(cmd.name, &wrapper, script)
+		}
+
+		err = os.WriteFile(filepath.Join(dir, cmd.name), []byte(shim), 0o755)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	return dir, cleanup, nil
+}
+
+// restrictedDenyScript renders a shim that rejects name. Unlike
+// [generateDenyWrapperScript] it does not depend on basename, since the host
+// PATH is not known to provide it.
+func restrictedDenyScript(name, logPath, reason string) string {
+	logLine := ""
+	if logPath != "" {
+		logLine = "echo " + shellQuote(name) + " >>" + shellQuote(logPath) + " 2>/dev/null\n"
+	}
+
+	return "#!/bin/sh\n" + logLine + "echo " + shellQuote("command '"+name+"' "+reason) + " >&2\nexit 126\n"
+}
@@ -0,0 +1,249 @@
+//go:build linux
+
+package sandbox
+
+// This file implements per-command overrides (see [RunSpec]).
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+)
+
+// RunSpec describes one command run in an existing [Sandbox], with settings
+// that apply to this command only. The sandbox policy is planned once by New;
+// a RunSpec never adds or removes mounts.
+type RunSpec struct {
+	// Argv is the command and its arguments.
+	Argv []string
+
+	// User, if set, runs the command as a different uid and gid inside the
+	// sandbox's user namespace, for workflows that alternate between
+	// building as the user and installing as fake root. It is passed to
+	// bwrap as --uid/--gid.
+	//
+	// Without [Config.MapSubIDs], bwrap maps only the caller's host uid and
+	// gid, so any User is accepted and files the caller owns appear owned by
+	// it. With MapSubIDs, User must be inside the mapped range (0 and the
+	// caller's subordinate ids). With [Etc.SynthesizePasswd], User must be
+	// root, nobody or the sandbox user, since the synthesized /etc/passwd
+	// has no other entries.
+	User *User
+
+	// ReadOnly makes every read-write bind of the policy read-only for this
+	// command, including the working directory and /tmp, so inspection steps
+	// (analysis, search) cannot write to the host whatever the policy allows.
+	// Tmpfs mounts stay writable; their contents are discarded on exit. It
+	// is not available in [ModeAudit] or [ModeRestricted].
+	ReadOnly bool
+
+	// PassFDs maps FD numbers in the command to open host files, so
+	// embedders can hand sockets (for example an accepted connection) or
+	// pipes directly to a sandboxed handler. The sandbox inherits its own
+	// FDs (wrapper scripts, pinned launcher, masks) first, starting at 3;
+	// every PassFDs number must be above them, and colliding numbers are
+	// rejected. Numbers in between are closed in the command. The caller
+	// keeps ownership of the files.
+	PassFDs map[int]*os.File
+
+	// Fifos are named pipes created for this command below [FifoDir] (see
+	// [Fifo]). Their host ends are set when the command is constructed.
+	// They are not available in [ModeAudit] or [ModeRestricted].
+	Fifos []*NamedPipe
+
+	// Identity overrides [Config.Identity] for this command: its non-empty
+	// fields replace the configured ones at the same precedence (see
+	// [Sandbox.Env]), so NormalizeEnv, ExtraCACerts, Clock and ExtraBwrapArgs
+	// still win. The synthesized /etc/passwd keeps the configured identity.
+	// Like Config.Identity, it has no effect in [ModeAudit].
+	Identity *Identity
+
+	// DefaultACL applies [Config.DefaultACL] on the host before the command
+	// is constructed (see [Sandbox.ApplyDefaultACL]). It is an error when
+	// Config.DefaultACL is not set.
+	DefaultACL bool
+}
+
+// User is a uid and gid inside the sandbox.
+type User struct {
+	UID int
+	GID int
+}
+
+// CommandSpec constructs an unstarted command like [Sandbox.Command], with the
+// per-command settings of spec. It is not available in [ModeAudit] or
+// [ModeRestricted] when spec.User, spec.ReadOnly or spec.Fifos is set;
+// spec.PassFDs works in every mode.
+//
+// The returned cleanup function must be called to release resources.
+func (s *Sandbox) CommandSpec(ctx context.Context, spec RunSpec) (*exec.Cmd, func() error, error) {
+	noop := func() error { return nil }
+
+	if s == nil || s.v == nil {
+		return nil, noop, errors.New("sandbox: uninitialized sandbox (use New or NewWithEnvironment)")
+	}
+
+	opts := execOptions{readOnly: spec.ReadOnly, passFDs: spec.PassFDs, fifos: spec.Fifos, identity: spec.Identity}
+
+	if spec.ReadOnly && (s.v.cfg.Mode == ModeAudit || s.v.cfg.Mode == ModeRestricted) {
+		return nil, noop, fmt.Errorf("sandbox: read-only run is not available in %s mode", s.v.cfg.Mode)
+	}
+
+	if len(spec.Fifos) > 0 {
+		if s.v.cfg.Mode == ModeAudit || s.v.cfg.Mode == ModeRestricted {
+			return nil, noop, fmt.Errorf("sandbox: fifos are not available in %s mode", s.v.cfg.Mode)
+		}
+
+		err := validateFifos(spec.Fifos)
+		if err != nil {
+			return nil, noop, fmt.Errorf("sandbox: %w", err)
+		}
+	}
+
+	if spec.Identity != nil {
+		err := errors.Join(validateIdentity(*spec.Identity)...)
+		if err != nil {
+			return nil, noop, fmt.Errorf("sandbox: %w", err)
+		}
+
+		if s.v.cfg.Debugf != nil {
+			s.v.cfg.Debugf("sandbox(command): identity home=%q user=%q shell=%q", spec.Identity.Home, spec.Identity.User, spec.Identity.Shell)
+		}
+	}
+
+	if spec.DefaultACL {
+		err := s.ApplyDefaultACL()
+		if err != nil {
+			return nil, noop, err
+		}
+	}
+
+	if spec.User != nil {
+		err := s.validateRunUser(*spec.User)
+		if err != nil {
+			return nil, noop, fmt.Errorf("sandbox: %w", err)
+		}
+
+		opts.bwrapFlags = append(opts.bwrapFlags, "--uid", strconv.Itoa(spec.User.UID), "--gid", strconv.Itoa(spec.User.GID))
+
+		if s.v.cfg.Debugf != nil {
+			s.v.cfg.Debugf("sandbox(command): running as uid=%d gid=%d", spec.User.UID, spec.User.GID)
+		}
+	}
+
+	if spec.ReadOnly && s.v.cfg.Debugf != nil {
+		s.v.cfg.Debugf("sandbox(command): read-only run")
+	}
+
+	if len(spec.PassFDs) > 0 && s.v.cfg.Debugf != nil {
+		s.v.cfg.Debugf("sandbox(command): passing FDs %v", slices.Sorted(maps.Keys(spec.PassFDs)))
+	}
+
+	return s.command(ctx, spec.Argv, nil, opts)
+}
+
+// readOnlyBinds replaces read-write bind options in args with their
+// read-only variants, in place. Operands are skipped by arity, so a path
+// spelled like an option is left alone.
+func readOnlyBinds(args []string) {
+	swap := map[string]string{
+		"--bind":     "--ro-bind",
+		"--bind-try": "--ro-bind-try",
+		"--bind-fd":  "--ro-bind-fd",
+	}
+
+	for i := 0; i < len(args); i++ {
+		if ro, ok := swap[args[i]]; ok {
+			args[i] = ro
+		}
+
+		i += bwrapOptionArity[args[i]]
+	}
+}
+
+// placePassFDs returns files extended so that each file of pass is inherited
+// at its FD number. files are the sandbox's own FDs starting at
+// [firstExtraFD]; a number among them, stdio or a nil or closed file is an
+// error. Gaps are nil and therefore closed in the child.
+func placePassFDs(files []*os.File, pass map[int]*os.File) ([]*os.File, error) {
+	if len(pass) == 0 {
+		return files, nil
+	}
+
+	firstFree := firstExtraFD + len(files)
+
+	for _, fd := range slices.Sorted(maps.Keys(pass)) {
+		file := pass[fd]
+
+		switch {
+		case fd < firstExtraFD:
+			return nil, fmt.Errorf("pass FD %d: stdin, stdout and stderr are set on the command", fd)
+		case fd < firstFree:
+			return nil, fmt.Errorf("pass FD %d: collides with the sandbox's own FDs %d-%d (use %d or higher)", fd, firstExtraFD, firstFree-1, firstFree)
+		case file == nil || file.Fd() == ^uintptr(0):
+			return nil, fmt.Errorf("pass FD %d: file is nil or closed", fd)
+		}
+
+		index := fd - firstExtraFD
+		if index >= len(files) {
+			files = append(files, make([]*os.File, index+1-len(files))...)
+		}
+
+		files[index] = file
+	}
+
+	return files, nil
+}
+
+// validateRunUser reports whether user can be mapped in the sandbox as
+// planned.
+func (s *Sandbox) validateRunUser(user User) error {
+	cfg := s.v.cfg
+
+	if user.UID < 0 || user.GID < 0 {
+		return fmt.Errorf("run user %d:%d: ids must not be negative", user.UID, user.GID)
+	}
+
+	if cfg.Mode == ModeAudit || cfg.Mode == ModeRestricted {
+		return fmt.Errorf("run user is not available in %s mode", cfg.Mode)
+	}
+
+	sandboxUID, sandboxGID := os.Getuid(), os.Getgid()
+
+	if cfg.MapSubIDs {
+		sandboxUID, sandboxGID = 0, 0
+
+		mapping, err := lookupSubIDMapping()
+		if err != nil {
+			return fmt.Errorf("run user %d:%d: %w", user.UID, user.GID, err)
+		}
+
+		if user.UID > subIDCount(mapping.uids) || user.GID > subIDCount(mapping.gids) {
+			return fmt.Errorf("run user %d:%d: outside the mapped subordinate ids (0-%d:0-%d)", user.UID, user.GID, subIDCount(mapping.uids), subIDCount(mapping.gids))
+		}
+	}
+
+	if cfg.Etc.SynthesizePasswd && cfg.Etc.Overrides["passwd"] == nil {
+		known := user.UID == 0 || user.UID == 65534 || user.UID == sandboxUID
+		if !known || (user.GID != 0 && user.GID != 65534 && user.GID != sandboxGID) {
+			return fmt.Errorf("run user %d:%d: not in the synthesized /etc/passwd (root, nobody or %d:%d)", user.UID, user.GID, sandboxUID, sandboxGID)
+		}
+	}
+
+	return nil
+}
+
+// subIDCount returns the number of subordinate ids in ranges.
+func subIDCount(ranges []SubIDRange) int {
+	count := 0
+	for _, r := range ranges {
+		count += int(r.Count)
+	}
+
+	return count
+}
@@ -41,10 +41,14 @@ package sandbox
 
 import (
 	"fmt"
+	"io/fs"
 	"maps"
 	"os"
+	"os/user"
+	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 )
 
 // Sandbox represents a reusable sandbox configuration and environment.
@@ -105,6 +109,10 @@ type Sandbox struct {
 	//
 	// It is computed during construction (New/NewWithEnvironment).
 	plan *plan
+
+	// launcher is the digest of [Commands.Launcher] recorded during
+	// construction, or nil when no command wrappers are configured.
+	launcher *fileDigest
 }
 
 // New constructs a Sandbox using an Environment derived from the current
@@ -125,12 +133,26 @@ func New(cfg *Config) (*Sandbox, error) {
 // process.
 //
 // Note: cfg and env are deep-copied during construction, so subsequent
-// modifications to the passed values do not affect the Sandbox.
+// modifications to the passed values do not affect the Sandbox. The first
+// construction in a process also removes temp directories orphaned by killed
+// processes (see [ReapOrphans]).
 func NewWithEnvironment(cfg *Config, env Environment) (*Sandbox, error) {
 	clonedCfg := cloneConfig(cfg)
 	env = cloneEnvironment(env)
 
-	err := validateConfigAndEnv(&clonedCfg, env)
+	reapOrphansAtConstruction(clonedCfg.Debugf)
+
+	dirFDs, err := resolveDirFDs(&env)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: validating: %w", err)
+	}
+
+	err = mergeBundleCommands(&clonedCfg)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: validating: %w", err)
+	}
+
+	err = validateConfigAndEnv(&clonedCfg, env)
 	if err != nil {
 		return nil, fmt.Errorf("sandbox: validating: %w", err)
 	}
@@ -142,22 +164,38 @@ func NewWithEnvironment(cfg *Config, env Environment) (*Sandbox, error) {
 		return nil, fmt.Errorf("sandbox: planning: %w", err)
 	}
 
-	return &Sandbox{v: &validatedCfg, plan: plan}, nil
+	plan.dirFDs = dirFDs
+
+	sb := &Sandbox{v: &validatedCfg, plan: plan}
+
+	// The launcher is mounted over wrapped commands and, with NetworkAllow
+	// or Umask, runs the proxy bridge or umask shim every command starts
+	// through.
+	if len(clonedCfg.Commands.Block) > 0 || len(clonedCfg.Commands.Wrappers) > 0 || len(clonedCfg.NetworkAllow) > 0 || clonedCfg.Umask != nil {
+		sb.launcher, err = newFileDigest(clonedCfg.Commands.Launcher)
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: recording launcher digest: %w", err)
+		}
+	}
+
+	return sb, nil
 }
 
 // DefaultEnvironment returns an Environment derived from the current process.
 //
-// HomeDir is resolved from os.UserHomeDir(). WorkDir is resolved from os.Getwd().
+// WorkDir is resolved from os.Getwd(), falling back to /proc/self/cwd. HomeDir
+// is resolved from os.UserHomeDir() ($HOME), falling back to the passwd entry
+// of the current user. The errors wrap [ErrNoWorkDir] and [ErrNoHomeDir].
 // HostEnv is populated from os.Environ(). Invalid KEY=VALUE entries are ignored.
 func DefaultEnvironment() (Environment, error) {
-	workDir, err := os.Getwd()
+	workDir, err := defaultWorkDir()
 	if err != nil {
-		return Environment{}, fmt.Errorf("get working directory: %w", err)
+		return Environment{}, err
 	}
 
-	homeDir, err := os.UserHomeDir()
+	homeDir, err := defaultHomeDir()
 	if err != nil {
-		return Environment{}, fmt.Errorf("get home directory: %w", err)
+		return Environment{}, err
 	}
 
 	hostEnv := make(map[string]string, len(os.Environ()))
@@ -175,9 +213,45 @@ func DefaultEnvironment() (Environment, error) {
 		HomeDir: homeDir,
 		WorkDir: workDir,
 		HostEnv: hostEnv,
+		WSL:     DetectWSL(),
 	}, nil
 }
 
+// defaultWorkDir returns the working directory of the current process.
+// os.Getwd fails when $PWD is unset and the directory was removed or is
+// unreachable; the kernel's view in /proc/self/cwd is used then, unless the
+// directory was deleted.
+func defaultWorkDir() (string, error) {
+	workDir, err := os.Getwd()
+	if err == nil {
+		return workDir, nil
+	}
+
+	link, linkErr := os.Readlink("/proc/self/cwd")
+	if linkErr == nil && filepath.IsAbs(link) && !strings.HasSuffix(link, " (deleted)") {
+		return link, nil
+	}
+
+	return "", fmt.Errorf("%w: %w", ErrNoWorkDir, err)
+}
+
+// defaultHomeDir returns $HOME, or the home directory from the passwd entry
+// of the current user when HOME is unset (for example under some service
+// managers and cron).
+func defaultHomeDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err == nil {
+		return homeDir, nil
+	}
+
+	current, userErr := user.Current()
+	if userErr == nil && filepath.IsAbs(current.HomeDir) {
+		return current.HomeDir, nil
+	}
+
+	return "", fmt.Errorf("%w: %w", ErrNoHomeDir, err)
+}
+
 // Config configures sandbox behavior.
 //
 // Config is intentionally independent from any config-file loading or CLI flag
@@ -198,6 +272,46 @@ type Config struct {
 	// If nil, the implementation applies its default behavior (true).
 	Network *bool
 
+	// NetworkAllow, if non-empty, restricts network access to the listed
+	// destinations: host names ("registry.npmjs.org"), "*." wildcards
+	// matching any subdomain, IPv4 or IPv6 addresses, and CIDR prefixes of
+	// either family. A host name that matches no name entry is allowed if
+	// all its addresses lie in an address or CIDR entry.
+	//
+	// The sandbox then gets its own network namespace, and each command is
+	// started behind an HTTP(S) proxy on the host that only connects to
+	// allowed destinations (see [ProxyBridge]). HTTP_PROXY and HTTPS_PROXY
+	// (and their lowercase forms) point at it; clients that ignore them, and
+	// protocols other than HTTP and CONNECT tunnels, have no network. Host
+	// names are resolved by the proxy, so the sandbox needs no DNS.
+	//
+	// It requires [Commands.Launcher], which runs the proxy bridge inside
+	// the sandbox, and cannot be combined with Network set to false. It is
+	// not enforced in [ModeAudit] and [ModeRestricted].
+	NetworkAllow []string
+
+	// ExtraCACerts lists absolute host paths of PEM files with additional CA
+	// certificates, for example of a TLS-intercepting proxy that is the only
+	// allowed egress. The host bundle and these certificates are merged into
+	// a read-only file at [CABundlePath], and SSL_CERT_FILE and
+	// NODE_EXTRA_CA_CERTS point at it. The host bundle is not modified.
+	ExtraCACerts []string
+
+	// SSH replaces ~/.ssh inside the sandbox with a synthesized known_hosts
+	// and config, so ssh (and git over ssh) can verify pinned host keys while
+	// the host ~/.ssh stays hidden. When ~/.ssh is not below a masked or
+	// synthesized directory, it must exist on the host.
+	SSH SSH
+
+	// Etc mirrors the host /etc read-only under [BaseFSEmpty] and overlays
+	// synthesized files such as hosts, resolv.conf or passwd.
+	Etc Etc
+
+	// Dev controls /dev/tty, /dev/shm and extra host devices. If nil, /dev
+	// is bwrap's minimal device set, plus a /dev/shm tmpfs when the @browser
+	// preset is enabled.
+	Dev *Dev
+
 	// Docker controls docker socket exposure inside the sandbox.
 	// If nil, the implementation applies its default behavior (false).
 	//
@@ -212,12 +326,23 @@ type Config struct {
 	// "/" read-only. BaseFSEmpty mounts a fresh tmpfs at "/".
 	BaseFS BaseFS
 
+	// ChdirFallback selects the working directory used when
+	// [Environment.WorkDir] is not accessible inside the sandbox. The default
+	// ("") is [ChdirError].
+	ChdirFallback ChdirFallback
+
 	// Filesystem configures filesystem policy mounts and low-level mounts.
 	Filesystem Filesystem
 
 	// Commands configures command wrapper behavior.
 	Commands Commands
 
+	// SandboxInfo mounts a policy helper at [SandboxInfoPath]. Running it
+	// prints network and docker status, writable paths, blocked and wrapped
+	// commands and excluded directories; `sandbox-info which CMD...` reports
+	// how each command resolves under the policy.
+	SandboxInfo bool
+
 	// TempDir is the host temp directory to bind-mount as /tmp inside the sandbox.
 	//
 	// When set, the host path is bind-mounted to /tmp and TMPDIR is set to "/tmp"
@@ -225,8 +350,129 @@ type Config struct {
 	// of the host's TMPDIR setting.
 	//
 	// When empty, no temp directory normalization is done.
+	//
+	// Without XDG_RUNTIME_DIR, the package keeps the files behind its own
+	// mounts in agent-sandbox-<uid> in the host temp directory (see
+	// [ReapOrphans]); that directory is masked wherever a mount, such as
+	// this one, would expose it.
 	TempDir string
 
+	// Umask, if set, is applied before the command is exec'd by the launcher,
+	// bound at [UmaskShimPath] (see [UmaskShim]), so it requires
+	// [Commands.Launcher]. Must be between 0 and 0777.
+	Umask *int
+
+	// DefaultACL is the default POSIX ACL [Sandbox.ApplyDefaultACL] sets on
+	// every directory of the read-write bind mounts at or below
+	// [Environment.WorkDir], so files created there are group-readable
+	// ([DefaultACLGroupRead]) or private ([DefaultACLPrivate]). Construction
+	// does not touch the host; a run opts in with [RunSpec.DefaultACL] or by
+	// calling ApplyDefaultACL. The ACLs remain on the host. The filesystem
+	// must support POSIX ACLs.
+	DefaultACL DefaultACL
+
+	// SanitizeWrites sweeps the read-write bind mounts when the cleanup
+	// function of [Sandbox.Command] runs. The sweep strips setuid/setgid bits
+	// and world-writable modes and deletes device nodes the command may have
+	// left for the host; see [Sandbox.SanitizeWrites]. It walks every
+	// writable tree, so it is slow for large trees.
+	SanitizeWrites bool
+
+	// MapSubIDs maps the caller's subordinate uid and gid ranges (see
+	// subuid(5)) into the sandbox using newuidmap and newgidmap. The caller
+	// becomes uid 0 and ids from 1 map to the ranges, so tools that chown
+	// files to several users (apt, dnf) work. Requires the uidmap tools and
+	// /etc/subuid and /etc/subgid entries; see [CheckSubIDMapping].
+	MapSubIDs bool
+
+	// Namespaces selects host namespaces (IPC, UTS, PID) the sandbox shares
+	// instead of unsharing them. The zero value unshares all namespaces;
+	// the network namespace is controlled by Network.
+	Namespaces Namespaces
+
+	// PinMountSources protects bind mounts against symlink swaps between
+	// construction and command start. Sources are resolved and identified
+	// (device and inode) during construction; [Sandbox.Command] opens each
+	// source without following symlinks, fails if it is no longer the same
+	// file, and passes it to bwrap as an FD (--bind-fd/--ro-bind-fd). A -try
+	// source that was missing during construction is not mounted. Requires
+	// bubblewrap 0.8.0 and Linux 5.6.
+	PinMountSources bool
+
+	// NormalizeEnv makes command output independent of the host locale, time
+	// zone and terminal: it sets LC_ALL and LANG to C.UTF-8, TZ to UTC, TERM
+	// to dumb, COLUMNS to 80, LINES to 24 and NO_COLOR to 1, and unsets
+	// variables that force colors (FORCE_COLOR, CLICOLOR_FORCE, COLORTERM,
+	// PY_COLORS, GIT_PAGER_IN_USE, TERM_PROGRAM). Identity overrides still
+	// apply.
+	NormalizeEnv bool
+
+	// NormalizeEnvOverrides replaces individual values set by NormalizeEnv,
+	// for example {"COLUMNS": "200"}. Naming a color-forcing variable sets
+	// it instead of unsetting it. Ignored unless NormalizeEnv is set.
+	NormalizeEnvOverrides map[string]string
+
+	// ExtraBwrapArgs are appended to the generated bwrap options, before
+	// command wrappers and injected files, for bwrap features the package
+	// does not expose (for example {"--hostname", "build", "--cap-drop",
+	// "ALL"}). Each entry must be a known bwrap option followed by its
+	// operands. Options that would undo sandbox guarantees are rejected
+	// during construction: joining or replacing namespaces, --share-net with
+	// Network disabled, read-write binds of "/" or of the paths guarded by
+	// [Filesystem.AllowDangerousMounts], binds whose source exposes an
+	// excluded path or the masked docker socket, mounts, --chmod and
+	// --remount-ro on excluded paths, command wrappers, the docker socket,
+	// injected files or the runtime directory, read-write binds over
+	// read-only planned paths, device binds, capabilities, and options that
+	// need inherited FDs. Ignored in [ModeAudit].
+	ExtraBwrapArgs []string
+
+	// Clock fakes the wall clock inside the sandbox with libfaketime, for
+	// reproducible timestamps. The zero value uses the host clock.
+	Clock Clock
+
+	// Identity overrides HOME, USER/LOGNAME, and SHELL inside the sandbox.
+	//
+	// The zero value keeps the host values from [Environment.HostEnv].
+	Identity Identity
+
+	// Systemd runs the sandbox inside a transient systemd user scope unit.
+	//
+	// If nil (the default), bwrap is started directly. When set but systemd-run
+	// or the user service manager is unavailable, bwrap is also started directly.
+	Systemd *SystemdScope
+
+	// Escalation starts bwrap with elevated privileges on hosts without
+	// unprivileged user namespaces, through a setuid bwrap or pkexec/sudo.
+	// The zero value starts bwrap as the calling user. See [Doctor].
+	Escalation Escalation
+
+	// Mode selects whether the policy is enforced. The default ("") is
+	// [ModeEnforce]. [ModeAudit] runs commands without isolation and reports
+	// the policy's rules without tracing accesses. [ModeRestricted] is partial enforcement for
+	// hosts without bwrap support.
+	Mode Mode
+
+	// Audit receives findings in [ModeAudit], once per finding each time a
+	// command is prepared. Findings are the planned rules, not accesses the
+	// command made. In [ModeRestricted] it receives the findings that
+	// mode cannot enforce. If nil, findings are sent to Debugf.
+	Audit func(AuditFinding)
+
+	// AuditLog, if set, is a host file that the report-only shims of blocked
+	// commands append the command name to (one line per invocation) in
+	// [ModeAudit].
+	AuditLog string
+
+	// Diagnostics configures hints for failed commands.
+	Diagnostics Diagnostics
+
+	// Labels are caller-defined key/value pairs (e.g. "run-id", "agent",
+	// "task-id") that identify the sandbox to an orchestrator. They do not
+	// affect the policy or [Sandbox.Fingerprint], and are attached to
+	// audit findings, history entries and OCI exports. See [Sandbox.Labels].
+	Labels map[string]string
+
 	// Debugf receives debug messages from sandbox preparation and command construction.
 	Debugf Debugf
 }
@@ -258,7 +504,8 @@ type Commands = commands
 
 type commands struct {
 	// Block lists commands to block entirely.
-	// These commands will print an error and exit when invoked.
+	// These commands will print an error and exit with [ExitDenied] when
+	// invoked.
 	Block []string
 
 	// Wrappers intercept commands with custom scripts.
@@ -277,7 +524,11 @@ type commands struct {
 	// target (e.g., /usr/bin/git). The launcher is expected to dispatch based on
 	// argv[0] and handle the wrapper logic.
 	//
-	// Required when Block or Wrappers is non-empty.
+	// Required when Block or Wrappers is non-empty, and for
+	// [Config.NetworkAllow].
+	//
+	// Its SHA-256 is recorded during construction; Command() fails with an
+	// error wrapping [ErrTampered] if the file changed since.
 	Launcher string
 
 	// MountPath is the sandbox path where wrapper runtime files are mounted.
@@ -286,13 +537,27 @@ type commands struct {
 	// prevents directory listing):
 	//
 	//   {MountPath}/
-	//   ├── bin/       # real binaries
-	//   └── wrappers/  # wrapper scripts
+	//   ├── bin/              # real binaries
+	//   ├── wrappers/         # wrapper scripts
+	//   └── wrapper-scripts/  # user scripts behind a Wrapper's Env, Chdir or Timeout prelude
 	//
 	// If empty, defaults to `/run/{basename(Launcher)}`.
 	//
 	// Set this explicitly if you need a stable, launcher-independent location.
 	MountPath string
+
+	// BlockLog, if set, is an existing host file that blocked commands append
+	// their name to (one line per invocation). It is bind-mounted read-write
+	// at `{MountPath}/blocked.log`, so sandboxed processes can also modify it;
+	// treat the content as a count, not as evidence.
+	BlockLog string
+
+	// RejectRelativePath fails construction when PATH (from
+	// [Environment.HostEnv]) has relative entries such as "." or "bin", or
+	// empty ones, which mean the current directory. Otherwise wrapper
+	// discovery resolves them against [Environment.WorkDir], where sandboxed
+	// commands start, and reports a warning via [Config.Debugf].
+	RejectRelativePath bool
 }
 
 // BaseFS controls how the sandbox root filesystem (/) is constructed.
@@ -303,7 +568,8 @@ type commands struct {
 //
 // In BaseFSEmpty, the sandbox starts from an empty tmpfs mounted at "/" (bwrap:
 // `--tmpfs /`). This is useful when you want an explicit allowlist of host paths
-// available inside the sandbox.
+// available inside the sandbox. Missing parent directories of every mount
+// destination are created on the tmpfs (`--dir`) before the mount itself.
 //
 // Note: In BaseFSEmpty you usually need to mount a minimal runtime for
 // dynamically-linked binaries (for example `/usr` and `/lib*`), plus any config
@@ -333,6 +599,24 @@ const (
 	BaseFSEmpty BaseFS = "empty"
 )
 
+// ChdirFallback selects where sandboxed commands start when
+// [Environment.WorkDir] is not accessible inside the sandbox: it is excluded,
+// hidden below an excluded directory, or not mounted over [BaseFSEmpty].
+//
+// Accessibility is decided during planning from the last mount at or above
+// the directory. Besides the named values, any absolute path is accepted; it
+// must be accessible itself.
+type ChdirFallback string
+
+const (
+	// ChdirError fails construction with an error naming the work directory.
+	ChdirError ChdirFallback = "error"
+	// ChdirHome starts in [Environment.HomeDir].
+	ChdirHome ChdirFallback = "home"
+	// ChdirRoot starts in "/".
+	ChdirRoot ChdirFallback = "root"
+)
+
 // Filesystem configures filesystem mounts.
 //
 // There are two categories of mounts:
@@ -349,6 +633,9 @@ const (
 //
 //   - Direct mounts (RoBind, Bind, Tmpfs, Dir, RoBindData, ...): these require
 //     absolute paths and are appended after policy mounts in a deterministic order.
+//     A destination whose parent directories pass through a symlink inside the
+//     sandbox (for example /lib -> usr/lib with [BaseFSHost]) is rejected, since
+//     bwrap would follow it and mount elsewhere.
 //
 // Policy precedence rules:
 //   - More specific destinations win (a deeper path beats an ancestor).
@@ -358,6 +645,9 @@ const (
 //
 // There is no inherent priority between RO/RW/Exclude beyond these rules. For
 // example, an Exclude can be overridden by a later or more specific RW mount.
+//
+// Use [Sandbox.Mounts] to inspect the resolved mounts in the order they are
+// applied.
 type Filesystem = filesystem
 
 type filesystem struct {
@@ -369,6 +659,33 @@ type filesystem struct {
 	//   - empty but non-nil: apply no presets
 	Presets []string
 
+	// StrictPresets makes construction fail when an enabled preset matches
+	// nothing on the host (for example @git outside a repository). The error
+	// lists the presets that contributed nothing. When false, these presets
+	// are only reported via Debugf.
+	StrictPresets bool
+
+	// CachePresets reuses the expansion of static presets (every preset
+	// except @git and @git-strict, which probe the repository layout on each
+	// construction) across sandboxes with the same HomeDir and WorkDir, so
+	// constructing sandboxes in a loop does not re-probe identical paths.
+	// Cached entries do not notice paths created or removed later; call
+	// [InvalidatePresetCache] after changing the host layout.
+	CachePresets bool
+
+	// ExcludeNotice places a read-only README-agent-sandbox.txt in every
+	// excluded directory, explaining that the path was masked by policy.
+	// Directories re-exposed by a later mount get no notice.
+	ExcludeNotice bool
+
+	// AllowDangerousMounts permits read-write mounts (RW, RWTry, Bind,
+	// BindTry and --bind in [Config.ExtraBwrapArgs]) of "/", the home
+	// directory, /usr, /etc, and ancestors of the command wrapper runtime
+	// directory, including mounts that reach them through globs or
+	// symlinks. Without it such mounts fail construction with
+	// [ErrDangerousMount].
+	AllowDangerousMounts bool
+
 	// Mounts are applied after presets, in the order provided.
 	Mounts []Mount
 }
@@ -391,8 +708,9 @@ type filesystem struct {
 // [Commands.MountPath] (default: /run/{basename(Launcher)}):
 //
 //	{MountPath}/
-//	├── bin/{cmd}       # real binary (first PATH match)
-//	└── wrappers/{cmd}  # wrapper scripts
+//	├── bin/{cmd}              # real binary (first PATH match)
+//	├── wrappers/{cmd}         # wrapper scripts
+//	└── wrapper-scripts/{cmd}  # user script run by the prelude of a wrapper with Env, Chdir or Timeout
 //
 // Example:
 //
@@ -415,6 +733,29 @@ type Wrapper struct {
 	// InlineScript is inline script content.
 	// Takes precedence over Path if both are set.
 	InlineScript string
+
+	// FS, if set, is the file system Path is read from instead of the host,
+	// for example an embed.FS bundled with the calling binary. Path must then
+	// be an [fs.ValidPath].
+	FS fs.FS
+
+	// Env sets environment variables for the wrapper script, overriding the
+	// caller's values (e.g. GIT_CONFIG_GLOBAL=/dev/null). Names must be valid
+	// shell variable names.
+	Env map[string]string
+
+	// Chdir, if set, is the absolute sandbox path the wrapper script runs in,
+	// regardless of the caller's working directory.
+	Chdir string
+
+	// Timeout, if positive, limits how long each invocation of the wrapped
+	// command may run, independent of the overall command. It is rounded up
+	// to whole seconds. When it expires, "command '<name>' timed out" is
+	// written to stderr and the wrapper script's process gets SIGTERM, then
+	// SIGKILL five seconds later. Scripts should exec the real binary so the
+	// signal reaches it; children they leave running are not signalled. The
+	// watchdog needs sleep(1) in the sandbox PATH and /proc mounted.
+	Timeout time.Duration
 }
 
 // Wrap creates a wrapper that uses a script file.
@@ -422,6 +763,11 @@ func Wrap(path string) Wrapper {
 	return Wrapper{Path: path}
 }
 
+// WrapFS creates a wrapper that uses the script name from fsys.
+func WrapFS(fsys fs.FS, name string) Wrapper {
+	return Wrapper{FS: fsys, Path: name}
+}
+
 // MountKind describes a mount or policy operation understood by this package.
 //
 // Some kinds correspond directly to bubblewrap flags (for example MountRoBind
@@ -483,8 +829,109 @@ const (
 
 	// MountExcludeDir hides a path by masking it with an empty directory.
 	MountExcludeDir
+
+	// MountExcludeAuto hides a path by masking it with an empty file or an empty
+	// directory, depending on the host path type at planning time
+	// (ExcludeAuto helper).
+	MountExcludeAuto
+
+	// MountCollect binds the regular files matching the glob Src read-only
+	// below Dst, preserving their paths relative to the glob's directory
+	// prefix (Collect helper).
+	MountCollect
+)
+
+// String returns the kind's short name (e.g. "ro-bind", "exclude-try").
+func (k MountKind) String() string {
+	return mountKindName(k)
+}
+
+// MissingMode controls how single-path exclusions ([ExcludeFile], [ExcludeDir],
+// [ExcludeAuto]) handle paths that do not exist on the host at planning time.
+//
+// A missing path may still appear after the Sandbox is constructed (for example
+// a tool writing a credentials file later). The mode decides whether and how the
+// sandbox masks that location in advance.
+//
+// The zero value (MissingDefault) applies the default of the mount kind.
+type MissingMode int
+
+const (
+	// MissingDefault applies the kind's default: ExcludeFile masks with an empty
+	// file, ExcludeDir masks with an empty directory, and ExcludeAuto skips the
+	// exclusion.
+	MissingDefault MissingMode = iota
+
+	// MissingSkip emits no mask for a missing path. In particular, no phantom
+	// empty file or directory is created inside the sandbox.
+	MissingSkip
+
+	// MissingMaskFile masks the path with an unreadable empty file. The file is
+	// created inside the sandbox (not on the host) and prevents creation of the
+	// real file.
+	MissingMaskFile
+
+	// MissingMaskDir masks the path with an empty directory (tmpfs).
+	MissingMaskDir
+
+	// MissingMaskParent masks the nearest existing ancestor directory of the path
+	// with an empty directory (tmpfs). The path cannot be read even if it
+	// appears on the host later, and writes inside the sandbox do not reach the
+	// host. Note that this also hides all siblings of the path.
+	//
+	// Planning fails if the nearest existing ancestor is "/".
+	MissingMaskParent
+)
+
+// MountPhase selects where a direct mount is emitted relative to the mounts
+// the sandbox generates itself. bwrap applies mounts in argument order, so a
+// later mount at the same or a parent destination wins.
+//
+// The sandbox emits mounts in these phases:
+//
+//  1. base: the root filesystem, /dev, /proc, /run, DNS files, and the
+//     sandbox temp directory
+//  2. policy: presets and RO/RW/Exclude mounts, resolved and sorted from
+//     shallowest to deepest destination (excludes included)
+//  3. direct: low-level mounts from Filesystem.Mounts ([PhaseDirect])
+//  4. wrappers: command wrapper directories, real binaries, and launchers
+//  5. docker: the docker socket mask or bind
+//  6. late: low-level mounts pinned with [PhaseLate]
+//  7. runtime: the CA bundle, sandbox info, exclude notices, and wrapper
+//     scripts added by [Sandbox.Command]
+//
+// Only direct mounts can be pinned; policy mounts always use the policy
+// phase.
+type MountPhase int
+
+const (
+	// PhaseDirect emits the mount after policy mounts and before command
+	// wrappers. It is the zero value.
+	PhaseDirect MountPhase = iota
+
+	// PhaseLate emits the mount after command wrapper and docker socket
+	// mounts, for example to replace the wrapper of one specific path.
+	//
+	// A late mount can re-expose a path that a wrapper or the docker socket
+	// mask would otherwise hide.
+	PhaseLate
 )
 
+// Phase returns a copy of m pinned to phase. See [MountPhase].
+func (m Mount) Phase(phase MountPhase) Mount {
+	m.MountPhase = phase
+
+	return m
+}
+
+// OnMissing returns a copy of m, a single-path exclusion, with mode for a path
+// that does not exist at planning time. See [MissingMode].
+func (m Mount) OnMissing(mode MissingMode) Mount {
+	m.Missing = mode
+
+	return m
+}
+
 // RO grants read-only access to a path pattern.
 //
 // The path may be absolute, relative, "~"-prefixed, or a glob pattern.
@@ -537,6 +984,10 @@ func ExcludeTry(path string) Mount {
 // it useful to prevent both reading and creating sensitive files.
 //
 // Unlike Exclude/ExcludeTry, ExcludeFile does not accept glob patterns.
+//
+// [Mount.OnMissing] overrides the behavior for a path that does not exist at
+// planning time (for example [MissingSkip] to avoid creating a phantom empty
+// file inside the sandbox).
 func ExcludeFile(path string) Mount {
 	return Mount{Kind: MountExcludeFile, Dst: path}
 }
@@ -548,10 +999,41 @@ func ExcludeFile(path string) Mount {
 // it useful to block access to and creation of whole directory trees.
 //
 // Unlike Exclude/ExcludeTry, ExcludeDir does not accept glob patterns.
+//
+// [Mount.OnMissing] overrides the behavior for a path that does not exist at
+// planning time.
 func ExcludeDir(path string) Mount {
 	return Mount{Kind: MountExcludeDir, Dst: path}
 }
 
+// ExcludeAuto hides a single path inside the sandbox, choosing the mask from the
+// host path type at planning time: directories are masked with an empty
+// directory (tmpfs) and everything else with an unreadable empty file.
+//
+// This is the decision [Exclude] makes for each match, made explicit for a
+// single path. If the path does not exist at planning time, it is skipped unless
+// [Mount.OnMissing] selects a different [MissingMode].
+//
+// Unlike Exclude/ExcludeTry, ExcludeAuto does not accept glob patterns.
+func ExcludeAuto(path string) Mount {
+	return Mount{Kind: MountExcludeAuto, Dst: path}
+}
+
+// MaskFS replaces dst (an absolute sandbox path) with the file name read from
+// fsys, for example a fake config embedded in the calling binary with
+// embed.FS. The file is read-only with mode perms (default 0444).
+//
+// The content is read during construction and injected like wrapper scripts,
+// after all other mounts.
+func MaskFS(dst string, fsys fs.FS, name string, perms ...os.FileMode) Mount {
+	m := Mount{Kind: MountRoBindData, Src: name, Dst: dst, FS: fsys, Perms: 0o444}
+	if len(perms) > 0 {
+		m.Perms = perms[0]
+	}
+
+	return m
+}
+
 // RoBind returns a read-only bind mount from src (host path) to dst (sandbox path).
 func RoBind(src, dst string) Mount {
 	return Mount{Kind: MountRoBind, Src: src, Dst: dst}
@@ -616,6 +1098,37 @@ func cloneConfig(cfg *Config) Config {
 		out.Docker = &v
 	}
 
+	if cfg.Umask != nil {
+		v := *cfg.Umask
+		out.Umask = &v
+	}
+
+	if cfg.Systemd != nil {
+		v := *cfg.Systemd
+		v.Properties = slices.Clone(cfg.Systemd.Properties)
+		out.Systemd = &v
+	}
+
+	if cfg.Dev != nil {
+		v := *cfg.Dev
+		v.Extra = slices.Clone(cfg.Dev.Extra)
+		out.Dev = &v
+	}
+
+	out.NetworkAllow = slices.Clone(cfg.NetworkAllow)
+	out.ExtraCACerts = slices.Clone(cfg.ExtraCACerts)
+	out.SSH.KnownHosts = slices.Clone(cfg.SSH.KnownHosts)
+	out.ExtraBwrapArgs = slices.Clone(cfg.ExtraBwrapArgs)
+	out.NormalizeEnvOverrides = maps.Clone(cfg.NormalizeEnvOverrides)
+	out.Labels = maps.Clone(cfg.Labels)
+
+	if cfg.Etc.Overrides != nil {
+		out.Etc.Overrides = make(map[string][]byte, len(cfg.Etc.Overrides))
+		for name, data := range cfg.Etc.Overrides {
+			out.Etc.Overrides[name] = slices.Clone(data)
+		}
+	}
+
 	out.BaseFS = cfg.BaseFS
 	out.Filesystem.Presets = slices.Clone(cfg.Filesystem.Presets)
 	out.Filesystem.Mounts = slices.Clone(cfg.Filesystem.Mounts)
@@ -626,7 +1139,10 @@ func cloneConfig(cfg *Config) Config {
 	out.Commands.MountPath = cfg.Commands.MountPath
 	if cfg.Commands.Wrappers != nil {
 		out.Commands.Wrappers = make(map[string]Wrapper, len(cfg.Commands.Wrappers))
-		maps.Copy(out.Commands.Wrappers, cfg.Commands.Wrappers)
+		for name, wrapper := range cfg.Commands.Wrappers {
+			wrapper.Env = maps.Clone(wrapper.Env)
+			out.Commands.Wrappers[name] = wrapper
+		}
 	}
 
 	out.Debugf = cfg.Debugf
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -676,3 +677,96 @@ func Test_SandboxE2E_Allows_Write_When_File_Mounted_ReadWrite(t *testing.T) {
 		t.Fatalf("expected blocked file to remain unchanged, got: %q", string(blockedData))
 	}
 }
+
+func Test_SandboxE2E_Pipeline_Stops_At_First_Failure_When_Step_Fails(t *testing.T) {
+	t.Parallel()
+
+	env := newE2EEnv(t)
+	s := mustNewSandbox(t, &sandbox.Config{Filesystem: sandbox.Filesystem{Presets: []string{"!@all"}}}, env)
+
+	pipeline, cleanup, err := s.Pipeline(t.Context(), [][]string{
+		{"echo", "fmt"},
+		{"sh", "-c", "exit 3"},
+		{"echo", "test"},
+	})
+	if err != nil {
+		t.Fatalf("Pipeline: %v", err)
+	}
+
+	t.Cleanup(func() { _ = cleanup() })
+
+	var outBuf bytes.Buffer
+
+	pipeline.Stdout = &outBuf
+
+	err = pipeline.Run()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("expected exit code 3, got %v", err)
+	}
+
+	if got := outBuf.String(); got != "fmt\n" {
+		t.Fatalf("expected only first step output, got %q", got)
+	}
+
+	codes, err := pipeline.ExitCodes()
+	if err != nil {
+		t.Fatalf("ExitCodes: %v", err)
+	}
+
+	if !slices.Equal(codes, []int{0, 3}) {
+		t.Fatalf("expected exit codes [0 3], got %v", codes)
+	}
+}
+
+func Test_SandboxE2E_Keeps_Wrapped_Binary_When_Writable_Parent_Is_Tampered_With(t *testing.T) {
+	t.Parallel()
+
+	// The bin dir is nested below the writable workdir, so each of its
+	// ancestors could be renamed away as well.
+	env := newE2EEnv(t)
+	binDir := filepath.Join(env.WorkDir, "tools", "node", "bin")
+	mustCreateDir(t, binDir)
+	env.HostEnv["PATH"] = binDir + ":" + env.HostEnv["PATH"]
+
+	mustWriteFile(t, filepath.Join(binDir, "tool"), []byte("#!/bin/sh\necho REAL\n"), 0o755)
+
+	cfg := sandbox.Config{
+		Filesystem: sandbox.Filesystem{Presets: []string{"!@all"}, Mounts: []sandbox.Mount{sandbox.RW(".")}},
+		Commands: sandbox.Commands{
+			Block:    []string{"tool"},
+			Launcher: "/bin/true",
+		},
+	}
+	s := mustNewSandbox(t, &cfg, env)
+
+	script := `
+cd tools/node
+rm -f bin/tool
+mv bin/tool bin/moved
+mount --bind /bin/sh bin/tool
+printf '#!/bin/sh\necho REPLACED\n' > bin/tool
+mv bin bin.old && mkdir bin && printf '#!/bin/sh\necho REPLACED\n' > bin/tool && chmod +x bin/tool
+cd ../..
+mv tools/node tools/node.old && mkdir -p tools/node/bin && printf '#!/bin/sh\necho REPLACED\n' > tools/node/bin/tool && chmod +x tools/node/bin/tool
+mv tools tools.old && mkdir -p tools/node/bin && printf '#!/bin/sh\necho REPLACED\n' > tools/node/bin/tool && chmod +x tools/node/bin/tool
+tool
+true`
+
+	res := runSandboxed(t, s, []string{"sh", "-c", script}, nil)
+	if strings.Contains(res.stdout, "REAL") || strings.Contains(res.stdout, "REPLACED") {
+		t.Fatalf("expected wrapped binary to stay in place, got stdout %q stderr %q", res.stdout, res.stderr)
+	}
+
+	for _, renamed := range []string{"tools.old", "tools/node.old", "tools/node/bin.old"} {
+		if _, err := os.Stat(filepath.Join(env.WorkDir, renamed)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected %s not to be created by a rename, stat err: %v", renamed, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(binDir, "tool"))
+	if err != nil || string(data) != "#!/bin/sh\necho REAL\n" {
+		t.Fatalf("expected host binary to be unchanged, got %q, %v", data, err)
+	}
+}
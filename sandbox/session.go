@@ -0,0 +1,183 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Session].
+//
+// The plan of a Sandbox is immutable. A Session holds the config a Sandbox was
+// built from and swaps in a new Sandbox when the policy changes. Each change
+// is validated on its own first, so a bad mount is reported without
+// replanning; accepted changes are then planned together with the rest of the
+// policy, since precedence (deeper wins, later wins) depends on all mounts.
+//
+// Swapping whole sandboxes is what makes a change atomic: wrapper scripts and
+// other injected files are part of the plan and are read once during
+// construction, and a command's data FDs are created from the plan of the
+// Sandbox that started it. A command therefore sees either the old or the new
+// wrapper set, never a mix, however long it runs.
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PolicyChange is one entry of the audit trail of a [Session].
+type PolicyChange struct {
+	Time time.Time
+
+	// Op is "add", "remove" or "reload".
+	Op string
+
+	// Mount is the added or removed mount; it is zero for "reload".
+	Mount Mount
+
+	// Version is the policy version the change produced (see
+	// [Session.Current]).
+	Version uint64
+
+	// Policy is the [Sandbox.Fingerprint] of the new policy, as recorded in
+	// [HistoryEntry.Policy] for the commands that run under it.
+	Policy string
+}
+
+// Session is a long-lived sandbox whose filesystem policy can be changed
+// between commands. Commands started before a change keep the policy they
+// started with. A Session is safe for concurrent use.
+type Session struct {
+	mu      sync.Mutex
+	cfg     Config
+	env     Environment
+	sb      *Sandbox
+	version uint64
+	changes []PolicyChange
+}
+
+// NewSession constructs a Session, as [NewWithEnvironment] does a Sandbox.
+func NewSession(cfg *Config, env Environment) (*Session, error) {
+	sb, err := NewWithEnvironment(cfg, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{cfg: cloneConfig(cfg), env: cloneEnvironment(env), sb: sb, version: 1}, nil
+}
+
+// Sandbox returns the sandbox for the current policy. Use it to start
+// commands; it is not affected by later changes.
+func (s *Session) Sandbox() *Sandbox {
+	sb, _ := s.Current()
+
+	return sb
+}
+
+// Current returns the sandbox for the current policy together with its
+// version. The initial policy is version 1, and every applied change
+// increments it; failed changes do not. Commands started from sb keep its
+// policy, including its wrapper set, when the policy changes later.
+func (s *Session) Current() (*Sandbox, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sb, s.version
+}
+
+// AddMount appends mnt to [Filesystem.Mounts] for subsequent commands. If
+// mnt is invalid or the new policy cannot be planned, the policy is left
+// unchanged.
+func (s *Session) AddMount(mnt Mount) error {
+	errs := validateMounts([]Mount{mnt})
+	if len(errs) > 0 {
+		return fmt.Errorf("sandbox: session: validating mount: %w", errors.Join(errs...))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg := cloneConfig(&s.cfg)
+	cfg.Filesystem.Mounts = append(cfg.Filesystem.Mounts, mnt)
+
+	return s.apply(cfg, PolicyChange{Op: "add", Mount: mnt})
+}
+
+// RemoveMount removes the last mount equal to mnt from [Filesystem.Mounts]
+// for subsequent commands. Preset mounts cannot be removed.
+func (s *Session) RemoveMount(mnt Mount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, m := range slices.Backward(s.cfg.Filesystem.Mounts) {
+		if sameMount(m, mnt) {
+			cfg := cloneConfig(&s.cfg)
+			cfg.Filesystem.Mounts = slices.Delete(cfg.Filesystem.Mounts, i, i+1)
+
+			return s.apply(cfg, PolicyChange{Op: "remove", Mount: mnt})
+		}
+	}
+
+	return fmt.Errorf("sandbox: session: mount %s %q is not configured", mountKindName(mnt.Kind), mnt.Dst)
+}
+
+// Reload replaces the whole config, including command wrappers and presets,
+// for subsequent commands, for example after the config file changed. The
+// new sandbox is built before anything is switched: if cfg is invalid or
+// cannot be planned, the policy is left unchanged.
+func (s *Session) Reload(cfg *Config) error {
+	if cfg == nil {
+		return errors.New("sandbox: session: reload: nil config")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.apply(cloneConfig(cfg), PolicyChange{Op: "reload"})
+}
+
+// Changes returns the policy changes applied so far, oldest first.
+func (s *Session) Changes() []PolicyChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.changes)
+}
+
+// apply replans with cfg and records change. s.mu must be held.
+func (s *Session) apply(cfg Config, change PolicyChange) error {
+	sb, err := NewWithEnvironment(&cfg, s.env)
+	if err != nil {
+		return fmt.Errorf("sandbox: session: %s: %w", change.describe(), err)
+	}
+
+	change.Time = time.Now()
+	change.Version = s.version + 1
+	change.Policy = sb.Fingerprint()
+
+	if cfg.Debugf != nil {
+		cfg.Debugf("session: %s (policy version %d, %s)", change.describe(), change.Version, change.Policy)
+	}
+
+	s.cfg = cfg
+	s.sb = sb
+	s.version = change.Version
+	s.changes = append(s.changes, change)
+
+	return nil
+}
+
+// describe formats the change for errors and debug output.
+func (c PolicyChange) describe() string {
+	if c.Op == "reload" {
+		return "reload"
+	}
+
+	return fmt.Sprintf("%s mount %s %q", c.Op, mountKindName(c.Mount.Kind), c.Mount.Dst)
+}
+
+// sameMount reports whether a and b describe the same mount. Mounts with an
+// FS are only equal to themselves by FS identity, which not every fs.FS
+// supports, so they never match.
+func sameMount(a, b Mount) bool {
+	return a.FS == nil && b.FS == nil && a == b
+}
@@ -0,0 +1,99 @@
+//go:build linux
+
+package sandbox
+
+// This file implements [Config.SSH].
+//
+// The sandbox's ~/.ssh is replaced by a fresh tmpfs holding only the
+// synthesized known_hosts and config files, injected with `--ro-bind-data`
+// like wrapper scripts. The host ~/.ssh (keys, agent config, its own
+// known_hosts) stays hidden even when no preset excludes it.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SSH configures the sandbox's ~/.ssh. The zero value leaves it alone.
+type SSH struct {
+	// KnownHosts are known_hosts(5) lines, for example
+	// "github.com ssh-ed25519 AAAAC3...". They pin the host keys ssh accepts.
+	KnownHosts []string
+
+	// ConfigSnippet is the content of ~/.ssh/config (see ssh_config(5)).
+	ConfigSnippet string
+}
+
+func (ssh SSH) enabled() bool {
+	return len(ssh.KnownHosts) > 0 || ssh.ConfigSnippet != ""
+}
+
+func validateSSH(ssh SSH) []error {
+	var errs []error
+
+	for i, line := range ssh.KnownHosts {
+		if strings.TrimSpace(line) == "" || strings.ContainsAny(line, "\n\x00") {
+			errs = append(errs, fmt.Errorf("SSH known_hosts line %d must be a single non-empty line", i))
+		}
+	}
+
+	if strings.ContainsRune(ssh.ConfigSnippet, 0) {
+		errs = append(errs, fmt.Errorf("SSH config snippet contains a NUL byte"))
+	}
+
+	return errs
+}
+
+// appendSSH mounts a tmpfs over ~/.ssh and injects the configured files. The
+// home directory is [Identity.Home] when set (ssh reads it from passwd, which
+// [Etc.SynthesizePasswd] fills the same way), otherwise
+// [Environment.HomeDir].
+func (p *planner) appendSSH() error {
+	home := p.cfg.Identity.Home
+	if home == "" {
+		home = p.env.HomeDir
+	}
+
+	dir := filepath.Join(home, ".ssh")
+
+	err := p.appendParentDirs(dir)
+	if err != nil {
+		return err
+	}
+
+	mnt, _, ok := p.coveringMount(dir)
+	if !ok || (mnt.Kind != MountTmpfs && mnt.Kind != MountDir) {
+		// The mount point must already exist on the host.
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			return fmt.Errorf("SSH: %s must exist on the host to be replaced", dir)
+		}
+	}
+
+	p.debugf("ssh dir=%q known_hosts=%d config=%t", dir, len(p.cfg.SSH.KnownHosts), p.cfg.SSH.ConfigSnippet != "")
+
+	err = p.appendMount(Tmpfs(dir))
+	if err != nil {
+		return err
+	}
+
+	if len(p.cfg.SSH.KnownHosts) > 0 {
+		p.plan.wrapperMounts = append(p.plan.wrapperMounts, roBindDataMount{
+			dst:   filepath.Join(dir, "known_hosts"),
+			perms: 0o444,
+			data:  strings.Join(p.cfg.SSH.KnownHosts, "\n") + "\n",
+		})
+	}
+
+	if p.cfg.SSH.ConfigSnippet != "" {
+		p.plan.wrapperMounts = append(p.plan.wrapperMounts, roBindDataMount{
+			dst:   filepath.Join(dir, "config"),
+			perms: 0o444,
+			data:  p.cfg.SSH.ConfigSnippet,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package sandbox
+
+// This file implements per-project state directories (see
+// [Sandbox.StateDir]).
+//
+// Everything agent-sandbox keeps about one project (command history today)
+// lives in one directory named after a digest of the project directory, so
+// it can be inspected and purged as a unit. A "project" file in each
+// directory records the project path, since the name alone cannot be mapped
+// back.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ProjectStateFile is the file in a project state directory that holds the
+// project directory it belongs to.
+const ProjectStateFile = "project"
+
+// StateHome returns the root of all agent-sandbox state:
+// $XDG_STATE_HOME/agent-sandbox, falling back to ~/.local/state when
+// XDG_STATE_HOME is unset.
+func StateHome(env Environment) string {
+	if dir := env.HostEnv["XDG_STATE_HOME"]; filepath.IsAbs(dir) {
+		return filepath.Join(dir, "agent-sandbox")
+	}
+
+	return filepath.Join(env.HomeDir, ".local", "state", "agent-sandbox")
+}
+
+// ProjectStateDir returns the state directory of the project at
+// [Environment.WorkDir]: a directory below [StateHome] named after a digest
+// of the project path. It does not create the directory.
+func ProjectStateDir(env Environment) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(env.WorkDir)))
+
+	return filepath.Join(StateHome(env), hex.EncodeToString(sum[:])[:16])
+}
+
+// StateDir returns the state directory of the project the sandbox runs in
+// (see [ProjectStateDir]), creating it with mode 0700 and its
+// [ProjectStateFile] if needed. Embedders should keep per-project artifacts
+// such as logs there, so removing the directory forgets the project.
+func (s *Sandbox) StateDir() (string, error) {
+	if s == nil || s.v == nil {
+		return "", errors.New("sandbox: uninitialized sandbox (use New or NewWithEnvironment)")
+	}
+
+	dir := ProjectStateDir(s.v.env)
+
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return "", fmt.Errorf("sandbox: state dir: %w", err)
+	}
+
+	marker := filepath.Join(dir, ProjectStateFile)
+
+	_, err = os.Stat(marker)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = os.WriteFile(marker, []byte(filepath.Clean(s.v.env.WorkDir)+"\n"), 0o600)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("sandbox: state dir: %w", err)
+	}
+
+	return dir, nil
+}
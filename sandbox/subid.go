@@ -0,0 +1,222 @@
+//go:build linux
+
+package sandbox
+
+// This file implements subordinate id mapping (see [Config.MapSubIDs]).
+//
+// An unprivileged bwrap can only map the caller's own uid and gid into its
+// user namespace, so package managers that chown files to system users
+// (apt's _apt, dnf's package owners) fail. With MapSubIDs, Command() creates
+// the user namespace itself: it starts a short-lived helper in a new user
+// namespace, lets the setuid newuidmap/newgidmap tools map the caller's
+// /etc/subuid and /etc/subgid ranges into it, and passes the namespace to
+// bwrap via --userns. Inside the sandbox the caller is uid 0 and ids 1..N map
+// to the subordinate range.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Default subordinate id databases (see subuid(5) and subgid(5)).
+const (
+	SubUIDPath = "/etc/subuid"
+	SubGIDPath = "/etc/subgid"
+)
+
+// userNSFDPlaceholder is substituted by Command() with the inherited FD of
+// the prepared user namespace, like [emptyDataFDPlaceholder].
+const userNSFDPlaceholder = "\x00AGENT_SANDBOX_USERNSFD\x00"
+
+// SubIDRange is one subordinate id range from /etc/subuid or /etc/subgid.
+type SubIDRange struct {
+	Start uint32
+	Count uint32
+}
+
+// SubIDRanges returns the ranges in the subordinate id file at path that
+// belong to the user with the given name or numeric id. A missing file has
+// no ranges.
+func SubIDRanges(path, name string, id int) ([]SubIDRange, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reading subordinate ids: %w", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	var ranges []SubIDRange
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || (fields[0] != name && fields[0] != strconv.Itoa(id)) {
+			continue
+		}
+
+		start, startErr := strconv.ParseUint(fields[1], 10, 32)
+		count, countErr := strconv.ParseUint(fields[2], 10, 32)
+
+		if startErr != nil || countErr != nil || count == 0 {
+			return nil, fmt.Errorf("reading subordinate ids: malformed line in %s: %q", path, line)
+		}
+
+		ranges = append(ranges, SubIDRange{Start: uint32(start), Count: uint32(count)})
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("reading subordinate ids: %w", err)
+	}
+
+	return ranges, nil
+}
+
+// subIDMapping is what Command() needs to map subordinate ids.
+type subIDMapping struct {
+	newuidmap string
+	newgidmap string
+	uid       int
+	gid       int
+	uids      []SubIDRange
+	gids      []SubIDRange
+}
+
+// CheckSubIDMapping reports whether [Config.MapSubIDs] can work for the
+// current user: newuidmap and newgidmap must be in PATH and the user must
+// have ranges in /etc/subuid and /etc/subgid.
+func CheckSubIDMapping() error {
+	_, err := lookupSubIDMapping()
+
+	return err
+}
+
+func lookupSubIDMapping() (*subIDMapping, error) {
+	mapping := &subIDMapping{uid: os.Getuid(), gid: os.Getgid()}
+
+	var err error
+
+	mapping.newuidmap, err = exec.LookPath("newuidmap")
+	if err != nil {
+		return nil, fmt.Errorf("subordinate ids: newuidmap not found in PATH (try installing with: sudo apt install uidmap): %w", err)
+	}
+
+	mapping.newgidmap, err = exec.LookPath("newgidmap")
+	if err != nil {
+		return nil, fmt.Errorf("subordinate ids: newgidmap not found in PATH (try installing with: sudo apt install uidmap): %w", err)
+	}
+
+	name := strconv.Itoa(mapping.uid)
+	if u, lookupErr := user.LookupId(name); lookupErr == nil {
+		name = u.Username
+	}
+
+	mapping.uids, err = SubIDRanges(SubUIDPath, name, mapping.uid)
+	if err != nil {
+		return nil, fmt.Errorf("subordinate ids: %w", err)
+	}
+
+	mapping.gids, err = SubIDRanges(SubGIDPath, name, mapping.uid)
+	if err != nil {
+		return nil, fmt.Errorf("subordinate ids: %w", err)
+	}
+
+	if len(mapping.uids) == 0 || len(mapping.gids) == 0 {
+		return nil, fmt.Errorf("subordinate ids: no ranges for user %q in %s and %s (try: sudo usermod --add-subuids 100000-165535 --add-subgids 100000-165535 %s)", name, SubUIDPath, SubGIDPath, name)
+	}
+
+	return mapping, nil
+}
+
+// mapArgs returns the newuidmap/newgidmap arguments for pid: the caller's id
+// maps to 0 and the subordinate ranges follow from 1.
+func mapArgs(pid, id int, ranges []SubIDRange) []string {
+	args := []string{strconv.Itoa(pid), "0", strconv.Itoa(id), "1"}
+
+	next := uint64(1)
+	for _, r := range ranges {
+		args = append(args, strconv.FormatUint(next, 10), strconv.FormatUint(uint64(r.Start), 10), strconv.FormatUint(uint64(r.Count), 10))
+		next += uint64(r.Count)
+	}
+
+	return args
+}
+
+// userNamespace creates a user namespace with the subordinate id mapping and
+// returns an open handle to it. The helper process that created it has
+// exited when userNamespace returns; the handle keeps the namespace alive.
+func (m *subIDMapping) userNamespace() (*os.File, error) {
+	catPath, err := exec.LookPath("cat")
+	if err != nil {
+		return nil, fmt.Errorf("subordinate ids: cat not found in PATH: %w", err)
+	}
+
+	stdin, keepAlive, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("subordinate ids: %w", err)
+	}
+
+	// cat blocks on the pipe until keepAlive is closed, which keeps the
+	// namespace's pid around while it is being mapped and opened.
+	helper := exec.Command(catPath)
+	helper.Stdin = stdin
+	helper.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}
+
+	err = helper.Start()
+
+	_ = stdin.Close()
+
+	if err != nil {
+		_ = keepAlive.Close()
+
+		return nil, fmt.Errorf("subordinate ids: starting user namespace helper: %w", err)
+	}
+
+	defer func() {
+		_ = keepAlive.Close()
+		_ = helper.Wait()
+	}()
+
+	pid := helper.Process.Pid
+
+	for _, tool := range []struct {
+		path   string
+		id     int
+		ranges []SubIDRange
+	}{
+		{m.newuidmap, m.uid, m.uids},
+		{m.newgidmap, m.gid, m.gids},
+	} {
+		out, runErr := exec.Command(tool.path, mapArgs(pid, tool.id, tool.ranges)...).CombinedOutput()
+		if runErr != nil {
+			_ = helper.Process.Kill()
+
+			return nil, fmt.Errorf("subordinate ids: %s: %w: %s", tool.path, runErr, strings.TrimSpace(string(out)))
+		}
+	}
+
+	ns, err := os.Open(fmt.Sprintf("/proc/%d/ns/user", pid))
+	if err != nil {
+		_ = helper.Process.Kill()
+
+		return nil, fmt.Errorf("subordinate ids: %w", err)
+	}
+
+	return ns, nil
+}
@@ -0,0 +1,162 @@
+//go:build linux
+
+package sandbox
+
+// This file contains the optional systemd integration.
+//
+// When enabled, Command() starts bwrap through `systemd-run --user --scope`
+// so the sandboxed workload is placed in its own transient scope unit. This
+// gives proper cgroup placement (and therefore resource limits via unit
+// properties), journal integration, and `systemctl --user` visibility.
+//
+// Scope units run the command directly as a child of systemd-run (rather than
+// of the service manager), so inherited ExtraFiles keep working unchanged.
+//
+// The integration is best-effort: if systemd-run (in the PATH of
+// [Environment.HostEnv]) or the user manager is not available, bwrap is
+// started directly.
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// SystemdScope configures running the sandbox inside a transient systemd user
+// scope unit.
+//
+// Example:
+//
+//	cfg.Systemd = &sandbox.SystemdScope{
+//		Unit:       "agent-build",
+//		Properties: []string{"MemoryMax=4G", "CPUQuota=200%"},
+//	}
+type SystemdScope struct {
+	// Unit is an optional unit name (passed as `--unit`). If empty, systemd
+	// generates one.
+	//
+	// Unit names must be unique while the scope is running; leave this empty
+	// when running commands concurrently.
+	Unit string
+
+	// Properties are unit properties in KEY=VALUE form (passed as
+	// `--property`), for example "MemoryMax=2G" or "TasksMax=512".
+	Properties []string
+}
+
+// wrapArgs returns the systemd-run arguments that start name with args inside
+// a transient scope.
+func (s *SystemdScope) wrapArgs(name string, args []string) []string {
+	out := make([]string, 0, 4+2*len(s.Properties)+1+len(args))
+	out = append(out, "--user", "--scope", "--quiet", "--collect")
+
+	if s.Unit != "" {
+		out = append(out, "--unit", s.Unit)
+	}
+
+	for _, prop := range s.Properties {
+		out = append(out, "--property", prop)
+	}
+
+	out = append(out, "--", name)
+	out = append(out, args...)
+
+	return out
+}
+
+// systemdRunPath returns the path to systemd-run, looked up in the PATH of
+// env, if the user service manager is reachable from hostEnv. Host paths are
+// stat'ed in fsys.
+//
+// The user manager is considered reachable if systemd is the init system
+// (/run/systemd/system exists) and either DBUS_SESSION_BUS_ADDRESS names a bus
+// (a unix:path= address must exist) or XDG_RUNTIME_DIR contains the systemd
+// private socket or the user bus.
+func systemdRunPath(fsys HostFS, hostEnv map[string]string, env []string, workDir string) (string, error) {
+	runPath, err := lookPathIn(fsys, "systemd-run", env, workDir)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = fsys.Stat("/run/systemd/system")
+	if err != nil {
+		return "", fmt.Errorf("systemd is not running: %w", err)
+	}
+
+	if sessionBusReachable(fsys, hostEnv["DBUS_SESSION_BUS_ADDRESS"]) {
+		return runPath, nil
+	}
+
+	runtimeDir := hostEnv["XDG_RUNTIME_DIR"]
+	if runtimeDir == "" || !filepath.IsAbs(runtimeDir) {
+		return "", errors.New("user manager not reachable: no session bus and XDG_RUNTIME_DIR is not set")
+	}
+
+	for _, name := range []string{"systemd/private", "bus"} {
+		_, statErr := fsys.Stat(filepath.Join(runtimeDir, name))
+		if statErr == nil {
+			return runPath, nil
+		}
+	}
+
+	return "", fmt.Errorf("user manager not reachable: no bus socket in %q", runtimeDir)
+}
+
+// sessionBusReachable reports whether the D-Bus address list addr names a bus
+// that may be reachable. unix:path= addresses must exist in fsys; other
+// transports (abstract sockets, tcp) cannot be checked and are accepted.
+func sessionBusReachable(fsys HostFS, addr string) bool {
+	for entry := range strings.SplitSeq(addr, ";") {
+		transport, params, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			continue
+		}
+
+		if transport != "unix" {
+			return true
+		}
+
+		path := ""
+
+		for param := range strings.SplitSeq(params, ",") {
+			key, value, _ := strings.Cut(param, "=")
+			if key == "path" {
+				path = value
+			} else if key == "abstract" {
+				return true
+			}
+		}
+
+		if path == "" {
+			continue
+		}
+
+		_, err := fsys.Stat(path)
+		if err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateSystemdScope(scope *SystemdScope) []error {
+	if scope == nil {
+		return nil
+	}
+
+	var errs []error
+
+	if scope.Unit != "" && (strings.ContainsAny(scope.Unit, "/ \t\n") || strings.HasPrefix(scope.Unit, "-")) {
+		errs = append(errs, fmt.Errorf("systemd Unit %q is invalid", scope.Unit))
+	}
+
+	for i, prop := range scope.Properties {
+		key, _, ok := strings.Cut(prop, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			errs = append(errs, fmt.Errorf("systemd property %d %q is invalid: expected KEY=VALUE", i, prop))
+		}
+	}
+
+	return errs
+}
@@ -5,8 +5,11 @@ package sandbox
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -18,13 +21,30 @@ import (
 // Internal code assumes these invariants; any violation indicates a bug and is
 // surfaced as an error from Sandbox methods.
 func validateConfigAndEnv(cfg *Config, env Environment) error {
-	errs := make([]error, 0, 5)
+	errs := make([]error, 0, 8)
 
 	errs = append(errs, validateEnvironment(env)...)
 	errs = append(errs, validateBaseFS(cfg.BaseFS)...)
+	errs = append(errs, validateChdirFallback(cfg.ChdirFallback)...)
 	errs = append(errs, validatePresetNames(cfg.Filesystem.Presets)...)
 	errs = append(errs, validateMounts(cfg.Filesystem.Mounts)...)
+	errs = append(errs, validateDangerousMounts(cfg.Filesystem, cfg.Commands, env)...)
 	errs = append(errs, validateCommandsConfig(cfg.Commands)...)
+	errs = append(errs, validateIdentity(cfg.Identity)...)
+	errs = append(errs, validateSystemdScope(cfg.Systemd)...)
+	errs = append(errs, validateEscalation(cfg.Escalation, cfg.MapSubIDs, cfg.PinMountSources)...)
+	errs = append(errs, validateMode(cfg.Mode)...)
+	errs = append(errs, validateUmask(cfg.Umask, cfg.Commands.Launcher)...)
+	errs = append(errs, validateDefaultACL(cfg.DefaultACL)...)
+	errs = append(errs, validateExtraCACerts(cfg.ExtraCACerts)...)
+	errs = append(errs, validateSSH(cfg.SSH)...)
+	errs = append(errs, validateEtc(cfg.Etc)...)
+	errs = append(errs, validateDev(cfg.Dev)...)
+	errs = append(errs, validateClock(cfg.Clock)...)
+	errs = append(errs, validateNormalizeEnvOverrides(cfg.NormalizeEnvOverrides)...)
+	errs = append(errs, validateLabels(cfg.Labels)...)
+	errs = append(errs, validateNetworkAllow(cfg.NetworkAllow, cfg.Network, cfg.Commands.Launcher)...)
+	errs = append(errs, validateExtraBwrapArgs(cfg.ExtraBwrapArgs, (cfg.Network == nil || *cfg.Network) && len(cfg.NetworkAllow) == 0)...)
 
 	return errors.Join(errs...)
 }
@@ -35,15 +55,21 @@ func validateEnvironment(env Environment) []error {
 	// Environment is part of the public API; keep invariants strict to simplify
 	// downstream planning.
 	if strings.TrimSpace(env.WorkDir) == "" {
-		errs = append(errs, errors.New("environment WorkDir is empty"))
+		errs = append(errs, fmt.Errorf("%w: environment WorkDir is empty", ErrNoWorkDir))
 	} else if !filepath.IsAbs(env.WorkDir) {
-		errs = append(errs, fmt.Errorf("environment WorkDir %q is not absolute", env.WorkDir))
+		errs = append(errs, fmt.Errorf("%w: environment WorkDir %q is not absolute", ErrNoWorkDir, env.WorkDir))
 	}
 
 	if strings.TrimSpace(env.HomeDir) == "" {
-		errs = append(errs, errors.New("environment HomeDir is empty"))
+		errs = append(errs, fmt.Errorf("%w: environment HomeDir is empty", ErrNoHomeDir))
 	} else if !filepath.IsAbs(env.HomeDir) {
-		errs = append(errs, fmt.Errorf("environment HomeDir %q is not absolute", env.HomeDir))
+		errs = append(errs, fmt.Errorf("%w: environment HomeDir %q is not absolute", ErrNoHomeDir, env.HomeDir))
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(env.HostEnv)) {
+		if key == "" || strings.ContainsAny(key, "=\x00") || strings.ContainsRune(env.HostEnv[key], 0) {
+			errs = append(errs, fmt.Errorf("environment HostEnv entry %q is not a valid variable", key))
+		}
 	}
 
 	return errs
@@ -62,9 +88,22 @@ func validateBaseFS(mode BaseFS) []error {
 	}
 }
 
+func validateChdirFallback(fallback ChdirFallback) []error {
+	switch fallback {
+	case "", ChdirError, ChdirHome, ChdirRoot:
+		return nil
+	}
+
+	if !filepath.IsAbs(string(fallback)) {
+		return []error{fmt.Errorf("invalid ChdirFallback %q (expected %q, %q, %q or an absolute path)", fallback, ChdirError, ChdirHome, ChdirRoot)}
+	}
+
+	return nil
+}
+
 func validatePresetNames(presets []string) []error {
 	// Preset names are pure syntax; validate early.
-	_, err := resolvePresetToggles(presets)
+	_, _, err := resolvePresetToggles(presets)
 	if err != nil {
 		return []error{err}
 	}
@@ -72,6 +111,115 @@ func validatePresetNames(presets []string) []error {
 	return nil
 }
 
+// ErrDangerousMount is returned when a read-write mount makes a system-wide
+// location writable and [Filesystem.AllowDangerousMounts] is not set.
+var ErrDangerousMount = errors.New("dangerous read-write mount")
+
+// validateDangerousMounts rejects read-write mounts of "/", the home
+// directory, /usr, /etc, or an ancestor of the command wrapper runtime
+// directory. These are almost always typos (RW("/") instead of RW("./")) and
+// leave the host writable. Only caller mounts are checked: presets never grant
+// such access.
+//
+// This catches mounts that name such a path directly, even if it does not
+// exist. Planning checks the mounts again after globs and symlinks are
+// resolved (see [planner.checkDangerousMount]).
+func validateDangerousMounts(fsCfg Filesystem, cmdsCfg Commands, env Environment) []error {
+	if fsCfg.AllowDangerousMounts || !filepath.IsAbs(env.WorkDir) || !filepath.IsAbs(env.HomeDir) {
+		return nil
+	}
+
+	dangerous := dangerousMountPaths(cmdsCfg, env)
+	paths := newPathResolver(env)
+
+	var errs []error
+
+	for i, mount := range fsCfg.Mounts {
+		var path string
+
+		switch mount.Kind {
+		case MountReadWrite, MountReadWriteTry:
+			path = paths.Resolve(mount.Dst)
+		case MountBind, MountBindTry:
+			path = paths.Resolve(mount.Src)
+		default:
+			continue
+		}
+
+		if what, ok := dangerous[path]; ok {
+			errs = append(errs, fmt.Errorf("%w: mount %d (%s) makes %s (%s) writable; set AllowDangerousMounts if intended", ErrDangerousMount, i, mountKindName(mount.Kind), path, what))
+		}
+	}
+
+	return errs
+}
+
+// dangerousMountPaths returns the host paths a caller mount must not make
+// writable, with a description of each. Paths that are symlinks on the host
+// are listed under their resolved path as well.
+func dangerousMountPaths(cmdsCfg Commands, env Environment) map[string]string {
+	dangerous := map[string]string{
+		"/":                         "the host root",
+		filepath.Clean(env.HomeDir): "the home directory",
+		"/usr":                      "system binaries",
+		"/etc":                      "system configuration",
+	}
+
+	runtimeDir := cmdsCfg.MountPath
+	if runtimeDir == "" && cmdsCfg.Launcher != "" {
+		runtimeDir = "/run/" + filepath.Base(cmdsCfg.Launcher)
+	}
+
+	if (len(cmdsCfg.Block) > 0 || len(cmdsCfg.Wrappers) > 0) && filepath.IsAbs(runtimeDir) {
+		for dir := filepath.Dir(filepath.Clean(runtimeDir)); dir != "/"; dir = filepath.Dir(dir) {
+			if _, ok := dangerous[dir]; !ok {
+				dangerous[dir] = "an ancestor of the command wrapper runtime " + runtimeDir
+			}
+		}
+	}
+
+	hostFS := env.hostFS()
+
+	for path, what := range maps.Clone(dangerous) {
+		resolved, err := evalSymlinksIn(hostFS, path)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := dangerous[resolved]; !ok {
+			dangerous[resolved] = what
+		}
+	}
+
+	return dangerous
+}
+
+// checkDangerousMount returns an [ErrDangerousMount] error if the host path
+// src, which the mount described by desc makes writable, resolves to one of
+// [dangerousMountPaths].
+func (p *planner) checkDangerousMount(desc, src string) error {
+	if p.cfg.Filesystem.AllowDangerousMounts {
+		return nil
+	}
+
+	if p.dangerous == nil {
+		p.dangerous = dangerousMountPaths(p.cfg.Commands, p.env)
+	}
+
+	path := filepath.Clean(src)
+
+	resolved, err := evalSymlinksIn(p.paths.hostFS, path)
+	if err == nil {
+		path = resolved
+	}
+
+	if what, ok := p.dangerous[path]; ok {
+		return fmt.Errorf("%w: %s makes %s (%s) writable; set AllowDangerousMounts if intended", ErrDangerousMount, desc, path, what)
+	}
+
+	return nil
+}
+
 func validateMounts(mounts []Mount) []error {
 	var errs []error
 
@@ -91,18 +239,44 @@ func validateMounts(mounts []Mount) []error {
 			}
 		}
 
+		// bwrap takes paths as arguments, which cannot carry NUL bytes.
+		if strings.ContainsRune(mount.Dst, 0) || strings.ContainsRune(mount.Src, 0) {
+			errs = append(errs, fmt.Errorf("mount %d (%s) path contains a NUL byte", i, mountKindName(mount.Kind)))
+		}
+
+		isSinglePathExclude := mount.Kind == MountExcludeFile || mount.Kind == MountExcludeDir || mount.Kind == MountExcludeAuto
+		if mount.Missing != MissingDefault && !isSinglePathExclude {
+			errs = append(errs, fmt.Errorf("mount %d (%s) does not accept a missing mode", i, mountKindName(mount.Kind)))
+		}
+
+		if mount.FS != nil && mount.Kind != MountRoBindData {
+			errs = append(errs, fmt.Errorf("mount %d (%s) does not accept FS", i, mountKindName(mount.Kind)))
+		}
+
+		if mount.MountPhase < PhaseDirect || mount.MountPhase > PhaseLate {
+			errs = append(errs, fmt.Errorf("mount %d (%s) has unknown phase %d", i, mountKindName(mount.Kind), mount.MountPhase))
+		}
+
 		switch mount.Kind {
-		case MountReadOnly, MountReadOnlyTry, MountReadWrite, MountReadWriteTry, MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir:
+		case MountReadOnly, MountReadOnlyTry, MountReadWrite, MountReadWriteTry, MountExclude, MountExcludeTry, MountExcludeFile, MountExcludeDir, MountExcludeAuto:
+			if mount.MountPhase != PhaseDirect {
+				errs = append(errs, fmt.Errorf("mount %d (%s) cannot be pinned to a phase", i, mountKindName(mount.Kind)))
+			}
+
 			if strings.TrimSpace(mount.Dst) == "" {
 				errs = append(errs, fmt.Errorf("mount %d has empty destination", i))
 
 				continue
 			}
 
-			if mount.Kind == MountExcludeFile || mount.Kind == MountExcludeDir {
+			if isSinglePathExclude {
 				if strings.ContainsAny(mount.Dst, "*?[") {
 					errs = append(errs, fmt.Errorf("mount %d (%s) does not accept glob patterns", i, mountKindName(mount.Kind)))
 				}
+
+				if mount.Missing < MissingDefault || mount.Missing > MissingMaskParent {
+					errs = append(errs, fmt.Errorf("mount %d (%s) has unknown missing mode %d", i, mountKindName(mount.Kind), mount.Missing))
+				}
 			}
 
 			if mount.Src != "" {
@@ -134,6 +308,28 @@ func validateMounts(mounts []Mount) []error {
 				errs = append(errs, fmt.Errorf("mount %d (%s) source %q is not absolute", i, mountKindName(mount.Kind), mount.Src))
 			}
 
+			// bwrap cannot change the mode of a bind mount: --chmod would chmod the
+			// underlying host inode (or fail on read-only binds), and bwrap has no
+			// noexec mount option. Reject Perms rather than silently ignoring them.
+			if mount.Perms != 0 {
+				errs = append(errs, fmt.Errorf("mount %d (%s) does not accept Perms: bwrap cannot change permissions of bind mounts without modifying the host", i, mountKindName(mount.Kind)))
+			}
+
+		case MountCollect:
+			if strings.TrimSpace(mount.Dst) == "" || strings.TrimSpace(mount.Src) == "" {
+				errs = append(errs, fmt.Errorf("mount %d (%s) requires a glob and a destination", i, mountKindName(mount.Kind)))
+
+				break
+			}
+
+			if !filepath.IsAbs(mount.Dst) || filepath.Clean(mount.Dst) == "/" {
+				errs = append(errs, fmt.Errorf("mount %d (%s) destination %q is not an absolute directory below /", i, mountKindName(mount.Kind), mount.Dst))
+			}
+
+			if mount.FD != 0 || mount.Perms != 0 {
+				errs = append(errs, fmt.Errorf("mount %d (%s) does not accept FD/Perms", i, mountKindName(mount.Kind)))
+			}
+
 		case MountTmpfs, MountDir:
 			if strings.TrimSpace(mount.Dst) == "" {
 				errs = append(errs, fmt.Errorf("mount %d (%s) has empty destination", i, mountKindName(mount.Kind)))
@@ -160,6 +356,18 @@ func validateMounts(mounts []Mount) []error {
 				errs = append(errs, fmt.Errorf("mount %d (%s) destination %q is not absolute", i, mountKindName(mount.Kind), mount.Dst))
 			}
 
+			if mount.FS != nil {
+				if !fs.ValidPath(mount.Src) {
+					errs = append(errs, fmt.Errorf("mount %d (%s) source %q is not a valid FS path", i, mountKindName(mount.Kind), mount.Src))
+				}
+
+				if mount.FD != 0 {
+					errs = append(errs, fmt.Errorf("mount %d (%s) does not accept both FS and FD", i, mountKindName(mount.Kind)))
+				}
+
+				break
+			}
+
 			if mount.Src != "" {
 				errs = append(errs, fmt.Errorf("mount %d (%s) does not accept a source path", i, mountKindName(mount.Kind)))
 			}
@@ -176,6 +384,24 @@ func validateMounts(mounts []Mount) []error {
 	return errs
 }
 
+func validateIdentity(id Identity) []error {
+	var errs []error
+
+	if id.Home != "" && !filepath.IsAbs(id.Home) {
+		errs = append(errs, fmt.Errorf("identity Home %q is not absolute", id.Home))
+	}
+
+	if id.Shell != "" && !filepath.IsAbs(id.Shell) {
+		errs = append(errs, fmt.Errorf("identity Shell %q is not absolute", id.Shell))
+	}
+
+	if strings.ContainsAny(id.User, "=/\x00") || (id.User != "" && strings.TrimSpace(id.User) != id.User) {
+		errs = append(errs, fmt.Errorf("identity User %q is invalid", id.User))
+	}
+
+	return errs
+}
+
 func validateCommandLauncher(path string) error {
 	trimmed := strings.TrimSpace(path)
 	if trimmed == "" {
@@ -218,6 +444,10 @@ func validateCommandsConfig(cmdsCfg Commands) []error {
 		errs = append(errs, fmt.Errorf("command MountPath %q is not absolute", cmdsCfg.MountPath))
 	}
 
+	if cmdsCfg.BlockLog != "" && !filepath.IsAbs(cmdsCfg.BlockLog) {
+		errs = append(errs, fmt.Errorf("command BlockLog %q is not absolute", cmdsCfg.BlockLog))
+	}
+
 	for _, cmdName := range cmdsCfg.Block {
 		if strings.TrimSpace(cmdName) == "" {
 			errs = append(errs, errors.New("blocked command has empty name"))
@@ -249,7 +479,49 @@ func validateCommandsConfig(cmdsCfg Commands) []error {
 		if !hasPath && !hasInline {
 			errs = append(errs, fmt.Errorf("wrapper %q: Path or InlineScript is required", cmdName))
 		}
+
+		if wrapper.FS != nil && hasPath && !fs.ValidPath(wrapper.Path) {
+			errs = append(errs, fmt.Errorf("wrapper %q: Path %q is not a valid FS path", cmdName, wrapper.Path))
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(wrapper.Env)) {
+			if !isShellName(name) {
+				errs = append(errs, fmt.Errorf("wrapper %q: env name %q is not a valid shell variable name", cmdName, name))
+			}
+
+			if strings.ContainsRune(wrapper.Env[name], 0) {
+				errs = append(errs, fmt.Errorf("wrapper %q: env %s contains a NUL byte", cmdName, name))
+			}
+		}
+
+		if wrapper.Chdir != "" && !filepath.IsAbs(wrapper.Chdir) {
+			errs = append(errs, fmt.Errorf("wrapper %q: Chdir %q is not absolute", cmdName, wrapper.Chdir))
+		}
+
+		if strings.ContainsRune(wrapper.Chdir, 0) {
+			errs = append(errs, fmt.Errorf("wrapper %q: Chdir contains a NUL byte", cmdName))
+		}
+
+		if wrapper.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("wrapper %q: Timeout %s is negative", cmdName, wrapper.Timeout))
+		}
 	}
 
 	return errs
 }
+
+// isShellName reports whether name is a valid POSIX shell variable name.
+func isShellName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		isLetter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !isLetter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -4,10 +4,15 @@ package sandbox
 
 import (
 	"fmt"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // This file implements command wrapper planning.
@@ -52,9 +57,26 @@ type commandWrapperPlan struct {
 	// command targets with a multicall launcher binary.
 	launcherMounts []Mount
 
+	// logMounts bind [Commands.BlockLog] into the sandbox.
+	logMounts []Mount
+
 	// dataMounts are per-command `--ro-bind-data` mounts that are materialized at
 	// runtime using exec.Cmd.ExtraFiles.
 	dataMounts []roBindDataMount
+
+	// blocked lists every blocked or wrapped command name (including target
+	// aliases) with the host binary it resolves to. It is only used by audit
+	// and restricted mode.
+	blocked []blockedCommand
+}
+
+// blockedCommand pairs a blocked or wrapped command name with a host binary
+// it resolves to. For wrapped commands, wrapper is the configured wrapper with
+// its script content in InlineScript.
+type blockedCommand struct {
+	name    string
+	target  string
+	wrapper *Wrapper
 }
 
 // isEmpty returns true if the plan has no mounts to apply.
@@ -108,9 +130,16 @@ func buildCommandWrapperPlan(cmdsCfg Commands, env Environment, paths pathResolv
 		return nil, fmt.Errorf("cannot apply command wrappers: PATH is empty (commands: %s)", strings.Join(allCmdNames, ", "))
 	}
 
-	pathDirs := parsePathDirs(pathVar, env.WorkDir)
-	if len(pathDirs) == 0 {
-		return nil, fmt.Errorf("cannot apply command wrappers: PATH has no usable entries (commands: %s)", strings.Join(allCmdNames, ", "))
+	pathDirs, relative := parsePathDirs(pathVar, env.WorkDir)
+
+	if len(relative) > 0 {
+		if cmdsCfg.RejectRelativePath {
+			return nil, fmt.Errorf("cannot apply command wrappers: PATH has relative entries %q (Commands.RejectRelativePath is set)", relative)
+		}
+
+		if debugf != nil {
+			debugf("warning: PATH has relative entries %q; resolved against the working directory %s", relative, env.WorkDir)
+		}
 	}
 
 	if debugf != nil {
@@ -122,15 +151,32 @@ func buildCommandWrapperPlan(cmdsCfg Commands, env Environment, paths pathResolv
 	needRunDir := false
 	needWrappersDir := false
 	needRealDir := false
+	needScriptsDir := false
+
+	blockLogDst := ""
+
+	if cmdsCfg.BlockLog != "" && len(cmdsCfg.Block) > 0 {
+		info, err := os.Stat(cmdsCfg.BlockLog)
+		if err != nil {
+			return nil, fmt.Errorf("block log: %w", err)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("block log %q is not a regular file", cmdsCfg.BlockLog)
+		}
+
+		blockLogDst = filepath.Join(mountDir, "blocked.log")
+		plan.logMounts = append(plan.logMounts, Bind(cmdsCfg.BlockLog, blockLogDst))
+	}
 
-	denyScript := generateDenyWrapperScript()
+	denyScript := generateDenyWrapperScript(blockLogDst)
 
 	for _, cmdName := range cmdsCfg.Block {
 		if strings.TrimSpace(cmdName) == "" || strings.Contains(cmdName, "/") {
 			return nil, internalErrorf("buildCommandWrapperPlan", "invalid blocked command name %q", cmdName)
 		}
 
-		targets, err := findCommandTargets(cmdName, pathDirs)
+		targets, err := findCommandTargets(paths.hostFS, cmdName, pathDirs)
 		if err != nil {
 			return nil, fmt.Errorf("discover command targets for blocked %q: %w", cmdName, err)
 		}
@@ -155,6 +201,8 @@ func buildCommandWrapperPlan(cmdsCfg Commands, env Environment, paths pathResolv
 		seenTargetNames := make(map[string]bool)
 		seenTargetNames[cmdName] = true
 
+		plan.blocked = append(plan.blocked, blockedCommand{name: cmdName, target: targets[0]})
+
 		for _, dst := range targets {
 			plan.launcherMounts = append(plan.launcherMounts, RoBind(cmdsCfg.Launcher, dst))
 
@@ -165,6 +213,7 @@ func buildCommandWrapperPlan(cmdsCfg Commands, env Environment, paths pathResolv
 				seenTargetNames[targetName] = true
 				aliasWrapperDst := filepath.Join(mountDir, "wrappers", targetName)
 				plan.dataMounts = append(plan.dataMounts, roBindDataMount{dst: aliasWrapperDst, perms: 0o555, data: denyScript})
+				plan.blocked = append(plan.blocked, blockedCommand{name: targetName, target: dst})
 			}
 		}
 	}
@@ -183,7 +232,7 @@ func buildCommandWrapperPlan(cmdsCfg Commands, env Environment, paths pathResolv
 			return nil, internalErrorf("buildCommandWrapperPlan", "invalid wrapper command name %q", cmdName)
 		}
 
-		targets, err := findCommandTargets(cmdName, pathDirs)
+		targets, err := findCommandTargets(paths.hostFS, cmdName, pathDirs)
 		if err != nil {
 			return nil, fmt.Errorf("discover command targets for wrapper %q: %w", cmdName, err)
 		}
@@ -201,6 +250,13 @@ func buildCommandWrapperPlan(cmdsCfg Commands, env Environment, paths pathResolv
 		switch {
 		case strings.TrimSpace(wrapper.InlineScript) != "":
 			contents = wrapper.InlineScript
+		case wrapper.FS != nil && strings.TrimSpace(wrapper.Path) != "":
+			data, err := fs.ReadFile(wrapper.FS, wrapper.Path)
+			if err != nil {
+				return nil, fmt.Errorf("read wrapper script %q for %q from FS: %w", wrapper.Path, cmdName, err)
+			}
+
+			contents = string(data)
 		case strings.TrimSpace(wrapper.Path) != "":
 			scriptHostPath := paths.Resolve(wrapper.Path)
 
@@ -227,6 +283,20 @@ func buildCommandWrapperPlan(cmdsCfg Commands, env Environment, paths pathResolv
 		needWrappersDir = true
 		needRealDir = true
 
+		resolved := &Wrapper{InlineScript: contents, Env: wrapper.Env, Chdir: wrapper.Chdir, Timeout: wrapper.Timeout}
+
+		// With Env, Chdir or Timeout, the user-provided script is mounted in
+		// a directory of its own, where it cannot collide with the wrapper of
+		// another command, and the wrapper becomes a prelude that sets them
+		// up and execs it.
+		if len(wrapper.Env) > 0 || wrapper.Chdir != "" || wrapper.Timeout > 0 {
+			needScriptsDir = true
+
+			scriptDst := filepath.Join(mountDir, "wrapper-scripts", cmdName)
+			plan.dataMounts = append(plan.dataMounts, roBindDataMount{dst: scriptDst, perms: 0o555, data: contents})
+			contents = generateWrapperPrelude(cmdName, resolved, scriptDst)
+		}
+
 		// The user-provided wrapper script is mounted into the sandbox and then
 		// the launcher is mounted over each real binary location.
 		wrapperDst := filepath.Join(mountDir, "wrappers", cmdName)
@@ -243,6 +313,8 @@ func buildCommandWrapperPlan(cmdsCfg Commands, env Environment, paths pathResolv
 		seenTargetNames := make(map[string]bool)
 		seenTargetNames[cmdName] = true
 
+		plan.blocked = append(plan.blocked, blockedCommand{name: cmdName, target: targets[0], wrapper: resolved})
+
 		for _, dst := range targets {
 			plan.launcherMounts = append(plan.launcherMounts, RoBind(cmdsCfg.Launcher, dst))
 
@@ -284,55 +356,54 @@ func buildCommandWrapperPlan(cmdsCfg Commands, env Environment, paths pathResolv
 		plan.dirs = append(plan.dirs, Dir(filepath.Join(mountDir, "wrappers"), runtimeDirPerms))
 	}
 
+	if needScriptsDir {
+		plan.dirs = append(plan.dirs, Dir(filepath.Join(mountDir, "wrapper-scripts"), runtimeDirPerms))
+	}
+
 	return plan, nil
 }
 
-// parsePathDirs splits PATH into a de-duplicated list of absolute host directories.
+// parsePathDirs splits PATH into a de-duplicated list of absolute host
+// directories. It also returns the entries that were relative, in order.
 //
-// Empty PATH entries (meaning "current directory") are ignored for wrapper
+// Relative entries (such as "." or "bin") and empty entries, which mean the
+// current directory, are resolved against workDir: that is where sandboxed
+// commands start, and a wrapped command found there must not escape
 // discovery.
-func parsePathDirs(pathVar, workDir string) []string {
+func parsePathDirs(pathVar, workDir string) (dirs, relative []string) {
 	parts := strings.Split(pathVar, ":")
 	seen := make(map[string]struct{})
-	out := make([]string, 0, len(parts))
+	dirs = make([]string, 0, len(parts))
 
 	for _, dir := range parts {
-		dir = strings.TrimSpace(dir)
-		if dir == "" {
-			continue
-		}
-
+		// Entries are taken literally, as execvp does: " /bin" is a
+		// relative directory, not /bin.
 		if !filepath.IsAbs(dir) {
+			relative = append(relative, dir)
 			dir = filepath.Join(workDir, dir)
 		}
 
 		dir = filepath.Clean(dir)
-		if dir == "" {
-			continue
-		}
 
 		if _, ok := seen[dir]; ok {
 			continue
 		}
 
 		seen[dir] = struct{}{}
-		out = append(out, dir)
+		dirs = append(dirs, dir)
 	}
 
-	return out
+	return dirs, relative
 }
 
-// findCommandTargets returns host absolute paths that should be wrapped for cmdName.
-//
-// Targets are returned in PATH order and deduplicated by resolved symlink target.
-func findCommandTargets(cmdName string, pathDirs []string) ([]string, error) {
+func findCommandTargets(fsys HostFS, cmdName string, pathDirs []string) ([]string, error) {
 	seen := make(map[string]struct{})
 	out := make([]string, 0, 4)
 
 	for _, dir := range pathDirs {
 		candidate := filepath.Join(dir, cmdName)
 
-		info, err := os.Stat(candidate)
+		info, err := fsys.Stat(candidate)
 		if err != nil {
 			if os.IsNotExist(err) {
 				continue
@@ -349,7 +420,7 @@ func findCommandTargets(cmdName string, pathDirs []string) ([]string, error) {
 			continue
 		}
 
-		resolved, err := filepath.EvalSymlinks(candidate)
+		resolved, err := evalSymlinksIn(fsys, candidate)
 		if err != nil {
 			if os.IsNotExist(err) {
 				continue
@@ -374,10 +445,155 @@ func findCommandTargets(cmdName string, pathDirs []string) ([]string, error) {
 	return out, nil
 }
 
-// generateDenyWrapperScript returns an executable script that denies the command.
-func generateDenyWrapperScript() string {
+// generateWrapperPrelude returns a script that changes to wrapper.Chdir (if
+// set), exports wrapper.Env, starts the timeout watchdog for wrapper.Timeout
+// (if set), and execs script with the wrapper's arguments. All values are
+// single-quoted, so they are never expanded by the shell.
+func generateWrapperPrelude(name string, wrapper *Wrapper, script string) string {
+	var b strings.Builder
+
+	b.WriteString("#!/bin/sh\n")
+
+	if wrapper.Chdir != "" {
+		b.WriteString("cd -- " + shellQuote(wrapper.Chdir) + " || exit 1\n")
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(wrapper.Env)) {
+		b.WriteString(key + "=" + shellQuote(wrapper.Env[key]) + "\n")
+		b.WriteString("export " + key + "\n")
+	}
+
+	if wrapper.Timeout > 0 {
+		b.WriteString(wrapperTimeoutWatchdog(name, wrapper.Timeout))
+	}
+
+	b.WriteString("exec " + shellQuote(script) + ` "$@"` + "\n")
+
+	return b.String()
+}
+
+// wrapperTimeoutWatchdog returns shell code that starts a background watchdog
+// for the current shell process, which then execs the wrapper script, so the
+// wrapped command keeps the wrapper's PID, stdin and signal dispositions.
+//
+// The watchdog checks once a second whether the process is still alive and
+// exits as soon as it is not, so it neither outlives the command by more than
+// a second nor signals a reused PID. Its own stdio is /dev/null so it never
+// keeps a caller's pipe open; the timeout notice is written through
+// /proc/<pid>/fd/2 instead. After the timeout the process gets SIGTERM and,
+// five seconds later, SIGKILL.
+func wrapperTimeoutWatchdog(name string, timeout time.Duration) string {
+	seconds := int64((timeout + time.Second - 1) / time.Second)
+	notice := shellQuote(fmt.Sprintf("command '%s' timed out after %s", name, timeout))
+
+	return `agent_sandbox_pid=$$
+(
+	agent_sandbox_left=` + strconv.FormatInt(seconds, 10) + `
+	while [ "$agent_sandbox_left" -gt 0 ]; do
+		sleep 1
+		kill -0 "$agent_sandbox_pid" 2>/dev/null || exit 0
+		agent_sandbox_left=$((agent_sandbox_left - 1))
+	done
+	echo ` + notice + ` >"/proc/$agent_sandbox_pid/fd/2" 2>/dev/null
+	kill -TERM "$agent_sandbox_pid" 2>/dev/null || exit 0
+	sleep 5
+	kill -0 "$agent_sandbox_pid" 2>/dev/null && kill -KILL "$agent_sandbox_pid" 2>/dev/null
+) </dev/null >/dev/null 2>&1 &
+`
+}
+
+// generateDenyWrapperScript returns an executable script that denies the
+// command. If logPath is set, the script first appends the command name to it.
+func generateDenyWrapperScript(logPath string) string {
+	logLine := ""
+	if logPath != "" {
+		logLine = `basename "$0" >>` + shellQuote(logPath) + " 2>/dev/null\n"
+	}
+
 	return `#!/bin/sh
-echo "command '$(basename "$0")' is blocked in this sandbox" >&2
-exit 1
+` + logLine + `echo "command '$(basename "$0")' is blocked in this sandbox" >&2
+exit 126
 `
 }
+
+// protectLauncherTargetDirs makes the directories holding launcher targets
+// read-only where they are writable inside the sandbox.
+//
+// A launcher mount cannot be unlinked or renamed (the kernel reports EBUSY),
+// but a writable directory holding it can be renamed away and recreated with
+// an unwrapped binary in its place, and so can any writable ancestor of that
+// directory. Every directory from the first one below the covering writable
+// mount down to the target directory is therefore re-bound read-only from
+// its host source, which also makes each one a mount point that cannot be
+// renamed; `--remount-ro` alone is not enough because the directories are
+// usually not mount points of their own. Mounts planned below the first
+// re-bound directory are emitted again on top, so excludes and more specific
+// mounts keep applying.
+func (p *planner) protectLauncherTargetDirs(launcherMounts []Mount) error {
+	dirs := make([]string, 0, len(launcherMounts))
+	for _, m := range launcherMounts {
+		dirs = append(dirs, filepath.Dir(m.Dst))
+	}
+
+	slices.Sort(dirs)
+
+	for _, dir := range slices.Compact(dirs) {
+		covering, rel, ok := p.coveringBindMount(dir)
+		if !ok || (covering.Kind != MountBind && covering.Kind != MountBindTry) {
+			continue
+		}
+
+		// rel is "." when dir is the writable mount itself.
+		parts := strings.Split(rel, string(filepath.Separator))
+		top := filepath.Join(covering.Dst, parts[0])
+
+		var nested []Mount
+
+		for _, m := range p.plan.mounts {
+			relDst, err := filepath.Rel(top, m.Dst)
+			if err == nil && relDst != "." && relDst != ".." && !strings.HasPrefix(relDst, "../") {
+				nested = append(nested, m)
+			}
+		}
+
+		p.debugf("launcher target dir %q is writable, re-binding %d directories below %q read-only (nested mounts=%d)", dir, len(parts), covering.Dst, len(nested))
+
+		for i := range parts {
+			sub := filepath.Join(parts[:i+1]...)
+
+			err := p.appendMount(RoBind(filepath.Join(covering.Src, sub), filepath.Join(covering.Dst, sub)))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, m := range nested {
+			err := p.appendMount(m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// coveringBindMount is like [planner.coveringMount] but ignores Dir mounts,
+// which create mount points without changing the file system they are on.
+func (p *planner) coveringBindMount(path string) (Mount, string, bool) {
+	for i := len(p.plan.mounts) - 1; i >= 0; i-- {
+		mnt := p.plan.mounts[i]
+		if mnt.Kind == MountDir {
+			continue
+		}
+
+		rel, err := filepath.Rel(mnt.Dst, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		return mnt, rel, true
+	}
+
+	return Mount{}, "", false
+}
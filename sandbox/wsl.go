@@ -0,0 +1,132 @@
+//go:build linux
+
+package sandbox
+
+// This file implements Windows Subsystem for Linux support (see
+// [Environment.WSL]).
+//
+// WSL1 translates Linux syscalls in the Windows kernel and has no user
+// namespaces, so bwrap cannot run at all. WSL2 runs a real Linux kernel, but
+// Windows drives are mounted over 9p (drvfs) at /mnt/<drive>, cgroup v2 is
+// only delegated when systemd is enabled in /etc/wsl.conf, and Docker Desktop
+// shares its sockets below /mnt/wsl instead of /var/run/docker.sock.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// wslOSReleasePath holds the kernel release, which names Microsoft on WSL.
+const wslOSReleasePath = "/proc/sys/kernel/osrelease"
+
+// wslDrivesRoot is where WSL mounts Windows drives by default.
+const wslDrivesRoot = "/mnt"
+
+// wslDockerDesktopSockets is where Docker Desktop's WSL integration keeps the
+// daemon sockets shared with every integrated distribution.
+const wslDockerDesktopSockets = "/mnt/wsl/docker-desktop/shared-sockets"
+
+// WSLVersion identifies the Windows Subsystem for Linux version of the host.
+type WSLVersion int
+
+const (
+	// WSLNone is a host that is not WSL.
+	WSLNone WSLVersion = iota
+
+	// WSL1 is the syscall translation layer. bwrap does not work on it.
+	WSL1
+
+	// WSL2 is the lightweight VM with a Linux kernel.
+	WSL2
+)
+
+func (v WSLVersion) String() string {
+	switch v {
+	case WSLNone:
+		return "none"
+	case WSL1:
+		return "wsl1"
+	case WSL2:
+		return "wsl2"
+	default:
+		return fmt.Sprintf("WSLVersion(%d)", int(v))
+	}
+}
+
+// DetectWSL reports the WSL version of the current host from the kernel
+// release ("...-Microsoft" on WSL1, "...-microsoft-standard-WSL2" on WSL2).
+func DetectWSL() WSLVersion {
+	release, err := os.ReadFile(wslOSReleasePath)
+	if err != nil {
+		return WSLNone
+	}
+
+	return wslVersionFromRelease(string(release))
+}
+
+func wslVersionFromRelease(release string) WSLVersion {
+	release = strings.ToLower(strings.TrimSpace(release))
+
+	switch {
+	case !strings.Contains(release, "microsoft"):
+		return WSLNone
+	case strings.Contains(release, "wsl2"), strings.Contains(release, "microsoft-standard"):
+		return WSL2
+	default:
+		return WSL1
+	}
+}
+
+// wslTranslatePath converts a Windows path ("C:\Users\me" or "C:/Users/me")
+// to its drvfs mount ("/mnt/c/Users/me"). Other paths are returned unchanged.
+func wslTranslatePath(path string) (string, bool) {
+	if len(path) < 3 || path[1] != ':' || (path[2] != '\\' && path[2] != '/') {
+		return path, false
+	}
+
+	drive := path[0] | 0x20
+	if drive < 'a' || drive > 'z' {
+		return path, false
+	}
+
+	rest := strings.ReplaceAll(path[3:], `\`, "/")
+
+	return filepath.Join(wslDrivesRoot, string(drive), rest), true
+}
+
+// isWSLDrivePath reports whether path is on a Windows drive mounted by WSL.
+func isWSLDrivePath(path string) bool {
+	rel, ok := strings.CutPrefix(filepath.Clean(path), wslDrivesRoot+"/")
+	if !ok {
+		return false
+	}
+
+	drive, _, _ := strings.Cut(rel, "/")
+
+	return len(drive) == 1 && drive[0] >= 'a' && drive[0] <= 'z'
+}
+
+// wslWarnings reports the configured features that do not work as expected
+// on the WSL host.
+func (p *planner) wslWarnings() {
+	switch p.env.WSL {
+	case WSLNone:
+		return
+	case WSL1:
+		p.debugf("warning: wsl1 has no user namespaces, bwrap cannot run; convert the distribution with `wsl --set-version <distro> 2`")
+	}
+
+	if p.cfg.Systemd != nil {
+		p.debugf("warning: wsl: systemd scopes need cgroup v2 delegation, which WSL only provides with systemd=true in /etc/wsl.conf")
+	}
+
+	if isWSLDrivePath(p.env.WorkDir) {
+		p.debugf("warning: wsl: working directory %q is on a Windows drive (9p); file modes are emulated and inotify does not see Windows-side changes", p.env.WorkDir)
+
+		if p.cfg.DefaultACL != DefaultACLNone {
+			p.debugf("warning: wsl: DefaultACL has no effect on Windows drives")
+		}
+	}
+}